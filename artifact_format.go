@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Формат манифеста артефакта: по нему новая версия dbacker понимает, чем и как создан файл
+const (
+	artifactManifestFormat  = "dbacker-artifact"
+	artifactManifestVersion = 1 // текущая версия манифеста; артефакты без манифеста считаются версией 0
+)
+
+// artifactManifest манифест артефакта, сохраняется в каталоге вместе с записью об артефакте
+// и переходит на его копии в удаленных хранилищах
+type artifactManifest struct {
+	Format        string   `json:"format"`
+	Version       int      `json:"version"`
+	Layout        string   `json:"layout"`            // устройство содержимого: pg_dump_custom, pg_dump_directory, basebackup, avro...
+	LayoutVersion int      `json:"layout_version"`    // версия устройства содержимого в dbacker
+	Tool          string   `json:"tool,omitempty"`    // программа, записавшая данные, например "pg_dump (PostgreSQL) 16.2"
+	Stages        []string `json:"stages,omitempty"`  // этапы export.pipeline, через которые прошел файл
+	Chunked       bool     `json:"chunked,omitempty"` // файл разбит на части по export.chunk_size
+
+	Spatial []spatialColumn `json:"spatial,omitempty"` // колонки PostGIS выгрузки с SRID и представлением (export.geometry)
+}
+
+// layoutSupport поддержка одного устройства артефакта этой сборкой
+type layoutSupport struct {
+	Version    int    // версия, которую пишет эта сборка
+	MinVersion int    // самая старая версия, которую она умеет восстанавливать (0 - и артефакты без манифеста)
+	Restore    string // команда восстановления или пусто, если артефакт восстанавливается вручную
+}
+
+// artifactLayouts матрица совместимости: какие версии каждого устройства артефакта восстанавливает эта сборка.
+// При несовместимом изменении устройства его Version увеличивается; MinVersion поднимается, только если
+// старые артефакты действительно нельзя больше восстановить.
+var artifactLayouts = map[string]layoutSupport{
+	"table":             {Version: 1},
+	"pg_dump_custom":    {Version: 1, Restore: "restore-dump"},
+	"pg_dump_directory": {Version: 1, Restore: "restore-dump"},
+	"basebackup":        {Version: 1},
+	"cdc_wal2json":      {Version: 1},
+	"cdc_test_decoding": {Version: 1},
+	"avro":              {Version: 1},
+	"arrow":             {Version: 1},
+	"duckdb":            {Version: 1},
+	"sqlite":            {Version: 1},
+	"warehouse":         {Version: 1},
+	"bigquery":          {Version: 1},
+}
+
+// artifactLayout возвращает устройство содержимого артефакта по его виду и настройкам
+func artifactLayout(config *Config, a *artifact) string {
+	switch a.Kind {
+	case artifactKindTable:
+		return "table"
+	case runKindDump:
+		return "pg_dump_" + config.Dump.Format
+	case runKindCluster:
+		return "basebackup"
+	case runKindCDC:
+		return "cdc_" + config.CDC.Plugin
+	case runKindRestore:
+		return ""
+	}
+	if _, ok := artifactLayouts[a.Kind]; ok {
+		return a.Kind
+	}
+	return ""
+}
+
+// stampArtifacts дополняет манифесты созданных запуском артефактов перед записью в каталог
+func stampArtifacts(config *Config, artifacts []artifact) {
+	for i := range artifacts {
+		a := &artifacts[i]
+		layout := artifactLayout(config, a)
+		if layout == "" {
+			continue
+		}
+		if a.Manifest == nil {
+			a.Manifest = &artifactManifest{}
+		}
+		m := a.Manifest
+		m.Format, m.Version = artifactManifestFormat, artifactManifestVersion
+		if m.Layout == "" {
+			m.Layout = layout
+		}
+		m.LayoutVersion = artifactLayouts[m.Layout].Version
+		// Конвейер export.pipeline применяется к файловым выгрузкам, кроме warehouse, и к дампам формата custom
+		if _, ok := exporters[a.Kind]; ok && a.Kind != "warehouse" || a.Kind == runKindDump && config.Dump.Format == "custom" {
+			m.Stages = nil
+			for _, s := range config.Export.Pipeline {
+				m.Stages = append(m.Stages, s.Type)
+			}
+			m.Chunked = config.Export.ChunkSize > 0
+		}
+	}
+}
+
+// checkRestorable проверяет по матрице совместимости, что эта сборка может восстановить артефакт командой
+// restorer. Для артефакта без манифеста передается манифест версии 0 с устройством, определенным по содержимому.
+func checkRestorable(m *artifactManifest, restorer string) error {
+	if m.Format != artifactManifestFormat {
+		return fmt.Errorf("неизвестный формат манифеста %q", m.Format)
+	}
+	if m.Version > artifactManifestVersion {
+		return fmt.Errorf("артефакт создан более новой версией dbacker (манифест версии %d, поддерживается до %d), обновите dbacker",
+			m.Version, artifactManifestVersion)
+	}
+	support, ok := artifactLayouts[m.Layout]
+	if !ok {
+		return fmt.Errorf("устройство артефакта %s неизвестно этой версии dbacker, обновите dbacker", m.Layout)
+	}
+	if support.Restore != restorer {
+		if support.Restore == "" {
+			return fmt.Errorf("артефакт %s не восстанавливается командой %s", m.Layout, restorer)
+		}
+		return fmt.Errorf("артефакт %s восстанавливается командой %s, а не %s", m.Layout, support.Restore, restorer)
+	}
+	if m.LayoutVersion > support.Version {
+		return fmt.Errorf("артефакт %s версии %d создан более новой версией dbacker (поддерживается до %d), обновите dbacker",
+			m.Layout, m.LayoutVersion, support.Version)
+	}
+	if m.LayoutVersion < support.MinVersion {
+		return fmt.Errorf("артефакт %s версии %d больше не поддерживается (минимальная версия %d), восстановите его прежней версией dbacker",
+			m.Layout, m.LayoutVersion, support.MinVersion)
+	}
+	if len(m.Stages) > 0 {
+		return fmt.Errorf("файл прошел этапы export.pipeline (%s), сначала восстановите исходный файл", strings.Join(m.Stages, ", "))
+	}
+	if m.Chunked {
+		return fmt.Errorf("файл разбит на части по export.chunk_size, сначала соберите его из частей")
+	}
+	return nil
+}
+
+// artifactManifestByPath ищет в каталоге манифест локального артефакта по пути или по имени файла
+// (архив могли скопировать в другой каталог или скачать из хранилища). Возвращает nil, если артефакта
+// нет в каталоге или он записан до появления манифестов.
+func artifactManifestByPath(db *sql.DB, path string) (*artifactManifest, error) {
+	if exists, err := catalogExists(db); err != nil || !exists {
+		return nil, err
+	}
+	var data string
+	base := filepath.Base(strings.TrimRight(path, "/"))
+	err := db.QueryRow(`
+		SELECT manifest::text FROM `+catalogArtifactsTable+`
+		WHERE manifest IS NOT NULL AND (path = $1 OR path = $2 OR right(path, length($2) + 1) = '/' || $2)
+		ORDER BY path = $1 DESC, created_at DESC
+		LIMIT 1`, path, base).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m artifactManifest
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return nil, fmt.Errorf("некорректный манифест артефакта %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// sniffDumpLayout определяет формат архива pg_dump по содержимому: custom начинается с сигнатуры PGDMP,
+// directory содержит toc.dat с той же сигнатурой
+func sniffDumpLayout(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	layout, file := "pg_dump_custom", path
+	if info.IsDir() {
+		layout, file = "pg_dump_directory", filepath.Join(path, "toc.dat")
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("%s не является архивом pg_dump формата directory: %w", path, err)
+	}
+	defer f.Close()
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(f, header); err != nil || string(header) != "PGDMP" {
+		return "", fmt.Errorf("%s не является архивом pg_dump формата custom или directory (нет сигнатуры PGDMP)", path)
+	}
+	return layout, nil
+}
+
+var postgresVersionRe = regexp.MustCompile(`\(PostgreSQL\) (\d+)`)
+
+// postgresMajor возвращает основную версию PostgreSQL из вывода --version утилиты ("pg_dump (PostgreSQL) 16.2")
+func postgresMajor(version string) int {
+	m := postgresVersionRe.FindStringSubmatch(version)
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// toolVersion возвращает первую строку вывода binary --version
+func toolVersion(binary string) (string, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(binary, "--version")
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(out.String()), "\n")
+	return line, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+func init() {
+	commands["asof"] = command{
+		Usage: "(Re)create SQL helpers to query backups as of a date (test run unless -run)",
+		Run:   cmdAsof,
+	}
+}
+
+// Функции для запросов к бэкапам на дату, создаются в схеме public базы с бэкапами
+const (
+	asofTableFunc = "public.dbacker_asof_table"
+	asofFunc      = "public.dbacker_asof"
+)
+
+// asofFunctions возвращает определения функций для запросов к бэкапам на дату:
+//
+//	dbacker_asof_table('orders', '2024-01-01') - имя последней таблицы бэкапа orders, снятой не позже даты;
+//	SELECT * FROM dbacker_asof(NULL::orders, '2024-01-01') - строки этой таблицы бэкапа.
+//
+// Бэкапы ищутся в текущей схеме (search_path), поэтому функции работают и для схем арендаторов.
+func asofFunctions(prefix string) []string {
+	return []string{
+		`CREATE OR REPLACE FUNCTION ` + asofTableFunc + `(source text, day date) RETURNS text
+		LANGUAGE sql STABLE AS $$
+			SELECT table_name::text
+			FROM information_schema.tables
+			WHERE table_schema = current_schema()
+			AND length(table_name) > length(source) + 9
+			AND left(table_name, length(table_name) - 9) = ` + pq.QuoteLiteral(prefix) + ` || '_' || source
+			AND right(table_name, 9) ~ '^_[0-9]{8}$'
+			AND right(table_name, 8) <= to_char(day, 'YYYYMMDD')
+			ORDER BY right(table_name, 8) DESC
+			LIMIT 1
+		$$`,
+		`CREATE OR REPLACE FUNCTION ` + asofFunc + `(source anyelement, day date) RETURNS SETOF anyelement
+		LANGUAGE plpgsql STABLE AS $$
+		DECLARE
+			source_name text;
+			backup text;
+		BEGIN
+			SELECT c.relname INTO source_name FROM pg_class c WHERE c.reltype = pg_typeof(source)::oid;
+			IF source_name IS NULL THEN
+				RAISE EXCEPTION 'dbacker_asof: % is not a table row type', pg_typeof(source);
+			END IF;
+			backup := ` + asofTableFunc + `(source_name, day);
+			IF backup IS NULL THEN
+				RAISE EXCEPTION 'dbacker_asof: no backup of % on or before %', source_name, day;
+			END IF;
+			RETURN QUERY EXECUTE format('SELECT * FROM %I', backup);
+		END
+		$$`,
+	}
+}
+
+// cmdAsof создает (или пересоздает после смены префикса) функции для запросов к бэкапам на дату
+func cmdAsof(args []string) error {
+	fs := flag.NewFlagSet("asof", flag.ExitOnError)
+	run := fs.Bool("run", false, "Normal run instead of test run?")
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	backupDB, err := openBackupDatabase(config, db)
+	if err != nil {
+		return err
+	}
+	if backupDB != db {
+		defer backupDB.Close()
+	}
+
+	for _, stmt := range asofFunctions(config.Backup.Prefix) {
+		if !*run {
+			log.Printf("Тестовый запуск, будет выполнено:\n%s", stmt)
+			continue
+		}
+		if _, err := backupDB.Exec(stmt); err != nil {
+			return fmt.Errorf("ошибка создания функций для запросов на дату: %w", err)
+		}
+	}
+	if *run {
+		log.Printf("Созданы функции %s и %s", asofTableFunc, asofFunc)
+	}
+	return nil
+}
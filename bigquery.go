@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// BigQueryConfig загрузка выгруженных файлов в BigQuery через Google Cloud Storage
+type BigQueryConfig struct {
+	Bucket        string `json:"bucket"`          // Адрес для файлов в GCS, например "gs://snapshots/postgres"
+	Project       string `json:"project"`         // Проект GCP (по умолчанию из настроек gcloud)
+	Dataset       string `json:"dataset"`         // Набор данных BigQuery (пустое значение отключает загрузку)
+	DatasetPerDay bool   `json:"dataset_per_day"` // Отдельный набор данных {dataset}_{YYYYMMDD} на каждый день вместо таблиц {table}_{YYYYMMDD}
+	Location      string `json:"location"`        // Регион для создаваемых наборов данных
+	GcloudBinary  string `json:"gcloud_binary"`   // Путь к gcloud (по умолчанию "gcloud")
+	BQBinary      string `json:"bq_binary"`       // Путь к bq (по умолчанию "bq")
+}
+
+// Форматы выгрузки, которые умеет загружать BigQuery, и соответствующий --source_format
+var bigQueryFormats = map[string]string{
+	"avro": "AVRO",
+}
+
+// validateBigQueryConfig проверяет настройки загрузки в BigQuery
+func validateBigQueryConfig(cfg *BigQueryConfig, export *ExportConfig) error {
+	if cfg.Dataset == "" {
+		return nil
+	}
+	if !strings.HasPrefix(cfg.Bucket, "gs://") {
+		return fmt.Errorf("bigquery.bucket должен начинаться с gs://")
+	}
+	supported := false
+	for _, f := range export.Formats {
+		if _, ok := bigQueryFormats[f]; ok {
+			supported = true
+		}
+	}
+	if !supported {
+		return fmt.Errorf("для загрузки в BigQuery нужен один из форматов выгрузки: avro")
+	}
+	if len(export.Pipeline) > 0 || export.ChunkSize > 0 {
+		return fmt.Errorf("BigQuery загружает только файлы avro без обработки: уберите export.pipeline и export.chunk_size")
+	}
+	if cfg.GcloudBinary == "" {
+		cfg.GcloudBinary = "gcloud"
+	}
+	if cfg.BQBinary == "" {
+		cfg.BQBinary = "bq"
+	}
+	return nil
+}
+
+// loadBigQuery копирует выгруженные файлы запуска в GCS и запускает задания загрузки в датированные таблицы BigQuery
+func loadBigQuery(config *Config, result *runResult, realRun bool) error {
+	cfg := &config.BigQuery
+	if cfg.Dataset == "" {
+		return nil
+	}
+
+	dataset := cfg.Dataset
+	if cfg.DatasetPerDay {
+		dataset = fmt.Sprintf("%s_%s", cfg.Dataset, result.Date)
+	}
+
+	var loads []artifact
+	for _, a := range result.Artifacts {
+		if _, ok := bigQueryFormats[a.Kind]; ok {
+			loads = append(loads, a)
+		}
+	}
+	if len(loads) == 0 {
+		return nil
+	}
+
+	if !realRun {
+		log.Printf("Тестовый запуск, будет выполнена загрузка %d файлов в BigQuery (набор данных %s)", len(loads), dataset)
+		return nil
+	}
+
+	if cfg.DatasetPerDay {
+		args := bqArgs(cfg, "mk", "--dataset", "--force")
+		if cfg.Location != "" {
+			args = append([]string{"--location", cfg.Location}, args...)
+		}
+		if err := runTool(cfg.BQBinary, append(args, bqDataset(cfg, dataset))...); err != nil {
+			return fmt.Errorf("ошибка создания набора данных %s: %w", dataset, err)
+		}
+	}
+
+	var failed []string
+	for _, a := range loads {
+		uri := fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(cfg.Bucket, "/"), result.Date, filepath.Base(a.Path))
+		if err := runTool(cfg.GcloudBinary, "storage", "cp", a.Path, uri); err != nil {
+			log.Printf("Ошибка копирования %s в GCS: %v", a.Path, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", a.Source, err))
+			continue
+		}
+
+		table := a.Source
+		if !cfg.DatasetPerDay {
+			table = fmt.Sprintf("%s_%s", a.Source, result.Date)
+		}
+		args := bqArgs(cfg, "load", "--replace", "--source_format", bigQueryFormats[a.Kind])
+		if a.Kind == "avro" {
+			args = append(args, "--use_avro_logical_types")
+		}
+		args = append(args, bqDataset(cfg, dataset)+"."+table, uri)
+		if err := runTool(cfg.BQBinary, args...); err != nil {
+			log.Printf("Ошибка загрузки %s в BigQuery: %v", uri, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", a.Source, err))
+			continue
+		}
+
+		log.Printf("Таблица %s загружена в BigQuery как %s.%s", a.Source, dataset, table)
+		result.addArtifacts(artifact{
+			Kind:      "bigquery",
+			Source:    a.Source,
+			Path:      uri,
+			Size:      a.Size,
+			CreatedAt: clock.Now(),
+		})
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("ошибка загрузки в BigQuery: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// bqArgs возвращает аргументы bq с глобальными флагами
+func bqArgs(cfg *BigQueryConfig, args ...string) []string {
+	if cfg.Project != "" {
+		return append([]string{"--project_id", cfg.Project}, args...)
+	}
+	return args
+}
+
+// bqDataset возвращает имя набора данных с проектом, если он указан
+func bqDataset(cfg *BigQueryConfig, dataset string) string {
+	if cfg.Project != "" {
+		return cfg.Project + ":" + dataset
+	}
+	return dataset
+}
+
+// runTool запускает внешнюю утилиту и возвращает ошибку с текстом stderr
+func runTool(binary string, args ...string) error {
+	cmd := exec.Command(binary, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// backupTable описывает существующую таблицу бэкапа
+type backupTable struct {
+	Name string
+	Date string // дата из имени таблицы в формате YYYYMMDD
+	Size int64
+}
+
+// getBackupTables возвращает все таблицы бэкапа с их размерами, от самых старых к самым новым
+func getBackupTables(db *sql.DB, prefix string) ([]backupTable, error) {
+	rows, err := db.Query(`
+		SELECT table_name, pg_total_relation_size(quote_ident(table_schema) || '.' || quote_ident(table_name))
+		FROM information_schema.tables 
+		WHERE table_schema = current_schema() 
+		AND table_name LIKE $1 || '%'`, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []backupTable
+	for rows.Next() {
+		var t backupTable
+		if err := rows.Scan(&t.Name, &t.Size); err != nil {
+			return nil, err
+		}
+		if len(t.Name) >= 8 {
+			t.Date = t.Name[len(t.Name)-8:]
+		}
+		tables = append(tables, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(tables, func(i, j int) bool {
+		return tables[i].Date < tables[j].Date
+	})
+	return tables, nil
+}
+
+// enforceBudget проверяет, что существующие бэкапы (в backupDB) вместе с новыми уложатся в max_total_backup_size.
+// При политике "prune" удаляет самые старые бэкапы, пока прогноз не уложится в бюджет,
+// при политике "refuse" возвращает ошибку и бэкап не выполняется. Возвращает удаленные бэкапы.
+// expired - бэкапы, удаленные политикой хранения в этом запуске: при тестовом запуске они еще существуют,
+// но в бюджете не учитываются, чтобы тестовый запуск принимал то же решение, что и настоящий.
+func enforceBudget(db, backupDB *sql.DB, cfg *BackupConfig, sizes map[string]int64, expired []backupTable, realRun bool) ([]string, error) {
+	if cfg.MaxTotalBackupSize <= 0 {
+		return nil, nil
+	}
+
+	all, err := getBackupTables(backupDB, cfg.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения размеров бэкапов: %w", err)
+	}
+	dropped := make(map[string]bool, len(expired))
+	for _, t := range expired {
+		dropped[t.Name] = true
+	}
+	var existing []backupTable
+	for _, t := range all {
+		if !dropped[t.Name] {
+			existing = append(existing, t)
+		}
+	}
+
+	var used, projected int64
+	for _, t := range existing {
+		used += t.Size
+	}
+	for _, size := range sizes {
+		projected += size
+	}
+
+	limit := int64(cfg.MaxTotalBackupSize)
+	log.Printf("Бюджет бэкапов: занято %s, прогноз нового запуска %s, лимит %s",
+		ByteSize(used), ByteSize(projected), cfg.MaxTotalBackupSize)
+	if used+projected <= limit {
+		return nil, nil
+	}
+
+	if cfg.BudgetPolicy != "prune" {
+		return nil, fmt.Errorf("превышен бюджет бэкапов: %s + %s > %s",
+			ByteSize(used), ByteSize(projected), cfg.MaxTotalBackupSize)
+	}
+
+	// Удаление самых старых бэкапов, пока прогноз не уложится в лимит; продленные и удерживаемые не удаляются
+	retained, err := retentionOverrides(db)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения продлений хранения: %w", err)
+	}
+	var pruned []string
+	for _, t := range existing {
+		if used+projected <= limit {
+			break
+		}
+		if until, ok := retained[t.Name]; ok && isRetained(until) {
+			continue
+		}
+		if realRun {
+			_, err := backupDB.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s%s", t.Name, dropCascade(cfg)))
+			if err != nil {
+				return pruned, fmt.Errorf("ошибка удаления таблицы %s: %w", t.Name, err)
+			}
+			pruned = append(pruned, t.Name)
+		}
+		used -= t.Size
+		if realRun {
+			log.Printf("Удалена таблица бэкапа для соблюдения бюджета: %s (%s)", t.Name, ByteSize(t.Size))
+		} else {
+			log.Printf("Тестовый запуск, для соблюдения бюджета будет удалена таблица бэкапа: %s (%s)", t.Name, ByteSize(t.Size))
+		}
+	}
+
+	if used+projected > limit {
+		return pruned, fmt.Errorf("бюджет бэкапов не соблюдён даже после удаления старых бэкапов: %s + %s > %s",
+			ByteSize(used), ByteSize(projected), cfg.MaxTotalBackupSize)
+	}
+	return pruned, nil
+}
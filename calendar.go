@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// CalendarConfig календарь периодов запрета автоматических запусков (квартальное закрытие, праздники)
+type CalendarConfig struct {
+	Blackouts []string `json:"blackouts"` // Периоды запрета: "2025-03-31", "2025-03-28..2025-04-02" или "2025-03-31 08:00..2025-03-31 20:00"
+	ICal      string   `json:"ical"`      // URL или путь к календарю iCal, каждое событие которого - период запрета
+	Action    string   `json:"action"`    // Что делать с запуском в период запрета: "skip" (по умолчанию) или "shift"
+	MaxShift  Duration `json:"max_shift"` // При "shift": сколько максимум ждать окончания периода, иначе запуск пропускается (по умолчанию "12h")
+}
+
+func init() {
+	commands["calendar"] = command{
+		Usage: "List blackout windows of the run calendar for the coming days",
+		Run:   cmdCalendar,
+	}
+}
+
+// blackout период запрета запусков [Start, End)
+type blackout struct {
+	Start, End time.Time
+	Summary    string
+}
+
+// Максимальное количество повторений события iCal, которое разворачивается из RRULE
+const maxRecurrences = 1000
+
+// cmdCalendar выводит периоды запрета на ближайшие дни
+func cmdCalendar(args []string) error {
+	fs := flag.NewFlagSet("calendar", flag.ExitOnError)
+	days := fs.Int("days", 90, "How many days ahead to list")
+	fs.Parse(args)
+
+	config, err := loadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("ошибка загрузки конфигурации: %w", err)
+	}
+	windows, err := loadBlackouts(&config.Calendar)
+	if err != nil {
+		return err
+	}
+
+	now := clock.Now()
+	until := now.AddDate(0, 0, *days)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Начало\tОкончание\tОписание")
+	found := false
+	for _, b := range windows {
+		if b.End.After(now) && b.Start.Before(until) {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", b.Start.Format("2006-01-02 15:04"), b.End.Format("2006-01-02 15:04"), b.Summary)
+			found = true
+		}
+	}
+	if !found {
+		fmt.Printf("Периодов запрета в ближайшие %d дней нет\n", *days)
+		return nil
+	}
+	return w.Flush()
+}
+
+// validateCalendar проверяет календарь и заполняет значения по умолчанию
+func validateCalendar(cfg *CalendarConfig) error {
+	switch cfg.Action {
+	case "":
+		cfg.Action = "skip"
+	case "skip", "shift":
+	default:
+		return fmt.Errorf("неизвестное значение calendar.action: %s", cfg.Action)
+	}
+	if cfg.MaxShift == 0 {
+		cfg.MaxShift = Duration(12 * time.Hour)
+	}
+	for _, s := range cfg.Blackouts {
+		if _, err := parseBlackout(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allowRun проверяет календарь перед автоматическим запуском операции (operation в родительном падеже). Возвращает false, если запуск
+// нужно пропустить. При action = "shift" ждет окончания периода запрета (с учетом идущих подряд периодов),
+// если оно наступает не позже чем через max_shift. Тестовый запуск только сообщает о периоде и выполняется.
+func allowRun(cfg *CalendarConfig, operation string, realRun bool) (bool, error) {
+	if len(cfg.Blackouts) == 0 && cfg.ICal == "" {
+		return true, nil
+	}
+	windows, err := loadBlackouts(cfg)
+	if err != nil {
+		return false, err
+	}
+
+	now := clock.Now()
+	free := now
+	for {
+		b := findBlackout(windows, free)
+		if b == nil {
+			break
+		}
+		if free == now {
+			log.Printf("Период запрета запусков %s: %s - %s", b.Summary, b.Start.Format("2006-01-02 15:04"), b.End.Format("2006-01-02 15:04"))
+		}
+		free = b.End
+	}
+	if free == now {
+		return true, nil
+	}
+
+	wait := free.Sub(now)
+	if cfg.Action == "shift" && wait <= time.Duration(cfg.MaxShift) {
+		if !realRun {
+			log.Printf("Тестовый запуск, запуск %s будет отложен до %s", operation, free.Format("2006-01-02 15:04"))
+			return true, nil
+		}
+		log.Printf("Запуск %s отложен до окончания периода запрета: %s", operation, free.Format("2006-01-02 15:04"))
+		clock.Sleep(wait)
+		return true, nil
+	}
+	if !realRun {
+		log.Printf("Тестовый запуск, запуск %s будет пропущен", operation)
+		return true, nil
+	}
+	log.Printf("Запуск %s пропущен: период запрета до %s", operation, free.Format("2006-01-02 15:04"))
+	return false, nil
+}
+
+// findBlackout возвращает период запрета, в который попадает момент t
+func findBlackout(windows []blackout, t time.Time) *blackout {
+	for i := range windows {
+		if !t.Before(windows[i].Start) && t.Before(windows[i].End) {
+			return &windows[i]
+		}
+	}
+	return nil
+}
+
+// loadBlackouts собирает периоды запрета из настроек и календаря iCal, отсортированные по началу
+func loadBlackouts(cfg *CalendarConfig) ([]blackout, error) {
+	var windows []blackout
+	for _, s := range cfg.Blackouts {
+		b, err := parseBlackout(s)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, b)
+	}
+	if cfg.ICal != "" {
+		events, err := loadICal(cfg.ICal)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка загрузки календаря %s: %w", cfg.ICal, err)
+		}
+		windows = append(windows, events...)
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+	return windows, nil
+}
+
+// parseBlackout разбирает период из calendar.blackouts: день, диапазон дней (включительно)
+// или диапазон моментов "YYYY-MM-DD HH:MM"
+func parseBlackout(s string) (blackout, error) {
+	from, to, isRange := strings.Cut(s, "..")
+	b := blackout{Summary: s}
+	var err error
+	var toDay bool
+	if b.Start, _, err = parseCalendarTime(strings.TrimSpace(from)); err != nil {
+		return b, fmt.Errorf("некорректный период calendar.blackouts %q: %w", s, err)
+	}
+	if !isRange {
+		to = from
+	}
+	if b.End, toDay, err = parseCalendarTime(strings.TrimSpace(to)); err != nil {
+		return b, fmt.Errorf("некорректный период calendar.blackouts %q: %w", s, err)
+	}
+	if toDay {
+		// день окончания входит в период
+		b.End = b.End.AddDate(0, 0, 1)
+	}
+	if !b.End.After(b.Start) {
+		return b, fmt.Errorf("некорректный период calendar.blackouts %q: окончание должно быть позже начала", s)
+	}
+	return b, nil
+}
+
+// parseCalendarTime разбирает день "YYYY-MM-DD" или момент "YYYY-MM-DD HH:MM" в местном времени
+func parseCalendarTime(s string) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, true, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+	if err != nil {
+		return t, false, fmt.Errorf("ожидается YYYY-MM-DD или YYYY-MM-DD HH:MM")
+	}
+	return t, false, nil
+}
+
+// loadICal читает календарь iCal по URL или из файла
+func loadICal(source string) ([]blackout, error) {
+	var r io.Reader
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("HTTP %s", resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return parseICal(r)
+}
+
+// parseICal разбирает события VEVENT календаря iCal (RFC 5545): DTSTART, DTEND, SUMMARY и простые
+// повторения RRULE с FREQ, INTERVAL, COUNT и UNTIL. События на весь день (VALUE=DATE) длятся
+// до DTEND не включительно, по умолчанию один день.
+func parseICal(r io.Reader) ([]blackout, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// Продолжение свернутой строки начинается с пробела или табуляции
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var events []blackout
+	var event map[string]string
+	for _, line := range lines {
+		switch line {
+		case "BEGIN:VEVENT":
+			event = make(map[string]string)
+			continue
+		case "END:VEVENT":
+			if event != nil {
+				expanded, err := icalEvent(event)
+				if err != nil {
+					return nil, err
+				}
+				events = append(events, expanded...)
+			}
+			event = nil
+			continue
+		}
+		if event == nil {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		// Параметры свойства (DTSTART;TZID=...;VALUE=DATE) сохраняются вместе с именем
+		key, _, _ := strings.Cut(name, ";")
+		event[key] = value
+		event[key+";"] = name
+	}
+	return events, nil
+}
+
+// icalEvent превращает событие iCal в периоды запрета (несколько, если событие повторяется)
+func icalEvent(event map[string]string) ([]blackout, error) {
+	summary := event["SUMMARY"]
+	start, allDay, err := icalTime(event["DTSTART;"], event["DTSTART"])
+	if err != nil {
+		return nil, fmt.Errorf("событие %q: DTSTART: %w", summary, err)
+	}
+	var end time.Time
+	if v, ok := event["DTEND"]; ok {
+		if end, _, err = icalTime(event["DTEND;"], v); err != nil {
+			return nil, fmt.Errorf("событие %q: DTEND: %w", summary, err)
+		}
+	} else if allDay {
+		end = start.AddDate(0, 0, 1)
+	} else {
+		// Событие без длительности ничего не запрещает
+		return nil, nil
+	}
+	if !end.After(start) {
+		return nil, nil
+	}
+	first := blackout{Start: start, End: end, Summary: summary}
+
+	rule, ok := event["RRULE"]
+	if !ok {
+		return []blackout{first}, nil
+	}
+	return expandRRule(first, rule)
+}
+
+// icalTime разбирает значение DATE или DATE-TIME с учетом параметров TZID и VALUE=DATE;
+// время без зоны считается местным
+func icalTime(name, value string) (time.Time, bool, error) {
+	loc := time.Local
+	for _, param := range strings.Split(name, ";")[1:] {
+		if tz, ok := strings.CutPrefix(param, "TZID="); ok {
+			if l, err := time.LoadLocation(strings.Trim(tz, `"`)); err == nil {
+				loc = l
+			}
+		}
+	}
+	switch {
+	case len(value) == 8:
+		t, err := time.ParseInLocation("20060102", value, time.Local)
+		return t, true, err
+	case strings.HasSuffix(value, "Z"):
+		t, err := time.Parse("20060102T150405Z", value)
+		return t.Local(), false, err
+	default:
+		t, err := time.ParseInLocation("20060102T150405", value, loc)
+		return t, false, err
+	}
+}
+
+// expandRRule разворачивает повторения события по правилу RRULE. Поддерживаются FREQ (DAILY, WEEKLY,
+// MONTHLY, YEARLY), INTERVAL, COUNT и UNTIL; правила с BYDAY и другими уточнениями разворачиваются
+// без них с предупреждением. Без COUNT и UNTIL повторения ограничены maxRecurrences.
+func expandRRule(first blackout, rule string) ([]blackout, error) {
+	var years, months, days int
+	interval, count := 1, maxRecurrences
+	var until time.Time
+	for _, part := range strings.Split(rule, ";") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "FREQ":
+			switch value {
+			case "DAILY":
+				days = 1
+			case "WEEKLY":
+				days = 7
+			case "MONTHLY":
+				months = 1
+			case "YEARLY":
+				years = 1
+			default:
+				return nil, fmt.Errorf("событие %q: неподдерживаемая частота повторения %s", first.Summary, value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("событие %q: некорректный INTERVAL %s", first.Summary, value)
+			}
+			interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("событие %q: некорректный COUNT %s", first.Summary, value)
+			}
+			if n < count {
+				count = n
+			}
+		case "UNTIL":
+			t, _, err := icalTime("UNTIL", value)
+			if err != nil {
+				return nil, fmt.Errorf("событие %q: некорректный UNTIL %s", first.Summary, value)
+			}
+			until = t
+			if len(value) == 8 {
+				until = until.AddDate(0, 0, 1)
+			}
+		case "WKST":
+		default:
+			log.Printf("Событие календаря %q: уточнение повторения %s не поддерживается и игнорируется", first.Summary, key)
+		}
+	}
+	if years+months+days == 0 {
+		return nil, fmt.Errorf("событие %q: в RRULE не указана FREQ", first.Summary)
+	}
+
+	duration := first.End.Sub(first.Start)
+	var windows []blackout
+	for i := 0; i < count; i++ {
+		start := first.Start.AddDate(years*interval*i, months*interval*i, days*interval*i)
+		if !until.IsZero() && !start.Before(until) {
+			break
+		}
+		windows = append(windows, blackout{Start: start, End: start.Add(duration), Summary: first.Summary})
+	}
+	return windows, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata" // TZID в тестовых календарях не зависит от базы часовых поясов системы
+)
+
+// fakeClock часы теста: Sleep не ждет, а переводит время вперед
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.slept += d
+	c.now = c.now.Add(d)
+}
+
+// setFakeClock подменяет часы на время теста
+func setFakeClock(t *testing.T, now time.Time) *fakeClock {
+	t.Helper()
+	c := &fakeClock{now: now}
+	saved := clock
+	clock = c
+	t.Cleanup(func() { clock = saved })
+	return c
+}
+
+func localTime(s string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestAllowRun(t *testing.T) {
+	blackouts := []string{"2025-03-31 08:00..2025-03-31 12:00", "2025-03-31 12:00..2025-03-31 14:00", "2025-04-05"}
+	tests := []struct {
+		name      string
+		now       string
+		action    string
+		maxShift  time.Duration
+		realRun   bool
+		want      bool
+		wantSlept time.Duration
+	}{
+		{"outside", "2025-03-31 07:59", "skip", 0, true, true, 0},
+		{"end is exclusive", "2025-03-31 14:00", "skip", 0, true, true, 0},
+		{"skip", "2025-03-31 09:00", "skip", 0, true, false, 0},
+		{"skip in test run", "2025-03-31 09:00", "skip", 0, false, true, 0},
+		// периоды подряд объединяются: ожидание до 14:00, а не до 12:00
+		{"shift over adjacent windows", "2025-03-31 09:00", "shift", 12 * time.Hour, true, true, 5 * time.Hour},
+		{"shift too long", "2025-03-31 09:00", "shift", 4 * time.Hour, true, false, 0},
+		{"shift in test run", "2025-03-31 09:00", "shift", 12 * time.Hour, false, true, 0},
+		{"whole day", "2025-04-05 23:30", "shift", time.Hour, true, true, 30 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := setFakeClock(t, localTime(tt.now))
+			cfg := &CalendarConfig{Blackouts: blackouts, Action: tt.action, MaxShift: Duration(tt.maxShift)}
+			got, err := allowRun(cfg, "бэкапа", tt.realRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || c.slept != tt.wantSlept {
+				t.Errorf("allowRun = %v, ожидание %v; ожидалось %v, %v", got, c.slept, tt.want, tt.wantSlept)
+			}
+		})
+	}
+
+	setFakeClock(t, localTime("2025-03-31 09:00"))
+	if ok, err := allowRun(&CalendarConfig{}, "бэкапа", true); !ok || err != nil {
+		t.Errorf("без календаря allowRun = %v, %v", ok, err)
+	}
+}
+
+func TestParseICal(t *testing.T) {
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"SUMMARY:Квартальное",
+		"  закрытие",
+		"DTSTART;VALUE=DATE:20250331",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"SUMMARY:Релиз",
+		"DTSTART;TZID=Europe/Moscow:20250402T200000",
+		"DTEND;TZID=Europe/Moscow:20250402T230000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"SUMMARY:Миграция",
+		"DTSTART:20250403T010000Z",
+		"DTEND:20250403T020000Z",
+		"RRULE:FREQ=WEEKLY;COUNT=2",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"SUMMARY:Напоминание без длительности",
+		"DTSTART:20250404T100000",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+	events, err := parseICal(strings.NewReader(ics))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moscow, _ := time.LoadLocation("Europe/Moscow")
+	want := []blackout{
+		{localTime("2025-03-31 00:00"), localTime("2025-04-01 00:00"), "Квартальное закрытие"},
+		{time.Date(2025, 4, 2, 20, 0, 0, 0, moscow), time.Date(2025, 4, 2, 23, 0, 0, 0, moscow), "Релиз"},
+		{time.Date(2025, 4, 3, 1, 0, 0, 0, time.UTC), time.Date(2025, 4, 3, 2, 0, 0, 0, time.UTC), "Миграция"},
+		{time.Date(2025, 4, 10, 1, 0, 0, 0, time.UTC), time.Date(2025, 4, 10, 2, 0, 0, 0, time.UTC), "Миграция"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("событий %d, ожидалось %d: %v", len(events), len(want), events)
+	}
+	for i, e := range events {
+		if !e.Start.Equal(want[i].Start) || !e.End.Equal(want[i].End) || e.Summary != want[i].Summary {
+			t.Errorf("событие %d: %v - %v %q, ожидалось %v - %v %q", i, e.Start, e.End, e.Summary, want[i].Start, want[i].End, want[i].Summary)
+		}
+	}
+
+	if _, err := parseICal(strings.NewReader("BEGIN:VEVENT\nSUMMARY:x\nDTSTART:завтра\nEND:VEVENT\n")); err == nil {
+		t.Error("некорректный DTSTART принят")
+	}
+}
+
+func TestExpandRRule(t *testing.T) {
+	first := blackout{Start: localTime("2025-01-31 22:00"), End: localTime("2025-02-01 02:00"), Summary: "Окно"}
+	tests := []struct {
+		rule       string
+		wantStarts []string
+		wantCount  int
+		wantErr    bool
+	}{
+		{rule: "FREQ=DAILY;INTERVAL=2;COUNT=3", wantStarts: []string{"2025-01-31 22:00", "2025-02-02 22:00", "2025-02-04 22:00"}},
+		{rule: "FREQ=WEEKLY;UNTIL=20250214", wantStarts: []string{"2025-01-31 22:00", "2025-02-07 22:00", "2025-02-14 22:00"}},
+		// AddDate нормализует 31 число, как и time.Date
+		{rule: "FREQ=MONTHLY;COUNT=2", wantStarts: []string{"2025-01-31 22:00", "2025-03-03 22:00"}},
+		{rule: "FREQ=YEARLY;COUNT=2;WKST=MO", wantStarts: []string{"2025-01-31 22:00", "2026-01-31 22:00"}},
+		{rule: "FREQ=DAILY;BYDAY=MO;COUNT=1", wantStarts: []string{"2025-01-31 22:00"}},
+		{rule: "FREQ=DAILY", wantCount: maxRecurrences},
+		{rule: "FREQ=HOURLY", wantErr: true},
+		{rule: "COUNT=3", wantErr: true},
+		{rule: "FREQ=DAILY;COUNT=0", wantErr: true},
+		{rule: "FREQ=DAILY;INTERVAL=x", wantErr: true},
+		{rule: "FREQ=DAILY;UNTIL=tomorrow", wantErr: true},
+	}
+	for _, tt := range tests {
+		windows, err := expandRRule(first, tt.rule)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("expandRRule(%s): ошибка %v", tt.rule, err)
+			continue
+		}
+		if tt.wantCount > 0 {
+			if len(windows) != tt.wantCount {
+				t.Errorf("expandRRule(%s): повторений %d, ожидалось %d", tt.rule, len(windows), tt.wantCount)
+			}
+			continue
+		}
+		if len(windows) != len(tt.wantStarts) {
+			t.Errorf("expandRRule(%s): повторений %d, ожидалось %d", tt.rule, len(windows), len(tt.wantStarts))
+			continue
+		}
+		for i, w := range windows {
+			if !w.Start.Equal(localTime(tt.wantStarts[i])) || w.End.Sub(w.Start) != 4*time.Hour {
+				t.Errorf("expandRRule(%s)[%d] = %v - %v, ожидалось начало %s", tt.rule, i, w.Start, w.End, tt.wantStarts[i])
+			}
+		}
+	}
+}
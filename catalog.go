@@ -0,0 +1,1241 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Таблицы каталога dbacker хранятся в той же базе (в схеме public, даже если бэкапятся другие схемы)
+// и исключаются из бэкапа
+const (
+	catalogPrefix         = "dbacker_"
+	catalogSchema         = "public."
+	catalogRunsTable      = catalogSchema + catalogPrefix + "runs"
+	catalogArtifactsTable = catalogSchema + catalogPrefix + "artifacts"
+	catalogRetentionTable = catalogSchema + catalogPrefix + "retention"
+	catalogReplicaTable   = catalogSchema + catalogPrefix + "replication"
+	catalogVersionTable   = catalogSchema + catalogPrefix + "schema_version"
+	catalogHoldsTable     = catalogSchema + catalogPrefix + "holds"
+	catalogHoldItemsTable = catalogSchema + catalogPrefix + "hold_items"
+	catalogHoldAuditTable = catalogSchema + catalogPrefix + "hold_audit"
+	catalogForgetTable    = catalogSchema + catalogPrefix + "forget_audit"
+	catalogLedgerTable    = catalogSchema + catalogPrefix + "ledger"
+	catalogAnchorsTable   = catalogSchema + catalogPrefix + "ledger_anchors"
+	catalogGuardsTable    = catalogSchema + catalogPrefix + "guards"
+)
+
+// catalogLedgerGuard функция триггера, запрещающего изменять и удалять записи журнала контрольных сумм
+const catalogLedgerGuard = catalogSchema + catalogPrefix + "ledger_append_only"
+
+// catalogTables все таблицы каталога в порядке, в котором их можно удалять (зависимые раньше)
+var catalogTables = []string{
+	catalogGuardsTable, catalogAnchorsTable, catalogLedgerTable, catalogForgetTable, catalogHoldItemsTable, catalogHoldAuditTable, catalogHoldsTable,
+	catalogReplicaTable, catalogRetentionTable, catalogArtifactsTable, catalogRunsTable, catalogVersionTable,
+}
+
+// Виды запусков в каталоге
+const (
+	runKindTables   = "tables"   // бэкап таблиц внутри базы
+	runKindDump     = "dump"     // дамп баз через pg_dump
+	runKindRestore  = "restore"  // восстановление из архива pg_dump
+	runKindCDC      = "cdc"      // файл изменений из слота логической репликации
+	runKindCluster  = "cluster"  // физический бэкап кластера через pg_basebackup
+	runKindSnapshot = "snapshot" // внеплановый бэкап одной таблицы (dbacker backup -table)
+)
+
+// Вид артефакта для таблицы бэкапа: Source - исходная таблица, Path - таблица бэкапа,
+// Size - размер исходной таблицы на момент копирования, SourceOID - oid исходной таблицы
+const artifactKindTable = "table"
+
+// Вид артефакта для HTML-отчета о запуске в report.dir: Source - база
+const artifactKindReport = "report"
+
+// runRecord запись каталога о запуске бэкапа
+type runRecord struct {
+	ID           int64
+	Kind         string
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	Status       string // "ok" или "failed"
+	TablesTotal  int    // количество обработанных объектов (таблиц или баз)
+	TablesFailed int
+	Error        string
+	DeletedCount int    // количество бэкапов, удаленных политикой хранения
+	DeletedBytes int64  // их суммарный размер
+	Schema       string // схема арендатора в режиме backup.schemas, пусто - public
+	Label        string // метка внепланового снимка
+}
+
+// Duration возвращает длительность запуска
+func (r *runRecord) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// artifact файл, созданный запуском (например, дамп базы), или его копия в удаленном хранилище
+type artifact struct {
+	ID          int64
+	RunID       int64
+	Kind        string
+	Source      string // база или таблица, из которой получен файл
+	Path        string // локальный путь или ключ в удаленном хранилище
+	Size        int64
+	CreatedAt   time.Time
+	Destination string // имя удаленного хранилища, пусто для локальных файлов
+	SourceOID   int64  // oid исходной таблицы для артефактов вида "table" (0 - неизвестен)
+	Manifest    *artifactManifest
+	Invalid     string // непройденные проверки backup.validations; пусто - бэкап прошел проверки или они не заданы
+}
+
+// catalogExists проверяет, создан ли каталог в базе
+func catalogExists(db *sql.DB) (bool, error) {
+	var exists bool
+	err := db.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, catalogRunsTable).Scan(&exists)
+	return exists, err
+}
+
+// recordRun сохраняет запись о запуске и созданные им файлы в каталог одной транзакцией: отчеты и status
+// видят запуск только целиком, со всеми результатами таблиц, даже если запуски идут параллельно
+func recordRun(db *sql.DB, r *runRecord, artifacts []artifact) error {
+	if err := ensureCatalog(db); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertRun(tx, r, artifacts); err != nil {
+		r.ID = 0
+		for i := range artifacts {
+			artifacts[i].ID, artifacts[i].RunID = 0, 0
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertRun добавляет запуск и его артефакты в транзакции tx
+func insertRun(tx *sql.Tx, r *runRecord, artifacts []artifact) error {
+	err := tx.QueryRow(`
+		INSERT INTO `+catalogRunsTable+` (kind, started_at, finished_at, status, tables_total, tables_failed, error, deleted_count, deleted_bytes, schema_name, label)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id`,
+		r.Kind, r.StartedAt, r.FinishedAt, r.Status, r.TablesTotal, r.TablesFailed, r.Error, r.DeletedCount, r.DeletedBytes, r.Schema, r.Label).Scan(&r.ID)
+	if err != nil {
+		return err
+	}
+
+	for i := range artifacts {
+		a := &artifacts[i]
+		var manifest sql.NullString
+		if a.Manifest != nil {
+			data, err := json.Marshal(a.Manifest)
+			if err != nil {
+				return err
+			}
+			manifest = sql.NullString{String: string(data), Valid: true}
+		}
+		a.RunID = r.ID
+		err := tx.QueryRow(`
+			INSERT INTO `+catalogArtifactsTable+` (run_id, kind, source, path, size_bytes, created_at, destination, source_oid, manifest, validation_error)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0), $9, $10)
+			RETURNING id`,
+			a.RunID, a.Kind, a.Source, a.Path, a.Size, a.CreatedAt, a.Destination, a.SourceOID, manifest, a.Invalid).Scan(&a.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markArtifactDeleted отмечает в каталоге, что локальный файл удален по политике хранения
+func markArtifactDeleted(db *sql.DB, path string) error {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return err
+	}
+	_, err = db.Exec(`
+		UPDATE `+catalogArtifactsTable+`
+		SET deleted_at = now()
+		WHERE path = $1 AND destination = '' AND deleted_at IS NULL`, path)
+	return err
+}
+
+// markTablesDeleted отмечает в каталоге, что таблицы бэкапа удалены (политикой хранения или бюджетом)
+func markTablesDeleted(db *sql.DB, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return err
+	}
+	_, err = db.Exec(`
+		UPDATE `+catalogArtifactsTable+`
+		SET deleted_at = now()
+		WHERE kind = $1 AND path = ANY($2) AND deleted_at IS NULL`, artifactKindTable, pq.Array(names))
+	return err
+}
+
+// markRemoteArtifactDeleted отмечает в каталоге, что копия в удаленном хранилище удалена
+func markRemoteArtifactDeleted(db *sql.DB, id int64) error {
+	_, err := db.Exec(`UPDATE `+catalogArtifactsTable+` SET deleted_at = now() WHERE id = $1`, id)
+	return err
+}
+
+// expiredRemoteArtifacts возвращает неудаленные копии в удаленных хранилищах, созданные раньше before
+func expiredRemoteArtifacts(db *sql.DB, before time.Time) ([]artifact, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, run_id, kind, source, path, size_bytes, created_at, destination
+		FROM `+catalogArtifactsTable+` a
+		WHERE destination <> '' AND deleted_at IS NULL AND created_at < $1
+		AND NOT EXISTS (
+			SELECT 1 FROM `+catalogHoldItemsTable+` i
+			JOIN `+catalogHoldsTable+` h ON h.id = i.hold_id
+			WHERE i.artifact_id = a.id AND h.released_at IS NULL)
+		ORDER BY created_at`, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var artifacts []artifact
+	for rows.Next() {
+		var a artifact
+		if err := rows.Scan(&a.ID, &a.RunID, &a.Kind, &a.Source, &a.Path, &a.Size, &a.CreatedAt, &a.Destination); err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, rows.Err()
+}
+
+// lastRuns возвращает последние запуски указанного вида в схеме schema (postgres.schema; у арендаторов
+// backup.schemas каталог общий, а история запусков у каждого своя) из каталога, от новых к старым
+func lastRuns(db *sql.DB, kind, schema string, limit int) ([]runRecord, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, kind, started_at, finished_at, status, tables_total, tables_failed, error, deleted_count, deleted_bytes, schema_name, label
+		FROM `+catalogRunsTable+`
+		WHERE kind = $1 AND schema_name = $2
+		ORDER BY started_at DESC
+		LIMIT $3`, kind, schema, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []runRecord
+	for rows.Next() {
+		var r runRecord
+		if err := rows.Scan(&r.ID, &r.Kind, &r.StartedAt, &r.FinishedAt, &r.Status, &r.TablesTotal, &r.TablesFailed, &r.Error, &r.DeletedCount, &r.DeletedBytes, &r.Schema, &r.Label); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// runKindStats сводка запусков одного вида (и схемы арендатора) для метрик Prometheus
+type runKindStats struct {
+	Kind, Schema  string
+	LastRun       time.Time     // окончание последнего запуска
+	LastSuccess   time.Time     // окончание последнего успешного запуска (нулевое, если таких нет)
+	FailureStreak int           // неудачных запусков подряд после последнего успешного
+	LastDuration  time.Duration // длительность последнего запуска
+	AvgDuration   time.Duration // средняя длительность предыдущих успешных запусков (0 - истории нет)
+}
+
+// runStats возвращает сводку запусков по видам и схемам арендаторов
+func runStats(db *sql.DB) ([]runKindStats, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		WITH last_ok AS (
+			SELECT kind, schema_name, max(started_at) AS started_at
+			FROM ` + catalogRunsTable + ` WHERE status = 'ok'
+			GROUP BY kind, schema_name
+		)
+		SELECT r.kind, r.schema_name, max(r.started_at), max(r.finished_at),
+			max(r.finished_at) FILTER (WHERE r.status = 'ok'),
+			count(*) FILTER (WHERE r.status <> 'ok' AND (o.started_at IS NULL OR r.started_at > o.started_at)),
+			(array_agg(extract(epoch FROM r.finished_at - r.started_at) ORDER BY r.started_at DESC))[1]
+		FROM ` + catalogRunsTable + ` r
+		LEFT JOIN last_ok o USING (kind, schema_name)
+		GROUP BY r.kind, r.schema_name
+		ORDER BY r.kind, r.schema_name`)
+	if err != nil {
+		return nil, err
+	}
+	var stats []runKindStats
+	var lastStarted []time.Time
+	for rows.Next() {
+		var st runKindStats
+		var started time.Time
+		var success sql.NullTime
+		var duration float64
+		if err := rows.Scan(&st.Kind, &st.Schema, &started, &st.LastRun, &success, &st.FailureStreak, &duration); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		st.LastSuccess = success.Time
+		st.LastDuration = time.Duration(duration * float64(time.Second))
+		stats = append(stats, st)
+		lastStarted = append(lastStarted, started)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Средняя длительность считается, как для уведомлений on_long_duration, по последним успешным запускам до текущего
+	for i := range stats {
+		var avg float64
+		err := db.QueryRow(`
+			SELECT coalesce(avg(d), 0) FROM (
+				SELECT extract(epoch FROM finished_at - started_at) AS d
+				FROM `+catalogRunsTable+`
+				WHERE kind = $1 AND schema_name = $2 AND status = 'ok' AND started_at < $3
+				ORDER BY started_at DESC
+				LIMIT $4
+			) h`, stats[i].Kind, stats[i].Schema, lastStarted[i], durationHistory).Scan(&avg)
+		if err != nil {
+			return nil, err
+		}
+		stats[i].AvgDuration = time.Duration(avg * float64(time.Second))
+	}
+	return stats, nil
+}
+
+// backupThroughput возвращает среднюю скорость последних limit успешных запусков бэкапа таблиц:
+// суммарный размер исходных таблиц, деленный на суммарную длительность запусков (вместе с выгрузками
+// и загрузкой в хранилища, поэтому оценка скорее занижена). Возвращает 0, если истории нет.
+func backupThroughput(db *sql.DB, limit int) (float64, int, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return 0, 0, err
+	}
+	var bytes, seconds float64
+	var runs int
+	err = db.QueryRow(`
+		SELECT coalesce(sum(size), 0), coalesce(sum(seconds), 0), count(*) FROM (
+			SELECT sum(a.size_bytes) AS size, extract(epoch FROM r.finished_at - r.started_at) AS seconds
+			FROM `+catalogRunsTable+` r
+			JOIN `+catalogArtifactsTable+` a ON a.run_id = r.id AND a.kind = $1
+			WHERE r.kind = $2 AND r.status = 'ok'
+			GROUP BY r.id
+			ORDER BY r.started_at DESC
+			LIMIT $3
+		) h`, artifactKindTable, runKindTables, limit).Scan(&bytes, &seconds, &runs)
+	if err != nil || seconds <= 0 {
+		return 0, runs, err
+	}
+	return bytes / seconds, runs, nil
+}
+
+// retentionOverride продление хранения отдельного бэкапа сверх обычной политики
+type retentionOverride struct {
+	Backup    string // имя таблицы бэкапа или путь к файлу
+	Until     time.Time
+	Reason    string
+	CreatedAt time.Time
+}
+
+// setRetentionOverride сохраняет в каталог продление хранения бэкапа до указанной даты
+func setRetentionOverride(db *sql.DB, o retentionOverride) error {
+	if err := ensureCatalog(db); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT INTO `+catalogRetentionTable+` (backup, until, reason)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (backup) DO UPDATE SET until = EXCLUDED.until, reason = EXCLUDED.reason, created_at = now()`,
+		o.Backup, o.Until.Format("2006-01-02"), o.Reason)
+	return err
+}
+
+// listRetentionOverrides возвращает все продления хранения, отсортированные по дате окончания
+func listRetentionOverrides(db *sql.DB) ([]retentionOverride, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT backup, until, reason, created_at
+		FROM ` + catalogRetentionTable + `
+		ORDER BY until, backup`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overrides []retentionOverride
+	for rows.Next() {
+		var o retentionOverride
+		if err := rows.Scan(&o.Backup, &o.Until, &o.Reason, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
+
+// retentionOverrides возвращает даты, до которых продлено хранение бэкапов, по имени бэкапа
+func retentionOverrides(db *sql.DB) (map[string]time.Time, error) {
+	overrides, err := listRetentionOverrides(db)
+	if err != nil {
+		return nil, err
+	}
+	retained := make(map[string]time.Time, len(overrides))
+	for _, o := range overrides {
+		retained[o.Backup] = o.Until
+	}
+	// Бэкапы под юридическим удержанием хранятся бессрочно, пока удержание не снято
+	held, err := heldBackups(db)
+	if err != nil {
+		return nil, err
+	}
+	for backup := range held {
+		retained[backup] = heldUntil
+	}
+	return retained, nil
+}
+
+// isRetained проверяет, что продление хранения до until еще действует (включая сам день until)
+func isRetained(until time.Time) bool {
+	return until.Format("20060102") >= clock.Now().Format("20060102")
+}
+
+// pendingReplications возвращает неудаленные копии в хранилище source, еще не реплицированные в target
+func pendingReplications(db *sql.DB, source, target string) ([]artifact, error) {
+	rows, err := db.Query(`
+		SELECT a.id, a.run_id, a.kind, a.source, a.path, a.size_bytes, a.created_at, a.destination
+		FROM `+catalogArtifactsTable+` a
+		WHERE a.destination = $1 AND a.deleted_at IS NULL
+		AND NOT EXISTS (
+			SELECT 1 FROM `+catalogReplicaTable+` r
+			WHERE r.artifact_id = a.id AND r.destination = $2 AND r.status = 'ok')
+		ORDER BY a.created_at`, source, target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var artifacts []artifact
+	for rows.Next() {
+		var a artifact
+		if err := rows.Scan(&a.ID, &a.RunID, &a.Kind, &a.Source, &a.Path, &a.Size, &a.CreatedAt, &a.Destination); err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, rows.Err()
+}
+
+// recordReplication сохраняет результат репликации копии a в хранилище target.
+// Успешная реплика регистрируется как отдельная копия с исходным временем создания,
+// чтобы политика хранения удаляла ее вместе с оригиналом.
+func recordReplication(db *sql.DB, a artifact, target string, replErr error) error {
+	status, msg := "ok", ""
+	if replErr != nil {
+		status, msg = "failed", replErr.Error()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO `+catalogReplicaTable+` (artifact_id, destination, status, attempts, error, updated_at)
+		VALUES ($1, $2, $3, 1, $4, now())
+		ON CONFLICT (artifact_id, destination) DO UPDATE
+		SET status = EXCLUDED.status, attempts = `+catalogReplicaTable+`.attempts + 1,
+			error = EXCLUDED.error, updated_at = now()`,
+		a.ID, target, status, msg)
+	if err != nil {
+		return err
+	}
+	if replErr == nil {
+		_, err = tx.Exec(`
+			INSERT INTO `+catalogArtifactsTable+` (run_id, kind, source, path, size_bytes, created_at, destination, manifest)
+			SELECT run_id, kind, source, path, size_bytes, created_at, $2, manifest
+			FROM `+catalogArtifactsTable+` WHERE id = $1`,
+			a.ID, target)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// runArtifacts возвращает локальные артефакты запуска указанного вида
+func runArtifacts(db *sql.DB, runID int64, kind string) ([]artifact, error) {
+	rows, err := db.Query(`
+		SELECT id, run_id, kind, source, path, size_bytes, created_at, destination
+		FROM `+catalogArtifactsTable+`
+		WHERE run_id = $1 AND kind = $2 AND destination = ''
+		ORDER BY source`, runID, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var artifacts []artifact
+	for rows.Next() {
+		var a artifact
+		if err := rows.Scan(&a.ID, &a.RunID, &a.Kind, &a.Source, &a.Path, &a.Size, &a.CreatedAt, &a.Destination); err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, rows.Err()
+}
+
+// lastTableNames возвращает последнее известное каталогу имя исходной таблицы по ее oid
+func lastTableNames(db *sql.DB) (map[int64]string, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT DISTINCT ON (source_oid) source_oid, source
+		FROM `+catalogArtifactsTable+`
+		WHERE kind = $1 AND source_oid IS NOT NULL
+		ORDER BY source_oid, created_at DESC`, artifactKindTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[int64]string)
+	for rows.Next() {
+		var oid int64
+		var name string
+		if err := rows.Scan(&oid, &name); err != nil {
+			return nil, err
+		}
+		names[oid] = name
+	}
+	return names, rows.Err()
+}
+
+// renameTableArtifacts переносит в каталоге историю бэкапов таблицы со старого имени на новое:
+// артефакты вида "table" и продления хранения переименованных таблиц бэкапа
+func renameTableArtifacts(db *sql.DB, renamed map[string]string, oldSource, newSource string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for oldName, newName := range renamed {
+		_, err := tx.Exec(`
+			UPDATE `+catalogArtifactsTable+` SET source = $1, path = $2
+			WHERE kind = $3 AND source = $4 AND path = $5`,
+			newSource, newName, artifactKindTable, oldSource, oldName)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE `+catalogRetentionTable+` SET backup = $1 WHERE backup = $2`, newName, oldName); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// lastTableBackups возвращает время последнего успешного бэкапа каждой исходной таблицы схемы арендатора schema
+// (пусто - схема public). Артефакты вида "table" записываются только для успешно скопированных таблиц;
+// бэкапы, не прошедшие проверки backup.validations, не учитываются.
+func lastTableBackups(db *sql.DB, schema string) (map[string]time.Time, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT a.source, max(a.created_at)
+		FROM `+catalogArtifactsTable+` a
+		JOIN `+catalogRunsTable+` r ON r.id = a.run_id
+		WHERE a.kind = $1 AND a.destination = '' AND r.schema_name = $2 AND a.validation_error = ''
+		GROUP BY a.source`, artifactKindTable, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	last := make(map[string]time.Time)
+	for rows.Next() {
+		var source string
+		var at time.Time
+		if err := rows.Scan(&source, &at); err != nil {
+			return nil, err
+		}
+		last[source] = at
+	}
+	return last, rows.Err()
+}
+
+// invalidBackups возвращает неудаленные таблицы бэкапа, не прошедшие проверки backup.validations
+func invalidBackups(db *sql.DB) ([]artifact, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, run_id, source, path, created_at, validation_error
+		FROM `+catalogArtifactsTable+`
+		WHERE kind = $1 AND deleted_at IS NULL AND validation_error <> ''
+		ORDER BY created_at, path`, artifactKindTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invalid []artifact
+	for rows.Next() {
+		a := artifact{Kind: artifactKindTable}
+		if err := rows.Scan(&a.ID, &a.RunID, &a.Source, &a.Path, &a.CreatedAt, &a.Invalid); err != nil {
+			return nil, err
+		}
+		invalid = append(invalid, a)
+	}
+	return invalid, rows.Err()
+}
+
+// storedArtifactSizes возвращает суммарный размер неудаленных файлов по хранилищам (пусто - локальные файлы)
+func storedArtifactSizes(db *sql.DB) (map[string]int64, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT destination, sum(size_bytes)::bigint
+		FROM `+catalogArtifactsTable+`
+		WHERE kind <> $1 AND deleted_at IS NULL
+		GROUP BY destination`, artifactKindTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]int64)
+	for rows.Next() {
+		var destination string
+		var size int64
+		if err := rows.Scan(&destination, &size); err != nil {
+			return nil, err
+		}
+		sizes[destination] = size
+	}
+	return sizes, rows.Err()
+}
+
+// dailySize суммарный размер бэкапов, созданных за день
+type dailySize struct {
+	Date time.Time
+	Size int64
+}
+
+// tableSizeHistory возвращает по дням размеры бэкапов каждой исходной таблицы, созданных начиная с since
+func tableSizeHistory(db *sql.DB, since time.Time) (map[string][]dailySize, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT source, created_at::date, sum(size_bytes)::bigint
+		FROM `+catalogArtifactsTable+`
+		WHERE kind = $1 AND destination = '' AND created_at >= $2
+		GROUP BY 1, 2
+		ORDER BY 1, 2`, artifactKindTable, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := make(map[string][]dailySize)
+	for rows.Next() {
+		var source string
+		var d dailySize
+		if err := rows.Scan(&source, &d.Date, &d.Size); err != nil {
+			return nil, err
+		}
+		history[source] = append(history[source], d)
+	}
+	return history, rows.Err()
+}
+
+// legalHold юридическое удержание набора бэкапов
+type legalHold struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Reason     string     `json:"reason"`
+	Criteria   string     `json:"criteria"` // условия отбора бэкапов в читаемом виде
+	CreatedAt  time.Time  `json:"created_at"`
+	CreatedBy  string     `json:"created_by"`
+	ReleasedAt *time.Time `json:"released_at"` // nil, пока удержание действует
+	ReleasedBy string     `json:"released_by"`
+	Items      int        `json:"items"` // количество удерживаемых артефактов
+}
+
+// holdCriteria условия отбора бэкапов для удержания; пустые условия не ограничивают выбор
+type holdCriteria struct {
+	Table string    // исходная таблица (артефакты с таким source)
+	Label string    // метка снимка (runs.label)
+	From  time.Time // с даты включительно
+	To    time.Time // по дату включительно
+}
+
+// holdCandidates возвращает неудаленные артефакты (таблицы, файлы и их копии в хранилищах), подходящие под условия
+func holdCandidates(db *sql.DB, c holdCriteria) ([]artifact, error) {
+	from, to := c.From, c.To
+	if from.IsZero() {
+		from = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if to.IsZero() {
+		to = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+	}
+
+	rows, err := db.Query(`
+		SELECT a.id, a.run_id, a.kind, a.source, a.path, a.size_bytes, a.created_at, a.destination
+		FROM `+catalogArtifactsTable+` a
+		JOIN `+catalogRunsTable+` r ON r.id = a.run_id
+		WHERE a.deleted_at IS NULL
+		AND ($1 = '' OR a.source = $1)
+		AND ($2 = '' OR r.label = $2)
+		AND a.created_at::date BETWEEN $3::date AND $4::date
+		ORDER BY a.created_at, a.id`,
+		c.Table, c.Label, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var artifacts []artifact
+	for rows.Next() {
+		var a artifact
+		if err := rows.Scan(&a.ID, &a.RunID, &a.Kind, &a.Source, &a.Path, &a.Size, &a.CreatedAt, &a.Destination); err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, rows.Err()
+}
+
+// createHold сохраняет удержание с артефактами items и записывает действие в журнал
+func createHold(db *sql.DB, h *legalHold, items []artifact) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO `+catalogHoldsTable+` (name, reason, criteria, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`,
+		h.Name, h.Reason, h.Criteria, h.CreatedBy).Scan(&h.ID, &h.CreatedAt)
+	if err != nil {
+		return err
+	}
+	for _, a := range items {
+		if _, err := tx.Exec(`INSERT INTO `+catalogHoldItemsTable+` (hold_id, artifact_id) VALUES ($1, $2)`, h.ID, a.ID); err != nil {
+			return err
+		}
+	}
+	h.Items = len(items)
+	details := fmt.Sprintf("%s; бэкапов: %d; причина: %s", h.Criteria, len(items), h.Reason)
+	if err := auditHold(tx, h.Name, "hold", h.CreatedBy, details); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// releaseHold снимает действующее удержание и возвращает его артефакты, которые не удерживаются другими удержаниями
+func releaseHold(db *sql.DB, name, actor string) ([]artifact, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`
+		UPDATE `+catalogHoldsTable+` SET released_at = now(), released_by = $2
+		WHERE name = $1 AND released_at IS NULL
+		RETURNING id`, name, actor).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("действующее удержание %s не найдено", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT a.id, a.run_id, a.kind, a.source, a.path, a.size_bytes, a.created_at, a.destination
+		FROM `+catalogHoldItemsTable+` i
+		JOIN `+catalogArtifactsTable+` a ON a.id = i.artifact_id
+		WHERE i.hold_id = $1 AND a.deleted_at IS NULL
+		AND NOT EXISTS (
+			SELECT 1 FROM `+catalogHoldItemsTable+` o
+			JOIN `+catalogHoldsTable+` h ON h.id = o.hold_id
+			WHERE o.artifact_id = a.id AND h.released_at IS NULL)
+		ORDER BY a.id`, id)
+	if err != nil {
+		return nil, err
+	}
+	var released []artifact
+	for rows.Next() {
+		var a artifact
+		if err := rows.Scan(&a.ID, &a.RunID, &a.Kind, &a.Source, &a.Path, &a.Size, &a.CreatedAt, &a.Destination); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		released = append(released, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := auditHold(tx, name, "release", actor, fmt.Sprintf("освобождено бэкапов: %d", len(released))); err != nil {
+		return nil, err
+	}
+	return released, tx.Commit()
+}
+
+// auditHold записывает действие с удержанием в журнал
+func auditHold(tx *sql.Tx, name, action, actor, details string) error {
+	_, err := tx.Exec(`
+		INSERT INTO `+catalogHoldAuditTable+` (hold_name, action, actor, details)
+		VALUES ($1, $2, $3, $4)`, name, action, actor, details)
+	return err
+}
+
+// listHolds возвращает все удержания, от новых к старым, с количеством удерживаемых артефактов
+func listHolds(db *sql.DB) ([]legalHold, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT h.id, h.name, h.reason, h.criteria, h.created_at, h.created_by, h.released_at, h.released_by,
+			(SELECT count(*) FROM ` + catalogHoldItemsTable + ` i WHERE i.hold_id = h.id)
+		FROM ` + catalogHoldsTable + ` h
+		ORDER BY h.created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var holds []legalHold
+	for rows.Next() {
+		var h legalHold
+		if err := rows.Scan(&h.ID, &h.Name, &h.Reason, &h.Criteria, &h.CreatedAt, &h.CreatedBy,
+			&h.ReleasedAt, &h.ReleasedBy, &h.Items); err != nil {
+			return nil, err
+		}
+		holds = append(holds, h)
+	}
+	return holds, rows.Err()
+}
+
+// holdAuditEntry запись журнала удержаний
+type holdAuditEntry struct {
+	HoldName string
+	Action   string // "hold" или "release"
+	Actor    string
+	At       time.Time
+	Details  string
+}
+
+// holdAudit возвращает журнал действий с удержаниями в хронологическом порядке
+func holdAudit(db *sql.DB) ([]holdAuditEntry, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT hold_name, action, actor, at, details FROM ` + catalogHoldAuditTable + ` ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []holdAuditEntry
+	for rows.Next() {
+		var e holdAuditEntry
+		if err := rows.Scan(&e.HoldName, &e.Action, &e.Actor, &e.At, &e.Details); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// heldUntil срок хранения, который retentionOverrides возвращает для бэкапов под удержанием
+var heldUntil = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// heldBackups возвращает имена таблиц бэкапа и пути локальных файлов под действующими удержаниями
+func heldBackups(db *sql.DB) (map[string]bool, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT DISTINCT a.path
+		FROM ` + catalogHoldItemsTable + ` i
+		JOIN ` + catalogHoldsTable + ` h ON h.id = i.hold_id
+		JOIN ` + catalogArtifactsTable + ` a ON a.id = i.artifact_id
+		WHERE h.released_at IS NULL AND a.destination = ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	held := make(map[string]bool)
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		held[path] = true
+	}
+	return held, rows.Err()
+}
+
+// forgetEntry запись журнала удаления строк из бэкапов
+type forgetEntry struct {
+	Request   string // номер обращения, например GDPR-2024-17
+	Table     string
+	Predicate string
+	Backups   int    // бэкапов, из которых удалялись строки
+	Rows      int64  // удалено строк
+	Skipped   string // бэкапы под юридическим удержанием, которые не изменялись
+	Actor     string
+	At        time.Time
+}
+
+// recordForget записывает удаление строк в журнал
+func recordForget(db *sql.DB, e *forgetEntry) error {
+	if err := ensureCatalog(db); err != nil {
+		return err
+	}
+	return db.QueryRow(`
+		INSERT INTO `+catalogForgetTable+` (request, table_name, predicate, backups, rows, skipped, actor)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING at`, e.Request, e.Table, e.Predicate, e.Backups, e.Rows, e.Skipped, e.Actor).Scan(&e.At)
+}
+
+// forgetAudit возвращает журнал удаления строк в хронологическом порядке
+func forgetAudit(db *sql.DB) ([]forgetEntry, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT request, table_name, predicate, backups, rows, skipped, actor, at
+		FROM ` + catalogForgetTable + ` ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []forgetEntry
+	for rows.Next() {
+		var e forgetEntry
+		if err := rows.Scan(&e.Request, &e.Table, &e.Predicate, &e.Backups, &e.Rows, &e.Skipped, &e.Actor, &e.At); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// liveFileArtifacts возвращает неудаленные файлы (выгрузки, дампы) и их копии в хранилищах,
+// полученные из указанных таблиц или баз
+func liveFileArtifacts(db *sql.DB, sources ...string) ([]artifact, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, run_id, kind, source, path, size_bytes, created_at, destination
+		FROM `+catalogArtifactsTable+`
+		WHERE kind <> ALL($1) AND source = ANY($2) AND deleted_at IS NULL
+		ORDER BY created_at`, pq.Array([]string{artifactKindTable, runKindRestore}), pq.Array(sources))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var artifacts []artifact
+	for rows.Next() {
+		var a artifact
+		if err := rows.Scan(&a.ID, &a.RunID, &a.Kind, &a.Source, &a.Path, &a.Size, &a.CreatedAt, &a.Destination); err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, rows.Err()
+}
+
+// ledgerEntry запись журнала контрольных сумм. EntryHash - sha256 от полей записи вместе с PrevHash,
+// поэтому изменение или удаление любой записи нарушает цепочку всех последующих
+type ledgerEntry struct {
+	Seq        int64
+	RecordedAt time.Time
+	Event      string // ledgerEventBackup или ledgerEventForget
+	Backup     string // таблица бэкапа
+	Source     string // исходная таблица
+	Checksum   string // md5/строк содержимого бэкапа (tableHash)
+	PrevHash   string
+	EntryHash  string
+}
+
+// ledgerAnchor заверение записи журнала службой меток времени RFC 3161
+type ledgerAnchor struct {
+	Seq        int64
+	EntryHash  string
+	Service    string // адрес службы
+	Token      []byte // ответ службы (TimeStampResp) как есть
+	AnchoredAt time.Time
+}
+
+// appendLedger добавляет записи в конец журнала контрольных сумм, заполняя номера и хеши цепочки (chainLedger).
+// Таблица журнала блокируется на время добавления, поэтому одновременные запуски не разветвляют цепочку.
+func appendLedger(db *sql.DB, entries []ledgerEntry) error {
+	if err := ensureCatalog(db); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`LOCK TABLE ` + catalogLedgerTable + ` IN EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+	var seq int64
+	var prev string
+	err = tx.QueryRow(`SELECT seq, entry_hash FROM `+catalogLedgerTable+` ORDER BY seq DESC LIMIT 1`).Scan(&seq, &prev)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	chainLedger(entries, seq, prev)
+	for _, e := range entries {
+		_, err := tx.Exec(`
+			INSERT INTO `+catalogLedgerTable+` (seq, recorded_at, event, backup, source, checksum, prev_hash, entry_hash)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			e.Seq, e.RecordedAt, e.Event, e.Backup, e.Source, e.Checksum, e.PrevHash, e.EntryHash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// chainLedger заполняет номера, время и хеши новых записей журнала, продолжая цепочку
+// после записи с номером seq и хешем prev
+func chainLedger(entries []ledgerEntry, seq int64, prev string) {
+	for i := range entries {
+		e := &entries[i]
+		seq++
+		e.Seq, e.PrevHash = seq, prev
+		// Время хранится в PostgreSQL с точностью до микросекунд, хеш считается по нему же
+		e.RecordedAt = clock.Now().UTC().Truncate(time.Microsecond)
+		e.EntryHash = ledgerHash(e)
+		prev = e.EntryHash
+	}
+}
+
+// ledgerEntries возвращает журнал контрольных сумм по возрастанию номера
+func ledgerEntries(db *sql.DB) ([]ledgerEntry, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT seq, recorded_at, event, backup, source, checksum, prev_hash, entry_hash
+		FROM ` + catalogLedgerTable + ` ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ledgerEntry
+	for rows.Next() {
+		var e ledgerEntry
+		if err := rows.Scan(&e.Seq, &e.RecordedAt, &e.Event, &e.Backup, &e.Source, &e.Checksum, &e.PrevHash, &e.EntryHash); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// recordAnchor сохраняет заверение записи журнала
+func recordAnchor(db *sql.DB, a *ledgerAnchor) error {
+	return db.QueryRow(`
+		INSERT INTO `+catalogAnchorsTable+` (seq, entry_hash, service, token)
+		VALUES ($1, $2, $3, $4)
+		RETURNING anchored_at`, a.Seq, a.EntryHash, a.Service, a.Token).Scan(&a.AnchoredAt)
+}
+
+// ledgerAnchors возвращает заверения журнала по возрастанию номера записи
+func ledgerAnchors(db *sql.DB) ([]ledgerAnchor, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT seq, entry_hash, service, token, anchored_at
+		FROM ` + catalogAnchorsTable + ` ORDER BY seq, anchored_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var anchors []ledgerAnchor
+	for rows.Next() {
+		var a ledgerAnchor
+		if err := rows.Scan(&a.Seq, &a.EntryHash, &a.Service, &a.Token, &a.AnchoredAt); err != nil {
+			return nil, err
+		}
+		anchors = append(anchors, a)
+	}
+	return anchors, rows.Err()
+}
+
+// liveTableBackups возвращает таблицы бэкапа, которые по каталогу не удалены
+func liveTableBackups(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(`
+		SELECT path FROM `+catalogArtifactsTable+`
+		WHERE kind = $1 AND deleted_at IS NULL`, artifactKindTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	live := make(map[string]bool)
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		live[path] = true
+	}
+	return live, rows.Err()
+}
+
+// backupSources возвращает исходные таблицы бэкапов по каталогу: имя таблицы бэкапа -> исходная таблица
+func backupSources(db *sql.DB) (map[string]string, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT DISTINCT ON (path) path, source FROM `+catalogArtifactsTable+`
+		WHERE kind = $1
+		ORDER BY path, created_at DESC`, artifactKindTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := make(map[string]string)
+	for rows.Next() {
+		var path, source string
+		if err := rows.Scan(&path, &source); err != nil {
+			return nil, err
+		}
+		sources[path] = source
+	}
+	return sources, rows.Err()
+}
+
+// guardEntry страховочная копия таблицы (dbacker guard), которая удаляется по истечении срока
+type guardEntry struct {
+	Backup    string
+	Source    string
+	ExpiresAt time.Time
+	Actor     string
+	CreatedAt time.Time
+}
+
+// recordGuard записывает страховочную копию в каталог
+func recordGuard(db *sql.DB, g *guardEntry) error {
+	if err := ensureCatalog(db); err != nil {
+		return err
+	}
+	return db.QueryRow(`
+		INSERT INTO `+catalogGuardsTable+` (backup, source, expires_at, actor)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at`, g.Backup, g.Source, g.ExpiresAt, g.Actor).Scan(&g.CreatedAt)
+}
+
+// listGuards возвращает неудаленные страховочные копии по возрастанию срока; при expired - только истекшие
+func listGuards(db *sql.DB, expired bool) ([]guardEntry, error) {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT backup, source, expires_at, actor, created_at
+		FROM `+catalogGuardsTable+`
+		WHERE dropped_at IS NULL AND (NOT $1 OR expires_at <= $2)
+		ORDER BY expires_at`, expired, clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var guards []guardEntry
+	for rows.Next() {
+		var g guardEntry
+		if err := rows.Scan(&g.Backup, &g.Source, &g.ExpiresAt, &g.Actor, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		guards = append(guards, g)
+	}
+	return guards, rows.Err()
+}
+
+// markGuardDropped отмечает, что таблица страховочной копии удалена
+func markGuardDropped(db *sql.DB, backup string) error {
+	_, err := db.Exec(`UPDATE `+catalogGuardsTable+` SET dropped_at = now() WHERE backup = $1`, backup)
+	return err
+}
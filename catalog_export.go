@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func init() {
+	commands["catalog"] = command{
+		Usage: "Backup catalog tools (catalog export / catalog import <file>)",
+		Run:   cmdCatalog,
+	}
+}
+
+// Признак файла выгрузки каталога
+const catalogExportFormat = "dbacker-catalog"
+
+// catalogExport переносимая выгрузка каталога: строки каждой таблицы в JSON по имени таблицы без схемы
+type catalogExport struct {
+	Format     string                       `json:"format"`
+	Version    int                          `json:"version"` // версия схемы каталога на момент выгрузки
+	ExportedAt time.Time                    `json:"exported_at"`
+	Database   string                       `json:"database"`
+	Tables     map[string][]json.RawMessage `json:"tables"`
+}
+
+// catalogDataTables таблицы каталога с данными в порядке загрузки (сначала те, на которые ссылаются)
+func catalogDataTables() []string {
+	var tables []string
+	for i := len(catalogTables) - 1; i >= 0; i-- {
+		if catalogTables[i] != catalogVersionTable {
+			tables = append(tables, catalogTables[i])
+		}
+	}
+	return tables
+}
+
+// cmdCatalog выполняет подкоманды переноса каталога
+func cmdCatalog(args []string) error {
+	fs := flag.NewFlagSet("catalog", flag.ExitOnError)
+	run := fs.Bool("run", false, "Normal run instead of test run? (import)")
+	output := fs.String("o", "", "Write the export to this file instead of stdout (export)")
+	positional := parseArgs(fs, args)
+
+	usage := fmt.Errorf("использование: dbacker catalog export [-o file] | dbacker catalog import <file> [-run]")
+	if len(positional) == 0 {
+		return usage
+	}
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch {
+	case positional[0] == "export" && len(positional) == 1:
+		return exportCatalog(db, config, *output)
+	case positional[0] == "import" && len(positional) == 2:
+		return importCatalog(db, positional[1], *run)
+	default:
+		return usage
+	}
+}
+
+// exportCatalog выгружает все таблицы каталога в JSON-файл (или stdout), который можно загрузить на другом сервере
+func exportCatalog(db *sql.DB, config *Config, output string) error {
+	if err := ensureCatalog(db); err != nil {
+		return err
+	}
+
+	export := catalogExport{
+		Format:     catalogExportFormat,
+		Version:    len(catalogMigrations),
+		ExportedAt: clock.Now(),
+		Database:   config.Postgres.DBName,
+		Tables:     make(map[string][]json.RawMessage),
+	}
+	for _, table := range catalogDataTables() {
+		var data []byte
+		err := db.QueryRow(`SELECT coalesce(json_agg(t), '[]') FROM ` + table + ` t`).Scan(&data)
+		if err != nil {
+			return fmt.Errorf("ошибка чтения %s: %w", table, err)
+		}
+		var rows []json.RawMessage
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return err
+		}
+		export.Tables[strings.TrimPrefix(table, catalogSchema)] = rows
+	}
+
+	out := os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(export); err != nil {
+		return err
+	}
+	if output != "" {
+		for _, name := range sortedKeys(export.Tables) {
+			log.Printf("Выгружено %s: %d строк", name, len(export.Tables[name]))
+		}
+		return out.Close()
+	}
+	return nil
+}
+
+// importCatalog загружает выгрузку каталога в пустой каталог этой базы, сохраняя идентификаторы записей.
+// Столбцы, которых не было в версии выгрузки, получают значения по умолчанию.
+func importCatalog(db *sql.DB, path string, realRun bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var export catalogExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return fmt.Errorf("ошибка разбора выгрузки каталога: %w", err)
+	}
+	if export.Format != catalogExportFormat {
+		return fmt.Errorf("%s не является выгрузкой каталога dbacker", path)
+	}
+	if export.Version > len(catalogMigrations) {
+		return fmt.Errorf("выгрузка сделана из каталога версии %d, эта версия dbacker поддерживает до %d",
+			export.Version, len(catalogMigrations))
+	}
+	log.Printf("Выгрузка каталога базы %s от %s, версия схемы %d",
+		export.Database, export.ExportedAt.Local().Format("2006-01-02 15:04:05"), export.Version)
+
+	if err := ensureCatalog(db); err != nil {
+		return err
+	}
+	var runs int
+	if err := db.QueryRow(`SELECT count(*) FROM ` + catalogRunsTable).Scan(&runs); err != nil {
+		return err
+	}
+	if runs > 0 {
+		return fmt.Errorf("каталог этой базы не пуст (%d запусков), загрузка возможна только в пустой каталог", runs)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range catalogDataTables() {
+		rows := export.Tables[strings.TrimPrefix(table, catalogSchema)]
+		if len(rows) > 0 {
+			if err := importCatalogTable(tx, table, rows); err != nil {
+				return fmt.Errorf("ошибка загрузки %s: %w", table, err)
+			}
+		}
+		log.Printf("Загружено %s: %d строк", table, len(rows))
+	}
+
+	// Последовательности идентификаторов продолжаются после загруженных записей
+	for _, table := range []string{catalogRunsTable, catalogArtifactsTable, catalogHoldsTable, catalogHoldAuditTable} {
+		_, err := tx.Exec(`SELECT setval(pg_get_serial_sequence($1, 'id'), coalesce(max(id), 0) + 1, false) FROM `+table, table)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !realRun {
+		log.Println("Тестовый запуск, каталог не изменен")
+		return nil
+	}
+	return tx.Commit()
+}
+
+// importCatalogTable вставляет строки выгрузки в таблицу каталога. Загружаются только столбцы,
+// которые есть и в выгрузке, и в таблице, поэтому выгрузки старых версий загружаются в новую схему.
+func importCatalogTable(tx *sql.Tx, table string, rows []json.RawMessage) error {
+	exported := make(map[string]bool)
+	for _, row := range rows {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(row, &fields); err != nil {
+			return err
+		}
+		for name := range fields {
+			exported[name] = true
+		}
+	}
+
+	existing, err := tx.Query(`
+		SELECT attname FROM pg_attribute
+		WHERE attrelid = $1::regclass AND attnum > 0 AND NOT attisdropped`, table)
+	if err != nil {
+		return err
+	}
+	var columns []string
+	for existing.Next() {
+		var name string
+		if err := existing.Scan(&name); err != nil {
+			existing.Close()
+			return err
+		}
+		if exported[name] {
+			columns = append(columns, pq.QuoteIdentifier(name))
+		}
+	}
+	existing.Close()
+	if err := existing.Err(); err != nil {
+		return err
+	}
+	sort.Strings(columns)
+
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	list := strings.Join(columns, ", ")
+	_, err = tx.Exec(fmt.Sprintf(`INSERT INTO %s (%s) SELECT %s FROM json_populate_recordset(NULL::%s, $1)`,
+		table, list, list, table), string(data))
+	return err
+}
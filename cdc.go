@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// CDCConfig настройки непрерывного архивирования изменений через слот логической репликации
+type CDCConfig struct {
+	Slot       string   `json:"slot"`        // Имя слота логической репликации (по умолчанию "dbacker_cdc")
+	Plugin     string   `json:"plugin"`      // Плагин декодирования: "wal2json" (по умолчанию) или "test_decoding"
+	Dir        string   `json:"dir"`         // Каталог для файлов изменений (по умолчанию "cdc")
+	Interval   Duration `json:"interval"`    // Пауза между опросами слота (по умолчанию "10s")
+	MaxChanges int      `json:"max_changes"` // Максимум изменений за один опрос (по умолчанию 10000)
+	Rotate     Duration `json:"rotate"`      // Как часто начинать новый файл (по умолчанию "1h")
+}
+
+func init() {
+	commands["cdc"] = command{
+		Usage: "Continuously archive row changes from a logical replication slot",
+		Run:   cmdCDC,
+	}
+}
+
+// cdcChange одно изменение из слота в файле архива (JSON Lines)
+type cdcChange struct {
+	LSN  string          `json:"lsn"`
+	XID  int64           `json:"xid"`
+	Data json.RawMessage `json:"data"`
+}
+
+// cmdCDC читает изменения из слота логической репликации и пишет их в файлы, которые затем
+// регистрируются в каталоге и загружаются в удаленные хранилища так же, как дампы
+func cmdCDC(args []string) error {
+	fs := flag.NewFlagSet("cdc", flag.ExitOnError)
+	run := fs.Bool("run", false, "Normal run instead of test run?")
+	once := fs.Bool("once", false, "Exit when the slot has no more changes instead of polling forever")
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	cfg := &config.CDC
+
+	if !*run {
+		var pending int
+		err := db.QueryRow(`SELECT count(*) FROM pg_logical_slot_peek_changes($1, NULL, NULL)`, cfg.Slot).Scan(&pending)
+		if err != nil {
+			return fmt.Errorf("ошибка чтения слота %s: %w", cfg.Slot, err)
+		}
+		log.Printf("Тестовый запуск, в слоте %s изменений: %d", cfg.Slot, pending)
+		return nil
+	}
+
+	if err := ensureReplicationSlot(db, cfg); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return fmt.Errorf("ошибка создания каталога изменений: %w", err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	w := &cdcWriter{db: db, config: config}
+	defer w.close()
+	for {
+		n, err := w.poll()
+		if err != nil {
+			return err
+		}
+		if *once && n == 0 {
+			return nil
+		}
+		if clock.Now().Sub(w.openedAt) >= time.Duration(cfg.Rotate) {
+			if err := w.close(); err != nil {
+				return err
+			}
+		}
+		if n == cfg.MaxChanges {
+			// в слоте есть еще изменения, читаем без паузы
+			continue
+		}
+
+		select {
+		case <-stop:
+			log.Println("cdc stopped")
+			return nil
+		case <-time.After(time.Duration(cfg.Interval)):
+		}
+	}
+}
+
+// ensureReplicationSlot создает слот логической репликации, если его еще нет
+func ensureReplicationSlot(db *sql.DB, cfg *CDCConfig) error {
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)`, cfg.Slot).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = db.Exec(`SELECT pg_create_logical_replication_slot($1, $2)`, cfg.Slot, cfg.Plugin)
+	if err != nil {
+		return fmt.Errorf("ошибка создания слота %s: %w", cfg.Slot, err)
+	}
+	log.Printf("Создан слот логической репликации %s (%s)", cfg.Slot, cfg.Plugin)
+	return nil
+}
+
+// cdcWriter пишет изменения в текущий файл архива и закрывает его по расписанию ротации
+type cdcWriter struct {
+	db       *sql.DB
+	config   *Config
+	file     *os.File
+	buf      *bufio.Writer
+	path     string
+	openedAt time.Time
+	changes  int
+}
+
+// poll читает очередную порцию изменений, записывает их в файл и только после записи на диск
+// продвигает слот, чтобы изменения не терялись при сбое
+func (w *cdcWriter) poll() (int, error) {
+	cfg := &w.config.CDC
+	query := `SELECT lsn::text, xid::text::bigint, data FROM pg_logical_slot_peek_changes($1, NULL, $2)`
+	if cfg.Plugin == "wal2json" {
+		query = `SELECT lsn::text, xid::text::bigint, data FROM pg_logical_slot_peek_changes($1, NULL, $2,
+			'format-version', '2', 'include-timestamp', '1')`
+	}
+	rows, err := w.db.Query(query, cfg.Slot, cfg.MaxChanges)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка чтения слота %s: %w", cfg.Slot, err)
+	}
+	defer rows.Close()
+
+	var n int
+	var lastLSN string
+	for rows.Next() {
+		var c cdcChange
+		var data string
+		if err := rows.Scan(&c.LSN, &c.XID, &data); err != nil {
+			return n, err
+		}
+		if cfg.Plugin == "wal2json" {
+			c.Data = json.RawMessage(data)
+		} else {
+			c.Data, _ = json.Marshal(data)
+		}
+
+		if w.file == nil {
+			if err := w.open(); err != nil {
+				return n, err
+			}
+		}
+		line, err := json.Marshal(c)
+		if err != nil {
+			return n, err
+		}
+		w.buf.Write(line)
+		if err := w.buf.WriteByte('\n'); err != nil {
+			return n, err
+		}
+		lastLSN = c.LSN
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	if err := w.buf.Flush(); err != nil {
+		return n, err
+	}
+	if err := w.file.Sync(); err != nil {
+		return n, err
+	}
+	if _, err := w.db.Exec(`SELECT pg_replication_slot_advance($1, $2::pg_lsn)`, cfg.Slot, lastLSN); err != nil {
+		return n, fmt.Errorf("ошибка подтверждения позиции слота %s: %w", cfg.Slot, err)
+	}
+	w.changes += n
+	return n, nil
+}
+
+// open начинает новый файл {prefix}_cdc_{dbname}_{HHMMSS}_{YYYYMMDD}.jsonl
+func (w *cdcWriter) open() error {
+	now := clock.Now()
+	name := fmt.Sprintf("%s_cdc_%s_%s_%s.jsonl", w.config.Backup.Prefix, w.config.Postgres.DBName,
+		now.Format("150405"), now.Format("20060102"))
+	w.path = filepath.Join(w.config.CDC.Dir, name)
+	f, err := os.Create(w.path)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.buf = bufio.NewWriterSize(f, 1<<20)
+	w.openedAt = now
+	w.changes = 0
+	return nil
+}
+
+// close закрывает текущий файл, регистрирует его в каталоге, загружает в удаленные хранилища
+// и применяет к файлам изменений политику хранения
+func (w *cdcWriter) close() error {
+	if w.file == nil {
+		w.openedAt = clock.Now()
+		return nil
+	}
+	err := w.buf.Flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.file = nil
+	if err != nil {
+		return err
+	}
+
+	size, _ := pathSize(w.path)
+	result := &runResult{
+		Tables: w.changes,
+		Artifacts: []artifact{{
+			Kind:      runKindCDC,
+			Source:    w.config.Postgres.DBName,
+			Path:      w.path,
+			Size:      size,
+			CreatedAt: clock.Now(),
+		}},
+	}
+	log.Printf("Записан файл изменений %s: %d изменений, %s", w.path, w.changes, ByteSize(size))
+
+	err = deliverArtifacts(w.db, w.config, result, true)
+	if delErr := deleteOldFiles(w.db, w.config.CDC.Dir, w.config.Backup.Prefix, w.config.Backup.Retention, true); delErr != nil {
+		log.Printf("Ошибка удаления старых файлов изменений: %v", delErr)
+	}
+	finishRun(w.db, w.config, runKindCDC, w.openedAt, result, err)
+	w.openedAt = clock.Now()
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Clock источник текущего времени для всей логики дат dbacker: имена и сроки хранения бэкапов,
+// календарь запусков, записи каталога. Подменяется для проверки поведения на заданную дату.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// clock текущие часы; по умолчанию системные, при DBACKER_NOW - сдвинутые на заданный момент
+var clock Clock = systemClock{}
+
+// systemClock системные часы
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// offsetClock часы, которые идут с постоянным сдвигом от системных: время внутри запуска течет
+// как обычно (паузы, длительности), но даты соответствуют заданному моменту
+type offsetClock struct {
+	offset time.Duration
+}
+
+func (c offsetClock) Now() time.Time        { return time.Now().Add(c.offset) }
+func (c offsetClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// Форматы момента в DBACKER_NOW
+var clockLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// setupClock переводит часы на момент из переменной окружения DBACKER_NOW, например
+// DBACKER_NOW=2025-03-31T02:00:00 для проверки календаря запусков или сроков хранения
+func setupClock() error {
+	value := os.Getenv("DBACKER_NOW")
+	if value == "" {
+		return nil
+	}
+	for _, layout := range clockLayouts {
+		if at, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			clock = offsetClock{offset: time.Until(at)}
+			return nil
+		}
+	}
+	return fmt.Errorf("некорректное значение DBACKER_NOW %q, ожидается YYYY-MM-DD[THH:MM:SS]", value)
+}
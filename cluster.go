@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ClusterConfig настройки физических бэкапов всего кластера через pg_basebackup
+type ClusterConfig struct {
+	Binary     string `json:"binary"`     // Путь к pg_basebackup (по умолчанию "pg_basebackup")
+	Dir        string `json:"dir"`        // Каталог для бэкапов (по умолчанию "basebackups")
+	Compress   int    `json:"compress"`   // Уровень сжатия gzip 1-9 (0 - без сжатия)
+	WALMethod  string `json:"wal_method"` // Способ включения WAL: "fetch" (по умолчанию), "stream" или "none"
+	Checkpoint string `json:"checkpoint"` // Режим контрольной точки: "fast" (по умолчанию) или "spread"
+}
+
+func init() {
+	commands["cluster"] = command{
+		Usage: "Physical full-cluster backup with pg_basebackup",
+		Run:   cmdCluster,
+	}
+}
+
+// cmdCluster выполняет физический бэкап кластера, удаляет устаревшие бэкапы и записывает результат в каталог
+func cmdCluster(args []string) error {
+	fs := flag.NewFlagSet("cluster", flag.ExitOnError)
+	run := fs.Bool("run", false, "Normal run instead of test run?")
+	ignoreCalendar := fs.Bool("ignore-calendar", false, "Run even inside a blackout window of the calendar (manual runs)")
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if !*ignoreCalendar {
+		allowed, err := allowRun(&config.Calendar, "бэкапа кластера", *run)
+		if err != nil {
+			return fmt.Errorf("ошибка проверки календаря запусков: %w", err)
+		}
+		if !allowed {
+			return nil
+		}
+	}
+
+	startedAt := clock.Now()
+	result, err := performClusterBackup(db, config, *run)
+	if uploadErr := deliverArtifacts(db, config, result, *run); uploadErr != nil && err == nil {
+		err = uploadErr
+	}
+	if *run {
+		finishRun(db, config, runKindCluster, startedAt, result, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Println("cluster backup done")
+	return nil
+}
+
+// performClusterBackup создает каталог {prefix}_cluster_{YYYYMMDD} с архивами tar от pg_basebackup
+func performClusterBackup(db *sql.DB, config *Config, realRun bool) (*runResult, error) {
+	cfg := &config.Cluster
+	result := &runResult{Tables: 1, Date: clock.Now().Format("20060102")}
+
+	// Удаление старых бэкапов кластера
+	err := deleteOldFiles(db, cfg.Dir, config.Backup.Prefix, config.Backup.Retention, realRun)
+	if err != nil {
+		return result, fmt.Errorf("ошибка удаления старых бэкапов кластера: %w", err)
+	}
+
+	// Протокол репликации pg_basebackup пулер не поддерживает
+	pg, err := sessionPostgres(&config.Postgres, "pg_basebackup")
+	if err != nil {
+		return result, err
+	}
+	path := filepath.Join(cfg.Dir, fmt.Sprintf("%s_cluster_%s", config.Backup.Prefix, result.Date))
+	args := []string{
+		"--host", pg.Host,
+		"--port", strconv.Itoa(pg.Port),
+		"--username", pg.User,
+		"--no-password",
+		"--pgdata", path,
+		"--format", "tar",
+		"--wal-method", cfg.WALMethod,
+		"--checkpoint", cfg.Checkpoint,
+		"--label", "dbacker " + result.Date,
+	}
+	if cfg.Compress > 0 {
+		args = append(args, "--gzip", "--compress", strconv.Itoa(cfg.Compress))
+	}
+
+	if !realRun {
+		log.Printf("Тестовый запуск, будет выполнено: %s %s", cfg.Binary, strings.Join(args, " "))
+		return result, nil
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return result, fmt.Errorf("ошибка создания каталога бэкапов кластера: %w", err)
+	}
+	// pg_basebackup требует пустой каталог; повторный запуск за тот же день перезаписывает бэкап
+	os.RemoveAll(path)
+
+	cmd := exec.Command(cfg.Binary, args...)
+	cmd.Env = append(os.Environ(), pgEnv(pg)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.RemoveAll(path)
+		result.addFailure(fmt.Sprintf("cluster: %v", err))
+		return result, fmt.Errorf("ошибка pg_basebackup: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	size, err := pathSize(path)
+	if err != nil {
+		log.Printf("Ошибка получения размера бэкапа %s: %v", path, err)
+	}
+	result.addArtifacts(artifact{
+		Kind:      runKindCluster,
+		Source:    config.Postgres.Host,
+		Path:      path,
+		Size:      size,
+		CreatedAt: clock.Now(),
+	})
+	log.Printf("Создан бэкап кластера: %s (%s)", path, ByteSize(size))
+	return result, nil
+}
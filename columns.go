@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// column описание колонки таблицы PostgreSQL
+type column struct {
+	Name     string
+	Type     string // полный тип с модификаторами, как в format_type, например "numeric(10,2)"
+	BaseType string // имя базового типа из pg_type, например "numeric" или "_int4" для массивов
+	NotNull  bool
+	Geometry string // представление колонки PostGIS в файле выгрузки (export.geometry), пусто для остальных колонок
+}
+
+// exportType возвращает базовый тип, в котором колонка приходит при чтении для выгрузки:
+// колонки PostGIS читаются как WKB (bytea) или WKT (text)
+func (c *column) exportType() string {
+	if c.Geometry == "" {
+		return c.BaseType
+	}
+	if geometryEncodings[c.Geometry].Binary {
+		return "bytea"
+	}
+	return "text"
+}
+
+// selectExpr возвращает выражение чтения колонки для выгрузки
+func (c *column) selectExpr() string {
+	if c.Geometry == "" {
+		return quoteIdent(c.Name)
+	}
+	return fmt.Sprintf("%s(%s) AS %s", geometryEncodings[c.Geometry].Output, quoteIdent(c.Name), quoteIdent(c.Name))
+}
+
+// tableColumns возвращает колонки таблицы в порядке их следования
+func tableColumns(db *sql.DB, table string) ([]column, error) {
+	rows, err := db.Query(`
+		SELECT a.attname, format_type(a.atttypid, a.atttypmod), t.typname, a.attnotnull
+		FROM pg_attribute a
+		JOIN pg_type t ON t.oid = a.atttypid
+		WHERE a.attrelid = to_regclass(quote_ident(current_schema()) || '.' || $1)
+		AND a.attnum > 0
+		AND NOT a.attisdropped
+		ORDER BY a.attnum`, quoteIdent(table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []column
+	for rows.Next() {
+		var c column
+		if err := rows.Scan(&c.Name, &c.Type, &c.BaseType, &c.NotNull); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("таблица %s не найдена", table)
+	}
+	return columns, nil
+}
+
+// scanTable читает все строки таблицы и передает их в fn.
+// Значения приходят в типах драйвера lib/pq: int64, float64, bool, string, time.Time, []byte или nil.
+func scanTable(db *sql.DB, table string, columns []column, fn func(values []interface{}) error) error {
+	return scanTableRange(db, table, columns, "", fn)
+}
+
+// scanTableRange читает строки таблицы, подходящие под условие where (пустое - все строки), и передает их в fn
+func scanTableRange(db *sql.DB, table string, columns []column, where string, fn func(values []interface{}) error) error {
+	exprs := make([]string, len(columns))
+	for i := range columns {
+		exprs[i] = columns[i].selectExpr()
+	}
+	query := "SELECT " + strings.Join(exprs, ", ") + " FROM " + quoteIdent(table)
+	if where != "" {
+		query += " WHERE " + where
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		if err := fn(values); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// quoteIdent экранирует идентификатор PostgreSQL
+func quoteIdent(name string) string {
+	return pq.QuoteIdentifier(name)
+}
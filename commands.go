@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"dbacker/dberrors"
+)
+
+// Файл конфигурации, из которого читают настройки все команды
+const configFile = "config.ini"
+
+// command подкоманда dbacker (dbacker <команда> [флаги])
+type command struct {
+	Usage string
+	Run   func(args []string) error
+}
+
+// commands доступные подкоманды
+var commands = map[string]command{}
+
+// runCommand выполняет подкоманду и завершает программу при ошибке
+func runCommand(name string, args []string) {
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Неизвестная команда %q, доступны:\n", name)
+		var names []string
+		for n := range commands {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			fmt.Fprintf(os.Stderr, "  %-12s %s\n", n, commands[n].Usage)
+		}
+		os.Exit(2)
+	}
+
+	if err := cmd.Run(args); err != nil {
+		// Код выхода зависит от вида ошибки (см. dberrors.ExitCode), чтобы скрипты различали причины без разбора текста
+		log.Printf("Ошибка выполнения команды %s: %v", name, err)
+		os.Exit(dberrors.ExitCode(err))
+	}
+}
+
+// openDatabase загружает конфигурацию и подключается к PostgreSQL
+func openDatabase() (*Config, *sql.DB, error) {
+	config, err := loadConfig(configFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка загрузки конфигурации: %w", err)
+	}
+
+	db, err := connectToPostgres(&config.Postgres)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка подключения к PostgreSQL: %w", err)
+	}
+	if err := migrateCatalog(db); err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("ошибка обновления каталога: %w", err)
+	}
+	return config, db, nil
+}
+
+// parseArgs разбирает флаги, допуская их и после позиционных аргументов
+// (dbacker restore-dump archive.dump -db staging), и возвращает позиционные аргументы
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
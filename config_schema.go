@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"dbacker/pipeline"
+)
+
+func init() {
+	commands["config"] = command{
+		Usage: "Configuration tools (config schema - print JSON Schema of config.ini)",
+		Run:   cmdConfig,
+	}
+}
+
+var (
+	byteSizeType = reflect.TypeOf(ByteSize(0))
+	durationType = reflect.TypeOf(Duration(0))
+)
+
+// schemaEnums допустимые значения параметров с фиксированным набором значений.
+// Путь - имена параметров через точку; для массивов значения относятся к элементам.
+func schemaEnums() map[string][]string {
+	return map[string][]string{
+		"postgres.pooler":           {poolerTransaction},
+		"remote.pooler":             {poolerTransaction},
+		"backup.budget_policy":      {"refuse", "prune"},
+		"backup.order":              {"name", "size_desc", "size_asc", "priority"},
+		"backup.on_error":           {"continue", "fail_fast", "fail_after_n"},
+		"backup.lock_strategy":      {"wait", "nowait"},
+		"backup.exclude_presets":    sortedKeys(excludePresets),
+		"dump.format":               {"custom", "directory"},
+		"export.formats":            sortedKeys(exporters),
+		"export.pipeline.type":      pipeline.Types(),
+		"export.geometry":           sortedKeys(geometryEncodings),
+		"cdc.plugin":                {"wal2json", "test_decoding"},
+		"cluster.wal_method":        {"fetch", "stream", "none"},
+		"cluster.checkpoint":        {"fast", "spread"},
+		"destinations.type":         sortedKeys(storageFactories),
+		"destinations.upload_order": {uploadOrderCreated, uploadOrderSmallestFirst},
+		"notifications.type":        {"slack", "webhook"},
+		"calendar.action":           {"skip", "shift"},
+		"notifications.policy":      {"always", "on_failure", "on_recovery", "on_long_duration", "on_slo"},
+	}
+}
+
+// sortedKeys возвращает отсортированные ключи словаря
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// cmdConfig выполняет подкоманды работы с конфигурацией
+func cmdConfig(args []string) error {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	fs.Parse(args)
+
+	switch fs.Arg(0) {
+	case "schema":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(configSchema())
+	default:
+		return fmt.Errorf("использование: dbacker config schema")
+	}
+}
+
+// configSchema строит JSON Schema формата конфигурации по структуре Config
+func configSchema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Config{}), "", schemaEnums())
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "dbacker configuration"
+	return schema
+}
+
+// typeSchema строит схему для типа t; path - путь параметра для поиска допустимых значений
+func typeSchema(t reflect.Type, path string, enums map[string][]string) map[string]interface{} {
+	switch t {
+	case byteSizeType:
+		return map[string]interface{}{
+			"description": `Size in bytes: a number or a string like "500MB", "20GB"`,
+			"oneOf": []interface{}{
+				map[string]interface{}{"type": "integer", "minimum": 0},
+				map[string]interface{}{"type": "string", "pattern": `^\s*[0-9.]+\s*([KMGTkmgt]?[Bb])?\s*$`},
+			},
+		}
+	case durationType:
+		return map[string]interface{}{
+			"description": `Duration like "5s", "10m", "1h30m"`,
+			"type":        "string",
+		}
+	}
+
+	var schema map[string]interface{}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), path, enums)
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		addStructProperties(t, path, enums, props)
+		schema = map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), path, enums)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), path, enums)}
+	case reflect.String:
+		schema = map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		schema = map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema = map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		schema = map[string]interface{}{"type": "number"}
+	default:
+		schema = map[string]interface{}{}
+	}
+	if values, ok := enums[path]; ok {
+		schema["enum"] = values
+	}
+	return schema
+}
+
+// addStructProperties добавляет в props параметры полей структуры; поля встроенных структур поднимаются на уровень выше
+func addStructProperties(t reflect.Type, path string, enums map[string][]string, props map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			addStructProperties(f.Type, path, enums, props)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		p := name
+		if path != "" {
+			p = path + "." + name
+		}
+		props[name] = typeSchema(f.Type, p, enums)
+	}
+}
+
+// checkConfigKeys сверяет ключи конфигурации со схемой и возвращает ошибку для первого неизвестного параметра
+func checkConfigKeys(data []byte, schema map[string]interface{}) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	return checkKeys(raw, schema, "")
+}
+
+// checkKeys рекурсивно проверяет значение v по схеме; path - путь для сообщения об ошибке
+func checkKeys(v interface{}, schema map[string]interface{}, path string) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		props, _ := schema["properties"].(map[string]interface{})
+		extra, _ := schema["additionalProperties"].(map[string]interface{})
+		keys := sortedKeys(v)
+		for _, k := range keys {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			var sub map[string]interface{}
+			switch {
+			case props != nil:
+				s, ok := props[k].(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("неизвестный параметр конфигурации: %s", p)
+				}
+				sub = s
+			case extra != nil:
+				sub = extra
+			default:
+				continue
+			}
+			if err := checkKeys(v[k], sub, p); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		items, _ := schema["items"].(map[string]interface{})
+		if items == nil {
+			return nil
+		}
+		for i, item := range v {
+			if err := checkKeys(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
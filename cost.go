@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// CostConfig цены хранения для отчета dbacker cost (за гигабайт в месяц)
+type CostConfig struct {
+	Currency      string  `json:"currency"`        // Валюта для отчета (по умолчанию "USD")
+	DatabasePerGB float64 `json:"database_per_gb"` // Цена диска базы, где хранятся таблицы бэкапа (сервер бэкапов в режиме remote)
+	LocalPerGB    float64 `json:"local_per_gb"`    // Цена локального диска с дампами, выгрузками и файлами изменений
+}
+
+func init() {
+	commands["cost"] = command{
+		Usage: "Estimate monthly storage cost of current backups and under another retention",
+		Run:   cmdCost,
+	}
+}
+
+// costLine строка отчета о стоимости хранения
+type costLine struct {
+	Storage  string
+	Size     int64
+	PerGB    float64
+	Monthly  float64
+	Proposed float64 // стоимость при хранении proposed дней
+}
+
+// cmdCost оценивает ежемесячную стоимость хранения текущих бэкапов по хранилищам и ее изменение
+// при другом сроке хранения. Объем при новом сроке оценивается пропорционально: бэкапы создаются
+// каждый день, поэтому занятое место растет линейно со сроком хранения.
+func cmdCost(args []string) error {
+	fs := flag.NewFlagSet("cost", flag.ExitOnError)
+	retention := fs.String("retention", "", "Proposed retention for projection, e.g. 30d or 8w (default: current)")
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	current := config.Backup.Retention
+	proposed := current
+	if *retention != "" {
+		if proposed, err = parseDays(*retention); err != nil {
+			return err
+		}
+	}
+
+	backupDB, err := openBackupDatabase(config, db)
+	if err != nil {
+		return err
+	}
+	if backupDB != db {
+		defer backupDB.Close()
+	}
+
+	backups, err := getBackupTables(backupDB, config.Backup.Prefix)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка бэкапов: %w", err)
+	}
+	var tablesSize int64
+	for _, b := range backups {
+		tablesSize += b.Size
+	}
+	files, err := storedArtifactSizes(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения каталога: %w", err)
+	}
+
+	lines := []costLine{
+		{Storage: "таблицы бэкапа", Size: tablesSize, PerGB: config.Cost.DatabasePerGB},
+		{Storage: "локальные файлы", Size: files[""], PerGB: config.Cost.LocalPerGB},
+	}
+	for _, d := range config.Destinations {
+		lines = append(lines, costLine{Storage: d.Name, Size: files[d.Name], PerGB: d.PricePerGB})
+	}
+
+	const gb = 1 << 30
+	var total costLine
+	total.Storage = "Итого"
+	for i := range lines {
+		l := &lines[i]
+		l.Monthly = float64(l.Size) / gb * l.PerGB
+		l.Proposed = l.Monthly * float64(proposed) / float64(current)
+		total.Size += l.Size
+		total.Monthly += l.Monthly
+		total.Proposed += l.Proposed
+	}
+
+	cur := config.Cost.Currency
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Хранилище\tОбъем\tЦена за ГБ\tВ месяц (%d дн.)\tВ месяц (%d дн.)\t\n", current, proposed)
+	for _, l := range append(lines, total) {
+		price := fmt.Sprintf("%.4f", l.PerGB)
+		if l.Storage == total.Storage {
+			price = ""
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.2f %s\t%.2f %s\t\n", l.Storage, ByteSize(l.Size), price, l.Monthly, cur, l.Proposed, cur)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if proposed != current {
+		fmt.Printf("Изменение при хранении %d дней вместо %d: %+.2f %s в месяц\n", proposed, current, total.Proposed-total.Monthly, cur)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Состояния обязательной таблицы (backup.required)
+const (
+	coverageCovered  = "covered"   // бэкап создан
+	coverageFailed   = "failed"    // копирование не удалось, ошибка уже есть в итогах запуска
+	coverageExcluded = "excluded"  // таблица подпадает под шаблоны exclude
+	coverageMissing  = "missing"   // таблица не вошла в запуск, например создана после его начала
+	coverageNotFound = "not_found" // под шаблон не подходит ни одна таблица
+)
+
+func init() {
+	commands["coverage"] = command{
+		Usage: "Check that every table listed in backup.required has a backup in the catalog (exit code 1 on gaps)",
+		Run:   cmdCoverage,
+	}
+}
+
+// coverageEntry состояние одной обязательной таблицы
+type coverageEntry struct {
+	Pattern string // элемент backup.required
+	Table   string // таблица (пусто при not_found)
+	State   string
+}
+
+// String описывает пробел в покрытии для логов и итогов запуска
+func (e coverageEntry) String() string {
+	switch e.State {
+	case coverageExcluded:
+		return fmt.Sprintf("%s: исключена шаблоном exclude", e.Table)
+	case coverageMissing:
+		return fmt.Sprintf("%s: нет бэкапа (таблица не вошла в запуск)", e.Table)
+	case coverageNotFound:
+		return fmt.Sprintf("%s: нет таблиц, подходящих под шаблон", e.Pattern)
+	}
+	return fmt.Sprintf("%s: %s", e.Table, e.State)
+}
+
+// compileRequired компилирует шаблоны обязательных таблиц; шаблон должен совпадать с именем целиком
+func compileRequired(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		re, err := regexp.Compile(`^(?:` + p + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный шаблон обязательной таблицы %q: %w", p, err)
+		}
+		compiled[i] = re
+	}
+	return compiled, nil
+}
+
+// evaluateCoverage сопоставляет обязательные таблицы с таблицами текущей схемы: backedUp - таблицы с бэкапом,
+// failed - таблицы, копирование которых не удалось
+func evaluateCoverage(db *sql.DB, cfg *BackupConfig, backedUp, failed map[string]bool) ([]coverageEntry, error) {
+	tables, err := sourceTables(db, cfg)
+	if err != nil {
+		return nil, err
+	}
+	internal, err := timescaleInternalTables(db)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tables)
+
+	var entries []coverageEntry
+	for i, re := range cfg.RequiredPatterns {
+		pattern := cfg.Required[i]
+		matched := false
+		for _, t := range tables {
+			if internal[t] || !re.MatchString(t) {
+				continue
+			}
+			matched = true
+			e := coverageEntry{Pattern: pattern, Table: t}
+			switch {
+			case backedUp[t]:
+				e.State = coverageCovered
+			case failed[t]:
+				e.State = coverageFailed
+			case isExcluded(t, cfg.Excludes):
+				e.State = coverageExcluded
+			default:
+				e.State = coverageMissing
+			}
+			entries = append(entries, e)
+		}
+		if !matched {
+			entries = append(entries, coverageEntry{Pattern: pattern, State: coverageNotFound})
+		}
+	}
+	return entries, nil
+}
+
+// checkRunCoverage проверяет после запуска, что все обязательные таблицы получили бэкап. Пробелы добавляются
+// в ошибки запуска, поэтому запуск получает статус ошибки и срабатывают уведомления on_failure.
+func checkRunCoverage(db *sql.DB, cfg *BackupConfig, result *runResult) error {
+	if len(cfg.RequiredPatterns) == 0 {
+		return nil
+	}
+	backedUp := make(map[string]bool, len(result.Created))
+	for _, c := range result.Created {
+		backedUp[c.Source] = true
+	}
+	failed := make(map[string]bool)
+	for _, f := range result.Failed {
+		if i := strings.Index(f, ": "); i > 0 {
+			failed[f[:i]] = true
+		}
+	}
+
+	entries, err := evaluateCoverage(db, cfg, backedUp, failed)
+	if err != nil {
+		return fmt.Errorf("ошибка проверки обязательных таблиц: %w", err)
+	}
+	var gaps []string
+	for _, e := range entries {
+		if e.State == coverageCovered || e.State == coverageFailed {
+			continue
+		}
+		log.Printf("Обязательная таблица без бэкапа: %s", e)
+		result.addFailure(e.String())
+		gaps = append(gaps, e.String())
+	}
+	if len(gaps) > 0 {
+		return fmt.Errorf("обязательные таблицы без бэкапа: %s", strings.Join(gaps, "; "))
+	}
+	return nil
+}
+
+// cmdCoverage выводит покрытие обязательных таблиц по каталогу: есть ли у каждой бэкап и когда был последний
+func cmdCoverage(args []string) error {
+	fs := flag.NewFlagSet("coverage", flag.ExitOnError)
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if len(config.Backup.Required) == 0 {
+		fmt.Println("Обязательные таблицы (backup.required) не заданы")
+		return nil
+	}
+	last, err := lastTableBackups(db, config.Postgres.Schema)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения каталога: %w", err)
+	}
+	backedUp := make(map[string]bool, len(last))
+	for t := range last {
+		backedUp[t] = true
+	}
+	entries, err := evaluateCoverage(db, &config.Backup, backedUp, nil)
+	if err != nil {
+		return err
+	}
+
+	var gaps int
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Шаблон\tТаблица\tСостояние\tПоследний бэкап")
+	for _, e := range entries {
+		at := "-"
+		if t, ok := last[e.Table]; ok {
+			at = fmt.Sprintf("%s (%s назад)", t.Local().Format("2006-01-02 15:04"), clock.Now().Sub(t).Round(time.Minute))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Pattern, e.Table, e.State, at)
+		if e.State != coverageCovered {
+			gaps++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if gaps > 0 {
+		return fmt.Errorf("обязательных таблиц без бэкапа: %d", gaps)
+	}
+	return nil
+}
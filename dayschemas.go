@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/lib/pq"
+)
+
+func init() {
+	commands["day-schemas"] = command{
+		Usage: "(Re)create per-day schemas backup_YYYYMMDD with views over backups (test run unless -run)",
+		Run:   cmdDaySchemas,
+	}
+}
+
+// cmdDaySchemas создает и обновляет схемы дней вручную, например сразу после включения backup.day_schemas
+func cmdDaySchemas(args []string) error {
+	fs := flag.NewFlagSet("day-schemas", flag.ExitOnError)
+	run := fs.Bool("run", false, "Normal run instead of test run?")
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	backupDB, err := openBackupDatabase(config, db)
+	if err != nil {
+		return err
+	}
+	if backupDB != db {
+		defer backupDB.Close()
+	}
+	return syncDaySchemas(backupDB, &config.Backup, *run)
+}
+
+// daySchemaPattern возвращает шаблон имени схемы дня {day_schema_prefix}_{YYYYMMDD}
+func daySchemaPattern(prefix string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(prefix) + `_\d{8}$`)
+}
+
+// syncDaySchemas приводит схемы дней в соответствие с бэкапами: для каждого дня с бэкапами схема
+// {day_schema_prefix}_{YYYYMMDD} содержит представления с исходными именами таблиц поверх таблиц бэкапа,
+// так что SET search_path TO backup_20240131 позволяет писать запросы к этому дню как к исходной базе.
+// Схема пересоздается, только если набор бэкапов дня изменился; схемы дней без бэкапов удаляются.
+func syncDaySchemas(db *sql.DB, cfg *BackupConfig, realRun bool) error {
+	var schema string
+	if err := db.QueryRow(`SELECT current_schema()`).Scan(&schema); err != nil {
+		return err
+	}
+	tables, err := getBackupTables(db, cfg.Prefix)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка бэкапов: %w", err)
+	}
+	pattern := backupNamePattern(cfg.Prefix)
+	days := make(map[string]map[string]string) // схема дня -> исходное имя -> таблица бэкапа
+	for _, t := range tables {
+		if !pattern.MatchString(t.Name) {
+			continue
+		}
+		name := cfg.DaySchemaPrefix + "_" + t.Date
+		if days[name] == nil {
+			days[name] = make(map[string]string)
+		}
+		days[name][backupSource(t.Name, cfg.Prefix)] = t.Name
+	}
+
+	existing, err := daySchemaViews(db, cfg.DaySchemaPrefix)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения схем дней: %w", err)
+	}
+
+	for _, name := range sortedKeys(existing) {
+		if _, ok := days[name]; ok {
+			continue
+		}
+		if !realRun {
+			log.Printf("Тестовый запуск, будет удалена схема дня %s", name)
+			continue
+		}
+		if _, err := db.Exec("DROP SCHEMA IF EXISTS " + quoteIdent(name) + " CASCADE"); err != nil {
+			return fmt.Errorf("ошибка удаления схемы дня %s: %w", name, err)
+		}
+		log.Printf("Удалена схема дня %s", name)
+	}
+
+	for _, name := range sortedKeys(days) {
+		views := days[name]
+		if current, ok := existing[name]; ok && sameViews(current, views) {
+			continue
+		}
+		if !realRun {
+			log.Printf("Тестовый запуск, будет создана схема дня %s: таблиц %d", name, len(views))
+			continue
+		}
+		if err := createDaySchema(db, schema, name, views); err != nil {
+			return fmt.Errorf("ошибка создания схемы дня %s: %w", name, err)
+		}
+		log.Printf("Создана схема дня %s: таблиц %d", name, len(views))
+	}
+	return nil
+}
+
+// createDaySchema пересоздает схему дня с представлениями над таблицами бэкапа из схемы schema
+// в одной транзакции, чтобы запросы к схеме не видели ее наполовину созданной
+func createDaySchema(db *sql.DB, schema, name string, views map[string]string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	quoted := quoteIdent(name)
+	for _, stmt := range []string{
+		"DROP SCHEMA IF EXISTS " + quoted + " CASCADE",
+		"CREATE SCHEMA " + quoted,
+		fmt.Sprintf("COMMENT ON SCHEMA %s IS %s", quoted, pq.QuoteLiteral("dbacker: backups as of "+name[len(name)-8:])),
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	for _, source := range sortedKeys(views) {
+		_, err := tx.Exec(fmt.Sprintf("CREATE VIEW %s.%s AS SELECT * FROM %s.%s",
+			quoted, quoteIdent(source), quoteIdent(schema), quoteIdent(views[source])))
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// daySchemaViews возвращает схемы дней и их представления (имя -> таблица бэкапа). Схемы, в которых
+// есть что-то кроме представлений, считаются чужими и не возвращаются, чтобы dbacker их не удалил.
+func daySchemaViews(db *sql.DB, prefix string) (map[string]map[string]string, error) {
+	rows, err := db.Query(`
+		SELECT n.nspname, c.relname, d.relname
+		FROM pg_namespace n
+		LEFT JOIN pg_class c ON c.relnamespace = n.oid AND c.relkind = 'v'
+		LEFT JOIN LATERAL (
+			SELECT t.relname FROM pg_rewrite r
+			JOIN pg_depend dep ON dep.objid = r.oid AND dep.classid = 'pg_rewrite'::regclass
+			JOIN pg_class t ON t.oid = dep.refobjid AND t.relkind = 'r'
+			WHERE r.ev_class = c.oid
+			LIMIT 1
+		) d ON true
+		WHERE n.nspname LIKE $1 || '\_%'
+		AND NOT EXISTS (SELECT 1 FROM pg_class o WHERE o.relnamespace = n.oid AND o.relkind <> 'v')`, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pattern := daySchemaPattern(prefix)
+	schemas := make(map[string]map[string]string)
+	for rows.Next() {
+		var schema string
+		var view, table sql.NullString
+		if err := rows.Scan(&schema, &view, &table); err != nil {
+			return nil, err
+		}
+		if !pattern.MatchString(schema) {
+			continue
+		}
+		if schemas[schema] == nil {
+			schemas[schema] = make(map[string]string)
+		}
+		if view.Valid {
+			schemas[schema][view.String] = table.String
+		}
+	}
+	return schemas, rows.Err()
+}
+
+// sameViews сравнивает представления схемы дня с нужными
+func sameViews(current, want map[string]string) bool {
+	if len(current) != len(want) {
+		return false
+	}
+	for name, table := range want {
+		if current[name] != table {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+// Package dberrors виды ошибок dbacker. Вызывающий код (команды dbacker и программы, встраивающие его)
+// выбирает реакцию через errors.Is по видам ErrPermission и др. вместо разбора текста ошибки,
+// а ошибки операций над таблицами получает как *TableError с операцией и исходной таблицей.
+package dberrors
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+
+	"github.com/lib/pq"
+)
+
+// Виды ошибок, по которым вызывающий код выбирает реакцию через errors.Is вместо разбора текста:
+// ошибки PostgreSQL и файловой системы сопоставляются с видом по коду SQLSTATE и errno
+var (
+	ErrPermission   = errors.New("недостаточно прав")                            // SQLSTATE 42501, EACCES/EPERM
+	ErrLockTimeout  = errors.New("таблица заблокирована")                        // SQLSTATE 55P03 (NOWAIT, lock_timeout)
+	ErrDiskFull     = errors.New("нет места на диске")                           // SQLSTATE 53100, ENOSPC
+	ErrNameTooLong  = errors.New("имя бэкапа длиннее 63 байт")                   // PostgreSQL обрезал бы имя таблицы
+	ErrBackupExists = errors.New("таблица бэкапа с таким именем уже существует") // SQLSTATE 42P07
+
+	ErrTableVanished = errors.New("таблица удалена во время запуска")              // нет в системном каталоге при повторной проверке
+	ErrSchemaChanged = errors.New("структура таблицы изменилась во время запуска") // другой oid или другие колонки
+
+	ErrValidationFailed = errors.New("бэкап не прошел проверку") // запросы backup.validations
+)
+
+// Операции, в которых возникает ошибка таблицы
+const (
+	OpCopy     = "copy"     // создание копии таблицы
+	OpVerify   = "verify"   // сверка копии с исходной таблицей
+	OpExport   = "export"   // выгрузка бэкапа в файл
+	OpValidate = "validate" // проверки бэкапа запросами backup.validations
+)
+
+// errorKinds коды видов ошибок в событиях table_failed и в кодах выхода команд
+var errorKinds = []struct {
+	err      error
+	code     string
+	exitCode int
+}{
+	{ErrPermission, "permission", 3},
+	{ErrLockTimeout, "lock_timeout", 4},
+	{ErrDiskFull, "disk_full", 5},
+	{ErrNameTooLong, "name_too_long", 6},
+	{ErrBackupExists, "backup_exists", 7},
+	{ErrTableVanished, "vanished", 8},
+	{ErrSchemaChanged, "schema_changed", 9},
+	{ErrValidationFailed, "validation_failed", 10},
+}
+
+// TableError ошибка операции над таблицей. Текст совпадает с текстом исходной ошибки,
+// а errors.Is находит и вид ошибки (ErrPermission и др.), и исходную ошибку
+type TableError struct {
+	Op    string // операция: copy, verify, export, validate
+	Table string // исходная таблица
+	Kind  error  // вид ошибки или nil, если вид не определен
+	Err   error  // исходная ошибка
+}
+
+func (e *TableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TableError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// Wrap оборачивает ошибку операции над таблицей с определением ее вида; nil остается nil
+func Wrap(op, table string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var te *TableError
+	if errors.As(err, &te) {
+		return err
+	}
+	return &TableError{Op: op, Table: table, Kind: Kind(err), Err: err}
+}
+
+// Kind определяет вид ошибки по коду SQLSTATE или errno; nil, если вид не определен
+func Kind(err error) error {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.err
+		}
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "42501":
+			return ErrPermission
+		case "55P03":
+			return ErrLockTimeout
+		case "53100":
+			return ErrDiskFull
+		case "42P07":
+			return ErrBackupExists
+		}
+	}
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return ErrPermission
+	case errors.Is(err, syscall.ENOSPC):
+		return ErrDiskFull
+	}
+	return nil
+}
+
+// Code возвращает код вида ошибки для событий и отчетов или пустую строку
+func Code(err error) string {
+	kind := Kind(err)
+	for _, k := range errorKinds {
+		if kind == k.err {
+			return k.code
+		}
+	}
+	return ""
+}
+
+// ExitCode возвращает код выхода команды по виду ошибки; 1 для ошибок без вида
+func ExitCode(err error) int {
+	kind := Kind(err)
+	for _, k := range errorKinds {
+		if kind == k.err {
+			return k.exitCode
+		}
+	}
+	return 1
+}
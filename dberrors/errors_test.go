@@ -0,0 +1,73 @@
+package dberrors
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestKind(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		kind     error
+		code     string
+		exitCode int
+	}{
+		{"insufficient privilege", &pq.Error{Code: "42501"}, ErrPermission, "permission", 3},
+		{"lock not available", fmt.Errorf("копирование: %w", &pq.Error{Code: "55P03"}), ErrLockTimeout, "lock_timeout", 4},
+		{"disk full", &pq.Error{Code: "53100"}, ErrDiskFull, "disk_full", 5},
+		{"duplicate table", &pq.Error{Code: "42P07"}, ErrBackupExists, "backup_exists", 7},
+		{"other sqlstate", &pq.Error{Code: "23505"}, nil, "", 1},
+		{"file permission", &fs.PathError{Op: "open", Path: "/exports", Err: syscall.EACCES}, ErrPermission, "permission", 3},
+		{"no space", &os.PathError{Op: "write", Path: "/exports/a.avro", Err: syscall.ENOSPC}, ErrDiskFull, "disk_full", 5},
+		{"sentinel", fmt.Errorf("%w: backup_orders_20240131", ErrNameTooLong), ErrNameTooLong, "name_too_long", 6},
+		{"vanished", ErrTableVanished, ErrTableVanished, "vanished", 8},
+		{"schema changed", ErrSchemaChanged, ErrSchemaChanged, "schema_changed", 9},
+		{"validation", &TableError{Op: OpValidate, Table: "orders", Kind: ErrValidationFailed, Err: errors.New("не прошел")},
+			ErrValidationFailed, "validation_failed", 10},
+		{"unknown", errors.New("обрыв соединения"), nil, "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Kind(tt.err); got != tt.kind {
+				t.Errorf("Kind() = %v, ожидалось %v", got, tt.kind)
+			}
+			if got := Code(tt.err); got != tt.code {
+				t.Errorf("Code() = %q, ожидалось %q", got, tt.code)
+			}
+			if got := ExitCode(tt.err); got != tt.exitCode {
+				t.Errorf("ExitCode() = %d, ожидалось %d", got, tt.exitCode)
+			}
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	if Wrap(OpCopy, "orders", nil) != nil {
+		t.Error("Wrap(nil) должен возвращать nil")
+	}
+
+	cause := &pq.Error{Code: "42501", Message: "permission denied for table orders"}
+	err := Wrap(OpCopy, "orders", cause)
+	var te *TableError
+	if !errors.As(err, &te) || te.Op != OpCopy || te.Table != "orders" {
+		t.Fatalf("Wrap() = %#v", err)
+	}
+	if err.Error() != cause.Error() {
+		t.Errorf("текст %q отличается от исходного %q", err.Error(), cause.Error())
+	}
+	if !errors.Is(err, ErrPermission) || !errors.Is(err, cause) {
+		t.Error("errors.Is не находит вид или исходную ошибку")
+	}
+
+	// уже обернутая ошибка не оборачивается повторно
+	if again := Wrap(OpExport, "orders", fmt.Errorf("выгрузка: %w", err)); !errors.As(again, &te) || te.Op != OpCopy {
+		t.Errorf("повторный Wrap изменил операцию: %#v", again)
+	}
+}
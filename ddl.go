@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"dbacker/dberrors"
+)
+
+// Изменения структуры исходных таблиц во время запуска. Между составлением списка таблиц и копированием
+// таблицу могут удалить, пересоздать или изменить ее колонки; копирование тогда падает с ошибкой, по которой
+// причина не видна (relation does not exist, несовпадение колонок в remote и copy_freeze). При ошибке копирования
+// таблица заново проверяется по системному каталогу и ошибка получает вид vanished или schema_changed.
+
+// sameColumns проверяет, что у колонок те же имена и типы в том же порядке
+func sameColumns(a, b []column) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Type != b[i].Type {
+			return false
+		}
+	}
+	return true
+}
+
+// tableDDLChange сравнивает таблицу с ее метаданными из модели запуска и возвращает
+// dberrors.ErrTableVanished, dberrors.ErrSchemaChanged или nil, если таблица не изменилась
+func tableDDLChange(db *sql.DB, table string, meta *tableMeta) (kind error, err error) {
+	oids, err := getTableOIDs(db, []string{table})
+	if err != nil {
+		return nil, err
+	}
+	current, ok := oids[table]
+	if !ok {
+		return dberrors.ErrTableVanished, nil
+	}
+	if current != meta.OID {
+		return dberrors.ErrSchemaChanged, nil
+	}
+	columns, err := tableColumns(db, table)
+	if err != nil {
+		return nil, err
+	}
+	if !sameColumns(columns, meta.Columns) {
+		return dberrors.ErrSchemaChanged, nil
+	}
+	return nil, nil
+}
+
+// classifyDDLError уточняет ошибку копирования таблицы, если таблица удалена или изменена после составления
+// списка таблиц; иначе (или если проверить не удалось) возвращает ошибку без изменений
+func classifyDDLError(db *sql.DB, table string, meta *tableMeta, err error) error {
+	if err == nil || meta == nil || dberrors.Kind(err) != nil {
+		return err
+	}
+	kind, checkErr := tableDDLChange(db, table, meta)
+	if checkErr != nil || kind == nil {
+		return err
+	}
+	reason := "таблица удалена после составления списка таблиц"
+	if kind == dberrors.ErrSchemaChanged {
+		reason = "структура таблицы изменилась после составления списка таблиц"
+	}
+	return &dberrors.TableError{Op: dberrors.OpCopy, Table: table, Kind: kind, Err: fmt.Errorf("%s: %w", reason, err)}
+}
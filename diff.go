@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// Пороги, при которых тестовый запуск выделяет изменения по сравнению с последним записанным запуском
+const (
+	diffGrowthFactor = 1.5      // таблица выросла в полтора раза и больше
+	diffMinGrowth    = 10 << 20 // и при этом не меньше чем на 10MB
+	diffDeleteFactor = 2.0      // удаление по политике хранения вдвое больше среднего
+)
+
+// printRunDiff сравнивает результат тестового запуска с последним запуском из каталога: какие таблицы
+// появились и исчезли, какие заметно выросли и не удаляет ли политика хранения больше обычного
+func printRunDiff(db *sql.DB, config *Config, result *runResult) error {
+	runs, err := lastRuns(db, runKindTables, config.Postgres.Schema, durationHistory)
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		fmt.Println("Сравнение с последним запуском: в каталоге нет запусков")
+		return nil
+	}
+	last := runs[0]
+	fmt.Printf("Сравнение с запуском #%d от %s (%s):\n", last.ID, last.StartedAt.Local().Format("2006-01-02 15:04"), last.Status)
+
+	previous, err := runArtifacts(db, last.ID, artifactKindTable)
+	if err != nil {
+		return err
+	}
+	if len(previous) == 0 {
+		fmt.Println("  в каталоге нет списка таблиц последнего запуска")
+	} else {
+		before := make(map[string]int64, len(previous))
+		for _, a := range previous {
+			before[a.Source] = a.Size
+		}
+		now := make(map[string]int64)
+		for _, a := range result.Artifacts {
+			if a.Kind == artifactKindTable {
+				now[a.Source] = a.Size
+			}
+		}
+
+		var appeared, disappeared, grown []string
+		for table, size := range now {
+			prev, ok := before[table]
+			switch {
+			case !ok:
+				appeared = append(appeared, fmt.Sprintf("%s (%s)", table, ByteSize(size)))
+			case prev > 0 && float64(size) >= float64(prev)*diffGrowthFactor && size-prev >= diffMinGrowth:
+				grown = append(grown, fmt.Sprintf("%s: %s -> %s (x%.1f)", table, ByteSize(prev), ByteSize(size), float64(size)/float64(prev)))
+			}
+		}
+		for table := range before {
+			if _, ok := now[table]; !ok {
+				disappeared = append(disappeared, table)
+			}
+		}
+		printDiffSection("Новые таблицы", appeared)
+		printDiffSection("Исчезнувшие таблицы", disappeared)
+		printDiffSection("Заметно выросшие таблицы", grown)
+		if len(appeared)+len(disappeared)+len(grown) == 0 {
+			fmt.Println("  состав и размеры таблиц без существенных изменений")
+		}
+	}
+
+	// Удаление по политике хранения по сравнению со средним за последние запуски
+	var total int64
+	for _, r := range runs {
+		total += r.DeletedBytes
+	}
+	average := float64(total) / float64(len(runs))
+	fmt.Printf("  Будет удалено бэкапов: %d (%s), в среднем за последние запуски %s\n",
+		result.Deleted, ByteSize(result.DeletedSize), ByteSize(int64(average)))
+	if result.DeletedSize > 0 && float64(result.DeletedSize) > average*diffDeleteFactor {
+		fmt.Println("  ! удаление по политике хранения больше обычного")
+	}
+	return nil
+}
+
+// printDiffSection выводит непустой список изменений с заголовком
+func printDiffSection(title string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	sort.Strings(items)
+	fmt.Printf("  %s (%d):\n", title, len(items))
+	for _, item := range items {
+		fmt.Printf("    %s\n", item)
+	}
+}
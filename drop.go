@@ -0,0 +1,81 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// dropBackupTables удаляет таблицы бэкапа пачками по drop_batch таблиц с паузой drop_pause между ними,
+// чтобы массовое удаление не создавало всплеск блокировок каталога и WAL. Каждая пачка удаляется
+// в отдельной транзакции с lock_timeout; при truncate_before_drop таблицы пачки сначала очищаются.
+// Пачка, которую не удалось удалить, пропускается и будет удалена следующим запуском.
+// Возвращает удаленные таблицы.
+func dropBackupTables(db *sql.DB, cfg *BackupConfig, tables []backupTable) []backupTable {
+	batch := cfg.DropBatch
+	if batch <= 0 {
+		batch = 1
+	}
+
+	var dropped []backupTable
+	for start := 0; start < len(tables); start += batch {
+		if start > 0 && cfg.DropPause > 0 {
+			clock.Sleep(time.Duration(cfg.DropPause))
+		}
+		end := start + batch
+		if end > len(tables) {
+			end = len(tables)
+		}
+		chunk := tables[start:end]
+
+		names := make([]string, len(chunk))
+		for i, t := range chunk {
+			names[i] = quoteIdent(t.Name)
+		}
+		list := strings.Join(names, ", ")
+
+		if cfg.TruncateBeforeDrop {
+			if err := execWithLockTimeout(db, cfg.DropLockTimeout, "TRUNCATE TABLE "+list); err != nil {
+				log.Printf("Ошибка очистки таблиц бэкапа %s: %v", list, err)
+				continue
+			}
+		}
+		if err := execWithLockTimeout(db, cfg.DropLockTimeout, "DROP TABLE IF EXISTS "+list+dropCascade(cfg)); err != nil {
+			log.Printf("Ошибка удаления таблиц бэкапа %s: %v", list, err)
+			continue
+		}
+		dropped = append(dropped, chunk...)
+	}
+	return dropped
+}
+
+// dropCascade возвращает CASCADE при backup.day_schemas: вместе с таблицей бэкапа удаляется
+// представление над ней в схеме дня, пустая схема удаляется при обновлении схем дней
+func dropCascade(cfg *BackupConfig) string {
+	if cfg.DaySchemas {
+		return " CASCADE"
+	}
+	return ""
+}
+
+// execWithLockTimeout выполняет запрос в отдельной транзакции, ограничивая ожидание блокировок timeout (0 - без ограничения)
+func execWithLockTimeout(db *sql.DB, timeout Duration, query string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if timeout > 0 {
+		ms := time.Duration(timeout).Milliseconds()
+		if _, err := tx.Exec(fmt.Sprintf("SET LOCAL lock_timeout = %d", ms)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(query); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"dbacker/pipeline"
+)
+
+// DumpConfig настройки режима логических дампов через pg_dump
+type DumpConfig struct {
+	Binary        string   `json:"binary"`         // Путь к pg_dump (по умолчанию "pg_dump")
+	RestoreBinary string   `json:"restore_binary"` // Путь к pg_restore (по умолчанию "pg_restore")
+	Format        string   `json:"format"`         // Формат дампа: "custom" (по умолчанию) или "directory"
+	Jobs          int      `json:"jobs"`           // Количество параллельных заданий pg_dump (только для формата directory)
+	Compress      int      `json:"compress"`       // Уровень сжатия pg_dump -Z (0 - по умолчанию pg_dump)
+	Dir           string   `json:"dir"`            // Каталог для дампов (по умолчанию "dumps")
+	Databases     []string `json:"databases"`      // Базы для дампа (по умолчанию postgres.dbname)
+}
+
+func init() {
+	commands["dump"] = command{
+		Usage: "Dump databases with pg_dump, apply retention and record results",
+		Run:   cmdDump,
+	}
+}
+
+// cmdDump выполняет логические дампы баз через pg_dump
+func cmdDump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	run := fs.Bool("run", false, "Normal run instead of test run?")
+	ignoreCalendar := fs.Bool("ignore-calendar", false, "Run even inside a blackout window of the calendar (manual runs)")
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if !*ignoreCalendar {
+		allowed, err := allowRun(&config.Calendar, "дампа", *run)
+		if err != nil {
+			return fmt.Errorf("ошибка проверки календаря запусков: %w", err)
+		}
+		if !allowed {
+			return nil
+		}
+	}
+
+	startedAt := clock.Now()
+	result, err := performDump(db, config, *run)
+	if uploadErr := deliverArtifacts(db, config, result, *run); uploadErr != nil && err == nil {
+		err = uploadErr
+	}
+	if *run {
+		finishRun(db, config, runKindDump, startedAt, result, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Println("dump done")
+	return nil
+}
+
+// performDump удаляет устаревшие дампы и создает новые для каждой базы
+func performDump(db *sql.DB, config *Config, realRun bool) (*runResult, error) {
+	cfg := &config.Dump
+	result := &runResult{Tables: len(cfg.Databases)}
+
+	if realRun {
+		if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+			return result, fmt.Errorf("ошибка создания каталога дампов: %w", err)
+		}
+	}
+
+	// Удаление старых дампов
+	err := deleteOldFiles(db, cfg.Dir, config.Backup.Prefix, config.Backup.Retention, realRun)
+	if err != nil {
+		return result, fmt.Errorf("ошибка удаления старых дампов: %w", err)
+	}
+
+	pg, err := sessionPostgres(&config.Postgres, "pg_dump")
+	if err != nil {
+		return result, err
+	}
+	// Версия pg_dump попадает в манифест: по ней restore-dump проверяет, что pg_restore прочитает архив
+	var tool string
+	if realRun {
+		if tool, err = toolVersion(cfg.Binary); err != nil {
+			log.Printf("Не удалось определить версию %s: %v", cfg.Binary, err)
+		}
+	}
+	currentDate := clock.Now().Format("20060102")
+	for _, dbname := range cfg.Databases {
+		name := fmt.Sprintf("%s_%s_%s", config.Backup.Prefix, dbname, currentDate)
+		if cfg.Format == "custom" {
+			name += ".dump"
+		}
+		path := filepath.Join(cfg.Dir, name)
+
+		if !realRun && cfg.Format == "custom" {
+			path += pipeline.Ext(config.Export.Stages)
+		}
+		if realRun {
+			path, err = runPgDump(pg, cfg, &config.Export, dbname, path)
+			if err != nil {
+				log.Printf("Ошибка дампа базы %s: %v", dbname, err)
+				failed := result.addFailure(fmt.Sprintf("%s: %v", dbname, err))
+				if config.Backup.OnError == "fail_fast" || (config.Backup.OnError == "fail_after_n" && len(failed) >= config.Backup.MaxErrors) {
+					return result, fmt.Errorf("дамп прерван после %d ошибок: %s", len(failed), strings.Join(failed, "; "))
+				}
+				continue
+			}
+
+			size, err := pathSize(path)
+			if err != nil {
+				log.Printf("Ошибка получения размера дампа %s: %v", path, err)
+			}
+			result.addArtifacts(artifact{
+				Kind:      runKindDump,
+				Source:    dbname,
+				Path:      path,
+				Size:      size,
+				CreatedAt: clock.Now(),
+				Manifest:  &artifactManifest{Tool: tool},
+			})
+		}
+		log.Printf("Создан дамп базы %s: %s", dbname, path)
+	}
+
+	if len(result.Failed) > 0 {
+		return result, fmt.Errorf("не удалось создать дамп %d из %d баз: %s", len(result.Failed), len(cfg.Databases), strings.Join(result.Failed, "; "))
+	}
+	return result, nil
+}
+
+// runPgDump запускает pg_dump для одной базы и возвращает путь к дампу. Архив формата custom pg_dump пишет
+// в stdout, и он проходит через конвейер export.pipeline (сжатие, шифрование, части), как выгрузки;
+// каталог формата directory pg_dump пишет сам.
+func runPgDump(pg *PostgresConfig, cfg *DumpConfig, export *ExportConfig, dbname, path string) (string, error) {
+	args := []string{
+		"--host", pg.Host,
+		"--port", strconv.Itoa(pg.Port),
+		"--username", pg.User,
+		"--no-password",
+		"--format", cfg.Format,
+	}
+	if cfg.Format == "directory" {
+		args = append(args, "--file", path)
+	}
+	if cfg.Jobs > 1 {
+		args = append(args, "--jobs", strconv.Itoa(cfg.Jobs))
+	}
+	if cfg.Compress > 0 {
+		args = append(args, "--compress", strconv.Itoa(cfg.Compress))
+	}
+	args = append(args, dbname)
+
+	cmd := exec.Command(cfg.Binary, args...)
+	cmd.Env = append(os.Environ(), pgEnv(pg)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	var out io.WriteCloser
+	if cfg.Format == "custom" {
+		var err error
+		if out, path, err = createPipeline(export, path); err != nil {
+			return "", err
+		}
+		cmd.Stdout = out
+	}
+	err := cmd.Run()
+	if out != nil {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		// Недописанный дамп не должен остаться в каталоге
+		os.RemoveAll(path)
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return path, nil
+}
+
+// pgEnv возвращает переменные окружения для утилит PostgreSQL (пароль, режим SSL и параметры сессии)
+func pgEnv(pg *PostgresConfig) []string {
+	ssl := "disable"
+	if pg.SSL {
+		ssl = "require"
+	}
+	env := []string{"PGPASSWORD=" + pg.Password, "PGSSLMODE=" + ssl}
+	if len(pg.Settings) > 0 {
+		var options []string
+		for _, name := range sortedKeys(pg.Settings) {
+			// пробелы в значении экранируются для разбора PGOPTIONS
+			options = append(options, "-c "+name+"="+strings.ReplaceAll(pg.Settings[name], " ", `\ `))
+		}
+		env = append(env, "PGOPTIONS="+strings.Join(options, " "))
+	}
+	return env
+}
+
+// backupFile файл или каталог бэкапа {prefix}_{source}_{YYYYMMDD}[.ext]
+type backupFile struct {
+	Path string
+	Date string // дата из имени файла в формате YYYYMMDD
+}
+
+// listBackupFiles возвращает файлы бэкапа с префиксом prefix в каталоге dir
+func listBackupFiles(dir, prefix string) ([]backupFile, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []backupFile
+	for _, e := range entries {
+		name := e.Name()
+		if i := strings.Index(name, "."); i >= 0 {
+			name = name[:i]
+		}
+		if !strings.HasPrefix(name, prefix+"_") || len(name) < 8 {
+			continue
+		}
+		// Извлечение даты из имени файла (последние 8 символов)
+		files = append(files, backupFile{Path: filepath.Join(dir, e.Name()), Date: name[len(name)-8:]})
+	}
+	return files, nil
+}
+
+// deleteOldFiles удаляет файлы бэкапа {prefix}_{source}_{YYYYMMDD}[.ext] в каталоге dir старше указанного количества дней,
+// кроме продленных командой retain
+func deleteOldFiles(db *sql.DB, dir, prefix string, retentionDays int, realRun bool) error {
+	files, err := listBackupFiles(dir, prefix)
+	if err != nil {
+		return err
+	}
+	retained, err := retentionOverrides(db)
+	if err != nil {
+		return err
+	}
+
+	threshold := clock.Now().AddDate(0, 0, -retentionDays).Format("20060102")
+	for _, f := range files {
+		if f.Date >= threshold {
+			continue
+		}
+		if until, ok := retained[f.Path]; ok && isRetained(until) {
+			log.Printf("Бэкап %s сохранен до %s", f.Path, until.Format("2006-01-02"))
+			continue
+		}
+
+		if realRun {
+			if err := os.RemoveAll(f.Path); err != nil {
+				log.Printf("Ошибка удаления файла %s: %v", f.Path, err)
+				continue
+			}
+			if err := markArtifactDeleted(db, f.Path); err != nil {
+				log.Printf("Ошибка обновления каталога для %s: %v", f.Path, err)
+			}
+		}
+		log.Printf("Удален старый файл бэкапа: %s", f.Path)
+	}
+	return nil
+}
+
+// pathSize возвращает размер файла или суммарный размер файлов каталога
+func pathSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
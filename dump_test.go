@@ -0,0 +1,50 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"dbacker/pipeline"
+)
+
+func TestRunPgDumpPipeline(t *testing.T) {
+	dir := t.TempDir()
+	// pg_dump формата custom пишет архив в stdout
+	binary := filepath.Join(dir, "pg_dump")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\nfor db; do :; done\nprintf 'PGDMP archive of %s' \"$db\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	stage, err := pipeline.New(&pipeline.StageConfig{Type: "gzip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	export := &ExportConfig{Stages: []pipeline.Stage{stage}}
+	cfg := &DumpConfig{Binary: binary, Format: "custom"}
+
+	path, err := runPgDump(&PostgresConfig{Host: "localhost", Port: 5432}, cfg, export, "app", filepath.Join(dir, "backup_app_20240131.dump"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "backup_app_20240131.dump.gz"); path != want {
+		t.Fatalf("путь %s, ожидался %s", path, want)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "PGDMP archive of app" {
+		t.Errorf("содержимое %q", data)
+	}
+}
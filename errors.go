@@ -0,0 +1,21 @@
+package main
+
+import "dbacker/dberrors"
+
+// maxIdentifierLength максимальная длина имени в PostgreSQL (NAMEDATALEN - 1): длинные имена сервер молча обрезает
+const maxIdentifierLength = 63
+
+// isRetryable проверяет, что повтор копирования может помочь: нехватка прав, длинное или занятое имя,
+// удаленная таблица и изменение структуры (для него есть backup.ddl_retry) не исправятся повтором.
+// Занятая блокировка повторяется, кроме режима nowait, в котором заблокированная таблица откладывается
+// до конца запуска
+func isRetryable(err error, nowait bool) bool {
+	switch dberrors.Kind(err) {
+	case dberrors.ErrLockTimeout:
+		return !nowait
+	case dberrors.ErrPermission, dberrors.ErrNameTooLong, dberrors.ErrBackupExists,
+		dberrors.ErrTableVanished, dberrors.ErrSchemaChanged:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// excludePresets встроенные наборы шаблонов (регулярных выражений) имен временных таблиц,
+// которые создают распространенные инструменты и которые не нужно бэкапить
+var excludePresets = map[string][]string{
+	// Кеш Django в базе (createcachetable) и сессии
+	"django": {`^django_cache`, `^cache_table$`, `^django_session$`},
+	// Результаты задач Celery (database backend и django-celery-results)
+	"celery": {`^celery_taskmeta$`, `^celery_tasksetmeta$`, `^django_celery_results_`},
+	// Остатки прерванного pg_repack: log_{oid} и table_{oid}
+	"pg_repack": {`^log_[0-9]+$`, `^table_[0-9]+$`},
+	// Временные таблицы ETL
+	"etl": {`^tmp_`, `^temp_`, `_tmp$`, `_temp$`},
+}
+
+// compileExcludes собирает шаблоны исключения из включенных наборов и собственных шаблонов exclude
+func compileExcludes(cfg *BackupConfig) ([]*regexp.Regexp, error) {
+	var patterns []string
+	for _, name := range cfg.ExcludePresets {
+		preset, ok := excludePresets[name]
+		if !ok {
+			return nil, fmt.Errorf("неизвестный набор исключений: %s (доступны %v)", name, sortedKeys(excludePresets))
+		}
+		patterns = append(patterns, preset...)
+	}
+	patterns = append(patterns, cfg.Exclude...)
+
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный шаблон исключения %q: %w", p, err)
+		}
+		compiled[i] = re
+	}
+	return compiled, nil
+}
+
+// isExcluded проверяет, что таблица подпадает под один из шаблонов исключения
+func isExcluded(table string, excludes []*regexp.Regexp) bool {
+	for _, re := range excludes {
+		if re.MatchString(table) {
+			return true
+		}
+	}
+	return false
+}
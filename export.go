@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"dbacker/dberrors"
+	"dbacker/pipeline"
+)
+
+// ExportConfig настройки выгрузки снимков таблиц в файлы
+type ExportConfig struct {
+	Formats      []string `json:"formats"`       // Форматы выгрузки, например ["duckdb", "sqlite", "avro", "arrow", "warehouse"] (по умолчанию выгрузка отключена)
+	Dir          string   `json:"dir"`           // Каталог для файлов выгрузки (по умолчанию "exports")
+	DuckDBBinary string   `json:"duckdb_binary"` // Путь к duckdb (по умолчанию "duckdb")
+	SQLiteBinary string   `json:"sqlite_binary"` // Путь к sqlite3 (по умолчанию "sqlite3")
+
+	// Путь к файлу расширения postgres для duckdb (postgres_scanner.duckdb_extension). По умолчанию расширение
+	// устанавливается командой INSTALL postgres, которой нужен доступ в интернет к репозиторию расширений DuckDB
+	DuckDBPostgresExtension string `json:"duckdb_postgres_extension"`
+
+	WarehouseURL         string   `json:"warehouse_url"`         // Адрес, куда будет загружен каталог выгрузки warehouse (s3://bucket/path), для manifest и COPY
+	WarehouseChunkSize   ByteSize `json:"warehouse_chunk_size"`  // Размер части CSV до сжатия (по умолчанию "256MB")
+	RedshiftIAMRole      string   `json:"redshift_iam_role"`     // IAM роль для COPY в Redshift
+	SnowflakeIntegration string   `json:"snowflake_integration"` // Storage integration для COPY INTO в Snowflake
+
+	Pipeline  []pipeline.StageConfig `json:"pipeline"`   // Этапы обработки файлов выгрузки по порядку, например сжатие и шифрование
+	ChunkSize ByteSize               `json:"chunk_size"` // Размер части: файл выгрузки записывается каталогом частей (0 - одним файлом)
+	Stages    []pipeline.Stage       `json:"-"`          // Этапы, созданные по pipeline
+
+	Geometry string `json:"geometry"` // Представление колонок PostGIS в файлах: "wkb", "wkt", "ewkb" или "ewkt" (по умолчанию "wkb")
+
+	Parallel        int      `json:"parallel"`          // Сколько потоков читают одну большую таблицу диапазонами страниц в формате warehouse (по умолчанию 1)
+	ParallelMinSize ByteSize `json:"parallel_min_size"` // Таблицы меньше этого размера выгружаются одним потоком (по умолчанию "1GB")
+}
+
+// createdBackup таблица бэкапа, созданная в текущем запуске
+type createdBackup struct {
+	Source string // исходная таблица
+	Backup string // таблица бэкапа
+}
+
+// exporter выгружает созданные за запуск бэкапы в файлы одного формата
+type exporter func(db *sql.DB, config *Config, created []createdBackup, date string) ([]artifact, error)
+
+// exporters доступные форматы выгрузки
+var exporters = map[string]exporter{}
+
+// validateExportConfig проверяет настройки выгрузки и устанавливает значения по умолчанию
+func validateExportConfig(cfg *ExportConfig) error {
+	for _, f := range cfg.Formats {
+		if _, ok := exporters[f]; !ok {
+			return fmt.Errorf("неизвестный формат выгрузки: %s", f)
+		}
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = "exports"
+	}
+	if cfg.DuckDBBinary == "" {
+		cfg.DuckDBBinary = "duckdb"
+	}
+	if cfg.SQLiteBinary == "" {
+		cfg.SQLiteBinary = "sqlite3"
+	}
+	if cfg.WarehouseChunkSize <= 0 {
+		cfg.WarehouseChunkSize = 256 << 20
+	}
+	for _, f := range cfg.Formats {
+		if f == "warehouse" && cfg.WarehouseURL == "" {
+			return fmt.Errorf("для формата warehouse нужно указать export.warehouse_url")
+		}
+	}
+	if cfg.Geometry == "" {
+		cfg.Geometry = "wkb"
+	}
+	if _, ok := geometryEncodings[cfg.Geometry]; !ok {
+		return fmt.Errorf("неизвестное представление export.geometry: %s", cfg.Geometry)
+	}
+	if cfg.Parallel < 0 {
+		return fmt.Errorf("export.parallel не может быть отрицательным")
+	}
+	if cfg.ParallelMinSize <= 0 {
+		cfg.ParallelMinSize = 1 << 30
+	}
+	if cfg.ChunkSize < 0 {
+		return fmt.Errorf("export.chunk_size не может быть отрицательным")
+	}
+	return compilePipeline(cfg)
+}
+
+// exportSnapshots выгружает созданные за запуск бэкапы во все настроенные форматы
+func exportSnapshots(db *sql.DB, config *Config, result *runResult, realRun bool) error {
+	cfg := &config.Export
+	if len(cfg.Formats) == 0 || len(result.Created) == 0 {
+		return nil
+	}
+
+	if !realRun {
+		log.Printf("Тестовый запуск, будет выполнена выгрузка %d таблиц в форматах %s в каталог %s",
+			len(result.Created), strings.Join(cfg.Formats, ", "), cfg.Dir)
+		return nil
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return fmt.Errorf("ошибка создания каталога выгрузки: %w", err)
+	}
+
+	var failed []string
+	for _, format := range cfg.Formats {
+		artifacts, err := exporters[format](db, config, result.Created, result.Date)
+		result.addArtifacts(artifacts...)
+		if err != nil {
+			log.Printf("Ошибка выгрузки в формате %s: %v", format, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", format, err))
+			continue
+		}
+		for _, a := range artifacts {
+			log.Printf("Выгружено в %s: %s (%s)", format, a.Path, ByteSize(a.Size))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("ошибка выгрузки: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// quoteSQLIdent экранирует идентификатор для SQL файловых форматов (DuckDB, SQLite)
+func quoteSQLIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqlStringLiteral экранирует строковый литерал стандартного SQL для скриптов файловых форматов (DuckDB, SQLite):
+// строка в одинарных кавычках с удвоением кавычек внутри, обратная косая черта не является escape-символом
+func sqlStringLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Виды значений, к которым приводятся типы PostgreSQL при выгрузке в типизированные форматы (Avro, Arrow)
+const (
+	kindInt16       = "int16"
+	kindInt32       = "int32"
+	kindInt64       = "int64"
+	kindFloat32     = "float32"
+	kindFloat64     = "float64"
+	kindBool        = "bool"
+	kindDate        = "date"
+	kindTimestamp   = "timestamp"   // timestamp without time zone, микросекунды
+	kindTimestampTZ = "timestamptz" // timestamp with time zone, микросекунды UTC
+	kindBytes       = "bytes"
+	kindString      = "string"
+)
+
+// columnKind подбирает вид значения по базовому типу PostgreSQL; numeric, json, массивы и прочее выгружаются строками
+func columnKind(baseType string) string {
+	switch baseType {
+	case "int2":
+		return kindInt16
+	case "int4":
+		return kindInt32
+	case "int8", "oid":
+		return kindInt64
+	case "float4":
+		return kindFloat32
+	case "float8":
+		return kindFloat64
+	case "bool":
+		return kindBool
+	case "date":
+		return kindDate
+	case "timestamp":
+		return kindTimestamp
+	case "timestamptz":
+		return kindTimestampTZ
+	case "bytea":
+		return kindBytes
+	default:
+		return kindString
+	}
+}
+
+// valueString приводит значение из lib/pq к строке
+func valueString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// valueInt64 приводит целое значение из lib/pq к int64
+func valueInt64(v interface{}) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case float64:
+		return int64(v)
+	default:
+		n, _ := strconv.ParseInt(valueString(v), 10, 64)
+		return n
+	}
+}
+
+// valueFloat64 приводит числовое значение из lib/pq к float64
+func valueFloat64(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case int64:
+		return float64(v)
+	default:
+		f, _ := strconv.ParseFloat(valueString(v), 64)
+		return f
+	}
+}
+
+// valueDays возвращает количество дней от 1970-01-01 для даты
+func valueDays(v interface{}) int32 {
+	t, _ := v.(time.Time)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return int32(day.Unix() / 86400)
+}
+
+// valueMicros возвращает количество микросекунд от 1970-01-01 00:00 UTC.
+// Для timestamp без часового пояса берется время "как есть", будто оно в UTC.
+func valueMicros(v interface{}, kind string) int64 {
+	t, _ := v.(time.Time)
+	if kind == kindTimestamp {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	}
+	return t.UnixMicro()
+}
+
+// exportFilePath возвращает путь файла выгрузки одной таблицы
+func exportFilePath(config *Config, table, date, ext string) string {
+	return filepath.Join(config.Export.Dir, fmt.Sprintf("%s_%s_%s.%s", config.Backup.Prefix, table, date, ext))
+}
+
+// exportTables выгружает каждую таблицу запуска в отдельный файл с помощью write;
+// поток проходит этапы конвейера export.pipeline
+func exportTables(db *sql.DB, config *Config, created []createdBackup, date, kind, ext string,
+	write func(w io.Writer, c createdBackup, columns []column) error) ([]artifact, error) {
+	var artifacts []artifact
+	for _, c := range created {
+		columns, err := exportColumns(db, &config.Export, c.Backup)
+		if err != nil {
+			return artifacts, dberrors.Wrap(dberrors.OpExport, c.Source, fmt.Errorf("%s: %w", c.Backup, err))
+		}
+
+		w, path, err := createPipeline(&config.Export, exportFilePath(config, c.Source, date, ext))
+		if err != nil {
+			return artifacts, dberrors.Wrap(dberrors.OpExport, c.Source, err)
+		}
+		bw := bufio.NewWriterSize(w, 1<<20)
+		err = write(bw, c, columns)
+		if err == nil {
+			err = bw.Flush()
+		}
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.RemoveAll(path)
+			return artifacts, dberrors.Wrap(dberrors.OpExport, c.Source, fmt.Errorf("%s: %w", c.Backup, err))
+		}
+
+		size, err := pathSize(path)
+		if err != nil {
+			return artifacts, err
+		}
+		artifacts = append(artifacts, artifact{
+			Kind:      kind,
+			Source:    c.Source,
+			Path:      path,
+			Size:      size,
+			CreatedAt: clock.Now(),
+			Manifest:  withSpatial(spatialManifest(c.Source, columns)),
+		})
+	}
+	return artifacts, nil
+}
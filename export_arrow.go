@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+func init() {
+	exporters["arrow"] = exportArrow
+}
+
+// Количество строк в одном RecordBatch
+const arrowBatchRows = 65536
+
+// Объем данных колонок, после которого RecordBatch сбрасывается раньше arrowBatchRows. Смещения строк
+// и двоичных значений в Arrow 32-битные, поэтому данные колонки в одном RecordBatch должны быть меньше 2 ГБ;
+// значение в PostgreSQL не больше 1 ГБ, так что после сброса по этому лимиту смещения не переполняются
+const arrowBatchBytes = 256 << 20
+
+// exportArrow выгружает каждую таблицу запуска в файл в формате Arrow IPC streaming (.arrows)
+func exportArrow(db *sql.DB, config *Config, created []createdBackup, date string) ([]artifact, error) {
+	return exportTables(db, config, created, date, "arrow", "arrows",
+		func(w io.Writer, c createdBackup, columns []column) error {
+			aw, err := newArrowWriter(w, columns)
+			if err != nil {
+				return err
+			}
+			if err := scanTable(db, c.Backup, columns, aw.WriteRecord); err != nil {
+				return err
+			}
+			return aw.Close()
+		})
+}
+
+// arrowWriter пишет строки в поток Arrow IPC: сообщение Schema, затем RecordBatch по arrowBatchRows строк
+// или меньше, если данные колонок достигли batchBytes
+type arrowWriter struct {
+	w          io.Writer
+	columns    []*arrowColumn
+	rows       int
+	batchBytes int // лимит объема данных колонок в RecordBatch (arrowBatchBytes)
+}
+
+// arrowColumn накапливает значения одной колонки текущего RecordBatch
+type arrowColumn struct {
+	kind     string
+	validity []byte
+	nulls    int
+	values   bytes.Buffer // значения фиксированной ширины или данные строк
+	offsets  []int32      // смещения строк для utf8/binary
+	bits     []byte       // значения bool
+}
+
+// newArrowWriter пишет в поток сообщение со схемой
+func newArrowWriter(w io.Writer, columns []column) (*arrowWriter, error) {
+	aw := &arrowWriter{w: w, batchBytes: arrowBatchBytes}
+	fields := make([]*fbTable, len(columns))
+	for i, c := range columns {
+		kind := columnKind(c.exportType())
+		aw.columns = append(aw.columns, &arrowColumn{kind: kind})
+		typeID, typeTable := arrowType(kind)
+		fields[i] = &fbTable{fields: []fbField{
+			{ref: fbString(c.Name)}, // name
+			fbScalar(1, 1),          // nullable
+			fbScalar(1, typeID),     // type_type
+			{ref: typeTable},        // type
+			{},                      // dictionary
+			{ref: fbTables{}},       // children
+		}}
+	}
+	aw.reset()
+
+	schema := &fbTable{fields: []fbField{
+		fbScalar(2, 0), // endianness: Little
+		{ref: fbTables(fields)},
+	}}
+	return aw, aw.writeMessage(1, schema, nil)
+}
+
+// WriteRecord добавляет строку в текущий RecordBatch
+func (aw *arrowWriter) WriteRecord(values []interface{}) error {
+	row := aw.rows
+	size := 0
+	for i, v := range values {
+		aw.columns[i].append(row, v)
+		size += aw.columns[i].values.Len()
+	}
+	aw.rows++
+	if aw.rows >= arrowBatchRows || size >= aw.batchBytes {
+		return aw.flush()
+	}
+	return nil
+}
+
+// Close сбрасывает последний RecordBatch и пишет маркер конца потока
+func (aw *arrowWriter) Close() error {
+	if err := aw.flush(); err != nil {
+		return err
+	}
+	_, err := aw.w.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0})
+	return err
+}
+
+// flush пишет накопленные строки как RecordBatch
+func (aw *arrowWriter) flush() error {
+	if aw.rows == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	var nodes, buffers bytes.Buffer
+	addBuffer := func(data []byte) {
+		binary.Write(&buffers, binary.LittleEndian, int64(body.Len()))
+		binary.Write(&buffers, binary.LittleEndian, int64(len(data)))
+		body.Write(data)
+		for body.Len()%8 != 0 {
+			body.WriteByte(0)
+		}
+	}
+	for _, c := range aw.columns {
+		binary.Write(&nodes, binary.LittleEndian, int64(aw.rows))
+		binary.Write(&nodes, binary.LittleEndian, int64(c.nulls))
+		addBuffer(c.validity)
+		switch c.kind {
+		case kindBool:
+			addBuffer(c.bits)
+		case kindString, kindBytes:
+			offsets := make([]byte, 4*len(c.offsets))
+			for i, o := range c.offsets {
+				binary.LittleEndian.PutUint32(offsets[4*i:], uint32(o))
+			}
+			addBuffer(offsets)
+			addBuffer(c.values.Bytes())
+		default:
+			addBuffer(c.values.Bytes())
+		}
+	}
+
+	batch := &fbTable{fields: []fbField{
+		fbScalar(8, uint64(aw.rows)),                                       // length
+		{ref: fbStructs{count: len(aw.columns), data: nodes.Bytes()}},      // nodes
+		{ref: fbStructs{count: buffers.Len() / 16, data: buffers.Bytes()}}, // buffers
+	}}
+	if err := aw.writeMessage(3, batch, body.Bytes()); err != nil {
+		return err
+	}
+	aw.reset()
+	return nil
+}
+
+// reset очищает накопленные значения перед следующим RecordBatch
+func (aw *arrowWriter) reset() {
+	aw.rows = 0
+	for _, c := range aw.columns {
+		c.validity = c.validity[:0]
+		c.bits = c.bits[:0]
+		c.nulls = 0
+		c.values.Reset()
+		c.offsets = append(c.offsets[:0], 0)
+	}
+}
+
+// writeMessage пишет инкапсулированное сообщение: маркер продолжения, размер метаданных, метаданные и тело
+func (aw *arrowWriter) writeMessage(headerType uint64, header *fbTable, body []byte) error {
+	message := &fbTable{fields: []fbField{
+		fbScalar(2, 4),          // version: V5
+		fbScalar(1, headerType), // header_type: Schema = 1, RecordBatch = 3
+		{ref: header},           // header
+		fbScalar(8, uint64(len(body))),
+	}}
+	meta := fbFinish(message)
+	for (8+len(meta))%8 != 0 {
+		meta = append(meta, 0)
+	}
+
+	var prefix [8]byte
+	binary.LittleEndian.PutUint32(prefix[0:], 0xFFFFFFFF)
+	binary.LittleEndian.PutUint32(prefix[4:], uint32(len(meta)))
+	for _, part := range [][]byte{prefix[:], meta, body} {
+		if _, err := aw.w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// append добавляет значение в колонку
+func (c *arrowColumn) append(row int, v interface{}) {
+	if row%8 == 0 {
+		c.validity = append(c.validity, 0)
+		if c.kind == kindBool {
+			c.bits = append(c.bits, 0)
+		}
+	}
+	if v == nil {
+		c.nulls++
+	} else {
+		c.validity[row/8] |= 1 << (row % 8)
+	}
+
+	var buf [8]byte
+	switch c.kind {
+	case kindBool:
+		if v == true {
+			c.bits[row/8] |= 1 << (row % 8)
+		}
+	case kindInt16:
+		binary.LittleEndian.PutUint16(buf[:], uint16(valueInt64OrZero(v)))
+		c.values.Write(buf[:2])
+	case kindInt32:
+		binary.LittleEndian.PutUint32(buf[:], uint32(valueInt64OrZero(v)))
+		c.values.Write(buf[:4])
+	case kindInt64:
+		binary.LittleEndian.PutUint64(buf[:], uint64(valueInt64OrZero(v)))
+		c.values.Write(buf[:8])
+	case kindFloat32:
+		var f float64
+		if v != nil {
+			f = valueFloat64(v)
+		}
+		binary.LittleEndian.PutUint32(buf[:], math.Float32bits(float32(f)))
+		c.values.Write(buf[:4])
+	case kindFloat64:
+		var f float64
+		if v != nil {
+			f = valueFloat64(v)
+		}
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+		c.values.Write(buf[:8])
+	case kindDate:
+		var days int32
+		if v != nil {
+			days = valueDays(v)
+		}
+		binary.LittleEndian.PutUint32(buf[:], uint32(days))
+		c.values.Write(buf[:4])
+	case kindTimestamp, kindTimestampTZ:
+		var micros int64
+		if v != nil {
+			micros = valueMicros(v, c.kind)
+		}
+		binary.LittleEndian.PutUint64(buf[:], uint64(micros))
+		c.values.Write(buf[:8])
+	case kindBytes:
+		if data, ok := v.([]byte); ok {
+			c.values.Write(data)
+		}
+		c.offsets = append(c.offsets, int32(c.values.Len()))
+	default:
+		if v != nil {
+			c.values.WriteString(valueString(v))
+		}
+		c.offsets = append(c.offsets, int32(c.values.Len()))
+	}
+}
+
+// valueInt64OrZero возвращает целое значение или 0 для NULL
+func valueInt64OrZero(v interface{}) int64 {
+	if v == nil {
+		return 0
+	}
+	return valueInt64(v)
+}
+
+// arrowType возвращает идентификатор типа в union Type схемы Arrow и таблицу параметров типа
+func arrowType(kind string) (uint64, *fbTable) {
+	switch kind {
+	case kindInt16, kindInt32, kindInt64:
+		width := map[string]uint64{kindInt16: 16, kindInt32: 32, kindInt64: 64}[kind]
+		return 2, &fbTable{fields: []fbField{fbScalar(4, width), fbScalar(1, 1)}} // Int{bitWidth, is_signed}
+	case kindFloat32:
+		return 3, &fbTable{fields: []fbField{fbScalar(2, 1)}} // FloatingPoint{SINGLE}
+	case kindFloat64:
+		return 3, &fbTable{fields: []fbField{fbScalar(2, 2)}} // FloatingPoint{DOUBLE}
+	case kindBytes:
+		return 4, &fbTable{} // Binary
+	case kindBool:
+		return 6, &fbTable{} // Bool
+	case kindDate:
+		return 8, &fbTable{fields: []fbField{fbScalar(2, 0)}} // Date{DAY}
+	case kindTimestamp:
+		return 10, &fbTable{fields: []fbField{fbScalar(2, 2)}} // Timestamp{MICROSECOND}
+	case kindTimestampTZ:
+		return 10, &fbTable{fields: []fbField{fbScalar(2, 2), {ref: fbString("UTC")}}} // Timestamp{MICROSECOND, "UTC"}
+	default:
+		return 5, &fbTable{} // Utf8
+	}
+}
+
+// Минимальный сериализатор flatbuffers для метаданных Arrow.
+// Буфер строится от начала к концу: vtable, затем таблица, затем объекты, на которые она ссылается.
+
+// fbTable таблица flatbuffers; индекс в fields - идентификатор поля в схеме
+type fbTable struct {
+	fields []fbField
+}
+
+// fbField поле таблицы: скаляр размером size байт или ссылка на объект; пустое поле не записывается
+type fbField struct {
+	size   int
+	scalar uint64
+	ref    interface{} // *fbTable, fbString, fbTables или fbStructs
+}
+
+// fbString строка flatbuffers
+type fbString string
+
+// fbTables вектор таблиц
+type fbTables []*fbTable
+
+// fbStructs вектор структур с выравниванием 8 байт (FieldNode, Buffer)
+type fbStructs struct {
+	count int
+	data  []byte
+}
+
+// fbScalar возвращает скалярное поле
+func fbScalar(size int, v uint64) fbField {
+	return fbField{size: size, scalar: v}
+}
+
+type fbBuilder struct {
+	buf []byte
+}
+
+// fbFinish сериализует корневую таблицу
+func fbFinish(root *fbTable) []byte {
+	b := &fbBuilder{buf: make([]byte, 4)}
+	pos := b.writeTable(root)
+	binary.LittleEndian.PutUint32(b.buf[0:], uint32(pos))
+	return b.buf
+}
+
+func (b *fbBuilder) pad(align int) {
+	for len(b.buf)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *fbBuilder) writeTable(t *fbTable) int {
+	b.pad(2)
+	vtable := len(b.buf)
+	b.buf = append(b.buf, make([]byte, 4+2*len(t.fields))...)
+
+	b.pad(4)
+	table := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(int32(table-vtable)))
+
+	type pending struct {
+		pos int
+		ref interface{}
+	}
+	var refs []pending
+	for i, f := range t.fields {
+		var pos int
+		switch {
+		case f.ref != nil:
+			b.pad(4)
+			pos = len(b.buf)
+			b.buf = append(b.buf, 0, 0, 0, 0)
+			refs = append(refs, pending{pos, f.ref})
+		case f.size > 0:
+			b.pad(f.size)
+			pos = len(b.buf)
+			var buf [8]byte
+			binary.LittleEndian.PutUint64(buf[:], f.scalar)
+			b.buf = append(b.buf, buf[:f.size]...)
+		default:
+			continue
+		}
+		binary.LittleEndian.PutUint16(b.buf[vtable+4+2*i:], uint16(pos-table))
+	}
+	binary.LittleEndian.PutUint16(b.buf[vtable:], uint16(4+2*len(t.fields)))
+	binary.LittleEndian.PutUint16(b.buf[vtable+2:], uint16(len(b.buf)-table))
+
+	for _, r := range refs {
+		target := b.writeObject(r.ref)
+		binary.LittleEndian.PutUint32(b.buf[r.pos:], uint32(target-r.pos))
+	}
+	return table
+}
+
+func (b *fbBuilder) writeObject(obj interface{}) int {
+	switch obj := obj.(type) {
+	case *fbTable:
+		return b.writeTable(obj)
+	case fbString:
+		b.pad(4)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(obj)))
+		b.buf = append(b.buf, obj...)
+		b.buf = append(b.buf, 0)
+		return pos
+	case fbTables:
+		b.pad(4)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(obj)))
+		slots := len(b.buf)
+		b.buf = append(b.buf, make([]byte, 4*len(obj))...)
+		for i, t := range obj {
+			target := b.writeTable(t)
+			slot := slots + 4*i
+			binary.LittleEndian.PutUint32(b.buf[slot:], uint32(target-slot))
+		}
+		return pos
+	case fbStructs:
+		// данные структур после длины вектора должны быть выровнены по 8 байт
+		for (len(b.buf)+4)%8 != 0 {
+			b.buf = append(b.buf, 0)
+		}
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(obj.count))
+		b.buf = append(b.buf, obj.data...)
+		return pos
+	}
+	panic("fbBuilder: неизвестный тип объекта")
+}
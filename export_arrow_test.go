@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Читатель потока Arrow IPC для тестов, написанный по спецификации формата (Message.fbs, Schema.fbs)
+// независимо от arrowWriter: разбирает flatbuffers метаданных и буферы тела RecordBatch.
+
+// fbReader таблица flatbuffers в буфере
+type fbReader struct {
+	buf []byte
+	pos int
+}
+
+func fbRootTable(buf []byte) fbReader {
+	return fbReader{buf, int(binary.LittleEndian.Uint32(buf))}
+}
+
+// field возвращает позицию поля i или 0, если поле не записано
+func (r fbReader) field(i int) int {
+	vtable := r.pos - int(int32(binary.LittleEndian.Uint32(r.buf[r.pos:])))
+	size := int(binary.LittleEndian.Uint16(r.buf[vtable:]))
+	if 4+2*i >= size {
+		return 0
+	}
+	off := int(binary.LittleEndian.Uint16(r.buf[vtable+4+2*i:]))
+	if off == 0 {
+		return 0
+	}
+	return r.pos + off
+}
+
+func (r fbReader) uint(i, size int) uint64 {
+	p := r.field(i)
+	if p == 0 {
+		return 0
+	}
+	var buf [8]byte
+	copy(buf[:], r.buf[p:p+size])
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+func (r fbReader) deref(i int) int {
+	p := r.field(i)
+	if p == 0 {
+		return 0
+	}
+	return p + int(binary.LittleEndian.Uint32(r.buf[p:]))
+}
+
+func (r fbReader) table(i int) fbReader {
+	return fbReader{r.buf, r.deref(i)}
+}
+
+func (r fbReader) str(i int) string {
+	p := r.deref(i)
+	if p == 0 {
+		return ""
+	}
+	n := int(binary.LittleEndian.Uint32(r.buf[p:]))
+	return string(r.buf[p+4 : p+4+n])
+}
+
+func (r fbReader) tables(i int) []fbReader {
+	p := r.deref(i)
+	n := int(binary.LittleEndian.Uint32(r.buf[p:]))
+	tables := make([]fbReader, n)
+	for j := range tables {
+		slot := p + 4 + 4*j
+		tables[j] = fbReader{r.buf, slot + int(binary.LittleEndian.Uint32(r.buf[slot:]))}
+	}
+	return tables
+}
+
+// structs возвращает вектор структур из двух int64 (FieldNode, Buffer)
+func (r fbReader) structs(i int) ([][2]int64, int) {
+	p := r.deref(i)
+	n := int(binary.LittleEndian.Uint32(r.buf[p:]))
+	out := make([][2]int64, n)
+	for j := range out {
+		at := p + 4 + 16*j
+		out[j][0] = int64(binary.LittleEndian.Uint64(r.buf[at:]))
+		out[j][1] = int64(binary.LittleEndian.Uint64(r.buf[at+8:]))
+	}
+	return out, p + 4
+}
+
+// arrowTestField поле схемы
+type arrowTestField struct {
+	Name     string
+	TypeID   int
+	BitWidth int
+	Unit     int
+	Timezone string
+}
+
+// arrowTestStream разобранный поток: схема и значения колонок всех RecordBatch
+type arrowTestStream struct {
+	Fields  []arrowTestField
+	Batches []int // количество строк в каждом RecordBatch
+	Columns [][]interface{}
+}
+
+func readArrowStream(t *testing.T, data []byte) *arrowTestStream {
+	t.Helper()
+	s := &arrowTestStream{}
+	for pos := 0; ; {
+		if binary.LittleEndian.Uint32(data[pos:]) != 0xFFFFFFFF {
+			t.Fatalf("смещение %d: нет маркера продолжения", pos)
+		}
+		metaLen := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		pos += 8
+		if metaLen == 0 {
+			if pos != len(data) {
+				t.Fatalf("данные после маркера конца потока: %d байт", len(data)-pos)
+			}
+			return s
+		}
+		if (pos+metaLen)%8 != 0 {
+			t.Fatalf("метаданные сообщения не выровнены по 8 байт")
+		}
+		msg := fbRootTable(data[pos : pos+metaLen])
+		pos += metaLen
+		if v := msg.uint(0, 2); v != 4 {
+			t.Fatalf("версия метаданных %d, ожидалась V5 (4)", v)
+		}
+		bodyLen := int(msg.uint(3, 8))
+		body := data[pos : pos+bodyLen]
+		pos += bodyLen
+
+		header := msg.table(2)
+		switch msg.uint(1, 1) {
+		case 1:
+			for _, f := range header.tables(1) {
+				typ := f.table(3)
+				field := arrowTestField{Name: f.str(0), TypeID: int(f.uint(2, 1))}
+				switch field.TypeID {
+				case 2:
+					field.BitWidth = int(typ.uint(0, 4))
+				case 3, 8:
+					field.Unit = int(typ.uint(0, 2))
+				case 10:
+					field.Unit = int(typ.uint(0, 2))
+					field.Timezone = typ.str(1)
+				}
+				s.Fields = append(s.Fields, field)
+			}
+			s.Columns = make([][]interface{}, len(s.Fields))
+		case 3:
+			s.readBatch(t, header, body)
+		default:
+			t.Fatalf("неожиданный тип сообщения %d", msg.uint(1, 1))
+		}
+	}
+}
+
+func (s *arrowTestStream) readBatch(t *testing.T, batch fbReader, body []byte) {
+	t.Helper()
+	length := int(batch.uint(0, 8))
+	nodes, _ := batch.structs(1)
+	buffers, _ := batch.structs(2)
+	s.Batches = append(s.Batches, length)
+	next := 0
+	buffer := func() []byte {
+		b := buffers[next]
+		next++
+		if b[0]%8 != 0 {
+			t.Fatalf("буфер по смещению %d не выровнен по 8 байт", b[0])
+		}
+		return body[b[0] : b[0]+b[1]]
+	}
+	for i, f := range s.Fields {
+		if int(nodes[i][0]) != length {
+			t.Fatalf("колонка %s: длина узла %d, у RecordBatch %d", f.Name, nodes[i][0], length)
+		}
+		validity := buffer()
+		valid := func(row int) bool { return validity[row/8]&(1<<(row%8)) != 0 }
+		var values, offsets []byte
+		switch f.TypeID {
+		case 4, 5:
+			offsets = buffer()
+			values = buffer()
+		default:
+			values = buffer()
+		}
+		nulls := 0
+		for row := 0; row < length; row++ {
+			if !valid(row) {
+				nulls++
+				s.Columns[i] = append(s.Columns[i], nil)
+				continue
+			}
+			var v interface{}
+			switch f.TypeID {
+			case 2:
+				switch f.BitWidth {
+				case 16:
+					v = int64(int16(binary.LittleEndian.Uint16(values[2*row:])))
+				case 32:
+					v = int64(int32(binary.LittleEndian.Uint32(values[4*row:])))
+				default:
+					v = int64(binary.LittleEndian.Uint64(values[8*row:]))
+				}
+			case 3:
+				if f.Unit == 1 {
+					v = float64(math.Float32frombits(binary.LittleEndian.Uint32(values[4*row:])))
+				} else {
+					v = math.Float64frombits(binary.LittleEndian.Uint64(values[8*row:]))
+				}
+			case 4, 5:
+				start := binary.LittleEndian.Uint32(offsets[4*row:])
+				end := binary.LittleEndian.Uint32(offsets[4*row+4:])
+				if f.TypeID == 4 {
+					v = append([]byte{}, values[start:end]...)
+				} else {
+					v = string(values[start:end])
+				}
+			case 6:
+				v = values[row/8]&(1<<(row%8)) != 0
+			case 8:
+				v = int64(int32(binary.LittleEndian.Uint32(values[4*row:])))
+			case 10:
+				v = int64(binary.LittleEndian.Uint64(values[8*row:]))
+			default:
+				t.Fatalf("колонка %s: неизвестный тип %d", f.Name, f.TypeID)
+			}
+			s.Columns[i] = append(s.Columns[i], v)
+		}
+		if int(nodes[i][1]) != nulls {
+			t.Fatalf("колонка %s: null_count %d, по битовой карте %d", f.Name, nodes[i][1], nulls)
+		}
+	}
+}
+
+// arrowTestColumns колонки всех поддерживаемых видов
+var arrowTestColumns = []column{
+	{Name: "id", BaseType: "int8"},
+	{Name: "small", BaseType: "int2"},
+	{Name: "n", BaseType: "int4"},
+	{Name: "ratio", BaseType: "float4"},
+	{Name: "amount_f", BaseType: "float8"},
+	{Name: "flag", BaseType: "bool"},
+	{Name: "day", BaseType: "date"},
+	{Name: "at", BaseType: "timestamp"},
+	{Name: "at_tz", BaseType: "timestamptz"},
+	{Name: "data", BaseType: "bytea"},
+	{Name: "name", BaseType: "text"},
+	{Name: "amount", BaseType: "numeric"},
+}
+
+// arrowTestRow строка i в том виде, в котором ее возвращает lib/pq; каждая пятая колонка по кругу - NULL
+func arrowTestRow(i int) []interface{} {
+	moscow := time.FixedZone("MSK", 3*3600)
+	row := []interface{}{
+		int64(i) * 1_000_000_007,
+		int64(i%200 - 100),
+		int64(-i * 3),
+		0.25 * float64(i),
+		math.Pi * float64(i),
+		i%2 == 0,
+		time.Date(1969+i%60, time.Month(1+i%12), 1+i%28, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 2, 29, 23, 59, 59, 123456000, time.UTC).Add(time.Duration(i) * time.Hour),
+		time.Date(2024, 1, 1, 3, 0, 0, 0, moscow).Add(time.Duration(i) * time.Minute),
+		[]byte{byte(i), 0, 0xFF},
+		fmt.Sprintf("строка %d 'с кавычкой'", i),
+		[]byte(fmt.Sprintf("%d.%02d", i, i%100)),
+	}
+	row[i%len(row)] = nil
+	return row
+}
+
+// arrowExpected значение, которое должен вернуть читатель для значения v колонки c
+func arrowExpected(c column, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch c.BaseType {
+	case "float4":
+		return float64(float32(v.(float64)))
+	case "date":
+		return int64(v.(time.Time).Sub(time.Unix(0, 0).UTC()) / (24 * time.Hour))
+	case "timestamp":
+		t := v.(time.Time)
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC).UnixMicro()
+	case "timestamptz":
+		return v.(time.Time).UnixMicro()
+	case "text":
+		return v.(string)
+	case "numeric":
+		return string(v.([]byte))
+	}
+	return v
+}
+
+func writeArrowTest(t *testing.T, rows int, batchBytes int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	aw, err := newArrowWriter(&buf, arrowTestColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batchBytes > 0 {
+		aw.batchBytes = batchBytes
+	}
+	for i := 0; i < rows; i++ {
+		if err := aw.WriteRecord(arrowTestRow(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestArrowRoundTrip(t *testing.T) {
+	const rows = 37 // несколько байтов битовых карт с неполным последним
+	s := readArrowStream(t, writeArrowTest(t, rows, 0))
+
+	wantTypes := []int{2, 2, 2, 3, 3, 6, 8, 10, 10, 4, 5, 5}
+	for i, f := range s.Fields {
+		if f.Name != arrowTestColumns[i].Name || f.TypeID != wantTypes[i] {
+			t.Errorf("поле %d: %s тип %d, ожидалось %s тип %d", i, f.Name, f.TypeID, arrowTestColumns[i].Name, wantTypes[i])
+		}
+	}
+	if s.Fields[1].BitWidth != 16 || s.Fields[2].BitWidth != 32 || s.Fields[0].BitWidth != 64 {
+		t.Errorf("разрядность целых: %d %d %d", s.Fields[0].BitWidth, s.Fields[1].BitWidth, s.Fields[2].BitWidth)
+	}
+	if s.Fields[7].Timezone != "" || s.Fields[8].Timezone != "UTC" {
+		t.Errorf("часовые пояса timestamp: %q и %q", s.Fields[7].Timezone, s.Fields[8].Timezone)
+	}
+	if !reflect.DeepEqual(s.Batches, []int{rows}) {
+		t.Errorf("RecordBatch: %v, ожидался один из %d строк", s.Batches, rows)
+	}
+	for i := 0; i < rows; i++ {
+		for j, v := range arrowTestRow(i) {
+			want := arrowExpected(arrowTestColumns[j], v)
+			if got := s.Columns[j][i]; !reflect.DeepEqual(got, want) {
+				t.Errorf("строка %d, колонка %s: %#v, ожидалось %#v", i, arrowTestColumns[j].Name, got, want)
+			}
+		}
+	}
+}
+
+func TestArrowBatchLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		rows       int
+		batchBytes int
+		check      func(batches []int) bool
+	}{
+		{"by rows", arrowBatchRows + 10, 0, func(b []int) bool { return reflect.DeepEqual(b, []int{arrowBatchRows, 10}) }},
+		{"by bytes", 100, 1024, func(b []int) bool { return len(b) > 1 && b[0] < 100 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := readArrowStream(t, writeArrowTest(t, tt.rows, tt.batchBytes))
+			if !tt.check(s.Batches) {
+				t.Fatalf("неожиданное разбиение на RecordBatch: %v", s.Batches)
+			}
+			total := 0
+			for _, n := range s.Batches {
+				total += n
+			}
+			if total != tt.rows {
+				t.Fatalf("строк %d, ожидалось %d", total, tt.rows)
+			}
+			for i := 0; i < tt.rows; i += 7 {
+				for j, v := range arrowTestRow(i) {
+					if got, want := s.Columns[j][i], arrowExpected(arrowTestColumns[j], v); !reflect.DeepEqual(got, want) {
+						t.Fatalf("строка %d, колонка %s: %#v, ожидалось %#v", i, arrowTestColumns[j].Name, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestArrowPyArrow читает файл эталонной реализацией pyarrow, если она установлена
+func TestArrowPyArrow(t *testing.T) {
+	if exec.Command("python3", "-c", "import pyarrow").Run() != nil {
+		t.Skip("pyarrow не установлен")
+	}
+	path := filepath.Join(t.TempDir(), "test.arrows")
+	if err := os.WriteFile(path, writeArrowTest(t, 37, 1024), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script := `
+import json, sys, pyarrow as pa
+table = pa.ipc.open_stream(open(sys.argv[1], "rb")).read_all()
+print(json.dumps({"rows": table.num_rows, "names": table.schema.names,
+	"types": [str(f.type) for f in table.schema], "nulls": [c.null_count for c in table.columns],
+	"name": table.column("name").to_pylist(), "at_tz": [str(v) for v in table.column("at_tz").to_pylist()]}))`
+	out, err := exec.Command("python3", "-c", script, path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("pyarrow: %v: %s", err, out)
+	}
+	var got struct {
+		Rows  int
+		Names []string
+		Types []string
+		Nulls []int
+		Name  []*string
+		AtTZ  []string `json:"at_tz"`
+	}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Rows != 37 {
+		t.Errorf("pyarrow прочитал %d строк", got.Rows)
+	}
+	wantTypes := []string{"int64", "int16", "int32", "float", "double", "bool", "date32[day]",
+		"timestamp[us]", "timestamp[us, tz=UTC]", "binary", "string", "string"}
+	if !reflect.DeepEqual(got.Types, wantTypes) {
+		t.Errorf("типы pyarrow: %v, ожидались %v", got.Types, wantTypes)
+	}
+	for i := 0; i < 37; i++ {
+		v := arrowTestRow(i)[10]
+		if (v == nil) != (got.Name[i] == nil) || v != nil && *got.Name[i] != v.(string) {
+			t.Errorf("строка %d: pyarrow прочитал name %v, ожидалось %v", i, got.Name[i], v)
+		}
+	}
+	if !strings.HasPrefix(got.AtTZ[0], "2024-01-01 00:00:00") {
+		t.Errorf("at_tz первой строки по pyarrow: %s", got.AtTZ[0])
+	}
+}
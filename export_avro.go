@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	exporters["avro"] = exportAvro
+}
+
+// Размер блока данных в файле Avro, после которого блок сбрасывается на диск
+const avroBlockSize = 1 << 20
+
+// exportAvro выгружает каждую таблицу запуска в файл Avro Object Container со встроенной схемой
+func exportAvro(db *sql.DB, config *Config, created []createdBackup, date string) ([]artifact, error) {
+	return exportTables(db, config, created, date, "avro", "avro",
+		func(w io.Writer, c createdBackup, columns []column) error {
+			aw, err := newAvroWriter(w, c.Source, columns)
+			if err != nil {
+				return err
+			}
+			if err := scanTable(db, c.Backup, columns, aw.WriteRecord); err != nil {
+				return err
+			}
+			return aw.Close()
+		})
+}
+
+// avroWriter пишет записи в формате Avro Object Container File (без сжатия)
+type avroWriter struct {
+	w     io.Writer
+	kinds []string
+	sync  [16]byte
+	block bytes.Buffer
+	count int64
+}
+
+// newAvroWriter пишет заголовок файла со схемой записи для колонок таблицы
+func newAvroWriter(w io.Writer, table string, columns []column) (*avroWriter, error) {
+	aw := &avroWriter{w: w, kinds: make([]string, len(columns))}
+	if _, err := rand.Read(aw.sync[:]); err != nil {
+		return nil, err
+	}
+
+	names := avroNames(columns)
+	fields := make([]map[string]interface{}, len(columns))
+	for i, c := range columns {
+		aw.kinds[i] = columnKind(c.exportType())
+		fields[i] = map[string]interface{}{
+			"name":    names[i],
+			"type":    []interface{}{"null", avroType(aw.kinds[i])},
+			"default": nil,
+			"doc":     c.Name + " " + c.Type,
+		}
+		if c.Geometry != "" {
+			fields[i]["doc"] = fmt.Sprintf("%s %s as %s", c.Name, c.Type, strings.ToUpper(c.Geometry))
+			// BigQuery загружает WKT колонок с SRID 4326 сразу в тип GEOGRAPHY
+			if s := spatialType(&c); c.Geometry == "wkt" && s.SRID == 4326 {
+				fields[i]["type"] = []interface{}{"null", map[string]interface{}{"type": "string", "sqlType": "GEOGRAPHY"}}
+			}
+		}
+	}
+	schema, err := json.Marshal(map[string]interface{}{
+		"type":      "record",
+		"name":      avroNames([]column{{Name: table}})[0],
+		"namespace": "dbacker",
+		"fields":    fields,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var header bytes.Buffer
+	header.WriteString("Obj\x01")
+	avroLong(&header, 2)
+	avroBytes(&header, []byte("avro.schema"))
+	avroBytes(&header, schema)
+	avroBytes(&header, []byte("avro.codec"))
+	avroBytes(&header, []byte("null"))
+	avroLong(&header, 0)
+	header.Write(aw.sync[:])
+	_, err = w.Write(header.Bytes())
+	return aw, err
+}
+
+// WriteRecord добавляет строку таблицы в текущий блок
+func (aw *avroWriter) WriteRecord(values []interface{}) error {
+	b := &aw.block
+	for i, v := range values {
+		if v == nil {
+			avroLong(b, 0)
+			continue
+		}
+		avroLong(b, 1)
+
+		switch aw.kinds[i] {
+		case kindInt16, kindInt32, kindInt64:
+			avroLong(b, valueInt64(v))
+		case kindFloat32:
+			var buf [4]byte
+			binary.LittleEndian.PutUint32(buf[:], math.Float32bits(float32(valueFloat64(v))))
+			b.Write(buf[:])
+		case kindFloat64:
+			var buf [8]byte
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(valueFloat64(v)))
+			b.Write(buf[:])
+		case kindBool:
+			if v == true {
+				b.WriteByte(1)
+			} else {
+				b.WriteByte(0)
+			}
+		case kindDate:
+			avroLong(b, int64(valueDays(v)))
+		case kindTimestamp, kindTimestampTZ:
+			avroLong(b, valueMicros(v, aw.kinds[i]))
+		case kindBytes:
+			data, _ := v.([]byte)
+			avroBytes(b, data)
+		default:
+			avroBytes(b, []byte(valueString(v)))
+		}
+	}
+
+	aw.count++
+	if b.Len() >= avroBlockSize {
+		return aw.flush()
+	}
+	return nil
+}
+
+// Close сбрасывает последний блок
+func (aw *avroWriter) Close() error {
+	return aw.flush()
+}
+
+// flush пишет накопленный блок: количество записей, размер, данные и sync-маркер
+func (aw *avroWriter) flush() error {
+	if aw.count == 0 {
+		return nil
+	}
+	var head bytes.Buffer
+	avroLong(&head, aw.count)
+	avroLong(&head, int64(aw.block.Len()))
+	for _, part := range [][]byte{head.Bytes(), aw.block.Bytes(), aw.sync[:]} {
+		if _, err := aw.w.Write(part); err != nil {
+			return err
+		}
+	}
+	aw.block.Reset()
+	aw.count = 0
+	return nil
+}
+
+// avroType возвращает тип Avro для вида значения
+func avroType(kind string) interface{} {
+	switch kind {
+	case kindInt16, kindInt32:
+		return "int"
+	case kindInt64:
+		return "long"
+	case kindFloat32:
+		return "float"
+	case kindFloat64:
+		return "double"
+	case kindBool:
+		return "boolean"
+	case kindDate:
+		return map[string]string{"type": "int", "logicalType": "date"}
+	case kindTimestamp:
+		return map[string]string{"type": "long", "logicalType": "local-timestamp-micros"}
+	case kindTimestampTZ:
+		return map[string]string{"type": "long", "logicalType": "timestamp-micros"}
+	case kindBytes:
+		return "bytes"
+	default:
+		return "string"
+	}
+}
+
+var avroInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// avroNames приводит имена колонок к допустимым в Avro ([A-Za-z_][A-Za-z0-9_]*) без повторов
+func avroNames(columns []column) []string {
+	names := make([]string, len(columns))
+	seen := make(map[string]bool)
+	for i, c := range columns {
+		name := avroInvalidChars.ReplaceAllString(c.Name, "_")
+		if name == "" || (name[0] >= '0' && name[0] <= '9') {
+			name = "_" + name
+		}
+		base := name
+		for n := 2; seen[strings.ToLower(name)]; n++ {
+			name = fmt.Sprintf("%s_%d", base, n)
+		}
+		seen[strings.ToLower(name)] = true
+		names[i] = name
+	}
+	return names
+}
+
+// avroLong пишет long в кодировке zigzag varint
+func avroLong(b *bytes.Buffer, v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	b.Write(buf[:n])
+}
+
+// avroBytes пишет bytes/string: длина и данные
+func avroBytes(b *bytes.Buffer, data []byte) {
+	avroLong(b, int64(len(data)))
+	b.Write(data)
+}
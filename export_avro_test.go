@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Читатель контейнера Avro (Object Container File) для тестов по спецификации Avro 1.11: разбирает
+// заголовок, схему из метаданных и блоки записей, значения декодирует по схеме, а не по колонкам.
+
+type avroTestReader struct {
+	t    *testing.T
+	data []byte
+	pos  int
+}
+
+func (r *avroTestReader) long() int64 {
+	var u uint64
+	for shift := 0; ; shift += 7 {
+		if r.pos >= len(r.data) || shift > 63 {
+			r.t.Fatalf("смещение %d: оборванный long", r.pos)
+		}
+		b := r.data[r.pos]
+		r.pos++
+		u |= uint64(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+func (r *avroTestReader) next(n int) []byte {
+	if n < 0 || r.pos+n > len(r.data) {
+		r.t.Fatalf("смещение %d: нет %d байт", r.pos, n)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *avroTestReader) bytes() []byte {
+	return r.next(int(r.long()))
+}
+
+// value читает значение типа из схемы: имя примитива, объект с логическим типом или union
+func (r *avroTestReader) value(typ interface{}) interface{} {
+	switch typ := typ.(type) {
+	case []interface{}:
+		return r.value(typ[r.long()])
+	case map[string]interface{}:
+		return r.value(typ["type"])
+	case string:
+		switch typ {
+		case "null":
+			return nil
+		case "int", "long":
+			return r.long()
+		case "float":
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(r.next(4))))
+		case "double":
+			return math.Float64frombits(binary.LittleEndian.Uint64(r.next(8)))
+		case "boolean":
+			return r.next(1)[0] == 1
+		case "bytes":
+			return append([]byte{}, r.bytes()...)
+		case "string":
+			return string(r.bytes())
+		}
+	}
+	r.t.Fatalf("неизвестный тип Avro %v", typ)
+	return nil
+}
+
+// avroTestFile разобранный файл: схема, количество записей в блоках и записи
+type avroTestFile struct {
+	Schema struct {
+		Type   string
+		Name   string
+		Fields []struct {
+			Name string
+			Type interface{}
+			Doc  string
+		}
+	}
+	Blocks  []int
+	Records [][]interface{}
+}
+
+func readAvroFile(t *testing.T, data []byte) *avroTestFile {
+	t.Helper()
+	r := &avroTestReader{t: t, data: data}
+	if string(r.next(4)) != "Obj\x01" {
+		t.Fatal("нет магической последовательности Obj1")
+	}
+	meta := make(map[string][]byte)
+	for {
+		n := r.long()
+		if n == 0 {
+			break
+		}
+		if n < 0 {
+			n = -n
+			r.long()
+		}
+		for ; n > 0; n-- {
+			key := string(r.bytes())
+			meta[key] = r.bytes()
+		}
+	}
+	if codec := string(meta["avro.codec"]); codec != "" && codec != "null" {
+		t.Fatalf("кодек %s", codec)
+	}
+	f := &avroTestFile{}
+	if err := json.Unmarshal(meta["avro.schema"], &f.Schema); err != nil {
+		t.Fatalf("схема: %v", err)
+	}
+	sync := r.next(16)
+	for r.pos < len(data) {
+		count := int(r.long())
+		size := int(r.long())
+		end := r.pos + size
+		for i := 0; i < count; i++ {
+			record := make([]interface{}, len(f.Schema.Fields))
+			for j, field := range f.Schema.Fields {
+				record[j] = r.value(field.Type)
+			}
+			f.Records = append(f.Records, record)
+		}
+		if r.pos != end {
+			t.Fatalf("блок %d: прочитано %d байт вместо %d", len(f.Blocks), size+r.pos-end, size)
+		}
+		if !bytes.Equal(r.next(16), sync) {
+			t.Fatalf("блок %d: sync-маркер не совпадает с заголовком", len(f.Blocks))
+		}
+		f.Blocks = append(f.Blocks, count)
+	}
+	return f
+}
+
+func writeAvroTest(t *testing.T, rows int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	aw, err := newAvroWriter(&buf, "public.orders", arrowTestColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < rows; i++ {
+		if err := aw.WriteRecord(arrowTestRow(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAvroRoundTrip(t *testing.T) {
+	const rows = 37
+	f := readAvroFile(t, writeAvroTest(t, rows))
+
+	if f.Schema.Type != "record" || f.Schema.Name != "public_orders" {
+		t.Errorf("запись %s %s", f.Schema.Type, f.Schema.Name)
+	}
+	wantLogical := map[string]string{"day": "date", "at": "local-timestamp-micros", "at_tz": "timestamp-micros"}
+	for i, field := range f.Schema.Fields {
+		if field.Name != arrowTestColumns[i].Name {
+			t.Errorf("поле %d: %s, ожидалось %s", i, field.Name, arrowTestColumns[i].Name)
+		}
+		union, _ := field.Type.([]interface{})
+		if len(union) != 2 || union[0] != "null" {
+			t.Errorf("поле %s: тип %v вместо [\"null\", T]", field.Name, field.Type)
+			continue
+		}
+		logical, _ := union[1].(map[string]interface{})
+		if want := wantLogical[field.Name]; want != "" && (logical == nil || logical["logicalType"] != want) {
+			t.Errorf("поле %s: тип %v, ожидался логический тип %s", field.Name, union[1], want)
+		}
+	}
+	if len(f.Records) != rows {
+		t.Fatalf("записей %d, ожидалось %d", len(f.Records), rows)
+	}
+	for i, record := range f.Records {
+		for j, v := range arrowTestRow(i) {
+			want := arrowExpected(arrowTestColumns[j], v)
+			if got := record[j]; !reflect.DeepEqual(got, want) {
+				t.Errorf("запись %d, поле %s: %#v, ожидалось %#v", i, arrowTestColumns[j].Name, got, want)
+			}
+		}
+	}
+}
+
+func TestAvroBlocks(t *testing.T) {
+	// строка занимает около сотни байт, так что данные не помещаются в один блок avroBlockSize
+	const rows = 20000
+	f := readAvroFile(t, writeAvroTest(t, rows))
+	if len(f.Blocks) < 2 {
+		t.Fatalf("блоков %d, ожидалось несколько", len(f.Blocks))
+	}
+	if len(f.Records) != rows {
+		t.Fatalf("записей %d, ожидалось %d", len(f.Records), rows)
+	}
+	for _, i := range []int{0, f.Blocks[0] - 1, f.Blocks[0], rows - 1} {
+		for j, v := range arrowTestRow(i) {
+			if got, want := f.Records[i][j], arrowExpected(arrowTestColumns[j], v); !reflect.DeepEqual(got, want) {
+				t.Fatalf("запись %d, поле %s: %#v, ожидалось %#v", i, arrowTestColumns[j].Name, got, want)
+			}
+		}
+	}
+}
+
+func TestAvroNames(t *testing.T) {
+	tests := []struct {
+		columns []string
+		want    []string
+	}{
+		{[]string{"id", "name"}, []string{"id", "name"}},
+		{[]string{"order id", "сумма", "1st"}, []string{"order_id", "_____", "_1st"}},
+		{[]string{"a-b", "a_b", "A_B"}, []string{"a_b", "a_b_2", "A_B_3"}},
+		{[]string{""}, []string{"_"}},
+	}
+	for _, tt := range tests {
+		columns := make([]column, len(tt.columns))
+		for i, name := range tt.columns {
+			columns[i] = column{Name: name}
+		}
+		if got := avroNames(columns); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("avroNames(%q) = %q, ожидалось %q", tt.columns, got, tt.want)
+		}
+	}
+}
+
+// TestAvroFastavro читает файл эталонной реализацией fastavro, если она установлена
+func TestAvroFastavro(t *testing.T) {
+	if exec.Command("python3", "-c", "import fastavro").Run() != nil {
+		t.Skip("fastavro не установлен")
+	}
+	path := filepath.Join(t.TempDir(), "test.avro")
+	if err := os.WriteFile(path, writeAvroTest(t, 37), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script := `
+import json, sys, fastavro
+records = list(fastavro.reader(open(sys.argv[1], "rb")))
+print(json.dumps({"rows": len(records), "id": [r["id"] for r in records], "name": [r["name"] for r in records],
+	"day": [str(r["day"]) if r["day"] is not None else None for r in records]}))`
+	out, err := exec.Command("python3", "-c", script, path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("fastavro: %v: %s", err, out)
+	}
+	var got struct {
+		Rows int
+		ID   []*int64
+		Name []*string
+		Day  []*string
+	}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Rows != 37 {
+		t.Fatalf("fastavro прочитал %d записей", got.Rows)
+	}
+	for i := 0; i < 37; i++ {
+		row := arrowTestRow(i)
+		if id, _ := row[0].(int64); (row[0] == nil) != (got.ID[i] == nil) || row[0] != nil && *got.ID[i] != id {
+			t.Errorf("запись %d: fastavro прочитал id %v, ожидалось %v", i, got.ID[i], row[0])
+		}
+		if name, _ := row[10].(string); (row[10] == nil) != (got.Name[i] == nil) || row[10] != nil && *got.Name[i] != name {
+			t.Errorf("запись %d: fastavro прочитал name %v, ожидалось %v", i, got.Name[i], row[10])
+		}
+	}
+	if got.Day[0] == nil || *got.Day[0] != "1969-01-01" {
+		t.Errorf("day первой записи по fastavro: %v", got.Day[0])
+	}
+}
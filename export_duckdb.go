@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	exporters["duckdb"] = exportDuckDB
+}
+
+// exportDuckDB записывает все таблицы запуска в один файл DuckDB (одна таблица на исходную таблицу).
+// Данные читает сам duckdb через расширение postgres, параметры подключения передаются через переменные окружения libpq.
+func exportDuckDB(db *sql.DB, config *Config, created []createdBackup, date string) ([]artifact, error) {
+	path := filepath.Join(config.Export.Dir,
+		fmt.Sprintf("%s_%s_%s.duckdb", config.Backup.Prefix, config.Postgres.DBName, date))
+
+	// Повторный запуск за тот же день перезаписывает файл
+	os.Remove(path)
+
+	var script strings.Builder
+	if ext := config.Export.DuckDBPostgresExtension; ext != "" {
+		fmt.Fprintf(&script, "LOAD %s;\n", sqlStringLiteral(ext))
+	} else {
+		// INSTALL скачивает расширение из репозитория DuckDB, если оно еще не установлено
+		script.WriteString("INSTALL postgres;\nLOAD postgres;\n")
+	}
+	script.WriteString("ATTACH '' AS pg (TYPE POSTGRES, READ_ONLY);\n")
+	schema := backupPostgres(config).Schema
+	if schema == "" {
+		schema = "public"
+	}
+	var spatial []spatialColumn
+	for _, c := range created {
+		columns, err := exportColumns(db, &config.Export, c.Backup)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Backup, err)
+		}
+		tableSpatial := spatialManifest(c.Source, columns)
+		if len(tableSpatial) == 0 {
+			fmt.Fprintf(&script, "CREATE TABLE %s AS SELECT * FROM pg.%s.%s;\n",
+				quoteSQLIdent(c.Source), quoteSQLIdent(schema), quoteSQLIdent(c.Backup))
+			continue
+		}
+		// Колонки PostGIS преобразуются на стороне PostgreSQL: расширение postgres в duckdb их не читает
+		exprs := make([]string, len(columns))
+		for i := range columns {
+			exprs[i] = columns[i].selectExpr()
+		}
+		query := fmt.Sprintf("SELECT %s FROM %s.%s", strings.Join(exprs, ", "), quoteIdent(schema), quoteIdent(c.Backup))
+		fmt.Fprintf(&script, "CREATE TABLE %s AS SELECT * FROM postgres_query('pg', %s);\n",
+			quoteSQLIdent(c.Source), sqlStringLiteral(query))
+		spatial = append(spatial, tableSpatial...)
+	}
+	script.WriteString("DETACH pg;\n")
+
+	cmd := exec.Command(config.Export.DuckDBBinary, path)
+	cmd.Stdin = strings.NewReader(script.String())
+	pg, err := sessionPostgres(backupPostgres(config), "Выгрузка в DuckDB")
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = append(os.Environ(), pgEnv(pg)...)
+	cmd.Env = append(cmd.Env,
+		"PGHOST="+pg.Host,
+		fmt.Sprintf("PGPORT=%d", pg.Port),
+		"PGUSER="+pg.User,
+		"PGDATABASE="+pg.DBName,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	size, err := pathSize(path)
+	if err != nil {
+		return nil, err
+	}
+	// Файл пишет duckdb, поэтому этапы export.pipeline применяются к готовому файлу
+	return pipeArtifacts(&config.Export, []artifact{{
+		Kind:      "duckdb",
+		Source:    config.Postgres.DBName,
+		Path:      path,
+		Size:      size,
+		CreatedAt: clock.Now(),
+		Manifest:  withSpatial(spatial),
+	}})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// Параллельная выгрузка большой таблицы (export.parallel). Таблица бэкапа - копия CREATE TABLE AS без первичного
+// ключа и индексов, поэтому диапазон ключа читался бы полным просмотром в каждом потоке. Вместо этого таблица
+// делится на диапазоны страниц: условие по ctid выполняется как TID Range Scan (PostgreSQL 14+) и читает только
+// свои страницы, так что потоки вместе читают таблицу один раз. Таблица бэкапа не изменяется,
+// поэтому потоки в разных транзакциях видят одни и те же строки.
+
+// exportRanges возвращает условия для потоков выгрузки таблицы: по одному на диапазон страниц
+// или одно пустое условие, если таблица меньше export.parallel_min_size или сервер не поддерживает TID Range Scan
+func exportRanges(db *sql.DB, cfg *ExportConfig, table string) ([]string, error) {
+	if cfg.Parallel <= 1 {
+		return []string{""}, nil
+	}
+	var size, blockSize int64
+	var version int
+	err := db.QueryRow(`
+		SELECT pg_relation_size(to_regclass(quote_ident(current_schema()) || '.' || $1)),
+			current_setting('block_size')::bigint, current_setting('server_version_num')::int`,
+		quoteIdent(table)).Scan(&size, &blockSize, &version)
+	if err != nil {
+		return nil, err
+	}
+	if size < int64(cfg.ParallelMinSize) {
+		return []string{""}, nil
+	}
+	if version < 140000 {
+		log.Printf("Таблица %s выгружается одним потоком: чтение диапазонов страниц (TID Range Scan) есть с PostgreSQL 14", table)
+		return []string{""}, nil
+	}
+
+	blocks := size / blockSize
+	streams := min(int64(cfg.Parallel), blocks)
+	ranges := make([]string, 0, streams)
+	for i := int64(0); i < streams; i++ {
+		start, end := blocks*i/streams, blocks*(i+1)/streams
+		if i == streams-1 {
+			// Последний диапазон без верхней границы
+			ranges = append(ranges, fmt.Sprintf("ctid >= '(%d,0)'::tid", start))
+			continue
+		}
+		ranges = append(ranges, fmt.Sprintf("ctid >= '(%d,0)'::tid AND ctid < '(%d,0)'::tid", start, end))
+	}
+	log.Printf("Таблица %s (%s) выгружается в %d потоков", table, ByteSize(size), len(ranges))
+	return ranges, nil
+}
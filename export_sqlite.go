@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	exporters["sqlite"] = exportSQLite
+}
+
+// exportSQLite записывает все таблицы запуска в датированный файл SQLite.
+// Строки читаются из PostgreSQL и передаются в sqlite3 потоком SQL-команд.
+func exportSQLite(db *sql.DB, config *Config, created []createdBackup, date string) ([]artifact, error) {
+	path := filepath.Join(config.Export.Dir,
+		fmt.Sprintf("%s_%s_%s.sqlite", config.Backup.Prefix, config.Postgres.DBName, date))
+
+	// Повторный запуск за тот же день перезаписывает файл
+	os.Remove(path)
+
+	cmd := exec.Command(config.Export.SQLiteBinary, "-bail", path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(stdin)
+	spatial, writeErr := writeSQLiteScript(db, &config.Export, w, created)
+	if writeErr == nil {
+		writeErr = w.Flush()
+	}
+	stdin.Close()
+	waitErr := cmd.Wait()
+
+	if writeErr != nil || waitErr != nil {
+		os.Remove(path)
+		if waitErr != nil {
+			return nil, fmt.Errorf("%v: %s", waitErr, strings.TrimSpace(stderr.String()))
+		}
+		return nil, writeErr
+	}
+
+	size, err := pathSize(path)
+	if err != nil {
+		return nil, err
+	}
+	// Файл пишет sqlite3, поэтому этапы export.pipeline применяются к готовому файлу
+	return pipeArtifacts(&config.Export, []artifact{{
+		Kind:      "sqlite",
+		Source:    config.Postgres.DBName,
+		Path:      path,
+		Size:      size,
+		CreatedAt: clock.Now(),
+		Manifest:  withSpatial(spatial),
+	}})
+}
+
+// writeSQLiteScript пишет DDL и данные всех таблиц в виде SQL для sqlite3 и возвращает колонки PostGIS для манифеста
+func writeSQLiteScript(db *sql.DB, cfg *ExportConfig, w *bufio.Writer, created []createdBackup) ([]spatialColumn, error) {
+	var spatial []spatialColumn
+	w.WriteString("PRAGMA journal_mode = OFF;\nPRAGMA synchronous = OFF;\nBEGIN;\n")
+	for _, c := range created {
+		columns, err := exportColumns(db, cfg, c.Backup)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Backup, err)
+		}
+		spatial = append(spatial, spatialManifest(c.Source, columns)...)
+
+		types := make([]string, len(columns))
+		defs := make([]string, len(columns))
+		for i, col := range columns {
+			types[i] = sqliteType(col.exportType())
+			defs[i] = quoteSQLIdent(col.Name) + " " + types[i]
+			if col.NotNull {
+				defs[i] += " NOT NULL"
+			}
+		}
+		fmt.Fprintf(w, "CREATE TABLE %s (%s);\n", quoteSQLIdent(c.Source), strings.Join(defs, ", "))
+
+		insert := "INSERT INTO " + quoteSQLIdent(c.Source) + " VALUES ("
+		err = scanTable(db, c.Backup, columns, func(values []interface{}) error {
+			w.WriteString(insert)
+			for i, v := range values {
+				if i > 0 {
+					w.WriteString(", ")
+				}
+				w.WriteString(sqliteLiteral(v, types[i]))
+			}
+			_, err := w.WriteString(");\n")
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Backup, err)
+		}
+	}
+	_, err := w.WriteString("COMMIT;\n")
+	return spatial, err
+}
+
+// sqliteType подбирает тип SQLite для типа PostgreSQL (по возможности, остальное хранится как текст)
+func sqliteType(pgType string) string {
+	switch pgType {
+	case "int2", "int4", "int8", "bool", "oid":
+		return "INTEGER"
+	case "float4", "float8":
+		return "REAL"
+	case "numeric":
+		return "NUMERIC"
+	case "bytea":
+		return "BLOB"
+	default:
+		return "TEXT"
+	}
+}
+
+// sqliteLiteral форматирует значение из lib/pq как литерал SQLite для колонки указанного типа
+func sqliteLiteral(v interface{}, typ string) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		// NaN и бесконечности в SQL SQLite не записываются числом, они сохраняются текстом как в PostgreSQL
+		switch {
+		case math.IsNaN(v):
+			return sqlStringLiteral("NaN")
+		case math.IsInf(v, 1):
+			return sqlStringLiteral("Infinity")
+		case math.IsInf(v, -1):
+			return sqlStringLiteral("-Infinity")
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		return sqlStringLiteral(v.Format(time.RFC3339Nano))
+	case string:
+		return sqlStringLiteral(v)
+	case []byte:
+		// bytea приходит уже декодированным, остальные типы (numeric, json, массивы) - текстом
+		if typ == "BLOB" {
+			return "X'" + hex.EncodeToString(v) + "'"
+		}
+		// ParseFloat принимает и "NaN", "Infinity": такие значения numeric остаются текстом
+		if f, err := strconv.ParseFloat(string(v), 64); err == nil && typ == "NUMERIC" && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return string(v)
+		}
+		return sqlStringLiteral(string(v))
+	default:
+		return sqlStringLiteral(fmt.Sprint(v))
+	}
+}
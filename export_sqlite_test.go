@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSQLiteLiteral(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		typ   string
+		want  string
+	}{
+		{"null", nil, "TEXT", "NULL"},
+		{"integer", int64(-42), "INTEGER", "-42"},
+		{"float", 1.5, "REAL", "1.5"},
+		{"float nan", math.NaN(), "REAL", "'NaN'"},
+		{"float +inf", math.Inf(1), "REAL", "'Infinity'"},
+		{"float -inf", math.Inf(-1), "REAL", "'-Infinity'"},
+		{"bool", true, "INTEGER", "1"},
+		{"time", time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), "TEXT", "'2024-05-01T10:00:00Z'"},
+		{"string with quote", "O'Brien", "TEXT", "'O''Brien'"},
+		{"string with backslash", `a\b`, "TEXT", `'a\b'`},
+		{"blob", []byte{0xde, 0xad}, "BLOB", "X'dead'"},
+		{"numeric", []byte("123.4500"), "NUMERIC", "123.4500"},
+		{"numeric nan", []byte("NaN"), "NUMERIC", "'NaN'"},
+		{"numeric infinity", []byte("Infinity"), "NUMERIC", "'Infinity'"},
+		{"numeric -infinity", []byte("-Infinity"), "NUMERIC", "'-Infinity'"},
+		{"numeric out of float range", []byte("1e400"), "NUMERIC", "'1e400'"},
+		{"json text", []byte(`{"a": 1}`), "TEXT", `'{"a": 1}'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sqliteLiteral(tt.value, tt.typ); got != tt.want {
+				t.Errorf("sqliteLiteral(%#v, %s) = %s, want %s", tt.value, tt.typ, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSQLiteLiteralAccepted проверяет, что sqlite3 принимает литералы нечисловых значений с -bail
+func TestSQLiteLiteralAccepted(t *testing.T) {
+	binary, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 не найден")
+	}
+	script := "CREATE TABLE t (r REAL, n NUMERIC);\n"
+	for _, v := range []interface{}{math.NaN(), math.Inf(1), math.Inf(-1), []byte("NaN"), []byte("Infinity")} {
+		script += fmt.Sprintf("INSERT INTO t VALUES (%s, %s);\n", sqliteLiteral(v, "REAL"), sqliteLiteral(v, "NUMERIC"))
+	}
+	script += "SELECT count(*) FROM t;\n"
+	cmd := exec.Command(binary, "-bail", ":memory:")
+	cmd.Stdin = strings.NewReader(script)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3: %v: %s\n%s", err, out, script)
+	}
+	if got := strings.TrimSpace(string(out)); got != "5" {
+		t.Errorf("sqlite3 вставил %s строк, ожидалось 5", got)
+	}
+}
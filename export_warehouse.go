@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	exporters["warehouse"] = exportWarehouse
+}
+
+// exportWarehouse готовит выгрузку для загрузки в Redshift (COPY ... MANIFEST) и Snowflake (COPY INTO):
+// gzip CSV частями по export.warehouse_chunk_size, manifest для каждой таблицы и готовые команды COPY.
+//
+//	{prefix}_{dbname}_{YYYYMMDD}_warehouse/
+//	    {table}/part-00000.csv.gz
+//	    {table}.manifest
+//	    copy_redshift.sql
+//	    copy_snowflake.sql
+func exportWarehouse(db *sql.DB, config *Config, created []createdBackup, date string) ([]artifact, error) {
+	cfg := &config.Export
+	dir := filepath.Join(cfg.Dir, fmt.Sprintf("%s_%s_%s_warehouse", config.Backup.Prefix, config.Postgres.DBName, date))
+	os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimSuffix(cfg.WarehouseURL, "/")
+	var redshift, snowflake strings.Builder
+	var spatial []spatialColumn
+	for _, c := range created {
+		columns, err := exportColumns(db, cfg, c.Backup)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("%s: %w", c.Backup, err)
+		}
+		spatial = append(spatial, spatialManifest(c.Source, columns)...)
+
+		ranges, err := exportRanges(db, cfg, c.Backup)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("%s: %w", c.Backup, err)
+		}
+		parts, err := writeCSVParts(db, filepath.Join(dir, c.Source), c.Backup, columns, int64(cfg.WarehouseChunkSize), ranges)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("%s: %w", c.Backup, err)
+		}
+
+		// Manifest в формате Redshift: список частей с обязательной загрузкой
+		type entry struct {
+			URL       string           `json:"url"`
+			Mandatory bool             `json:"mandatory"`
+			Meta      map[string]int64 `json:"meta"`
+		}
+		var manifest struct {
+			Entries []entry `json:"entries"`
+		}
+		for _, p := range parts {
+			size, err := pathSize(p)
+			if err != nil {
+				os.RemoveAll(dir)
+				return nil, err
+			}
+			manifest.Entries = append(manifest.Entries, entry{
+				URL:       fmt.Sprintf("%s/%s/%s", url, c.Source, filepath.Base(p)),
+				Mandatory: true,
+				Meta:      map[string]int64{"content_length": size},
+			})
+		}
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(dir, c.Source+".manifest"), data, 0o644); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+
+		fmt.Fprintf(&redshift,
+			"COPY %s FROM '%s/%s.manifest'\n  IAM_ROLE '%s'\n  MANIFEST CSV GZIP IGNOREHEADER 1 EMPTYASNULL\n  DATEFORMAT 'auto' TIMEFORMAT 'auto';\n\n",
+			quoteSQLIdent(c.Source), url, c.Source, orPlaceholder(cfg.RedshiftIAMRole, "<iam_role_arn>"))
+		fmt.Fprintf(&snowflake,
+			"COPY INTO %s FROM '%s/%s/'\n  STORAGE_INTEGRATION = %s\n  PATTERN = '.*part-[0-9]+[.]csv[.]gz'\n"+
+				"  FILE_FORMAT = (TYPE = CSV COMPRESSION = GZIP SKIP_HEADER = 1 FIELD_OPTIONALLY_ENCLOSED_BY = '\"' EMPTY_FIELD_AS_NULL = TRUE);\n\n",
+			quoteSQLIdent(c.Source), url, c.Source, orPlaceholder(cfg.SnowflakeIntegration, "<storage_integration>"))
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "copy_redshift.sql"), []byte(redshift.String()), 0o644); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "copy_snowflake.sql"), []byte(snowflake.String()), 0o644); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	size, err := pathSize(dir)
+	if err != nil {
+		return nil, err
+	}
+	return []artifact{{
+		Kind:      "warehouse",
+		Source:    config.Postgres.DBName,
+		Path:      dir,
+		Size:      size,
+		CreatedAt: clock.Now(),
+		Manifest:  withSpatial(spatial),
+	}}, nil
+}
+
+// writeCSVParts пишет таблицу в файлы dir/part-NNNNN.csv.gz, начиная новую часть после chunkSize байт несжатых данных.
+// В каждой части есть строка заголовка; NULL записывается пустым полем, все остальные значения - в кавычках.
+// Каждое условие ranges читается отдельным потоком в свои части, номера частей общие.
+func writeCSVParts(db *sql.DB, dir, table string, columns []column, chunkSize int64, ranges []string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	kinds := make([]string, len(columns))
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		kinds[i] = columnKind(c.exportType())
+		names[i] = csvQuote(c.Name)
+	}
+	header := strings.Join(names, ",") + "\n"
+
+	var mu sync.Mutex
+	var parts []string
+	nextPart := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		path := filepath.Join(dir, fmt.Sprintf("part-%05d.csv.gz", len(parts)))
+		parts = append(parts, path)
+		return path
+	}
+
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+	for i, where := range ranges {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = writeCSVRange(db, table, columns, kinds, header, where, chunkSize, nextPart)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return parts, err
+		}
+	}
+	return parts, nil
+}
+
+// writeCSVRange пишет строки таблицы, подходящие под условие where, в части, пути которых выдает nextPart
+func writeCSVRange(db *sql.DB, table string, columns []column, kinds []string, header, where string, chunkSize int64, nextPart func() string) error {
+	var f *os.File
+	var gz *gzip.Writer
+	var bw *bufio.Writer
+	var written int64
+
+	closePart := func() error {
+		if f == nil {
+			return nil
+		}
+		err := bw.Flush()
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		f = nil
+		return err
+	}
+	openPart := func() error {
+		var err error
+		f, err = os.Create(nextPart())
+		if err != nil {
+			return err
+		}
+		gz = gzip.NewWriter(f)
+		bw = bufio.NewWriter(gz)
+		written = 0
+		_, err = bw.WriteString(header)
+		return err
+	}
+
+	if err := openPart(); err != nil {
+		return err
+	}
+	var line strings.Builder
+	err := scanTableRange(db, table, columns, where, func(values []interface{}) error {
+		if written >= chunkSize {
+			if err := closePart(); err != nil {
+				return err
+			}
+			if err := openPart(); err != nil {
+				return err
+			}
+		}
+
+		line.Reset()
+		for i, v := range values {
+			if i > 0 {
+				line.WriteByte(',')
+			}
+			if v != nil {
+				line.WriteString(csvQuote(csvValue(v, kinds[i])))
+			}
+		}
+		line.WriteByte('\n')
+		written += int64(line.Len())
+		_, err := bw.WriteString(line.String())
+		return err
+	})
+	if closeErr := closePart(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// csvValue форматирует значение из lib/pq для CSV в виде, понятном Redshift и Snowflake
+func csvValue(v interface{}, kind string) string {
+	switch kind {
+	case kindBytes:
+		data, _ := v.([]byte)
+		return hex.EncodeToString(data)
+	case kindDate:
+		if t, ok := v.(time.Time); ok {
+			return t.Format("2006-01-02")
+		}
+	case kindTimestamp:
+		if t, ok := v.(time.Time); ok {
+			return t.Format("2006-01-02 15:04:05.999999")
+		}
+	case kindTimestampTZ:
+		if t, ok := v.(time.Time); ok {
+			return t.Format("2006-01-02 15:04:05.999999Z07:00")
+		}
+	case kindFloat32, kindFloat64:
+		if f, ok := v.(float64); ok {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	}
+	return valueString(v)
+}
+
+// csvQuote заключает значение в кавычки, удваивая кавычки внутри
+func csvQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// orPlaceholder возвращает значение или подсказку, что его нужно подставить вручную
+func orPlaceholder(value, placeholder string) string {
+	if value == "" {
+		return placeholder
+	}
+	return value
+}
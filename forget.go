@@ -0,0 +1,198 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+func init() {
+	commands["forget"] = command{
+		Usage: "Delete matching rows from all backups of a table (forget -table users -where \"user_id = 42\"), or print the audit log",
+		Run:   cmdForget,
+	}
+}
+
+// cmdForget удаляет строки по условию из всех бэкапов таблицы, например по запросу на удаление
+// персональных данных, и записывает действие в журнал; с -audit выводит журнал
+func cmdForget(args []string) error {
+	fs := flag.NewFlagSet("forget", flag.ExitOnError)
+	run := fs.Bool("run", false, "Normal run instead of test run?")
+	table := fs.String("table", "", "Source table whose backups are purged")
+	where := fs.String("where", "", "SQL condition selecting the rows to delete, e.g. \"user_id = 42\"")
+	request := fs.String("request", "", "Reference of the erasure request, stored in the audit log")
+	audit := fs.Bool("audit", false, "Print the audit log of purges")
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *audit {
+		return printForgetAudit(db)
+	}
+	if *table == "" || strings.TrimSpace(*where) == "" {
+		return fmt.Errorf("использование: dbacker forget -table <table> -where <condition> [-request id] [-run] | dbacker forget -audit")
+	}
+
+	backupDB, err := openBackupDatabase(config, db)
+	if err != nil {
+		return err
+	}
+	if backupDB != db {
+		defer backupDB.Close()
+	}
+
+	actor := os.Getenv("USER")
+	if actor == "" {
+		actor = "unknown"
+	}
+	entry := &forgetEntry{Request: *request, Table: *table, Predicate: *where, Actor: actor}
+	if err := forgetRows(db, backupDB, config, entry, *run); err != nil {
+		return err
+	}
+	if !*run {
+		return nil
+	}
+	fmt.Printf("Из %d бэкапов таблицы %s удалено строк: %d\n", entry.Backups, entry.Table, entry.Rows)
+	return nil
+}
+
+// forgetRows удаляет строки по условию из всех бэкапов таблицы в одной транзакции: если условие
+// не подходит хотя бы к одному бэкапу (например, в старом бэкапе еще нет колонки), не изменяется ни один.
+// Бэкапы под юридическим удержанием не изменяются и перечисляются в журнале. После удаления бэкапы
+// очищаются VACUUM, чтобы удаленные строки не оставались в файлах таблиц.
+func forgetRows(db, backupDB *sql.DB, config *Config, e *forgetEntry, realRun bool) error {
+	prefix := config.Backup.Prefix
+	all, err := getBackupTables(backupDB, prefix)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка бэкапов: %w", err)
+	}
+	held, err := heldBackups(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения удержаний: %w", err)
+	}
+	sources, err := backupSources(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения каталога: %w", err)
+	}
+
+	backups, skipped := forgetBackups(all, prefix, e.Table, sources, held)
+	for _, name := range skipped {
+		log.Printf("Бэкап %s под юридическим удержанием, строки из него не удаляются", name)
+	}
+	if len(backups) == 0 {
+		return fmt.Errorf("нет бэкапов таблицы %s, из которых можно удалить строки", e.Table)
+	}
+
+	// Строки могли попасть и в файлы: выгрузки таблицы и дампы всей базы
+	files, err := liveFileArtifacts(db, e.Table, config.Postgres.DBName)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения каталога: %w", err)
+	}
+
+	tx, err := backupDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var purged []string
+	for _, name := range backups {
+		var rows int64
+		if realRun {
+			res, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", quoteIdent(name), e.Predicate))
+			if err != nil {
+				return fmt.Errorf("ошибка удаления строк из %s: %w", name, err)
+			}
+			rows, _ = res.RowsAffected()
+		} else if err := tx.QueryRow(fmt.Sprintf("SELECT count(*) FROM %s WHERE %s", quoteIdent(name), e.Predicate)).Scan(&rows); err != nil {
+			return fmt.Errorf("ошибка проверки условия на %s: %w", name, err)
+		}
+		if rows > 0 {
+			purged = append(purged, name)
+		}
+		e.Rows += rows
+		if realRun {
+			log.Printf("Из бэкапа %s удалено строк: %d", name, rows)
+		} else {
+			log.Printf("Тестовый запуск, из бэкапа %s будет удалено строк: %d", name, rows)
+		}
+	}
+	e.Backups = len(backups)
+	e.Skipped = strings.Join(skipped, ", ")
+
+	for _, a := range files {
+		log.Printf("Строки могут оставаться в файле %s (%s): dbacker не изменяет файлы, удалите или пересоздайте его вручную", describeArtifact(a), a.Kind)
+	}
+	if !realRun {
+		log.Printf("Тестовый запуск, из %d бэкапов таблицы %s будет удалено строк: %d", e.Backups, e.Table, e.Rows)
+		return nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if err := recordForget(db, e); err != nil {
+		return fmt.Errorf("строки удалены, но запись в журнал не удалась: %w", err)
+	}
+	for _, name := range purged {
+		if _, err := backupDB.Exec("VACUUM " + quoteIdent(name)); err != nil {
+			log.Printf("Ошибка VACUUM %s, выполните его вручную: %v", name, err)
+		}
+	}
+	// Новые суммы измененных бэкапов записываются в журнал, иначе ledger verify сочтет их подмененными
+	if config.Ledger.Enabled {
+		changed := make([]createdBackup, len(purged))
+		for i, name := range purged {
+			changed[i] = createdBackup{Source: e.Table, Backup: name}
+		}
+		if err := recordLedger(db, backupDB, &config.Ledger, ledgerEventForget, changed); err != nil {
+			return fmt.Errorf("строки удалены, но запись в журнал контрольных сумм не удалась: %w", err)
+		}
+	}
+	return nil
+}
+
+// forgetBackups выбирает из таблиц бэкапа все бэкапы таблицы table, включая снимки и страховочные копии:
+// исходная таблица определяется по каталогу (backupSourceOf). Бэкапы под удержанием возвращаются в skipped
+func forgetBackups(all []backupTable, prefix, table string, sources map[string]string, held map[string]bool) (backups, skipped []string) {
+	pattern := backupNamePattern(prefix)
+	for _, t := range all {
+		if !pattern.MatchString(t.Name) || backupSourceOf(t.Name, prefix, sources) != table {
+			continue
+		}
+		if held[t.Name] {
+			skipped = append(skipped, t.Name)
+			continue
+		}
+		backups = append(backups, t.Name)
+	}
+	return backups, skipped
+}
+
+// printForgetAudit выводит журнал удаления строк из бэкапов
+func printForgetAudit(db *sql.DB) error {
+	entries, err := forgetAudit(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения журнала удаления строк: %w", err)
+	}
+	if len(entries) == 0 {
+		fmt.Println("Журнал удаления строк пуст")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Время\tОбращение\tТаблица\tУсловие\tБэкапов\tСтрок\tКто\tПропущены (удержание)")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", e.At.Format("2006-01-02 15:04:05"),
+			e.Request, e.Table, e.Predicate, e.Backups, e.Rows, e.Actor, e.Skipped)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestForgetBackups(t *testing.T) {
+	all := []backupTable{
+		{Name: "backup_orders_20240130", Date: "20240130"},
+		{Name: "backup_orders_before_fix_20240131", Date: "20240131"},
+		{Name: "backup_orders_guard_120000_20240131", Date: "20240131"},
+		{Name: "backup_orders_20240131", Date: "20240131"},
+		{Name: "backup_order_items_20240131", Date: "20240131"},
+		{Name: "backup_orders_archive", Date: "_archive"},
+	}
+	// каталог: ежедневные бэкапы, снимок и страховочная копия; backup_orders_20240130 создан до каталога
+	sources := map[string]string{
+		"backup_orders_before_fix_20240131":   "orders",
+		"backup_orders_guard_120000_20240131": "orders",
+		"backup_orders_20240131":              "orders",
+		"backup_order_items_20240131":         "order_items",
+	}
+
+	tests := []struct {
+		name        string
+		table       string
+		held        map[string]bool
+		wantBackups []string
+		wantSkipped []string
+	}{
+		{
+			name:  "snapshot and guard are purged",
+			table: "orders",
+			wantBackups: []string{"backup_orders_20240130", "backup_orders_before_fix_20240131",
+				"backup_orders_guard_120000_20240131", "backup_orders_20240131"},
+		},
+		{
+			name:        "held snapshot is skipped",
+			table:       "orders",
+			held:        map[string]bool{"backup_orders_before_fix_20240131": true},
+			wantBackups: []string{"backup_orders_20240130", "backup_orders_guard_120000_20240131", "backup_orders_20240131"},
+			wantSkipped: []string{"backup_orders_before_fix_20240131"},
+		},
+		{
+			name:        "similar table name",
+			table:       "order_items",
+			wantBackups: []string{"backup_order_items_20240131"},
+		},
+		{
+			name:  "label is not a table",
+			table: "orders_before_fix",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backups, skipped := forgetBackups(all, "backup", tt.table, sources, tt.held)
+			if !reflect.DeepEqual(backups, tt.wantBackups) || !reflect.DeepEqual(skipped, tt.wantSkipped) {
+				t.Errorf("forgetBackups(%s) = %v, %v; ожидалось %v, %v", tt.table, backups, skipped, tt.wantBackups, tt.wantSkipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Быстрый путь создания бэкапа (backup.copy_freeze). Таблица бэкапа создается пустой и заполняется
+// COPY ... FREEZE в той же транзакции: строки записываются сразу замороженными с установленными
+// битами подсказок, поэтому первое чтение бэкапа не переписывает страницы, а autovacuum не замораживает
+// их повторно. При wal_level = minimal данные таблицы, созданной в транзакции, еще и не пишутся в WAL:
+// PostgreSQL сбрасывает файл таблицы на диск при фиксации.
+
+// freezeCopyMode проверяет настройки сервера для быстрого пути и сообщает в лог, что он дает:
+// COPY FREEZE работает при любом wal_level, а без WAL данные пишутся только при minimal
+func freezeCopyMode(db *sql.DB) error {
+	var walLevel string
+	var version int
+	if err := db.QueryRow(`SELECT current_setting('wal_level'), current_setting('server_version_num')::int`).Scan(&walLevel, &version); err != nil {
+		return err
+	}
+	if version < 90300 {
+		return fmt.Errorf("COPY FREEZE поддерживается с PostgreSQL 9.3, версия сервера %d", version)
+	}
+	if walLevel == "minimal" {
+		log.Printf("Бэкапы создаются через COPY FREEZE без записи данных в WAL (wal_level = minimal)")
+	} else {
+		log.Printf("Бэкапы создаются через COPY FREEZE; данные пишутся в WAL, так как wal_level = %s (без WAL только при minimal)", walLevel)
+	}
+	return nil
+}
+
+// createBackupTableFreeze создает копию таблицы через COPY FREEZE. Исходная таблица читается
+// в отдельной транзакции (при Nowait - с блокировкой без ожидания, при Hash и проверках с match - в REPEATABLE READ
+// с подсчетом хеша и значений проверок в том же снимке), строки передаются во вторую транзакцию, которая создает таблицу бэкапа
+// и загружает их. Значения передаются в текстовом представлении, так что типы колонок сохраняются как есть.
+func createBackupTableFreeze(db *sql.DB, originalTable, backupTable string, opts copyOptions) (copyResult, error) {
+	var result copyResult
+	columns, err := opts.columns(db, originalTable)
+	if err != nil {
+		return result, err
+	}
+	names := make([]string, len(columns))
+	exprs := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = quoteIdent(c.Name)
+		exprs[i] = quoteIdent(c.Name) + "::text"
+	}
+
+	readOpts := &sql.TxOptions{ReadOnly: true}
+	if opts.snapshot() {
+		readOpts.Isolation = sql.LevelRepeatableRead
+	}
+	read, err := db.BeginTx(context.Background(), readOpts)
+	if err != nil {
+		return result, err
+	}
+	defer read.Rollback()
+	if opts.Nowait {
+		if _, err := read.Exec(fmt.Sprintf("LOCK TABLE %s IN ACCESS SHARE MODE NOWAIT", quoteIdent(originalTable))); err != nil {
+			return result, err
+		}
+	}
+
+	write, err := db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer write.Rollback()
+	// Структура бэкапа такая же, как у CREATE TABLE AS: те же колонки и типы без ограничений и индексов
+	if _, err := write.Exec(fmt.Sprintf("CREATE TABLE %s AS SELECT * FROM %s WITH NO DATA", quoteIdent(backupTable), quoteIdent(originalTable))); err != nil {
+		return result, err
+	}
+	// lib/pq выполняет подготовленный запрос COPY ... FROM STDIN как загрузку потоком
+	stmt, err := write.Prepare(fmt.Sprintf("COPY %s (%s) FROM STDIN WITH (FREEZE)", quoteIdent(backupTable), strings.Join(names, ", ")))
+	if err != nil {
+		return result, err
+	}
+	defer stmt.Close()
+
+	rows, err := read.Query(fmt.Sprintf("SELECT %s FROM %s", strings.Join(exprs, ", "), quoteIdent(originalTable)))
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	scanned := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range scanned {
+		dest[i] = &scanned[i]
+	}
+	values := make([]interface{}, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return result, err
+		}
+		for i, v := range scanned {
+			values[i] = nil
+			if v.Valid {
+				values[i] = v.String
+			}
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return result, err
+		}
+		result.Rows++
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+	if _, err := stmt.Exec(); err != nil {
+		return result, fmt.Errorf("ошибка завершения COPY в %s: %w", backupTable, err)
+	}
+
+	if opts.Hash {
+		if result.Hash, err = tableHash(read, quoteIdent(originalTable)); err != nil {
+			return result, fmt.Errorf("ошибка подсчета md5 таблицы %s: %w", originalTable, err)
+		}
+	}
+	if result.Checks, err = sourceCheckValues(read, quoteIdent(originalTable), opts.Checks); err != nil {
+		return result, err
+	}
+	return result, write.Commit()
+}
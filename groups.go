@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// TableGroupConfig группа связанных таблиц (например orders, order_items, payments), которые копируются
+// в одной транзакции и проверяются на ссылочную целостность: бэкап группы создается целиком или не создается
+type TableGroupConfig struct {
+	Name   string   `json:"name"`   // Имя группы для логов и отчета
+	Tables []string `json:"tables"` // Таблицы группы
+}
+
+// groupResult итог бэкапа группы таблиц
+type groupResult struct {
+	Name   string
+	Tables []string
+	Err    error
+}
+
+// validateGroups проверяет группы таблиц: уникальные имена, непустой состав, таблица не более чем в одной группе
+func validateGroups(groups []TableGroupConfig) error {
+	names := make(map[string]bool)
+	owner := make(map[string]string)
+	for i, g := range groups {
+		if g.Name == "" || len(g.Tables) == 0 {
+			return fmt.Errorf("для backup.groups[%d] нужно указать name и tables", i)
+		}
+		if names[g.Name] {
+			return fmt.Errorf("имя группы %s используется несколько раз", g.Name)
+		}
+		names[g.Name] = true
+		for _, t := range g.Tables {
+			if other, ok := owner[t]; ok {
+				return fmt.Errorf("таблица %s входит в группы %s и %s", t, other, g.Name)
+			}
+			owner[t] = g.Name
+		}
+	}
+	return nil
+}
+
+// tableGroups возвращает группу каждой сгруппированной таблицы
+func tableGroups(groups []TableGroupConfig) map[string]*TableGroupConfig {
+	byTable := make(map[string]*TableGroupConfig)
+	for i := range groups {
+		for _, t := range groups[i].Tables {
+			byTable[t] = &groups[i]
+		}
+	}
+	return byTable
+}
+
+// copyGroup копирует таблицы группы в бэкапы backups в одной транзакции REPEATABLE READ, то есть в одном
+// снимке данных, и до фиксации проверяет внешние ключи между таблицами группы на копиях. При ошибке
+// не создается ни один бэкап группы. Таблицы из verify сверяются по md5 после фиксации, значения проверок checks
+// с match на исходных таблицах считаются в том же снимке.
+func copyGroup(db *sql.DB, tables []string, backups map[string]string, verify map[string]bool, checks map[string][]ValidationConfig, nowait bool) (map[string]copyResult, error) {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Блокировки всех таблиц берутся до первого копирования, чтобы не ждать посреди группы
+	if nowait {
+		for _, t := range tables {
+			if _, err := tx.Exec(fmt.Sprintf("LOCK TABLE %s IN ACCESS SHARE MODE NOWAIT", t)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	results := make(map[string]copyResult, len(tables))
+	for _, t := range tables {
+		var r copyResult
+		res, err := tx.Exec(fmt.Sprintf("CREATE TABLE %s AS SELECT * FROM %s", backups[t], t))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t, err)
+		}
+		r.Rows, _ = res.RowsAffected()
+		if verify[t] {
+			if r.Hash, err = tableHash(tx, t); err != nil {
+				return nil, fmt.Errorf("ошибка подсчета md5 таблицы %s: %w", t, err)
+			}
+		}
+		if r.Checks, err = sourceCheckValues(tx, t, checks[t]); err != nil {
+			return nil, err
+		}
+		results[t] = r
+	}
+	if err := checkGroupReferences(tx, tables, backups); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, t := range tables {
+		if !verify[t] {
+			continue
+		}
+		if err := verifyBackup(db, t, backups[t], results[t].Hash); err != nil {
+			for _, backup := range backups {
+				db.Exec("DROP TABLE IF EXISTS " + quoteIdent(backup))
+			}
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// groupReference внешний ключ между двумя таблицами группы
+type groupReference struct {
+	Name          string
+	Table, Parent string
+	Columns       []string // колонки ссылающейся таблицы
+	ParentColumns []string // колонки, на которые они ссылаются
+}
+
+// checkGroupReferences проверяет на копиях все внешние ключи между таблицами группы: каждая ссылка
+// (с непустыми колонками, как MATCH SIMPLE) должна находить строку в копии родительской таблицы
+func checkGroupReferences(tx *sql.Tx, tables []string, backups map[string]string) error {
+	rows, err := tx.Query(`
+		SELECT c.conname, cl.relname, pl.relname,
+			array(SELECT a.attname::text FROM unnest(c.conkey) WITH ORDINALITY k(n, i)
+				JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.n ORDER BY k.i),
+			array(SELECT a.attname::text FROM unnest(c.confkey) WITH ORDINALITY k(n, i)
+				JOIN pg_attribute a ON a.attrelid = c.confrelid AND a.attnum = k.n ORDER BY k.i)
+		FROM pg_constraint c
+		JOIN pg_class cl ON cl.oid = c.conrelid
+		JOIN pg_class pl ON pl.oid = c.confrelid
+		WHERE c.contype = 'f'
+		AND cl.relnamespace = current_schema()::regnamespace AND pl.relnamespace = cl.relnamespace
+		AND cl.relname = ANY($1) AND pl.relname = ANY($1)
+		ORDER BY cl.relname, c.conname`, pq.Array(tables))
+	if err != nil {
+		return fmt.Errorf("ошибка чтения внешних ключей: %w", err)
+	}
+	var refs []groupReference
+	for rows.Next() {
+		var r groupReference
+		if err := rows.Scan(&r.Name, &r.Table, &r.Parent, pq.Array(&r.Columns), pq.Array(&r.ParentColumns)); err != nil {
+			rows.Close()
+			return err
+		}
+		refs = append(refs, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range refs {
+		var notNull, match []string
+		for i, col := range r.Columns {
+			notNull = append(notNull, "c."+quoteIdent(col)+" IS NOT NULL")
+			match = append(match, "p."+quoteIdent(r.ParentColumns[i])+" = c."+quoteIdent(col))
+		}
+		var orphans int64
+		err := tx.QueryRow(fmt.Sprintf(`
+			SELECT count(*) FROM %s c
+			WHERE %s AND NOT EXISTS (SELECT 1 FROM %s p WHERE %s)`,
+			quoteIdent(backups[r.Table]), strings.Join(notNull, " AND "),
+			quoteIdent(backups[r.Parent]), strings.Join(match, " AND "))).Scan(&orphans)
+		if err != nil {
+			return fmt.Errorf("ошибка проверки внешнего ключа %s: %w", r.Name, err)
+		}
+		if orphans > 0 {
+			return fmt.Errorf("нарушена ссылочная целостность %s: %d строк %s ссылаются на отсутствующие строки %s",
+				r.Name, orphans, r.Table, r.Parent)
+		}
+	}
+	return nil
+}
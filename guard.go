@@ -0,0 +1,192 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	commands["guard"] = command{
+		Usage: "Snapshot a table before a risky manual change and print the restore command (guard orders -ttl 2h)",
+		Run:   cmdGuard,
+	}
+}
+
+// cmdGuard создает страховочную копию таблицы перед ручным изменением данных: снимок с коротким сроком
+// хранения и готовой командой восстановления. Истекшие копии удаляются при каждом вызове guard
+// и при каждом запуске бэкапа таблиц. Без аргументов выводит действующие копии.
+func cmdGuard(args []string) error {
+	fs := flag.NewFlagSet("guard", flag.ExitOnError)
+	ttl := fs.Duration("ttl", 24*time.Hour, "How long to keep the guard copy, e.g. 2h")
+	positional := parseArgs(fs, args)
+	if len(positional) > 1 {
+		return fmt.Errorf("использование: dbacker guard <table> [-ttl 2h] | dbacker guard")
+	}
+	if *ttl <= 0 {
+		return fmt.Errorf("-ttl должен быть положительным")
+	}
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if config.Remote.remoteEnabled() {
+		return fmt.Errorf("guard создает копию рядом с таблицей и недоступен в режиме remote: используйте dbacker backup -table")
+	}
+
+	if err := dropExpiredGuards(db, db, config, true); err != nil {
+		log.Printf("Ошибка удаления истекших страховочных копий: %v", err)
+	}
+	if len(positional) == 0 {
+		return printGuards(db)
+	}
+
+	table := positional[0]
+	startedAt := clock.Now()
+	result, err := runSnapshot(db, db, config, table, "guard_"+startedAt.Format("150405"), true, nil)
+	if err != nil {
+		return err
+	}
+	actor := os.Getenv("USER")
+	if actor == "" {
+		actor = "unknown"
+	}
+	g := &guardEntry{Backup: result.Created[0].Backup, Source: table, ExpiresAt: startedAt.Add(*ttl), Actor: actor}
+	if err := recordGuard(db, g); err != nil {
+		// Без записи копия не удалится автоматически, поэтому она удаляется сразу
+		db.Exec("DROP TABLE IF EXISTS " + quoteIdent(g.Backup))
+		return fmt.Errorf("ошибка записи страховочной копии в каталог: %w", err)
+	}
+
+	restore, err := guardRestoreCommand(db, &config.Postgres, g)
+	if err != nil {
+		return fmt.Errorf("ошибка построения команды восстановления: %w", err)
+	}
+	fmt.Printf("Страховочная копия %s таблицы %s хранится до %s\n", g.Backup, table, g.ExpiresAt.Local().Format("2006-01-02 15:04"))
+	fmt.Printf("Восстановление таблицы из копии:\n\n%s\n", restore)
+	return nil
+}
+
+// guardRestoreCommand возвращает команду psql, которая одним запросом (в одной транзакции) заменяет содержимое таблицы
+// строками страховочной копии. Генерируемые колонки вычисляются заново, значения колонок
+// GENERATED ALWAYS AS IDENTITY берутся из копии (OVERRIDING SYSTEM VALUE).
+func guardRestoreCommand(db *sql.DB, pg *PostgresConfig, g *guardEntry) (string, error) {
+	var schema string
+	if err := db.QueryRow(`SELECT current_schema()`).Scan(&schema); err != nil {
+		return "", err
+	}
+	// attgenerated появилась в PostgreSQL 12, поэтому читается через to_jsonb
+	rows, err := db.Query(`
+		SELECT a.attname
+		FROM pg_attribute a
+		WHERE a.attrelid = to_regclass(quote_ident(current_schema()) || '.' || $1)
+		AND a.attnum > 0 AND NOT a.attisdropped
+		AND coalesce(to_jsonb(a) ->> 'attgenerated', '') = ''
+		ORDER BY a.attnum`, quoteIdent(g.Source))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return "", err
+		}
+		columns = append(columns, quoteIdent(name))
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(columns) == 0 {
+		return "", fmt.Errorf("таблица %s не найдена", g.Source)
+	}
+
+	list := strings.Join(columns, ", ")
+	target := quoteIdent(schema) + "." + quoteIdent(g.Source)
+	query := fmt.Sprintf("TRUNCATE %s; INSERT INTO %s (%s) OVERRIDING SYSTEM VALUE SELECT %s FROM %s.%s;",
+		target, target, list, list, quoteIdent(schema), quoteIdent(g.Backup))
+
+	cmd := []string{"psql", "-v", "ON_ERROR_STOP=1"}
+	if pg.Host != "" {
+		cmd = append(cmd, "-h", pg.Host)
+	}
+	if pg.Port != 0 {
+		cmd = append(cmd, "-p", strconv.Itoa(pg.Port))
+	}
+	if pg.User != "" {
+		cmd = append(cmd, "-U", pg.User)
+	}
+	cmd = append(cmd, "-d", pg.DBName, "-c", shellQuote(query))
+	return strings.Join(cmd, " "), nil
+}
+
+// shellQuote заключает строку в одинарные кавычки для командной строки sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// dropExpiredGuards удаляет таблицы страховочных копий с истекшим сроком. Копии под юридическим удержанием
+// или с продлением хранения (dbacker retain) не удаляются, пока удержание или продление действует.
+func dropExpiredGuards(db, backupDB *sql.DB, config *Config, realRun bool) error {
+	guards, err := listGuards(db, true)
+	if err != nil || len(guards) == 0 {
+		return err
+	}
+	held, err := heldBackups(db)
+	if err != nil {
+		return err
+	}
+	retained, err := retentionOverrides(db)
+	if err != nil {
+		return err
+	}
+
+	var dropped []string
+	for _, g := range guards {
+		if until, ok := retained[g.Backup]; held[g.Backup] || ok && isRetained(until) {
+			continue
+		}
+		if !realRun {
+			log.Printf("Тестовый запуск, будет удалена истекшая страховочная копия %s", g.Backup)
+			continue
+		}
+		if _, err := backupDB.Exec("DROP TABLE IF EXISTS " + quoteIdent(g.Backup) + dropCascade(&config.Backup)); err != nil {
+			return fmt.Errorf("ошибка удаления %s: %w", g.Backup, err)
+		}
+		if err := markGuardDropped(db, g.Backup); err != nil {
+			return err
+		}
+		dropped = append(dropped, g.Backup)
+		log.Printf("Удалена истекшая страховочная копия %s таблицы %s", g.Backup, g.Source)
+	}
+	return markTablesDeleted(db, dropped)
+}
+
+// printGuards выводит действующие страховочные копии
+func printGuards(db *sql.DB) error {
+	guards, err := listGuards(db, false)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения каталога: %w", err)
+	}
+	if len(guards) == 0 {
+		fmt.Println("Страховочных копий нет")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Копия\tТаблица\tСоздана\tХранится до\tКто")
+	for _, g := range guards {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", g.Backup, g.Source, g.CreatedAt.Local().Format("2006-01-02 15:04"),
+			g.ExpiresAt.Local().Format("2006-01-02 15:04"), g.Actor)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	commands["hold"] = command{
+		Usage: "Place backups under legal hold (hold case_42 -table orders -from 2025-01-01), release or audit holds",
+		Run:   cmdHold,
+	}
+}
+
+// cmdHold ставит набор бэкапов под юридическое удержание, снимает удержание (-release) или выводит журнал (-audit);
+// без аргументов выводит список удержаний
+func cmdHold(args []string) error {
+	fs := flag.NewFlagSet("hold", flag.ExitOnError)
+	run := fs.Bool("run", false, "Normal run instead of test run?")
+	table := fs.String("table", "", "Hold backups of this source table")
+	label := fs.String("label", "", "Hold snapshots with this label")
+	from := fs.String("from", "", "Hold backups created on or after this date (YYYY-MM-DD)")
+	to := fs.String("to", "", "Hold backups created on or before this date (YYYY-MM-DD)")
+	reason := fs.String("reason", "", "Why the backups are held (e.g. case number)")
+	release := fs.String("release", "", "Release the hold with this name")
+	audit := fs.Bool("audit", false, "Print the audit trail of all holds")
+	positional := parseArgs(fs, args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	actor := os.Getenv("USER")
+	switch {
+	case *audit:
+		return printHoldAudit(db)
+	case *release != "":
+		if !*run {
+			log.Printf("Тестовый запуск, будет снято удержание %s", *release)
+			return nil
+		}
+		released, err := liftHold(db, config, *release, actor)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Удержание %s снято, освобождено бэкапов: %d\n", *release, len(released))
+		return nil
+	case len(positional) == 0:
+		return printHolds(db)
+	case len(positional) > 1:
+		return fmt.Errorf("использование: dbacker hold <name> [-table t] [-label l] [-from date] [-to date] [-reason text] [-run]")
+	}
+
+	criteria := holdCriteria{Table: *table, Label: *label}
+	for _, d := range []struct {
+		value string
+		dest  *time.Time
+		flag  string
+	}{{*from, &criteria.From, "-from"}, {*to, &criteria.To, "-to"}} {
+		if d.value == "" {
+			continue
+		}
+		if *d.dest, err = time.ParseInLocation("2006-01-02", d.value, time.Local); err != nil {
+			return fmt.Errorf("некорректная дата %s %q, ожидается YYYY-MM-DD", d.flag, d.value)
+		}
+	}
+	h := &legalHold{Name: positional[0], Reason: *reason, CreatedBy: actor}
+	items, err := placeHold(db, config, h, criteria, *run)
+	if err != nil {
+		return err
+	}
+	if !*run {
+		for _, a := range items {
+			log.Printf("Тестовый запуск, будет удержан бэкап %s", describeArtifact(a))
+		}
+		log.Printf("Тестовый запуск, удержание %s (%s): бэкапов %d", h.Name, h.Criteria, len(items))
+		return nil
+	}
+	fmt.Printf("Удержание %s поставлено, бэкапов: %d\n", h.Name, len(items))
+	return nil
+}
+
+// placeHold отбирает бэкапы по условиям и при realRun сохраняет удержание в каталоге и ставит legal hold
+// на копии в хранилищах с Object Lock. Возвращает удерживаемые артефакты.
+func placeHold(db *sql.DB, config *Config, h *legalHold, c holdCriteria, realRun bool) ([]artifact, error) {
+	if h.Name == "" {
+		return nil, fmt.Errorf("не указано имя удержания")
+	}
+	if c.Table == "" && c.Label == "" && c.From.IsZero() && c.To.IsZero() {
+		return nil, fmt.Errorf("укажите хотя бы одно условие: -table, -label, -from или -to")
+	}
+	if !c.From.IsZero() && !c.To.IsZero() && c.To.Before(c.From) {
+		return nil, fmt.Errorf("дата -to раньше даты -from")
+	}
+	h.Criteria = c.String()
+	if h.CreatedBy == "" {
+		h.CreatedBy = "unknown"
+	}
+
+	if realRun {
+		if err := ensureCatalog(db); err != nil {
+			return nil, fmt.Errorf("ошибка создания каталога: %w", err)
+		}
+	} else if exists, err := catalogExists(db); err != nil || !exists {
+		return nil, err
+	}
+	items, err := holdCandidates(db, c)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка отбора бэкапов: %w", err)
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("нет бэкапов, подходящих под условия: %s", h.Criteria)
+	}
+	if !realRun {
+		return items, nil
+	}
+
+	if err := createHold(db, h, items); err != nil {
+		return nil, fmt.Errorf("ошибка записи удержания в каталог: %w", err)
+	}
+	log.Printf("Удержание %s поставлено (%s), бэкапов: %d", h.Name, h.Criteria, len(items))
+	return items, setRemoteLegalHolds(config, items, true)
+}
+
+// liftHold снимает удержание в каталоге и снимает legal hold с копий в хранилищах, которые больше
+// не удерживаются другими удержаниями. Возвращает освобожденные артефакты.
+func liftHold(db *sql.DB, config *Config, name, actor string) ([]artifact, error) {
+	if actor == "" {
+		actor = "unknown"
+	}
+	released, err := releaseHold(db, name, actor)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Удержание %s снято, освобождено бэкапов: %d", name, len(released))
+	return released, setRemoteLegalHolds(config, released, false)
+}
+
+// setRemoteLegalHolds ставит или снимает legal hold с копий артефактов в удаленных хранилищах.
+// Хранилища без поддержки Object Lock пропускаются: их копии защищает от удаления только каталог.
+func setRemoteLegalHolds(config *Config, items []artifact, on bool) error {
+	storages := make(map[string]legalHolder)
+	var failed []string
+	for _, a := range items {
+		if a.Destination == "" {
+			continue
+		}
+		holder, ok := storages[a.Destination]
+		if !ok {
+			d := findDestination(config.Destinations, a.Destination)
+			if d != nil && d.ObjectLockBucket != "" {
+				st, err := openStorage(d)
+				if err != nil {
+					failed = append(failed, fmt.Sprintf("%s: %v", a.Destination, err))
+				} else {
+					holder, _ = st.(legalHolder)
+				}
+			}
+			storages[a.Destination] = holder
+		}
+		if holder == nil {
+			continue
+		}
+		if err := holder.SetLegalHold(a.Path, on); err != nil {
+			log.Printf("Ошибка установки legal hold для %s в хранилище %s: %v", a.Path, a.Destination, err)
+			failed = append(failed, fmt.Sprintf("%s -> %s: %v", a.Path, a.Destination, err))
+			continue
+		}
+		if on {
+			log.Printf("Legal hold установлен: %s в хранилище %s", a.Path, a.Destination)
+		} else {
+			log.Printf("Legal hold снят: %s в хранилище %s", a.Path, a.Destination)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("ошибка legal hold в удаленных хранилищах: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// String описывает условия удержания в читаемом виде для каталога и журнала
+func (c holdCriteria) String() string {
+	var parts []string
+	if c.Table != "" {
+		parts = append(parts, "table="+c.Table)
+	}
+	if c.Label != "" {
+		parts = append(parts, "label="+c.Label)
+	}
+	if !c.From.IsZero() {
+		parts = append(parts, "from="+c.From.Format("2006-01-02"))
+	}
+	if !c.To.IsZero() {
+		parts = append(parts, "to="+c.To.Format("2006-01-02"))
+	}
+	return strings.Join(parts, " ")
+}
+
+// describeArtifact возвращает имя артефакта с хранилищем, если это удаленная копия
+func describeArtifact(a artifact) string {
+	if a.Destination != "" {
+		return fmt.Sprintf("%s (%s)", a.Path, a.Destination)
+	}
+	return a.Path
+}
+
+// printHolds выводит удержания из каталога
+func printHolds(db *sql.DB) error {
+	holds, err := listHolds(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения удержаний: %w", err)
+	}
+	if len(holds) == 0 {
+		fmt.Println("Удержаний нет")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Удержание\tСтатус\tБэкапов\tУсловия\tПоставлено\tПричина")
+	for _, h := range holds {
+		status := "действует"
+		if h.ReleasedAt != nil {
+			status = "снято " + h.ReleasedAt.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s %s\t%s\n", h.Name, status, h.Items, h.Criteria,
+			h.CreatedAt.Format("2006-01-02"), h.CreatedBy, h.Reason)
+	}
+	return w.Flush()
+}
+
+// printHoldAudit выводит журнал действий с удержаниями
+func printHoldAudit(db *sql.DB) error {
+	entries, err := holdAudit(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения журнала удержаний: %w", err)
+	}
+	if len(entries) == 0 {
+		fmt.Println("Журнал удержаний пуст")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Время\tУдержание\tДействие\tКто\tПодробности")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.At.Format("2006-01-02 15:04:05"), e.HoldName, e.Action, e.Actor, e.Details)
+	}
+	return w.Flush()
+}
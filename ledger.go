@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/asn1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// LedgerConfig журнал контрольных сумм созданных бэкапов для аудита их целостности
+type LedgerConfig struct {
+	Enabled      bool     `json:"enabled"`       // Записывать контрольную сумму каждого созданного бэкапа в журнал
+	TimestampURL string   `json:"timestamp_url"` // Служба меток времени RFC 3161, заверяющая последнюю запись после каждого запуска (пусто - без заверения)
+	Timeout      Duration `json:"timeout"`       // Таймаут запроса к службе меток времени (по умолчанию "30s")
+}
+
+// События журнала контрольных сумм
+const (
+	ledgerEventBackup = "backup" // бэкап создан
+	ledgerEventForget = "forget" // из бэкапа удалены строки (dbacker forget), записана новая сумма
+)
+
+func init() {
+	commands["ledger"] = command{
+		Usage: "Backup checksum ledger (ledger show [-table t] / ledger verify / ledger token <seq>)",
+		Run:   cmdLedger,
+	}
+}
+
+// cmdLedger выполняет подкоманды журнала контрольных сумм
+func cmdLedger(args []string) error {
+	fs := flag.NewFlagSet("ledger", flag.ExitOnError)
+	table := fs.String("table", "", "Show only entries of backups of this source table (show)")
+	positional := parseArgs(fs, args)
+
+	usage := fmt.Errorf("использование: dbacker ledger show [-table t] | dbacker ledger verify | dbacker ledger token <seq>")
+	if len(positional) == 0 {
+		return usage
+	}
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch {
+	case positional[0] == "show" && len(positional) == 1:
+		return printLedger(db, *table)
+	case positional[0] == "verify" && len(positional) == 1:
+		backupDB, err := openBackupDatabase(config, db)
+		if err != nil {
+			return err
+		}
+		if backupDB != db {
+			defer backupDB.Close()
+		}
+		return verifyLedger(db, backupDB, config.Backup.Prefix)
+	case positional[0] == "token" && len(positional) == 2:
+		seq, err := strconv.ParseInt(positional[1], 10, 64)
+		if err != nil {
+			return usage
+		}
+		return writeAnchorToken(db, seq)
+	default:
+		return usage
+	}
+}
+
+// ledgerHash считает хеш записи журнала: sha256 от всех полей записи и хеша предыдущей
+func ledgerHash(e *ledgerEntry) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		strconv.FormatInt(e.Seq, 10),
+		e.RecordedAt.UTC().Format(time.RFC3339Nano),
+		e.Event, e.Backup, e.Source, e.Checksum, e.PrevHash,
+	}, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordLedger считает контрольные суммы бэкапов на сервере бэкапов, добавляет их в журнал
+// и заверяет последнюю запись службой меток времени: заверение последней записи по цепочке хешей
+// подтверждает и все предыдущие
+func recordLedger(db, backupDB *sql.DB, cfg *LedgerConfig, event string, created []createdBackup) error {
+	if len(created) == 0 {
+		return nil
+	}
+	entries := make([]ledgerEntry, 0, len(created))
+	for _, c := range created {
+		checksum, err := tableHash(backupDB, quoteIdent(c.Backup))
+		if err != nil {
+			return fmt.Errorf("ошибка подсчета md5 бэкапа %s: %w", c.Backup, err)
+		}
+		entries = append(entries, ledgerEntry{Event: event, Backup: c.Backup, Source: c.Source, Checksum: checksum})
+	}
+	if err := appendLedger(db, entries); err != nil {
+		return err
+	}
+	head := entries[len(entries)-1]
+	log.Printf("В журнал контрольных сумм записано бэкапов: %d (последняя запись %d)", len(entries), head.Seq)
+
+	if cfg.TimestampURL == "" {
+		return nil
+	}
+	token, err := requestTimestamp(cfg, head.EntryHash)
+	if err != nil {
+		return fmt.Errorf("ошибка заверения записи %d службой меток времени: %w", head.Seq, err)
+	}
+	anchor := &ledgerAnchor{Seq: head.Seq, EntryHash: head.EntryHash, Service: cfg.TimestampURL, Token: token}
+	if err := recordAnchor(db, anchor); err != nil {
+		return err
+	}
+	log.Printf("Запись журнала %d заверена службой меток времени %s", head.Seq, cfg.TimestampURL)
+	return nil
+}
+
+// Структуры запроса и ответа RFC 3161
+type tsaAlgorithm struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type tsaImprint struct {
+	HashAlgorithm tsaAlgorithm
+	HashedMessage []byte
+}
+
+type tsaRequest struct {
+	Version        int
+	MessageImprint tsaImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+type tsaResponse struct {
+	Status struct {
+		Status int
+	}
+	Token asn1.RawValue `asn1:"optional"`
+}
+
+// oidSHA256 идентификатор алгоритма SHA-256
+var oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+
+// requestTimestamp заверяет хеш записи журнала службой меток времени RFC 3161 и возвращает ответ службы.
+// Хеш записи уже является sha256, поэтому он передается службе как отпечаток SHA-256 без повторного хеширования.
+func requestTimestamp(cfg *LedgerConfig, entryHash string) ([]byte, error) {
+	digest, err := hex.DecodeString(entryHash)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	req, err := asn1.Marshal(tsaRequest{
+		Version: 1,
+		MessageImprint: tsaImprint{
+			HashAlgorithm: tsaAlgorithm{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: time.Duration(cfg.Timeout)}
+	resp, err := client.Post(cfg.TimestampURL, "application/timestamp-query", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("служба ответила %s", resp.Status)
+	}
+	if err := checkTimestamp(body, digest); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// checkTimestamp проверяет, что ответ службы меток времени выдан (granted) и заверяет именно этот отпечаток.
+// Подпись службы не проверяется: для этого ответ выгружается dbacker ledger token и проверяется openssl ts -verify.
+func checkTimestamp(reply, digest []byte) error {
+	var resp tsaResponse
+	if _, err := asn1.Unmarshal(reply, &resp); err != nil {
+		return fmt.Errorf("ответ службы не является TimeStampResp: %w", err)
+	}
+	// 0 - granted, 1 - grantedWithMods
+	if resp.Status.Status > 1 {
+		return fmt.Errorf("служба отказала в метке времени (status %d)", resp.Status.Status)
+	}
+	if len(resp.Token.FullBytes) == 0 || !bytes.Contains(resp.Token.FullBytes, digest) {
+		return fmt.Errorf("метка времени не содержит отпечаток записи")
+	}
+	return nil
+}
+
+// verifyLedger проверяет журнал контрольных сумм: непрерывность номеров и цепочку хешей, заверения
+// и содержимое существующих бэкапов по последней записанной для них сумме. Бэкап, которого нет на сервере,
+// считается нарушением, только если по каталогу он не удален (политикой хранения, prune, uninstall).
+func verifyLedger(db, backupDB *sql.DB, prefix string) error {
+	entries, err := ledgerEntries(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения журнала контрольных сумм: %w", err)
+	}
+	if len(entries) == 0 {
+		fmt.Println("Журнал контрольных сумм пуст")
+		return nil
+	}
+	anchors, err := ledgerAnchors(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения заверений журнала: %w", err)
+	}
+	live, err := liveTableBackups(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения каталога: %w", err)
+	}
+
+	var violations int
+	violation := func(format string, args ...interface{}) {
+		violations++
+		fmt.Printf("НАРУШЕНИЕ "+format+"\n", args...)
+	}
+
+	// Цепочка записей
+	for _, v := range ledgerChainViolations(entries) {
+		violation("%s", v)
+	}
+	byHash := make(map[int64]string, len(entries))
+	latest := make(map[string]ledgerEntry)
+	for _, e := range entries {
+		byHash[e.Seq] = e.EntryHash
+		latest[e.Backup] = e
+	}
+	fmt.Printf("Записей в журнале: %d, последняя %d от %s\n", len(entries), entries[len(entries)-1].Seq,
+		entries[len(entries)-1].RecordedAt.Local().Format("2006-01-02 15:04:05"))
+
+	// Заверения
+	for _, a := range anchors {
+		if byHash[a.Seq] != a.EntryHash {
+			violation("заверение записи %d: запись в журнале отличается от заверенной", a.Seq)
+			continue
+		}
+		digest, _ := hex.DecodeString(a.EntryHash)
+		if err := checkTimestamp(a.Token, digest); err != nil {
+			violation("заверение записи %d (%s): %v", a.Seq, a.Service, err)
+		}
+	}
+	if len(anchors) > 0 {
+		last := anchors[len(anchors)-1]
+		fmt.Printf("Заверений: %d, последнее - запись %d от %s\n", len(anchors), last.Seq, last.AnchoredAt.Local().Format("2006-01-02 15:04:05"))
+	}
+
+	// Содержимое бэкапов
+	existing := make(map[string]bool)
+	tables, err := getBackupTables(backupDB, prefix)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка бэкапов: %w", err)
+	}
+	for _, t := range tables {
+		existing[t.Name] = true
+	}
+	var checked, gone int
+	for _, name := range sortedKeys(latest) {
+		e := latest[name]
+		if !existing[name] {
+			if live[name] {
+				violation("бэкап %s отсутствует, хотя по каталогу не удален", name)
+			} else {
+				gone++
+			}
+			continue
+		}
+		checksum, err := tableHash(backupDB, quoteIdent(name))
+		if err != nil {
+			return fmt.Errorf("ошибка подсчета md5 бэкапа %s: %w", name, err)
+		}
+		checked++
+		if checksum != e.Checksum {
+			violation("бэкап %s изменен или подменен: md5/строк %s, в журнале %s (запись %d)", name, checksum, e.Checksum, e.Seq)
+		}
+	}
+	fmt.Printf("Проверено бэкапов: %d, удалено по каталогу: %d\n", checked, gone)
+
+	if violations > 0 {
+		return fmt.Errorf("нарушений целостности журнала и бэкапов: %d", violations)
+	}
+	fmt.Println("Журнал и бэкапы не изменялись")
+	return nil
+}
+
+// ledgerChainViolations проверяет непрерывность номеров записей журнала и цепочку хешей;
+// возвращает описания нарушений
+func ledgerChainViolations(entries []ledgerEntry) []string {
+	var violations []string
+	var prev string
+	for i, e := range entries {
+		if e.Seq != int64(i+1) {
+			violations = append(violations, fmt.Sprintf("запись %d: ожидался номер %d, записи удалены или вставлены", e.Seq, i+1))
+		}
+		if e.PrevHash != prev {
+			violations = append(violations, fmt.Sprintf("запись %d: хеш предыдущей записи не совпадает, цепочка разорвана", e.Seq))
+		}
+		if ledgerHash(&e) != e.EntryHash {
+			violations = append(violations, fmt.Sprintf("запись %d (%s): хеш записи не совпадает с ее содержимым, запись изменена", e.Seq, e.Backup))
+		}
+		prev = e.EntryHash
+	}
+	return violations
+}
+
+// printLedger выводит журнал контрольных сумм, при table - только записи бэкапов этой таблицы
+func printLedger(db *sql.DB, table string) error {
+	entries, err := ledgerEntries(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения журнала контрольных сумм: %w", err)
+	}
+	anchors, err := ledgerAnchors(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения заверений журнала: %w", err)
+	}
+	anchored := make(map[int64]string)
+	for _, a := range anchors {
+		anchored[a.Seq] = a.AnchoredAt.Local().Format("2006-01-02 15:04:05")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "№\tВремя\tСобытие\tБэкап\tmd5/строк\tХеш записи\tЗаверена")
+	for _, e := range entries {
+		if table != "" && e.Source != table {
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Seq, e.RecordedAt.Local().Format("2006-01-02 15:04:05"),
+			e.Event, e.Backup, e.Checksum, e.EntryHash[:16], anchored[e.Seq])
+	}
+	return w.Flush()
+}
+
+// writeAnchorToken выводит в stdout ответ службы меток времени для записи seq, чтобы проверить его
+// подпись независимо от dbacker: openssl ts -verify -digest <хеш записи> -in token.tsr -CAfile tsa.pem
+func writeAnchorToken(db *sql.DB, seq int64) error {
+	anchors, err := ledgerAnchors(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения заверений журнала: %w", err)
+	}
+	for _, a := range anchors {
+		if a.Seq == seq {
+			log.Printf("Запись %d, хеш записи %s, служба %s", a.Seq, a.EntryHash, a.Service)
+			_, err := os.Stdout.Write(a.Token)
+			return err
+		}
+	}
+	return fmt.Errorf("запись %d журнала не заверена", seq)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testLedger журнал из n записей бэкапов
+func testLedger(n int) []ledgerEntry {
+	entries := make([]ledgerEntry, n)
+	for i := range entries {
+		entries[i] = ledgerEntry{Event: ledgerEventBackup, Backup: "backup_orders_2024013" + string(rune('0'+i)),
+			Source: "orders", Checksum: "d41d8cd98f00b204e9800998ecf8427e/" + string(rune('0'+i))}
+	}
+	chainLedger(entries, 0, "")
+	return entries
+}
+
+func TestChainLedger(t *testing.T) {
+	entries := testLedger(3)
+	for i, e := range entries {
+		if e.Seq != int64(i+1) || e.EntryHash != ledgerHash(&e) {
+			t.Fatalf("запись %d: номер %d, хеш %s", i, e.Seq, e.EntryHash)
+		}
+		if i > 0 && e.PrevHash != entries[i-1].EntryHash {
+			t.Fatalf("запись %d не ссылается на хеш предыдущей", e.Seq)
+		}
+	}
+
+	// продолжение цепочки следующим запуском
+	next := []ledgerEntry{{Event: ledgerEventForget, Backup: "backup_orders_20240130", Source: "orders", Checksum: "0cc175b9c0f1b6a831c399e269772661/9"}}
+	chainLedger(next, entries[2].Seq, entries[2].EntryHash)
+	if v := ledgerChainViolations(append(entries, next...)); len(v) > 0 {
+		t.Errorf("продолженная цепочка с нарушениями: %v", v)
+	}
+}
+
+func TestLedgerChainViolations(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(entries []ledgerEntry) []ledgerEntry
+		want   []string // фрагменты нарушений по порядку
+	}{
+		{"intact", func(e []ledgerEntry) []ledgerEntry { return e }, nil},
+		{
+			"checksum replaced",
+			func(e []ledgerEntry) []ledgerEntry { e[1].Checksum = "ffffffffffffffffffffffffffffffff/1"; return e },
+			[]string{"запись 2 (backup_orders_20240131): хеш записи не совпадает"},
+		},
+		{
+			"entry rehashed after change",
+			func(e []ledgerEntry) []ledgerEntry {
+				e[1].Checksum = "ffffffffffffffffffffffffffffffff/1"
+				e[1].EntryHash = ledgerHash(&e[1])
+				return e
+			},
+			[]string{"запись 3: хеш предыдущей записи не совпадает"},
+		},
+		{
+			"entry deleted",
+			func(e []ledgerEntry) []ledgerEntry { return append(e[:1], e[2:]...) },
+			[]string{"запись 3: ожидался номер 2", "запись 3: хеш предыдущей записи не совпадает", "запись 4: ожидался номер 3"},
+		},
+		// удаление последних записей по цепочке не обнаруживается, для этого записи заверяются службой меток времени
+		{
+			"last entry deleted",
+			func(e []ledgerEntry) []ledgerEntry { return e[:3] },
+			nil,
+		},
+		{
+			"entries swapped",
+			func(e []ledgerEntry) []ledgerEntry { e[2], e[3] = e[3], e[2]; return e },
+			[]string{"запись 4: ожидался номер 3", "запись 4: хеш предыдущей записи не совпадает",
+				"запись 3: ожидался номер 4", "запись 3: хеш предыдущей записи не совпадает"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ledgerChainViolations(tt.tamper(testLedger(4)))
+			if len(got) != len(tt.want) {
+				t.Fatalf("нарушения %q, ожидалось %q", got, tt.want)
+			}
+			for i := range got {
+				if !strings.Contains(got[i], tt.want[i]) {
+					t.Errorf("нарушение %q, ожидалось содержащее %q", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
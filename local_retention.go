@@ -0,0 +1,137 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// LocalRetentionConfig хранение файлов выгрузок и отчетов на локальном диске (каталоги export.dir и report.dir)
+type LocalRetentionConfig struct {
+	MaxAgeDays int      `json:"max_age_days"` // Удалять файлы старше указанного количества дней (0 - без ограничения по возрасту)
+	MaxSize    ByteSize `json:"max_size"`     // Лимит суммарного размера файлов, сверх которого удаляются самые старые, например "100GB" (0 - без лимита)
+}
+
+// validateLocalRetention проверяет настройки хранения локальных файлов
+func validateLocalRetention(cfg *LocalRetentionConfig) error {
+	if cfg.MaxAgeDays < 0 {
+		return fmt.Errorf("local_retention.max_age_days не может быть отрицательным")
+	}
+	if cfg.MaxSize < 0 {
+		return fmt.Errorf("local_retention.max_size не может быть отрицательным")
+	}
+	return nil
+}
+
+// localRetentionDirs возвращает каталоги, к которым применяется local_retention
+func localRetentionDirs(config *Config) []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, dir := range []string{config.Export.Dir, config.Report.Dir} {
+		if dir != "" && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// localFile файл выгрузки или отчета с размером
+type localFile struct {
+	backupFile
+	Size int64
+}
+
+// cleanupLocalFiles удаляет из каталогов выгрузок и отчетов файлы старше local_retention.max_age_days, а затем
+// самые старые файлы, пока их суммарный размер больше local_retention.max_size. Файлы за дату today
+// (текущего запуска), под юридическим удержанием и продленные командой retain не удаляются.
+func cleanupLocalFiles(db *sql.DB, config *Config, today string, realRun bool) error {
+	cfg := &config.LocalRetention
+	if cfg.MaxAgeDays == 0 && cfg.MaxSize == 0 {
+		return nil
+	}
+
+	var files []localFile
+	for _, dir := range localRetentionDirs(config) {
+		found, err := listBackupFiles(dir, config.Backup.Prefix)
+		if err != nil {
+			return fmt.Errorf("ошибка чтения каталога %s: %w", dir, err)
+		}
+		for _, f := range found {
+			size, err := pathSize(f.Path)
+			if err != nil {
+				return err
+			}
+			files = append(files, localFile{backupFile: f, Size: size})
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].Date == files[j].Date {
+			return files[i].Path < files[j].Path
+		}
+		return files[i].Date < files[j].Date
+	})
+
+	held, err := heldBackups(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения удержаний: %w", err)
+	}
+	retained, err := retentionOverrides(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения продлений хранения: %w", err)
+	}
+	protected := func(f localFile) bool {
+		until, ok := retained[f.Path]
+		return f.Date >= today || held[f.Path] || ok && isRetained(until)
+	}
+
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	remove := func(f localFile, reason string) {
+		if !realRun {
+			log.Printf("Тестовый запуск, будет удален локальный файл %s (%s): %s", f.Path, ByteSize(f.Size), reason)
+			total -= f.Size
+			return
+		}
+		if err := os.RemoveAll(f.Path); err != nil {
+			log.Printf("Ошибка удаления файла %s: %v", f.Path, err)
+			return
+		}
+		if err := markArtifactDeleted(db, f.Path); err != nil {
+			log.Printf("Ошибка обновления каталога для %s: %v", f.Path, err)
+		}
+		total -= f.Size
+		log.Printf("Удален локальный файл %s (%s): %s", f.Path, ByteSize(f.Size), reason)
+	}
+
+	var kept []localFile
+	threshold := clock.Now().AddDate(0, 0, -cfg.MaxAgeDays).Format("20060102")
+	for _, f := range files {
+		if cfg.MaxAgeDays > 0 && f.Date < threshold && !protected(f) {
+			remove(f, fmt.Sprintf("старше %d дн.", cfg.MaxAgeDays))
+			continue
+		}
+		kept = append(kept, f)
+	}
+
+	if cfg.MaxSize == 0 {
+		return nil
+	}
+	for _, f := range kept {
+		if total <= int64(cfg.MaxSize) {
+			return nil
+		}
+		if !protected(f) {
+			remove(f, fmt.Sprintf("файлы занимают больше %s", cfg.MaxSize))
+		}
+	}
+	if total > int64(cfg.MaxSize) {
+		log.Printf("Локальные файлы занимают %s при лимите %s: оставшиеся файлы текущего запуска, удержанные или продленные не удаляются",
+			ByteSize(total), cfg.MaxSize)
+	}
+	return nil
+}
@@ -3,13 +3,19 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
-	_ "github.com/lib/pq"
+	"dbacker/dberrors"
+	"github.com/lib/pq"
 )
 
 type PostgresConfig struct {
@@ -19,29 +25,135 @@ type PostgresConfig struct {
 	Password string `json:"password"`
 	DBName   string `json:"dbname"`
 	SSL      bool   `json:"ssl"`
+
+	// Параметры сессии (GUC) для соединений dbacker, например {"work_mem": "256MB", "synchronous_commit": "off"}
+	Settings map[string]string `json:"settings"`
+
+	// Режим пулера соединений между dbacker и сервером: "" (прямое подключение) или "transaction"
+	// (pgBouncer pool_mode = transaction) - без параметров сессии и повторно используемых подготовленных запросов
+	Pooler     string `json:"pooler"`
+	DirectHost string `json:"direct_host"` // Прямой адрес сервера в обход пулера для операций, которым нужна сессия
+	DirectPort int    `json:"direct_port"` // Прямой порт сервера (по умолчанию port)
+
+	Schema string `json:"-"` // Схема таблиц (задается для каждого арендатора в режиме backup.schemas, по умолчанию public)
 }
 
 type BackupConfig struct {
 	Prefix    string `json:"prefix"`    // Префикс для таблиц бэкапа (по умолчанию "autobackup")
 	Retention int    `json:"retention"` // Количество дней хранения бэкапов (по умолчанию 14)
+
+	MaxTotalBackupSize ByteSize `json:"max_total_backup_size"` // Лимит суммарного размера бэкапов, например "50GB" (0 - без лимита)
+	BudgetPolicy       string   `json:"budget_policy"`         // Действие при превышении лимита: "refuse" (по умолчанию) или "prune"
+
+	Order    string   `json:"order"`    // Порядок обхода таблиц: "name", "size_desc", "size_asc", "priority" (по умолчанию как вернул information_schema)
+	Priority []string `json:"priority"` // Таблицы, которые бэкапятся первыми при order = "priority"
+
+	OnError   string `json:"on_error"`   // Поведение при ошибке бэкапа таблицы: "continue" (по умолчанию), "fail_fast", "fail_after_n"
+	MaxErrors int    `json:"max_errors"` // Количество ошибок, после которого прерывается запуск при on_error = "fail_after_n"
+
+	Retries    int      `json:"retries"`     // Количество повторных попыток копирования таблицы при ошибке (по умолчанию 0)
+	RetryDelay Duration `json:"retry_delay"` // Пауза перед первым повтором, удваивается с каждой попыткой (по умолчанию "5s", "0s" - без паузы)
+
+	LockStrategy   string   `json:"lock_strategy"`    // Ожидание блокировки исходной таблицы: "wait" (по умолчанию) или "nowait"
+	LockRetries    int      `json:"lock_retries"`     // Сколько раз повторять таблицы, заблокированные при "nowait" (в конце запуска) или при "wait" по lock_timeout (по умолчанию 3, 0 - не повторять)
+	LockRetryDelay Duration `json:"lock_retry_delay"` // Пауза перед каждым повтором заблокированных таблиц (по умолчанию "30s")
+
+	VerifySample int      `json:"verify_sample"` // Сколько случайных таблиц за запуск сверять с исходными по md5 (0 - не сверять)
+	VerifyTables []string `json:"verify_tables"` // Таблицы, которые сверяются при каждом запуске
+
+	Validations []ValidationConfig `json:"validations"` // Проверки данных бэкапов запросами, например совпадение sum(amount) с исходной таблицей
+
+	TrackRenames bool `json:"track_renames"` // Находить переименованные таблицы по oid и переименовывать их старые бэкапы
+
+	DDLRetry bool `json:"ddl_retry"` // Повторять копирование таблицы, структура которой изменилась во время запуска, один раз с новыми метаданными
+
+	CopyFreeze bool `json:"copy_freeze"` // Создавать бэкап пустым и заполнять COPY FREEZE в той же транзакции (при wal_level = minimal без записи в WAL)
+
+	DropBatch          int      `json:"drop_batch"`           // Сколько таблиц бэкапа удалять одним DROP при очистке по сроку хранения (по умолчанию 1)
+	DropPause          Duration `json:"drop_pause"`           // Пауза между пачками удаления, например "2s" (по умолчанию без паузы)
+	DropLockTimeout    Duration `json:"drop_lock_timeout"`    // lock_timeout для удаления: пачка, не получившая блокировку, удаляется следующим запуском
+	TruncateBeforeDrop bool     `json:"truncate_before_drop"` // Очищать таблицы TRUNCATE отдельной транзакцией перед DROP
+
+	ExcludePresets []string         `json:"exclude_presets"` // Встроенные наборы исключений: "django", "celery", "pg_repack", "etl"
+	Exclude        []string         `json:"exclude"`         // Регулярные выражения имен таблиц, которые не бэкапятся
+	Excludes       []*regexp.Regexp `json:"-"`               // Скомпилированные шаблоны exclude_presets и exclude
+
+	// Таблицы, которые обязательно должны попасть в каждый запуск: имена или регулярные выражения,
+	// совпадающие с именем таблицы целиком, например "payments" или "billing_.*"
+	Required         []string         `json:"required"`
+	RequiredPatterns []*regexp.Regexp `json:"-"` // Скомпилированные шаблоны required
+
+	TempRole bool `json:"temp_role"` // Выполнять запуск под временной ролью с минимальными правами, которую создает и удаляет dbacker
+
+	Groups []TableGroupConfig `json:"groups"` // Группы таблиц, которые копируются в одной транзакции с проверкой внешних ключей
+
+	Schemas string `json:"schemas"` // Шаблон LIKE схем арендаторов, например "tenant_%": бэкап выполняется в каждой схеме отдельно
+
+	DaySchemas      bool   `json:"day_schemas"`       // Создавать для каждого дня схему {day_schema_prefix}_{YYYYMMDD} с представлениями под исходными именами таблиц
+	DaySchemaPrefix string `json:"day_schema_prefix"` // Префикс схем дней (по умолчанию "backup")
 }
 
 // Config структура для хранения параметров конфигурации
 type Config struct {
 	Postgres PostgresConfig `json:"postgres"`
 	Backup   BackupConfig   `json:"backup"`
+	Dump     DumpConfig     `json:"dump"`
+	Export   ExportConfig   `json:"export"`
+	BigQuery BigQueryConfig `json:"bigquery"`
+	Remote   RemoteConfig   `json:"remote"`
+	CDC      CDCConfig      `json:"cdc"`
+	Cluster  ClusterConfig  `json:"cluster"`
+	Restore  RestoreConfig  `json:"restore"`
+
+	Destinations []DestinationConfig `json:"destinations"`
+
+	Notifications []NotifierConfig `json:"notifications"`
+
+	Report ReportConfig `json:"report"` // HTML-отчет о запусках
+
+	Cost CostConfig `json:"cost"` // Цены хранения для отчета dbacker cost
+
+	Targets []TargetConfig `json:"targets"` // Базы, которые запускает dbacker targets
+
+	Ledger LedgerConfig `json:"ledger"` // Журнал контрольных сумм бэкапов (dbacker ledger verify)
+
+	SLOs []SLOConfig `json:"slos"` // Цели по свежести бэкапов таблиц (dbacker check, /metrics)
+
+	Calendar CalendarConfig `json:"calendar"` // Периоды, в которые автоматические запуски пропускаются или откладываются
+
+	LocalRetention LocalRetentionConfig `json:"local_retention"` // Хранение файлов выгрузок и отчетов на локальном диске
 }
 
 func main() {
+	if err := setupClock(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// Подкоманды: dbacker <команда> [флаги]
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	run := flag.Bool("run", false, "Normal run instead of test run?")
+	ignoreCalendar := flag.Bool("ignore-calendar", false, "Run even inside a blackout window of the calendar (manual runs)")
 	flag.Parse()
 	flag.Usage()
 
 	// Загрузка конфигурации
-	config, err := loadConfig("config.ini")
+	config, err := loadConfig(configFile)
 	if err != nil {
 		log.Fatalf("Ошибка загрузки конфигурации: %v", err)
 	}
+	if !*ignoreCalendar {
+		allowed, err := allowRun(&config.Calendar, "бэкапа таблиц", *run)
+		if err != nil {
+			log.Fatalf("Ошибка проверки календаря запусков: %v", err)
+		}
+		if !allowed {
+			return
+		}
+	}
 
 	// Подключение к PostgreSQL
 	db, err := connectToPostgres(&config.Postgres)
@@ -49,9 +161,35 @@ func main() {
 		log.Fatalf("Ошибка подключения к PostgreSQL: %v", err)
 	}
 	defer db.Close()
+	if err := migrateCatalog(db); err != nil {
+		log.Fatalf("Ошибка обновления каталога: %v", err)
+	}
 
-	// Выполнение задачи бэкапа
-	err = performBackup(db, config.Backup.Prefix, config.Backup.Retention, *run)
+	// База, в которой хранятся бэкапы (основная или сервер бэкапов в режиме postgres_fdw)
+	backupDB, err := openBackupDatabase(config, db)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if backupDB != db {
+		defer backupDB.Close()
+	}
+
+	// Выполнение задачи бэкапа: по схемам арендаторов или для схемы public
+	if config.Backup.Schemas != "" {
+		if err := runTenants(db, config, *run, nil); err != nil {
+			log.Fatalf("Ошибка выполнения бэкапа: %v", err)
+		}
+		log.Println("backup done")
+		return
+	}
+	result, err := runBackup(db, backupDB, config, *run, nil)
+
+	// Тестовый запуск сравнивается с последним записанным
+	if !*run {
+		if diffErr := printRunDiff(db, config, result); diffErr != nil {
+			log.Printf("Ошибка сравнения с последним запуском: %v", diffErr)
+		}
+	}
 	if err != nil {
 		log.Fatalf("Ошибка выполнения бэкапа: %v", err)
 	}
@@ -59,31 +197,362 @@ func main() {
 	log.Println("backup done")
 }
 
+// runBackup выполняет полный запуск бэкапа таблиц: копирование, выгрузки, загрузку в хранилища,
+// а при настоящем запуске - запись в каталог и уведомления
+func runBackup(db, backupDB *sql.DB, config *Config, realRun bool, progress progressFunc) (*runResult, error) {
+	startedAt := clock.Now()
+
+	// Исходные таблицы читаются и копируются под временной ролью, бэкапы и каталог обслуживает основная
+	source := db
+	var result *runResult
+	var err error
+	if config.Backup.TempRole && realRun {
+		var role *tempRole
+		if role, err = openTempRole(db, config); err == nil {
+			defer role.close(db)
+			source = role.DB
+		}
+	}
+	if err == nil {
+		result, err = performBackup(source, backupDB, config, realRun, progress)
+	} else {
+		result = &runResult{}
+	}
+	// Обязательные таблицы проверяются, если запуск дошел до копирования таблиц
+	if err == nil || result.Tables > 0 {
+		if coverageErr := checkRunCoverage(db, &config.Backup, result); coverageErr != nil && err == nil {
+			err = coverageErr
+		}
+	}
+	if config.Ledger.Enabled && realRun {
+		if ledgerErr := recordLedger(db, backupDB, &config.Ledger, ledgerEventBackup, result.Created); ledgerErr != nil {
+			log.Printf("Ошибка записи в журнал контрольных сумм: %v", ledgerErr)
+		}
+	}
+	if guardErr := dropExpiredGuards(db, backupDB, config, realRun); guardErr != nil {
+		log.Printf("Ошибка удаления истекших страховочных копий: %v", guardErr)
+	}
+
+	// Выгрузка созданных бэкапов в файлы; файлы загружаются в хранилища по мере готовности
+	if realRun && len(config.Export.Formats) > 0 && len(result.Created) > 0 {
+		result.startUploads(config)
+	}
+	if exportErr := exportSnapshots(backupDB, config, result, realRun); exportErr != nil && err == nil {
+		err = exportErr
+	}
+	if loadErr := loadBigQuery(config, result, realRun); loadErr != nil && err == nil {
+		err = loadErr
+	}
+	// Отчет сохраняется до загрузки в хранилища, чтобы загрузка и хранение охватили и его
+	if realRun && config.Report.Dir != "" {
+		if reportErr := saveRunReport(db, config, result, runRecordOf(config, runKindTables, startedAt, result, err)); reportErr != nil {
+			log.Printf("Ошибка сохранения отчета: %v", reportErr)
+		}
+	}
+	if uploadErr := deliverArtifacts(db, config, result, realRun); uploadErr != nil && err == nil {
+		err = uploadErr
+	}
+
+	if config.Backup.DaySchemas {
+		if syncErr := syncDaySchemas(backupDB, &config.Backup, realRun); syncErr != nil {
+			log.Printf("Ошибка обновления схем дней: %v", syncErr)
+		}
+	}
+
+	// Запись результата в каталог и уведомления (только при настоящем запуске)
+	if realRun {
+		finishRun(db, config, runKindTables, startedAt, result, err)
+	}
+	// Файлы уже загружены в хранилища, старые локальные копии выгрузок и отчетов можно удалять
+	if cleanupErr := cleanupLocalFiles(db, config, startedAt.Format("20060102"), realRun); cleanupErr != nil {
+		log.Printf("Ошибка очистки локальных файлов: %v", cleanupErr)
+	}
+
+	finished := progressEvent{Type: eventRunFinished, Tables: result.Tables, Failed: len(result.Failed)}
+	if err != nil {
+		finished.Error = err.Error()
+	}
+	progress.orNop()(finished)
+	return result, err
+}
+
 // loadConfig загружает конфигурацию из файла
 func loadConfig(filename string) (*Config, error) {
 	file, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка чтения файла конфигурации: %v", err)
+		return nil, fmt.Errorf("ошибка чтения файла конфигурации: %w", err)
 	}
 
+	// Значения по умолчанию, для которых ноль - допустимая настройка, задаются до разбора файла:
+	// явно указанный в конфигурации ноль их переопределяет
 	var config Config
+	config.Backup.RetryDelay = Duration(5 * time.Second)
+	config.Backup.LockRetries = 3
+	config.Backup.LockRetryDelay = Duration(30 * time.Second)
 	err = json.Unmarshal(file, &config)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка парсинга конфигурации: %v", err)
+		return nil, fmt.Errorf("ошибка парсинга конфигурации: %w", err)
+	}
+	if err := checkConfigKeys(file, configSchema()); err != nil {
+		return nil, err
 	}
 
 	// Установка значений по умолчанию
+	if err := validateSettings(config.Postgres.Settings); err != nil {
+		return nil, err
+	}
+	if err := validateSettings(config.Remote.Settings); err != nil {
+		return nil, err
+	}
+	if err := validatePooler(&config.Postgres, "postgres"); err != nil {
+		return nil, err
+	}
+	if err := validatePooler(&config.Remote.PostgresConfig, "remote"); err != nil {
+		return nil, err
+	}
 	if config.Backup.Prefix == "" {
 		config.Backup.Prefix = "autobackup"
 	}
 	if config.Backup.Retention == 0 {
 		config.Backup.Retention = 14
 	}
+	switch config.Backup.BudgetPolicy {
+	case "":
+		config.Backup.BudgetPolicy = "refuse"
+	case "refuse", "prune":
+	default:
+		return nil, fmt.Errorf("неизвестное значение budget_policy: %s", config.Backup.BudgetPolicy)
+	}
+	switch config.Backup.Order {
+	case "", "name", "size_desc", "size_asc", "priority":
+	default:
+		return nil, fmt.Errorf("неизвестное значение order: %s", config.Backup.Order)
+	}
+	switch config.Backup.OnError {
+	case "":
+		config.Backup.OnError = "continue"
+	case "continue", "fail_fast":
+	case "fail_after_n":
+		if config.Backup.MaxErrors <= 0 {
+			return nil, fmt.Errorf("для on_error = fail_after_n нужно указать max_errors > 0")
+		}
+	default:
+		return nil, fmt.Errorf("неизвестное значение on_error: %s", config.Backup.OnError)
+	}
+	if config.Backup.Retries < 0 {
+		return nil, fmt.Errorf("retries не может быть отрицательным")
+	}
+	if config.Backup.RetryDelay < 0 {
+		return nil, fmt.Errorf("retry_delay не может быть отрицательным")
+	}
+	switch config.Backup.LockStrategy {
+	case "":
+		config.Backup.LockStrategy = "wait"
+	case "wait", "nowait":
+	default:
+		return nil, fmt.Errorf("неизвестное значение lock_strategy: %s", config.Backup.LockStrategy)
+	}
+	if config.Backup.LockRetries < 0 {
+		return nil, fmt.Errorf("lock_retries не может быть отрицательным")
+	}
+	if config.Backup.LockRetryDelay < 0 {
+		return nil, fmt.Errorf("lock_retry_delay не может быть отрицательным")
+	}
+	if config.Backup.Excludes, err = compileExcludes(&config.Backup); err != nil {
+		return nil, err
+	}
+	if config.Backup.RequiredPatterns, err = compileRequired(config.Backup.Required); err != nil {
+		return nil, err
+	}
+	if err := validateValidations(config.Backup.Validations); err != nil {
+		return nil, err
+	}
+	if config.Backup.DropBatch < 0 {
+		return nil, fmt.Errorf("drop_batch не может быть отрицательным")
+	}
+	if config.Backup.DropBatch == 0 {
+		config.Backup.DropBatch = 1
+	}
+	if config.Dump.Binary == "" {
+		config.Dump.Binary = "pg_dump"
+	}
+	if config.Dump.RestoreBinary == "" {
+		config.Dump.RestoreBinary = "pg_restore"
+	}
+	switch config.Dump.Format {
+	case "":
+		config.Dump.Format = "custom"
+	case "custom", "directory":
+	default:
+		return nil, fmt.Errorf("неизвестный формат дампа: %s", config.Dump.Format)
+	}
+	if config.Dump.Jobs > 1 && config.Dump.Format != "directory" {
+		return nil, fmt.Errorf("параллельный дамп (jobs) поддерживается только для формата directory")
+	}
+	// Каталог формата directory pg_dump пишет сам, по файлу на таблицу и параллельно: пропустить его
+	// через конвейер нельзя, а без конвейера дамп остался бы несжатым и незашифрованным вопреки настройкам
+	if config.Dump.Format == "directory" && (len(config.Export.Pipeline) > 0 || config.Export.ChunkSize > 0) {
+		return nil, fmt.Errorf("формат дампа directory несовместим с export.pipeline и export.chunk_size, используйте формат custom")
+	}
+	if config.Dump.Dir == "" {
+		config.Dump.Dir = "dumps"
+	}
+	if len(config.Dump.Databases) == 0 {
+		config.Dump.Databases = []string{config.Postgres.DBName}
+	}
+	if err := validateExportConfig(&config.Export); err != nil {
+		return nil, err
+	}
+	if err := validateBigQueryConfig(&config.BigQuery, &config.Export); err != nil {
+		return nil, err
+	}
+	if err := validateDestinations(config.Destinations); err != nil {
+		return nil, err
+	}
+	if err := validateLocalRetention(&config.LocalRetention); err != nil {
+		return nil, err
+	}
+	if config.CDC.Slot == "" {
+		config.CDC.Slot = "dbacker_cdc"
+	}
+	switch config.CDC.Plugin {
+	case "":
+		config.CDC.Plugin = "wal2json"
+	case "wal2json", "test_decoding":
+	default:
+		return nil, fmt.Errorf("неподдерживаемый плагин декодирования: %s", config.CDC.Plugin)
+	}
+	if config.CDC.Dir == "" {
+		config.CDC.Dir = "cdc"
+	}
+	if config.CDC.Interval == 0 {
+		config.CDC.Interval = Duration(10 * time.Second)
+	}
+	if config.CDC.MaxChanges == 0 {
+		config.CDC.MaxChanges = 10000
+	}
+	if config.CDC.Rotate == 0 {
+		config.CDC.Rotate = Duration(time.Hour)
+	}
+	if config.Cluster.Binary == "" {
+		config.Cluster.Binary = "pg_basebackup"
+	}
+	if config.Cluster.Dir == "" {
+		config.Cluster.Dir = "basebackups"
+	}
+	switch config.Cluster.WALMethod {
+	case "":
+		config.Cluster.WALMethod = "fetch"
+	case "fetch", "stream", "none":
+	default:
+		return nil, fmt.Errorf("неизвестное значение cluster.wal_method: %s", config.Cluster.WALMethod)
+	}
+	switch config.Cluster.Checkpoint {
+	case "":
+		config.Cluster.Checkpoint = "fast"
+	case "fast", "spread":
+	default:
+		return nil, fmt.Errorf("неизвестное значение cluster.checkpoint: %s", config.Cluster.Checkpoint)
+	}
+	if config.Backup.Schemas != "" && config.Remote.remoteEnabled() {
+		return nil, fmt.Errorf("backup.schemas нельзя использовать вместе с remote")
+	}
+	if config.Backup.DaySchemaPrefix == "" {
+		config.Backup.DaySchemaPrefix = "backup"
+	}
+	if config.Backup.DaySchemas && config.Backup.Schemas != "" {
+		return nil, fmt.Errorf("backup.day_schemas нельзя использовать вместе с backup.schemas")
+	}
+	if config.Report.Days <= 0 {
+		config.Report.Days = 30
+	}
+	if err := validateGroups(config.Backup.Groups); err != nil {
+		return nil, err
+	}
+	if len(config.Backup.Groups) > 0 && config.Remote.remoteEnabled() {
+		return nil, fmt.Errorf("backup.groups нельзя использовать вместе с remote")
+	}
+	if config.Backup.CopyFreeze && config.Remote.remoteEnabled() {
+		return nil, fmt.Errorf("backup.copy_freeze нельзя использовать вместе с remote: копия создается на сервере бэкапов через postgres_fdw")
+	}
+	if config.Backup.TempRole && (config.Remote.remoteEnabled() || config.Backup.Schemas != "") {
+		return nil, fmt.Errorf("backup.temp_role нельзя использовать вместе с remote и backup.schemas")
+	}
+	if config.Remote.remoteEnabled() {
+		if config.Remote.ServerName == "" {
+			config.Remote.ServerName = "dbacker_backup"
+		}
+		// postgres_fdw настраивает удаленную сессию при подключении, поэтому в обход пулера
+		// ходит на сервер бэкапов по прямому адресу
+		fdw := &config.Remote.PostgresConfig
+		if fdw.Pooler == poolerTransaction && fdw.DirectHost != "" {
+			fdw, _ = sessionPostgres(fdw, "postgres_fdw")
+		}
+		if config.Remote.FDWHost == "" {
+			config.Remote.FDWHost = fdw.Host
+		}
+		if config.Remote.FDWPort == 0 {
+			config.Remote.FDWPort = fdw.Port
+		}
+	}
+	for i := range config.Notifications {
+		n := &config.Notifications[i]
+		switch n.Type {
+		case "slack", "webhook":
+		default:
+			return nil, fmt.Errorf("неизвестный тип уведомлений: %s", n.Type)
+		}
+		if n.URL == "" {
+			return nil, fmt.Errorf("для уведомлений %s не указан url", n.Type)
+		}
+		if len(n.Policy) == 0 {
+			n.Policy = []string{"on_failure"}
+		}
+		for _, p := range n.Policy {
+			switch p {
+			case "always", "on_failure", "on_recovery", "on_long_duration", "on_slo":
+			default:
+				return nil, fmt.Errorf("неизвестная политика уведомлений: %s", p)
+			}
+		}
+		if n.DurationFactor == 0 {
+			n.DurationFactor = 3
+		}
+	}
+	if config.Cost.Currency == "" {
+		config.Cost.Currency = "USD"
+	}
+	if config.Ledger.Timeout == 0 {
+		config.Ledger.Timeout = Duration(30 * time.Second)
+	}
+	if config.Ledger.TimestampURL != "" && !strings.HasPrefix(config.Ledger.TimestampURL, "http://") && !strings.HasPrefix(config.Ledger.TimestampURL, "https://") {
+		return nil, fmt.Errorf("ledger.timestamp_url должен быть адресом http(s): %s", config.Ledger.TimestampURL)
+	}
+	if config.Ledger.TimestampURL != "" && !config.Ledger.Enabled {
+		return nil, fmt.Errorf("ledger.timestamp_url задан, но журнал контрольных сумм выключен (ledger.enabled)")
+	}
+	if err := validateSLOs(config.SLOs); err != nil {
+		return nil, err
+	}
+	if err := validateTargets(config.Targets); err != nil {
+		return nil, err
+	}
+	if err := validateCalendar(&config.Calendar); err != nil {
+		return nil, err
+	}
 	return &config, nil
 }
 
 // connectToPostgres устанавливает соединение с PostgreSQL
 func connectToPostgres(cfg *PostgresConfig) (*sql.DB, error) {
+	if cfg.Pooler == poolerTransaction && (len(cfg.Settings) > 0 || cfg.Schema != "") {
+		// Параметры сессии и search_path схемы арендатора через пулер не сохраняются
+		direct, err := sessionPostgres(cfg, "параметры сессии и схема арендатора")
+		if err != nil {
+			return nil, err
+		}
+		cfg = direct
+	}
 	ssl := "disable"
 	if cfg.SSL {
 		ssl = "require"
@@ -91,6 +560,18 @@ func connectToPostgres(cfg *PostgresConfig) (*sql.DB, error) {
 
 	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, ssl)
+	// Параметры сессии передаются серверу при подключении и действуют для каждого соединения пула
+	for _, name := range sortedKeys(cfg.Settings) {
+		connStr += fmt.Sprintf(" %s=%s", name, quoteConnValue(cfg.Settings[name]))
+	}
+	if cfg.Schema != "" {
+		connStr += " search_path=" + quoteConnValue(pq.QuoteIdentifier(cfg.Schema))
+	}
+	if cfg.Pooler == poolerTransaction {
+		// Запрос с параметрами отправляется одним пакетом Parse/Bind/Execute, без подготовленного
+		// оператора, который пулер мог бы оставить в другой сессии сервера
+		connStr += " binary_parameters=yes"
+	}
 
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
@@ -105,91 +586,522 @@ func connectToPostgres(cfg *PostgresConfig) (*sql.DB, error) {
 	return db, nil
 }
 
-// performBackup выполняет основную логику бэкапа
-func performBackup(db *sql.DB, prefix string, retentionDays int, realRun bool) error {
+// quoteConnValue заключает значение параметра строки подключения в кавычки
+func quoteConnValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	return "'" + strings.ReplaceAll(v, "'", `\'`) + "'"
+}
+
+// validateSettings проверяет имена параметров сессии
+func validateSettings(settings map[string]string) error {
+	for name := range settings {
+		if !settingName.MatchString(name) {
+			return fmt.Errorf("некорректное имя параметра сессии: %q", name)
+		}
+	}
+	return nil
+}
+
+// settingName допустимое имя параметра сессии PostgreSQL (включая параметры расширений вида "pg_stat_statements.track")
+var settingName = regexp.MustCompile(`^[a-z_][a-z0-9_]*(\.[a-z_][a-z0-9_]*)?$`)
+
+// runResult итоги выполнения бэкапа
+type runResult struct {
+	Tables    int             // Количество таблиц (или баз), для которых выполнялся бэкап
+	Failed    []string        // Таблицы, бэкап которых не удался, с текстом ошибки
+	Created   []createdBackup // Созданные таблицы бэкапа
+	Date      string          // Дата запуска в именах бэкапов (YYYYMMDD)
+	Artifacts []artifact      // Созданные файлы и таблицы бэкапа
+
+	Deleted     int      // Количество бэкапов, удаленных политикой хранения
+	DeletedSize int64    // Их суммарный размер
+	Dropped     []string // Таблицы бэкапа, удаленные политикой хранения и бюджетом при настоящем запуске
+
+	Label string // Метка внепланового снимка
+
+	Groups []groupResult // Итоги групп таблиц (backup.groups)
+
+	uploads *uploadQueue // очередь загрузки в хранилища, запущенная до выгрузок (startUploads)
+
+	mu sync.Mutex // защищает списки: методы add* вызываются из параллельных обработчиков таблиц и баз
+}
+
+// addBackup регистрирует созданный бэкап таблицы
+func (r *runResult) addBackup(c createdBackup, a artifact) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Created = append(r.Created, c)
+	r.Artifacts = append(r.Artifacts, a)
+}
+
+// addArtifacts регистрирует созданные файлы и, если очередь загрузки уже запущена, ставит их в нее
+func (r *runResult) addArtifacts(artifacts ...artifact) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Artifacts = append(r.Artifacts, artifacts...)
+	if r.uploads != nil {
+		r.uploads.add(artifacts...)
+	}
+}
+
+// startUploads запускает очередь загрузки в хранилища, чтобы файлы загружались по мере создания;
+// дожидается ее uploadArtifacts
+func (r *runResult) startUploads(config *Config) {
+	if len(config.Destinations) == 0 {
+		return
+	}
+	q := newUploadQueue(config, r)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.uploads = q
+}
+
+// takeUploads возвращает запущенную очередь загрузки (или nil) вместе с зарегистрированными файлами
+// и отвязывает очередь от запуска
+func (r *runResult) takeUploads() (*uploadQueue, []artifact) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	q := r.uploads
+	r.uploads = nil
+	return q, append([]artifact(nil), r.Artifacts...)
+}
+
+// addFailure регистрирует ошибку таблицы или базы и возвращает все ошибки запуска
+func (r *runResult) addFailure(msg string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Failed = append(r.Failed, msg)
+	return append([]string(nil), r.Failed...)
+}
+
+// addGroup регистрирует итог группы таблиц
+func (r *runResult) addGroup(g groupResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Groups = append(r.Groups, g)
+}
+
+// finishRun сохраняет результат запуска в каталог и отправляет уведомления
+func finishRun(db *sql.DB, config *Config, kind string, startedAt time.Time, result *runResult, runErr error) {
+	history, err := lastRuns(db, kind, config.Postgres.Schema, durationHistory+1)
+	if err != nil {
+		log.Printf("Ошибка чтения каталога запусков: %v", err)
+	}
+
+	run := runRecordOf(config, kind, startedAt, result, runErr)
+	stampArtifacts(config, result.Artifacts)
+	if err := recordRun(db, run, result.Artifacts); err != nil {
+		log.Printf("Ошибка записи запуска в каталог: %v", err)
+	}
+	if err := markTablesDeleted(db, result.Dropped); err != nil {
+		log.Printf("Ошибка отметки удаленных бэкапов в каталоге: %v", err)
+	}
+	notifyRun(config.Notifications, run, history)
+}
+
+// runRecordOf составляет запись каталога о запуске, завершающемся сейчас
+func runRecordOf(config *Config, kind string, startedAt time.Time, result *runResult, runErr error) *runRecord {
+	run := &runRecord{
+		Kind:         kind,
+		StartedAt:    startedAt,
+		FinishedAt:   clock.Now(),
+		Status:       "ok",
+		TablesTotal:  result.Tables,
+		TablesFailed: len(result.Failed),
+		DeletedCount: result.Deleted,
+		DeletedBytes: result.DeletedSize,
+		Schema:       config.Postgres.Schema,
+		Label:        result.Label,
+	}
+	if runErr != nil {
+		run.Status = "failed"
+		run.Error = runErr.Error()
+	}
+	return run
+}
+
+// performBackup выполняет основную логику бэкапа.
+// db - основная база, backupDB - база, где хранятся таблицы бэкапа (та же или сервер бэкапов).
+// progress получает события о ходе бэкапа таблиц (может быть nil).
+func performBackup(db, backupDB *sql.DB, config *Config, realRun bool, progress progressFunc) (*runResult, error) {
+	cfg := &config.Backup
+	prefix := cfg.Prefix
+	result := &runResult{}
+	progress = progress.orNop()
+
+	// Способ создания копии: в той же базе или на сервере бэкапов через postgres_fdw
+	nowait := cfg.LockStrategy == "nowait"
+	var verify map[string]bool
+	var model *tableModel // метаданные исходных таблиц на момент составления списка
+	var copied copyResult // результат последнего копирования
+	copyTable := func(table, backup string) error {
+		var err error
+		opts := copyOptions{Nowait: nowait, Hash: verify[table], Columns: model.columns(table), Checks: tableValidations(cfg, table)}
+		copied, err = copyToBackup(db, backupDB, config, table, backup, opts)
+		return classifyDDLError(db, table, model.meta(table), err)
+	}
+	if config.Remote.remoteEnabled() && realRun {
+		if err := provisionFDW(db, &config.Remote); err != nil {
+			return result, fmt.Errorf("ошибка настройки postgres_fdw: %w", err)
+		}
+	}
+	if cfg.CopyFreeze {
+		if err := freezeCopyMode(db); err != nil {
+			return result, fmt.Errorf("быстрый путь backup.copy_freeze недоступен: %w", err)
+		}
+	}
+
 	// Удаление старых бэкапов
-	err := deleteOldBackups(db, prefix, retentionDays, realRun)
+	retained, err := retentionOverrides(db)
+	if err != nil {
+		return result, fmt.Errorf("ошибка чтения продлений хранения: %w", err)
+	}
+	deleted, err := deleteOldBackups(backupDB, cfg, retained, realRun)
+	if err != nil {
+		return result, fmt.Errorf("ошибка удаления старых бэкапов: %w", err)
+	}
+	for _, t := range deleted {
+		result.Deleted++
+		result.DeletedSize += t.Size
+		if realRun {
+			result.Dropped = append(result.Dropped, t.Name)
+		}
+	}
+
+	// Список таблиц для бэкапа и их метаданные читаются один раз на весь запуск
+	model, err = loadTableModel(db, cfg)
+	if err != nil {
+		return result, fmt.Errorf("ошибка получения списка таблиц: %w", err)
+	}
+	tables := model.backupTables(cfg)
+	result.Tables = len(tables)
+	sizes := model.sizes(tables)
+	oids := model.oids()
+
+	// История бэкапов переименованных таблиц переносится на новые имена
+	if cfg.TrackRenames {
+		if err := followRenames(db, backupDB, prefix, tables, oids, realRun); err != nil {
+			return result, fmt.Errorf("ошибка переноса бэкапов переименованных таблиц: %w", err)
+		}
+	}
+
+	// Проверка лимита на суммарный размер бэкапов
+	pruned, err := enforceBudget(db, backupDB, cfg, sizes, deleted, realRun)
+	result.Dropped = append(result.Dropped, pruned...)
 	if err != nil {
-		return fmt.Errorf("ошибка удаления старых бэкапов: %v", err)
+		return result, err
 	}
 
-	// Получение списка таблиц для бэкапа
-	tables, err := getTablesToBackup(db, prefix)
+	// Упорядочивание таблиц
+	tables, err = orderTables(cfg, tables, sizes)
+	if err != nil {
+		return result, err
+	}
+	// Несовместимости версий с сервером бэкапов выявляются до копирования
+	if config.Remote.remoteEnabled() {
+		if err := checkRemoteCompatibility(db, backupDB, &config.Remote, model, tables); err != nil {
+			return result, err
+		}
+	}
+	// Права на все таблицы проверяются до копирования, чтобы проблемы попали в лог одним списком;
+	// бэкапы создаются той же сессией, что читает таблицы, а в режиме remote - на сервере бэкапов
+	target := db
+	if config.Remote.remoteEnabled() {
+		target = backupDB
+	}
+	denied, err := probePermissions(db, target, tables)
 	if err != nil {
-		return fmt.Errorf("ошибка получения списка таблиц: %v", err)
+		return result, err
 	}
+	verify = verifySample(cfg, tables)
+	progress(progressEvent{Type: eventRunStarted, Tables: len(tables)})
 
 	// Создание бэкапов для каждой таблицы
-	currentDate := time.Now().Format("20060102")
-	for _, table := range tables {
+	currentDate := clock.Now().Format("20060102")
+	result.Date = currentDate
+	var deferred []string
+	abortOnErrors := func(failed []string) error {
+		if cfg.OnError == "fail_fast" || (cfg.OnError == "fail_after_n" && len(failed) >= cfg.MaxErrors) {
+			return fmt.Errorf("бэкап прерван после %d ошибок: %s", len(failed), strings.Join(failed, "; "))
+		}
+		return nil
+	}
+
+	// Группа таблиц копируется целиком в одной транзакции; отложенная группа повторяется по первой таблице
+	groups := tableGroups(cfg.Groups)
+	present := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		present[t] = true
+	}
+	backupGroup := func(g *TableGroupConfig, lastAttempt bool) error {
+		backups := make(map[string]string, len(g.Tables))
+		checks := make(map[string][]ValidationConfig, len(g.Tables))
+		var missing, forbidden []string
+		for _, t := range g.Tables {
+			backups[t] = fmt.Sprintf("%s_%s_%s", prefix, t, currentDate)
+			checks[t] = tableValidations(cfg, t)
+			if !present[t] {
+				missing = append(missing, t)
+			} else if denied[t] != nil {
+				forbidden = append(forbidden, t)
+			}
+			progress(progressEvent{Type: eventTableStarted, Table: t, Backup: backups[t]})
+		}
+		var copies map[string]copyResult
+		var err error
+		if len(missing) > 0 {
+			err = fmt.Errorf("таблицы %s не найдены или исключены из бэкапа", strings.Join(missing, ", "))
+		} else if len(forbidden) > 0 {
+			err = fmt.Errorf("%w: нет права SELECT на таблицы %s", dberrors.ErrPermission, strings.Join(forbidden, ", "))
+		} else if realRun {
+			err = createBackupWithRetry(cfg, "группы "+g.Name, "", func(string, string) error {
+				var err error
+				copies, err = copyGroup(db, g.Tables, backups, verify, checks, nowait)
+				return err
+			})
+		}
+		if err != nil && nowait && !lastAttempt && isLockNotAvailable(err) {
+			log.Printf("Таблица группы %s заблокирована, бэкап группы отложен", g.Name)
+			deferred = append(deferred, g.Tables[0])
+			for _, t := range g.Tables {
+				progress(progressEvent{Type: eventTableDeferred, Table: t, Backup: backups[t]})
+			}
+			return nil
+		}
+		if err != nil {
+			for _, t := range g.Tables {
+				if classified := classifyDDLError(db, t, model.meta(t), err); classified != err {
+					err = classified
+					break
+				}
+			}
+		}
+		result.addGroup(groupResult{Name: g.Name, Tables: g.Tables, Err: err})
+		if err != nil {
+			log.Printf("Ошибка создания бэкапа группы %s: %v", g.Name, err)
+			var failed []string
+			for _, t := range g.Tables {
+				progress(progressEvent{Type: eventTableFailed, Table: t, Backup: backups[t], Error: err.Error(), Kind: dberrors.Code(err)})
+				failed = result.addFailure(fmt.Sprintf("%s: группа %s: %v", t, g.Name, err))
+			}
+			return abortOnErrors(failed)
+		}
+		log.Printf("Создан бэкап группы %s: %s", g.Name, strings.Join(g.Tables, ", "))
+		var failed []string
+		for _, t := range g.Tables {
+			progress(progressEvent{Type: eventTableFinished, Table: t, Backup: backups[t], Rows: copies[t].Rows})
+			a := artifact{
+				Kind:      artifactKindTable,
+				Source:    t,
+				Path:      backups[t],
+				Size:      sizes[t],
+				CreatedAt: clock.Now(),
+				SourceOID: oids[t],
+			}
+			var invalid error
+			if realRun {
+				invalid = validateBackup(backupDB, t, backups[t], checks[t], copies[t].Checks)
+			}
+			if invalid != nil {
+				a.Invalid = invalid.Error()
+			}
+			result.addBackup(createdBackup{Source: t, Backup: backups[t]}, a)
+			if invalid != nil {
+				log.Printf("Ошибка проверки бэкапа таблицы %s: %v", t, invalid)
+				failed = result.addFailure(fmt.Sprintf("%s: %v", t, invalid))
+			}
+		}
+		if failed != nil {
+			return abortOnErrors(failed)
+		}
+		return nil
+	}
+
+	backupOne := func(table string, lastAttempt bool) error {
+		if g := groups[table]; g != nil {
+			return backupGroup(g, lastAttempt)
+		}
 		backupTableName := fmt.Sprintf("%s_%s_%s", prefix, table, currentDate)
-		if realRun {
-			err := createBackupTable(db, table, backupTableName)
+		progress(progressEvent{Type: eventTableStarted, Table: table, Backup: backupTableName})
+		copied = copyResult{}
+		// Таблица без права SELECT, найденная проверкой прав, не копируется и сразу записывается в ошибки
+		if err := denied[table]; err != nil || realRun {
+			if err == nil {
+				err = createBackupWithRetry(cfg, table, backupTableName, copyTable)
+			}
+			if err != nil && nowait && !lastAttempt && isLockNotAvailable(err) {
+				log.Printf("Таблица %s заблокирована, бэкап отложен", table)
+				deferred = append(deferred, table)
+				progress(progressEvent{Type: eventTableDeferred, Table: table, Backup: backupTableName})
+				return nil
+			}
+			if errors.Is(err, dberrors.ErrSchemaChanged) && cfg.DDLRetry {
+				log.Printf("Структура таблицы %s изменилась во время запуска, повтор с новыми метаданными: %v", table, err)
+				if reloadErr := model.reload(db, table); reloadErr != nil {
+					log.Printf("Ошибка чтения метаданных таблицы %s: %v", table, reloadErr)
+				}
+				oids[table] = model.meta(table).OID
+				err = copyTable(table, backupTableName)
+			}
 			if err != nil {
 				log.Printf("Ошибка создания бэкапа таблицы %s: %v", table, err)
-				continue
+				progress(progressEvent{Type: eventTableFailed, Table: table, Backup: backupTableName, Error: err.Error(), Kind: dberrors.Code(err)})
+				return abortOnErrors(result.addFailure(fmt.Sprintf("%s: %v", table, err)))
 			}
 		}
 		log.Printf("Создан бэкап таблицы %s как %s", table, backupTableName)
+		progress(progressEvent{Type: eventTableFinished, Table: table, Backup: backupTableName, Rows: copied.Rows})
+		a := artifact{
+			Kind:      artifactKindTable,
+			Source:    table,
+			Path:      backupTableName,
+			Size:      sizes[table],
+			CreatedAt: clock.Now(),
+			SourceOID: oids[table],
+		}
+		// Бэкап, не прошедший проверки, остается и отмечается в каталоге, а таблица попадает в ошибки запуска
+		var invalid error
+		if realRun {
+			invalid = validateBackup(backupDB, table, backupTableName, tableValidations(cfg, table), copied.Checks)
+		}
+		if invalid != nil {
+			a.Invalid = invalid.Error()
+		}
+		result.addBackup(createdBackup{Source: table, Backup: backupTableName}, a)
+		if invalid != nil {
+			log.Printf("Ошибка проверки бэкапа таблицы %s: %v", table, invalid)
+			return abortOnErrors(result.addFailure(fmt.Sprintf("%s: %v", table, invalid)))
+		}
+		return nil
+	}
+	handled := make(map[string]bool)
+	for _, table := range tables {
+		if g := groups[table]; g != nil {
+			if handled[g.Name] {
+				continue
+			}
+			handled[g.Name] = true
+		}
+		if err := backupOne(table, cfg.LockRetries == 0); err != nil {
+			return result, err
+		}
 	}
 
-	return nil
+	// Повтор отложенных из-за блокировок таблиц в конце запуска
+	for attempt := 1; len(deferred) > 0 && attempt <= cfg.LockRetries; attempt++ {
+		pending := deferred
+		deferred = nil
+		log.Printf("Повтор %d из %d для заблокированных таблиц (%d) через %s",
+			attempt, cfg.LockRetries, len(pending), time.Duration(cfg.LockRetryDelay))
+		clock.Sleep(time.Duration(cfg.LockRetryDelay))
+		for _, table := range pending {
+			if err := backupOne(table, attempt == cfg.LockRetries); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	// Итог по группам таблиц
+	for _, g := range result.Groups {
+		if g.Err != nil {
+			log.Printf("Группа %s: ошибка, бэкап не создан ни для одной из таблиц %s", g.Name, strings.Join(g.Tables, ", "))
+		} else {
+			log.Printf("Группа %s: согласованный бэкап %d таблиц", g.Name, len(g.Tables))
+		}
+	}
+
+	// Итоговый отчёт об ошибках при on_error = "continue"
+	if len(result.Failed) > 0 {
+		return result, fmt.Errorf("не удалось создать бэкап %d из %d таблиц: %s", len(result.Failed), len(tables), strings.Join(result.Failed, "; "))
+	}
+
+	return result, nil
 }
 
-// deleteOldBackups удаляет бэкапы старше указанного количества дней
-func deleteOldBackups(db *sql.DB, prefix string, retentionDays int, realRun bool) error {
-	thresholdDate := time.Now().AddDate(0, 0, -retentionDays)
+// deleteOldBackups удаляет бэкапы старше указанного количества дней, кроме продленных командой retain,
+// и возвращает удаленные (при тестовом запуске - подлежащие удалению) таблицы
+func deleteOldBackups(db *sql.DB, cfg *BackupConfig, retained map[string]time.Time, realRun bool) ([]backupTable, error) {
+	thresholdDate := clock.Now().AddDate(0, 0, -cfg.Retention)
 	threshold := thresholdDate.Format("20060102")
 
 	// Получение списка всех таблиц с префиксом бэкапа
 	rows, err := db.Query(`
-		SELECT table_name 
+		SELECT table_name, pg_total_relation_size(quote_ident(table_schema) || '.' || quote_ident(table_name))
 		FROM information_schema.tables 
-		WHERE table_schema = 'public' 
-		AND table_name LIKE $1 || '%'`, prefix)
+		WHERE table_schema = current_schema() 
+		AND table_name LIKE $1 || '%'`, cfg.Prefix)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer rows.Close()
 
-	var tablesToDelete []string
+	var tablesToDelete []backupTable
 	for rows.Next() {
-		var tableName string
-		if err := rows.Scan(&tableName); err != nil {
-			return err
+		var t backupTable
+		if err := rows.Scan(&t.Name, &t.Size); err != nil {
+			return nil, err
 		}
 
 		// Извлечение даты из имени таблицы (последние 8 символов)
-		if len(tableName) >= 8 {
-			datePart := tableName[len(tableName)-8:]
-			if datePart < threshold {
-				tablesToDelete = append(tablesToDelete, tableName)
+		if len(t.Name) >= 8 {
+			t.Date = t.Name[len(t.Name)-8:]
+			if t.Date < threshold {
+				if until, ok := retained[t.Name]; ok && isRetained(until) {
+					log.Printf("Бэкап %s сохранен до %s", t.Name, until.Format("2006-01-02"))
+					continue
+				}
+				tablesToDelete = append(tablesToDelete, t)
 			}
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	// Удаление старых таблиц
-	for _, table := range tablesToDelete {
-		if realRun {
-			_, err := db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", table))
-			if err != nil {
-				log.Printf("Ошибка удаления таблицы %s: %v", table, err)
-				continue
-			}
+	// Удаление старых таблиц пачками
+	deleted := tablesToDelete
+	if realRun {
+		deleted = dropBackupTables(db, cfg, tablesToDelete)
+	}
+	for _, t := range deleted {
+		log.Printf("Удалена старая таблица бэкапа: %s", t.Name)
+	}
+
+	return deleted, nil
+}
+
+// getTablesToBackup возвращает список таблиц, которые нужно бэкапировать, без исключенных шаблонами exclude
+func getTablesToBackup(db *sql.DB, cfg *BackupConfig) ([]string, error) {
+	all, err := sourceTables(db, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Чанки и внутренние таблицы TimescaleDB бэкапятся в составе своих гипертаблиц
+	internal, err := timescaleInternalTables(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []string
+	for _, tableName := range all {
+		if isExcluded(tableName, cfg.Excludes) || internal[tableName] {
+			continue
 		}
-		log.Printf("Удалена старая таблица бэкапа: %s", table)
+		tables = append(tables, tableName)
 	}
 
-	return nil
+	return tables, nil
 }
 
-// getTablesToBackup возвращает список таблиц, которые нужно бэкапировать
-func getTablesToBackup(db *sql.DB, prefix string) ([]string, error) {
+// sourceTables возвращает все таблицы текущей схемы, кроме таблиц бэкапа и каталога
+func sourceTables(db *sql.DB, cfg *BackupConfig) ([]string, error) {
 	rows, err := db.Query(`
 		SELECT table_name 
 		FROM information_schema.tables 
-		WHERE table_schema = 'public' 
-		AND table_name NOT LIKE $1 || '%'`, prefix)
+		WHERE table_schema = current_schema() 
+		AND table_name NOT LIKE $1 || '%'
+		AND table_name NOT LIKE $2 || '%'`, cfg.Prefix, catalogPrefix)
 	if err != nil {
 		return nil, err
 	}
@@ -203,12 +1115,80 @@ func getTablesToBackup(db *sql.DB, prefix string) ([]string, error) {
 		}
 		tables = append(tables, tableName)
 	}
+	return tables, rows.Err()
+}
 
-	return tables, nil
+// copyToBackup создает копию таблицы в той же базе или на сервере бэкапов через postgres_fdw
+// и при opts.Hash сверяет ее с исходной таблицей
+func copyToBackup(db, backupDB *sql.DB, config *Config, table, backup string, opts copyOptions) (copyResult, error) {
+	var copied copyResult
+	var err error
+	if len(backup) > maxIdentifierLength {
+		return copied, dberrors.Wrap(dberrors.OpCopy, table, fmt.Errorf("%w: %s", dberrors.ErrNameTooLong, backup))
+	}
+	switch {
+	case config.Remote.remoteEnabled():
+		copied, err = createRemoteBackup(db, backupDB, &config.Remote, table, backup, opts)
+	case config.Backup.CopyFreeze:
+		copied, err = createBackupTableFreeze(db, table, backup, opts)
+	case opts.Nowait || opts.snapshot():
+		copied, err = createBackupTableTx(db, table, backup, opts)
+	default:
+		copied.Rows, err = createBackupTable(db, table, backup)
+	}
+	if err != nil || !opts.Hash {
+		return copied, dberrors.Wrap(dberrors.OpCopy, table, err)
+	}
+	return copied, dberrors.Wrap(dberrors.OpVerify, table, verifyBackup(backupDB, table, backup, copied.Hash))
 }
 
-// createBackupTable создает копию таблицы
-func createBackupTable(db *sql.DB, originalTable, backupTable string) error {
-	_, err := db.Exec(fmt.Sprintf("CREATE TABLE %s AS SELECT * FROM %s", backupTable, originalTable))
+// createBackupWithRetry создает копию таблицы, повторяя попытку при ошибке с экспоненциальной паузой;
+// ошибки, которые повтор не исправит (см. isRetryable), возвращаются сразу. Занятая блокировка
+// (lock_timeout при lock_strategy "wait") повторяется отдельно: lock_retries раз через lock_retry_delay
+func createBackupWithRetry(cfg *BackupConfig, originalTable, backupTable string, copyTable func(table, backup string) error) error {
+	delay := time.Duration(cfg.RetryDelay)
+	nowait := cfg.LockStrategy == "nowait"
+	attempt, lockAttempt := 1, 1
+	err := copyTable(originalTable, backupTable)
+	for err != nil && isRetryable(err, nowait) {
+		if isLockNotAvailable(err) {
+			if lockAttempt > cfg.LockRetries {
+				break
+			}
+			log.Printf("Таблица %s заблокирована: %v, повтор %d из %d через %s",
+				originalTable, err, lockAttempt, cfg.LockRetries, time.Duration(cfg.LockRetryDelay))
+			clock.Sleep(time.Duration(cfg.LockRetryDelay))
+			lockAttempt++
+		} else {
+			if attempt > cfg.Retries {
+				break
+			}
+			log.Printf("Ошибка создания бэкапа таблицы %s: %v, повтор %d из %d через %s",
+				originalTable, err, attempt, cfg.Retries, delay)
+			clock.Sleep(delay)
+			delay *= 2
+			attempt++
+		}
+		err = copyTable(originalTable, backupTable)
+	}
 	return err
 }
+
+// createBackupTable создает копию таблицы и возвращает количество скопированных строк
+func createBackupTable(db *sql.DB, originalTable, backupTable string) (int64, error) {
+	res, err := db.Exec(fmt.Sprintf("CREATE TABLE %s AS SELECT * FROM %s", backupTable, originalTable))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// createBackupTableTx создает копию таблицы в отдельной транзакции с указанными опциями
+func createBackupTableTx(db *sql.DB, originalTable, backupTable string, opts copyOptions) (copyResult, error) {
+	return execCopy(db, originalTable, fmt.Sprintf("CREATE TABLE %s AS SELECT * FROM %s", backupTable, originalTable), opts)
+}
+
+// isLockNotAvailable проверяет, что запрос не выполнен из-за занятой блокировки (SQLSTATE 55P03)
+func isLockNotAvailable(err error) bool {
+	return dberrors.Kind(err) == dberrors.ErrLockTimeout
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestCreateBackupWithRetry(t *testing.T) {
+	lockErr := &pq.Error{Code: "55P03", Message: "canceling statement due to lock timeout"}
+
+	// copyTable, которая завершается ошибками errs по очереди, затем успешно
+	failing := func(errs ...error) (func(table, backup string) error, *int) {
+		calls := 0
+		return func(table, backup string) error {
+			calls++
+			if calls <= len(errs) {
+				return errs[calls-1]
+			}
+			return nil
+		}, &calls
+	}
+	cfg := BackupConfig{Retries: 1, RetryDelay: Duration(time.Second), LockRetries: 3, LockRetryDelay: Duration(30 * time.Second)}
+
+	t.Run("wait retries lock timeouts", func(t *testing.T) {
+		c := setFakeClock(t, time.Now())
+		cfg := cfg
+		cfg.LockStrategy = "wait"
+		copyTable, calls := failing(lockErr, lockErr, lockErr)
+		if err := createBackupWithRetry(&cfg, "orders", "backup_orders_20240131", copyTable); err != nil {
+			t.Fatal(err)
+		}
+		// блокировки не расходуют retries и ждут lock_retry_delay без удвоения
+		if *calls != 4 || c.slept != 90*time.Second {
+			t.Errorf("попыток %d, ожидание %s", *calls, c.slept)
+		}
+	})
+
+	t.Run("wait gives up after lock_retries", func(t *testing.T) {
+		setFakeClock(t, time.Now())
+		cfg := cfg
+		cfg.LockStrategy = "wait"
+		copyTable, calls := failing(lockErr, lockErr, lockErr, lockErr, lockErr)
+		if err := createBackupWithRetry(&cfg, "orders", "backup_orders_20240131", copyTable); !isLockNotAvailable(err) {
+			t.Fatalf("ошибка %v, ожидалась занятая блокировка", err)
+		}
+		if *calls != 4 {
+			t.Errorf("попыток %d, ожидалось 4", *calls)
+		}
+	})
+
+	t.Run("nowait defers lock timeouts", func(t *testing.T) {
+		c := setFakeClock(t, time.Now())
+		cfg := cfg
+		cfg.LockStrategy = "nowait"
+		copyTable, calls := failing(lockErr)
+		if err := createBackupWithRetry(&cfg, "orders", "backup_orders_20240131", copyTable); !isLockNotAvailable(err) {
+			t.Fatalf("ошибка %v, ожидалась занятая блокировка", err)
+		}
+		if *calls != 1 || c.slept != 0 {
+			t.Errorf("попыток %d, ожидание %s; таблица должна откладываться без повтора", *calls, c.slept)
+		}
+	})
+
+	t.Run("other errors use retries", func(t *testing.T) {
+		c := setFakeClock(t, time.Now())
+		cfg := cfg
+		cfg.LockStrategy = "wait"
+		broken := errors.New("connection reset")
+		copyTable, calls := failing(lockErr, broken, broken)
+		if err := createBackupWithRetry(&cfg, "orders", "backup_orders_20240131", copyTable); err != broken {
+			t.Fatalf("ошибка %v", err)
+		}
+		if *calls != 3 || c.slept != 31*time.Second {
+			t.Errorf("попыток %d, ожидание %s", *calls, c.slept)
+		}
+	})
+
+	t.Run("permission is not retried", func(t *testing.T) {
+		setFakeClock(t, time.Now())
+		copyTable, calls := failing(&pq.Error{Code: "42501"})
+		if err := createBackupWithRetry(&cfg, "orders", "backup_orders_20240131", copyTable); err == nil || *calls != 1 {
+			t.Errorf("ошибка %v после %d попыток", err, *calls)
+		}
+	})
+}
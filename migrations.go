@@ -0,0 +1,215 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Ключ advisory lock, под которым применяются миграции каталога ("dbkr")
+const catalogLockKey = 0x64626b72
+
+// catalogMigrations версии схемы каталога: миграция с индексом i переводит каталог в версию i+1.
+// Миграции только добавляются в конец; первые написаны идемпотентно, чтобы каталоги, созданные
+// до появления версий, прошли их без ошибок.
+var catalogMigrations = []string{
+	// 1: запуски и файлы
+	`CREATE TABLE IF NOT EXISTS ` + catalogRunsTable + ` (
+		id            bigserial PRIMARY KEY,
+		started_at    timestamptz NOT NULL,
+		finished_at   timestamptz NOT NULL,
+		status        text NOT NULL,
+		tables_total  integer NOT NULL DEFAULT 0,
+		tables_failed integer NOT NULL DEFAULT 0,
+		error         text NOT NULL DEFAULT ''
+	);
+	CREATE TABLE IF NOT EXISTS ` + catalogArtifactsTable + ` (
+		id         bigserial PRIMARY KEY,
+		run_id     bigint REFERENCES ` + catalogRunsTable + ` (id),
+		kind       text NOT NULL,
+		source     text NOT NULL,
+		path       text NOT NULL,
+		size_bytes bigint NOT NULL DEFAULT 0,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		deleted_at timestamptz
+	)`,
+	// 2: виды запусков (дампы, восстановление, CDC, кластер)
+	`ALTER TABLE ` + catalogRunsTable + ` ADD COLUMN IF NOT EXISTS kind text NOT NULL DEFAULT '` + runKindTables + `'`,
+	// 3: копии в удаленных хранилищах
+	`ALTER TABLE ` + catalogArtifactsTable + ` ADD COLUMN IF NOT EXISTS destination text NOT NULL DEFAULT ''`,
+	// 4: продления хранения (retain)
+	`CREATE TABLE IF NOT EXISTS ` + catalogRetentionTable + ` (
+		backup     text PRIMARY KEY,
+		until      date NOT NULL,
+		reason     text NOT NULL DEFAULT '',
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`,
+	// 5: статус репликации копий
+	`CREATE TABLE IF NOT EXISTS ` + catalogReplicaTable + ` (
+		artifact_id bigint NOT NULL REFERENCES ` + catalogArtifactsTable + ` (id),
+		destination text NOT NULL,
+		status      text NOT NULL,
+		attempts    integer NOT NULL DEFAULT 0,
+		error       text NOT NULL DEFAULT '',
+		updated_at  timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (artifact_id, destination)
+	)`,
+	// 6: объем удаления по политике хранения
+	`ALTER TABLE ` + catalogRunsTable + ` ADD COLUMN IF NOT EXISTS deleted_count integer NOT NULL DEFAULT 0;
+	ALTER TABLE ` + catalogRunsTable + ` ADD COLUMN IF NOT EXISTS deleted_bytes bigint NOT NULL DEFAULT 0`,
+	// 7: oid исходных таблиц для отслеживания переименований
+	`ALTER TABLE ` + catalogArtifactsTable + ` ADD COLUMN IF NOT EXISTS source_oid bigint`,
+	// 8: схемы арендаторов
+	`ALTER TABLE ` + catalogRunsTable + ` ADD COLUMN IF NOT EXISTS schema_name text NOT NULL DEFAULT ''`,
+	// 9: метки внеплановых снимков
+	`ALTER TABLE ` + catalogRunsTable + ` ADD COLUMN label text NOT NULL DEFAULT ''`,
+	// 10: юридические удержания и их журнал
+	`CREATE TABLE ` + catalogHoldsTable + ` (
+		id          bigserial PRIMARY KEY,
+		name        text NOT NULL UNIQUE,
+		reason      text NOT NULL DEFAULT '',
+		criteria    text NOT NULL DEFAULT '',
+		created_at  timestamptz NOT NULL DEFAULT now(),
+		created_by  text NOT NULL DEFAULT '',
+		released_at timestamptz,
+		released_by text NOT NULL DEFAULT ''
+	);
+	CREATE TABLE ` + catalogHoldItemsTable + ` (
+		hold_id     bigint NOT NULL REFERENCES ` + catalogHoldsTable + ` (id),
+		artifact_id bigint NOT NULL REFERENCES ` + catalogArtifactsTable + ` (id),
+		PRIMARY KEY (hold_id, artifact_id)
+	);
+	CREATE TABLE ` + catalogHoldAuditTable + ` (
+		id        bigserial PRIMARY KEY,
+		hold_name text NOT NULL,
+		action    text NOT NULL,
+		actor     text NOT NULL,
+		at        timestamptz NOT NULL DEFAULT now(),
+		details   text NOT NULL DEFAULT ''
+	)`,
+	// 11: манифесты артефактов с версией формата
+	`ALTER TABLE ` + catalogArtifactsTable + ` ADD COLUMN manifest jsonb`,
+	// 12: журнал удаления строк из бэкапов (dbacker forget)
+	`CREATE TABLE ` + catalogForgetTable + ` (
+		id         bigserial PRIMARY KEY,
+		request    text NOT NULL DEFAULT '',
+		table_name text NOT NULL,
+		predicate  text NOT NULL,
+		backups    integer NOT NULL,
+		rows       bigint NOT NULL,
+		skipped    text NOT NULL DEFAULT '',
+		actor      text NOT NULL,
+		at         timestamptz NOT NULL DEFAULT now()
+	)`,
+	// 13: журнал контрольных сумм бэкапов и его заверения службой меток времени; записи только добавляются
+	`CREATE TABLE ` + catalogLedgerTable + ` (
+		seq         bigint PRIMARY KEY,
+		recorded_at timestamptz NOT NULL,
+		event       text NOT NULL,
+		backup      text NOT NULL,
+		source      text NOT NULL,
+		checksum    text NOT NULL,
+		prev_hash   text NOT NULL,
+		entry_hash  text NOT NULL
+	);
+	CREATE TABLE ` + catalogAnchorsTable + ` (
+		seq         bigint NOT NULL REFERENCES ` + catalogLedgerTable + ` (seq),
+		entry_hash  text NOT NULL,
+		service     text NOT NULL,
+		token       bytea NOT NULL,
+		anchored_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (seq, service)
+	);
+	CREATE FUNCTION ` + catalogLedgerGuard + `() RETURNS trigger LANGUAGE plpgsql AS $$
+	BEGIN
+		RAISE EXCEPTION 'журнал контрольных сумм dbacker только дополняется: % запрещен', TG_OP;
+	END
+	$$;
+	CREATE TRIGGER dbacker_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON ` + catalogLedgerTable + `
+		FOR EACH STATEMENT EXECUTE PROCEDURE ` + catalogLedgerGuard + `();
+	CREATE TRIGGER dbacker_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON ` + catalogAnchorsTable + `
+		FOR EACH STATEMENT EXECUTE PROCEDURE ` + catalogLedgerGuard + `()`,
+	// 14: страховочные копии таблиц с коротким сроком хранения (dbacker guard)
+	`CREATE TABLE ` + catalogGuardsTable + ` (
+		backup     text PRIMARY KEY,
+		source     text NOT NULL,
+		expires_at timestamptz NOT NULL,
+		actor      text NOT NULL,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		dropped_at timestamptz
+	)`,
+	// 15: результат проверок бэкапов запросами backup.validations
+	`ALTER TABLE ` + catalogArtifactsTable + ` ADD COLUMN validation_error text NOT NULL DEFAULT ''`,
+}
+
+// migratedCatalogs базы, каталог которых уже проверен и обновлен этим процессом
+var (
+	migratedMu       sync.Mutex
+	migratedCatalogs = map[*sql.DB]bool{}
+)
+
+// ensureCatalog создает каталог или обновляет его схему до текущей версии. Миграции применяются в одной
+// транзакции под advisory lock, поэтому одновременно запущенные экземпляры dbacker не мешают друг другу.
+// Если каталог обновлен более новой версией dbacker, возвращается ошибка.
+func ensureCatalog(db *sql.DB) error {
+	migratedMu.Lock()
+	defer migratedMu.Unlock()
+	if migratedCatalogs[db] {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, catalogLockKey); err != nil {
+		return fmt.Errorf("ошибка блокировки каталога: %w", err)
+	}
+	_, err = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS ` + catalogVersionTable + ` (
+			version    integer PRIMARY KEY,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	if err := tx.QueryRow(`SELECT coalesce(max(version), 0) FROM ` + catalogVersionTable).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(catalogMigrations) {
+		return fmt.Errorf("схема каталога версии %d создана более новой версией dbacker, эта версия поддерживает до %d",
+			version, len(catalogMigrations))
+	}
+
+	for v := version + 1; v <= len(catalogMigrations); v++ {
+		if _, err := tx.Exec(catalogMigrations[v-1]); err != nil {
+			return fmt.Errorf("ошибка миграции каталога до версии %d: %w", v, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO `+catalogVersionTable+` (version) VALUES ($1)`, v); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if version < len(catalogMigrations) && version > 0 {
+		log.Printf("Схема каталога обновлена с версии %d до %d", version, len(catalogMigrations))
+	}
+
+	migratedCatalogs[db] = true
+	return nil
+}
+
+// migrateCatalog при запуске обновляет схему уже созданного каталога; новый каталог создается при первой записи
+func migrateCatalog(db *sql.DB) error {
+	exists, err := catalogExists(db)
+	if err != nil || !exists {
+		return err
+	}
+	return ensureCatalog(db)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// NotifierConfig настройки одного получателя уведомлений
+type NotifierConfig struct {
+	Type           string   `json:"type"`            // "slack" или "webhook"
+	URL            string   `json:"url"`             // Адрес incoming webhook
+	Policy         []string `json:"policy"`          // "always", "on_failure", "on_recovery", "on_long_duration", "on_slo" (по умолчанию on_failure)
+	DurationFactor float64  `json:"duration_factor"` // Во сколько раз запуск должен быть дольше среднего для on_long_duration (по умолчанию 3)
+}
+
+// Количество предыдущих успешных запусков, по которым считается средняя длительность
+const durationHistory = 10
+
+// notifyRun отправляет уведомления о завершенном запуске согласно политикам получателей.
+// history - предыдущие запуски из каталога, от новых к старым, без текущего.
+func notifyRun(notifiers []NotifierConfig, run *runRecord, history []runRecord) {
+	for _, n := range notifiers {
+		reasons := notifyReasons(&n, run, history)
+		if len(reasons) == 0 {
+			continue
+		}
+		if err := sendNotification(&n, run, reasons); err != nil {
+			log.Printf("Ошибка отправки уведомления (%s): %v", n.Type, err)
+		}
+	}
+}
+
+// notifyReasons возвращает сработавшие политики получателя для запуска
+func notifyReasons(n *NotifierConfig, run *runRecord, history []runRecord) []string {
+	var reasons []string
+	for _, p := range n.Policy {
+		switch p {
+		case "always":
+			reasons = append(reasons, p)
+		case "on_failure":
+			if run.Status != "ok" {
+				reasons = append(reasons, p)
+			}
+		case "on_recovery":
+			if run.Status == "ok" && len(history) > 0 && history[0].Status != "ok" {
+				reasons = append(reasons, p)
+			}
+		case "on_long_duration":
+			avg, ok := averageDuration(history)
+			if ok && float64(run.Duration()) > float64(avg)*n.DurationFactor {
+				reasons = append(reasons, p)
+			}
+		}
+	}
+	return reasons
+}
+
+// averageDuration считает среднюю длительность последних успешных запусков
+func averageDuration(history []runRecord) (time.Duration, bool) {
+	var total time.Duration
+	var count int
+	for _, r := range history {
+		if r.Status != "ok" {
+			continue
+		}
+		total += r.Duration()
+		count++
+		if count == durationHistory {
+			break
+		}
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return total / time.Duration(count), true
+}
+
+// runSummary формирует текст уведомления о запуске
+func runSummary(run *runRecord, reasons []string) string {
+	kind := run.Kind
+	if run.Schema != "" {
+		kind += ", " + run.Schema
+	}
+	text := fmt.Sprintf("dbacker (%s): бэкап завершен со статусом %s за %s, объектов %d, ошибок %d",
+		kind, run.Status, run.Duration().Round(time.Second), run.TablesTotal, run.TablesFailed)
+	if run.Error != "" {
+		text += "\n" + run.Error
+	}
+	return text + fmt.Sprintf("\n(политики: %v)", reasons)
+}
+
+// sendNotification отправляет уведомление получателю
+func sendNotification(n *NotifierConfig, run *runRecord, reasons []string) error {
+	var payload interface{}
+	switch n.Type {
+	case "slack":
+		payload = map[string]string{"text": runSummary(run, reasons)}
+	default:
+		payload = map[string]interface{}{
+			"kind":          run.Kind,
+			"schema":        run.Schema,
+			"status":        run.Status,
+			"started_at":    run.StartedAt,
+			"finished_at":   run.FinishedAt,
+			"duration_sec":  run.Duration().Seconds(),
+			"tables_total":  run.TablesTotal,
+			"tables_failed": run.TablesFailed,
+			"error":         run.Error,
+			"reasons":       reasons,
+		}
+	}
+
+	return postJSON(n.URL, payload)
+}
+
+// postJSON отправляет payload в формате JSON на адрес webhook
+func postJSON(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("сервер вернул %s", resp.Status)
+	}
+	return nil
+}
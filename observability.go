@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands["observability"] = command{
+		Usage: "Generate a Grafana dashboard and Prometheus alert rules for dbacker metrics (observability export)",
+		Run:   cmdObservability,
+	}
+}
+
+// observedDB база, для которой строятся панели и правила: имя (метка db метрик) и ее цели по свежести
+type observedDB struct {
+	Name string
+	SLOs []SLOConfig
+}
+
+// alertThresholds пороги правил оповещений
+type alertThresholds struct {
+	Stale          time.Duration // нет успешного бэкапа таблиц дольше
+	Failures       int           // неудачных запусков подряд
+	DurationFactor float64       // последний запуск дольше среднего во столько раз
+}
+
+// cmdObservability выгружает дашборд Grafana и правила оповещений Prometheus по метрикам /metrics dbacker serve
+func cmdObservability(args []string) error {
+	fs := flag.NewFlagSet("observability", flag.ExitOnError)
+	dashboard := fs.String("dashboard", "dbacker-dashboard.json", "Grafana dashboard output file (- for stdout, empty to skip)")
+	rules := fs.String("rules", "dbacker-alerts.yml", "Prometheus alerting rules output file (- for stdout, empty to skip)")
+	stale := fs.Duration("stale", 26*time.Hour, "Alert when a database has no successful table backup for longer than this")
+	failures := fs.Int("failures", 2, "Alert after this many failed runs in a row")
+	factor := fs.Float64("duration-factor", 3, "Alert when the last run took this many times longer than the average")
+	positional := parseArgs(fs, args)
+
+	if len(positional) != 1 || positional[0] != "export" {
+		return fmt.Errorf("использование: dbacker observability export [-dashboard file] [-rules file] [-stale 26h] [-failures 2] [-duration-factor 3]")
+	}
+	if *stale <= 0 || *failures < 1 || *factor <= 1 {
+		return fmt.Errorf("пороги должны быть положительными, -failures не меньше 1, -duration-factor больше 1")
+	}
+
+	config, err := loadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	dbs, err := observedDatabases(config)
+	if err != nil {
+		return err
+	}
+	thresholds := alertThresholds{Stale: *stale, Failures: *failures, DurationFactor: *factor}
+
+	if *dashboard != "" {
+		data, err := json.MarshalIndent(grafanaDashboard(dbs), "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeObservability(*dashboard, append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	if *rules != "" {
+		if err := writeObservability(*rules, []byte(prometheusRules(dbs, thresholds))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// observedDatabases собирает базы из конфигурации: основную и базы целей dbacker targets
+// (у каждой цели свой config.ini в ее каталоге)
+func observedDatabases(config *Config) ([]observedDB, error) {
+	dbs := []observedDB{{Name: config.Postgres.DBName, SLOs: config.SLOs}}
+	seen := map[string]bool{config.Postgres.DBName: true}
+	for _, t := range config.Targets {
+		target, err := loadConfig(filepath.Join(t.Dir, configFile))
+		if err != nil {
+			return nil, fmt.Errorf("цель %s: %w", t.Name, err)
+		}
+		if seen[target.Postgres.DBName] {
+			continue
+		}
+		seen[target.Postgres.DBName] = true
+		dbs = append(dbs, observedDB{Name: target.Postgres.DBName, SLOs: target.SLOs})
+	}
+	return dbs, nil
+}
+
+// writeObservability записывает сгенерированный файл или выводит его в stdout при path "-"
+func writeObservability(path string, data []byte) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+	log.Printf("Записан файл %s", path)
+	return nil
+}
+
+// grafanaDashboard строит дашборд для импорта в Grafana: переменные источника данных и базы,
+// панели свежести, серий неудач, длительности запусков и целей по свежести таблиц
+func grafanaDashboard(dbs []observedDB) map[string]any {
+	var names []string
+	var tables []string
+	seenTables := make(map[string]bool)
+	for _, db := range dbs {
+		names = append(names, db.Name)
+		for _, s := range db.SLOs {
+			if !seenTables[s.Table] {
+				seenTables[s.Table] = true
+				tables = append(tables, regexp.QuoteMeta(s.Table))
+			}
+		}
+	}
+
+	var options []map[string]any
+	for _, name := range names {
+		options = append(options, map[string]any{"text": name, "value": name, "selected": true})
+	}
+	datasource := map[string]any{"type": "prometheus", "uid": "${datasource}"}
+
+	var panels []map[string]any
+	y := 0
+	addPanel := func(title, kind, unit string, width int, targets ...map[string]any) {
+		x := 0
+		if n := len(panels); n > 0 {
+			prev := panels[n-1]["gridPos"].(map[string]int)
+			if prev["x"]+prev["w"]+width <= 24 {
+				x = prev["x"] + prev["w"]
+			} else {
+				y += 8
+			}
+		}
+		panels = append(panels, map[string]any{
+			"id":          len(panels) + 1,
+			"type":        kind,
+			"title":       title,
+			"datasource":  datasource,
+			"gridPos":     map[string]int{"x": x, "y": y, "w": width, "h": 8},
+			"fieldConfig": map[string]any{"defaults": map[string]any{"unit": unit}, "overrides": []any{}},
+			"targets":     targets,
+		})
+	}
+	target := func(ref, expr, legend string) map[string]any {
+		return map[string]any{"refId": ref, "datasource": datasource, "expr": expr, "legendFormat": legend}
+	}
+
+	addPanel("Time since last successful run", "stat", "s", 12,
+		target("A", `time() - dbacker_last_success_timestamp_seconds{db=~"$db"}`, "{{db}} {{kind}} {{schema}}"))
+	addPanel("Failed runs in a row", "stat", "none", 12,
+		target("A", `dbacker_run_failure_streak{db=~"$db"}`, "{{db}} {{kind}} {{schema}}"))
+	addPanel("Run duration", "timeseries", "s", 24,
+		target("A", `dbacker_last_run_duration_seconds{db=~"$db"}`, "{{db}} {{kind}} {{schema}} last"),
+		target("B", `dbacker_run_duration_average_seconds{db=~"$db"}`, "{{db}} {{kind}} {{schema}} average"))
+	if len(tables) > 0 {
+		table := strings.Join(tables, "|")
+		addPanel("Backup age by table", "timeseries", "s", 16,
+			target("A", fmt.Sprintf(`dbacker_slo_backup_age_seconds{db=~"$db",table=~%q}`, table), "{{db}} {{schema}} {{table}}"),
+			target("B", fmt.Sprintf(`dbacker_slo_max_age_seconds{db=~"$db",table=~%q}`, table), "{{db}} {{schema}} {{table}} max"))
+		addPanel("Breached SLOs", "stat", "none", 8,
+			target("A", `sum(dbacker_slo_breached{db=~"$db"}) or vector(0)`, "breached"))
+	}
+
+	return map[string]any{
+		"title":         "dbacker",
+		"uid":           "dbacker",
+		"tags":          []string{"dbacker", "backup"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"refresh":       "1m",
+		"time":          map[string]string{"from": "now-7d", "to": "now"},
+		"templating": map[string]any{"list": []map[string]any{
+			{"name": "datasource", "label": "Data source", "type": "datasource", "query": "prometheus"},
+			{
+				"name":       "db",
+				"label":      "Database",
+				"type":       "custom",
+				"query":      strings.Join(names, ","),
+				"multi":      true,
+				"includeAll": true,
+				"current":    map[string]any{"text": names, "value": names},
+				"options":    options,
+			},
+		}},
+		"panels": panels,
+	}
+}
+
+// prometheusRules строит правила оповещений Prometheus: группа на базу с правилами устаревшего бэкапа,
+// серии неудачных запусков, роста длительности и нарушения целей по свежести.
+// Цели под угрозой (warn_at) не дублируются: о них сообщает dbacker check -notify.
+func prometheusRules(dbs []observedDB, t alertThresholds) string {
+	var b strings.Builder
+	b.WriteString("# Generated by dbacker observability export\ngroups:\n")
+	rule := func(name, expr, severity, summary string) {
+		fmt.Fprintf(&b, "      - alert: %s\n        expr: '%s'\n", name, strings.ReplaceAll(expr, "'", "''"))
+		fmt.Fprintf(&b, "        labels:\n          severity: %s\n", severity)
+		fmt.Fprintf(&b, "        annotations:\n          summary: %q\n", summary)
+	}
+	for _, db := range dbs {
+		sel := fmt.Sprintf("db=%q", db.Name)
+		fmt.Fprintf(&b, "  - name: dbacker-%s\n    rules:\n", db.Name)
+		rule("DbackerBackupStale",
+			fmt.Sprintf(`(time() - dbacker_last_success_timestamp_seconds{%s,kind="tables"}) > %d`, sel, int64(t.Stale.Seconds())),
+			"critical",
+			fmt.Sprintf("No successful table backup of %s{{ if $labels.schema }} (schema {{ $labels.schema }}){{ end }} for more than %s", db.Name, t.Stale))
+		rule("DbackerBackupNeverSucceeded",
+			fmt.Sprintf(`dbacker_last_run_timestamp_seconds{%s,kind="tables"} unless dbacker_last_success_timestamp_seconds{%s,kind="tables"}`, sel, sel),
+			"critical",
+			fmt.Sprintf("Table backups of %s have never succeeded", db.Name))
+		rule("DbackerFailureStreak",
+			fmt.Sprintf(`dbacker_run_failure_streak{%s} >= %d`, sel, t.Failures),
+			"warning",
+			fmt.Sprintf("{{ $value }} failed {{ $labels.kind }} runs in a row on %s", db.Name))
+		rule("DbackerDurationRegression",
+			fmt.Sprintf(`dbacker_last_run_duration_seconds{%s} > %g * dbacker_run_duration_average_seconds{%s}`, sel, t.DurationFactor, sel),
+			"warning",
+			fmt.Sprintf("Last {{ $labels.kind }} run on %s took more than %g times the average duration", db.Name, t.DurationFactor))
+		if len(db.SLOs) > 0 {
+			rule("DbackerSLOBreached",
+				fmt.Sprintf(`dbacker_slo_breached{%s} == 1`, sel),
+				"critical",
+				fmt.Sprintf("Backup of %s.{{ $labels.table }} is older than its max_age", db.Name))
+		}
+	}
+	return b.String()
+}
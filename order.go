@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// orderTables упорядочивает список таблиц согласно настройке order; sizes - размеры таблиц из модели запуска
+func orderTables(cfg *BackupConfig, tables []string, sizes map[string]int64) ([]string, error) {
+	ordered := append([]string(nil), tables...)
+
+	switch cfg.Order {
+	case "":
+		// порядок, в котором таблицы вернул information_schema
+	case "name":
+		sort.Strings(ordered)
+	case "size_desc", "size_asc":
+		sort.SliceStable(ordered, func(i, j int) bool {
+			a, b := sizes[ordered[i]], sizes[ordered[j]]
+			if a == b {
+				return ordered[i] < ordered[j]
+			}
+			if cfg.Order == "size_desc" {
+				return a > b
+			}
+			return a < b
+		})
+	case "priority":
+		// Сначала таблицы из списка priority в указанном порядке, затем остальные по алфавиту
+		rank := make(map[string]int, len(cfg.Priority))
+		for i, t := range cfg.Priority {
+			if _, ok := rank[t]; !ok {
+				rank[t] = i
+			}
+		}
+		sort.SliceStable(ordered, func(i, j int) bool {
+			ri, iok := rank[ordered[i]]
+			rj, jok := rank[ordered[j]]
+			switch {
+			case iok && jok:
+				return ri < rj
+			case iok != jok:
+				return iok
+			default:
+				return ordered[i] < ordered[j]
+			}
+		})
+	default:
+		return nil, fmt.Errorf("неизвестное значение order: %s", cfg.Order)
+	}
+
+	return ordered, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOrderTables(t *testing.T) {
+	tables := []string{"orders", "users", "audit_log", "items", "events"}
+	sizes := map[string]int64{"orders": 300, "users": 100, "audit_log": 900, "items": 100, "events": 0}
+
+	check := func(t *testing.T, cfg *BackupConfig, want []string) {
+		t.Helper()
+		got, err := orderTables(cfg, tables, sizes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("порядок %v, ожидался %v", got, want)
+		}
+	}
+
+	t.Run("default keeps input order", func(t *testing.T) {
+		check(t, &BackupConfig{}, tables)
+	})
+	t.Run("name", func(t *testing.T) {
+		check(t, &BackupConfig{Order: "name"}, []string{"audit_log", "events", "items", "orders", "users"})
+	})
+	t.Run("size", func(t *testing.T) {
+		// при равных размерах таблицы идут по имени в обоих направлениях
+		check(t, &BackupConfig{Order: "size_desc"}, []string{"audit_log", "orders", "items", "users", "events"})
+		check(t, &BackupConfig{Order: "size_asc"}, []string{"events", "items", "users", "orders", "audit_log"})
+	})
+	t.Run("priority", func(t *testing.T) {
+		// повтор в priority не меняет ранг, таблицы не из запуска пропускаются, остальные идут по имени
+		cfg := &BackupConfig{Order: "priority", Priority: []string{"users", "missing", "orders", "users"}}
+		check(t, cfg, []string{"users", "orders", "audit_log", "events", "items"})
+	})
+	t.Run("unknown", func(t *testing.T) {
+		if _, err := orderTables(&BackupConfig{Order: "random"}, tables, sizes); err == nil {
+			t.Error("неизвестный порядок принят")
+		}
+	})
+
+	if tables[0] != "orders" || tables[4] != "events" {
+		t.Errorf("orderTables изменил исходный список: %v", tables)
+	}
+}
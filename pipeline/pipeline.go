@@ -0,0 +1,96 @@
+// Package pipeline этапы конвейера обработки файлов выгрузки dbacker. Данные проходят путь: exporter →
+// этапы export.pipeline по порядку → запись в файл (или части по export.chunk_size) → загрузка в удаленные
+// хранилища (destinations).
+//
+// Свой этап (проверка антивирусом, шифрование ключом из KMS и т.п.) добавляется без изменения выгрузок:
+// реализация интерфейса Stage и вызов Register в init() отдельного файла, после чего этап указывается
+// в export.pipeline по имени.
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// StageConfig настройки этапа конвейера (элемент export.pipeline)
+type StageConfig struct {
+	Type    string   `json:"type"`    // Вид этапа: "gzip", "exec" или зарегистрированный через Register
+	Level   int      `json:"level"`   // Уровень сжатия gzip 1-9 (по умолчанию стандартный)
+	Command []string `json:"command"` // Для exec: программа с аргументами, которая читает stdin и пишет stdout, например ["age", "-r", "age1..."]
+	Ext     string   `json:"ext"`     // Суффикс имени файла после этапа, например ".age" (для gzip по умолчанию ".gz")
+}
+
+// Stage этап конвейера выгрузки
+type Stage interface {
+	// Wrap возвращает writer, который обрабатывает поток и пишет результат в next.
+	// Close возвращенного writer дописывает остаток данных и закрывает next.
+	Wrap(next io.WriteCloser) (io.WriteCloser, error)
+	// Ext возвращает суффикс, который этап добавляет к имени файла (или пустую строку)
+	Ext() string
+}
+
+// Factory создает этап по настройкам, проверяя их
+type Factory func(cfg *StageConfig) (Stage, error)
+
+// factories конструкторы этапов по виду
+var factories = map[string]Factory{}
+
+func init() {
+	Register("gzip", newGzipStage)
+	Register("exec", newExecStage)
+}
+
+// Register регистрирует конструктор этапа вида name. Вызывается из init(); повторная регистрация
+// того же вида - ошибка программы, поэтому вызывает panic
+func Register(name string, factory Factory) {
+	if factory == nil {
+		panic("pipeline: пустой конструктор этапа " + name)
+	}
+	if _, ok := factories[name]; ok {
+		panic("pipeline: этап " + name + " зарегистрирован дважды")
+	}
+	factories[name] = factory
+}
+
+// Types возвращает отсортированные виды зарегистрированных этапов
+func Types() []string {
+	types := make([]string, 0, len(factories))
+	for name := range factories {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// New создает этап по настройкам
+func New(cfg *StageConfig) (Stage, error) {
+	factory, ok := factories[cfg.Type]
+	if !ok {
+		return nil, fmt.Errorf("неизвестный вид этапа: %s", cfg.Type)
+	}
+	return factory(cfg)
+}
+
+// Ext возвращает суффиксы всех этапов по порядку
+func Ext(stages []Stage) string {
+	var ext string
+	for _, s := range stages {
+		ext += s.Ext()
+	}
+	return ext
+}
+
+// Chain оборачивает w этапами так, что данные проходят их по порядку и попадают в w.
+// Close возвращенного writer завершает все этапы и закрывает w; при ошибке w закрывается сразу
+func Chain(w io.WriteCloser, stages []Stage) (io.WriteCloser, error) {
+	for i := len(stages) - 1; i >= 0; i-- {
+		next, err := stages[i].Wrap(w)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w = next
+	}
+	return w, nil
+}
@@ -0,0 +1,125 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+// bufferCloser буфер, запоминающий закрытие
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+// upperStage этап для проверки Register: переводит латиницу в верхний регистр
+type upperStage struct{}
+
+func (upperStage) Ext() string { return ".upper" }
+
+func (upperStage) Wrap(next io.WriteCloser) (io.WriteCloser, error) {
+	return &chainWriter{Writer: upperWriter{next}, closers: []io.Closer{next}}, nil
+}
+
+type upperWriter struct{ w io.Writer }
+
+func (u upperWriter) Write(p []byte) (int, error) {
+	return u.w.Write(bytes.ToUpper(p))
+}
+
+func init() {
+	Register("upper", func(cfg *StageConfig) (Stage, error) { return upperStage{}, nil })
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		cfg     StageConfig
+		wantExt string
+		wantErr bool
+	}{
+		{StageConfig{Type: "gzip"}, ".gz", false},
+		{StageConfig{Type: "gzip", Level: 9, Ext: ".gzip"}, ".gzip", false},
+		{StageConfig{Type: "gzip", Level: 10}, "", true},
+		{StageConfig{Type: "exec", Command: []string{"cat"}, Ext: ".cat"}, ".cat", false},
+		{StageConfig{Type: "exec"}, "", true},
+		{StageConfig{Type: "upper"}, ".upper", false},
+		{StageConfig{Type: "zstd"}, "", true},
+	}
+	for _, tt := range tests {
+		stage, err := New(&tt.cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("New(%+v): ошибка %v", tt.cfg, err)
+			continue
+		}
+		if err == nil && stage.Ext() != tt.wantExt {
+			t.Errorf("New(%+v).Ext() = %q, ожидалось %q", tt.cfg, stage.Ext(), tt.wantExt)
+		}
+	}
+}
+
+func TestTypes(t *testing.T) {
+	if got, want := Types(), []string{"exec", "gzip", "upper"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Types() = %v, ожидалось %v", got, want)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("повторная регистрация gzip не вызвала panic")
+		}
+	}()
+	Register("gzip", newGzipStage)
+}
+
+func TestChain(t *testing.T) {
+	cfgs := []StageConfig{{Type: "upper"}, {Type: "gzip"}}
+	if _, err := exec.LookPath("cat"); err == nil {
+		cfgs = append(cfgs, StageConfig{Type: "exec", Command: []string{"cat"}})
+	}
+	var stages []Stage
+	for i := range cfgs {
+		stage, err := New(&cfgs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		stages = append(stages, stage)
+	}
+	if got := Ext(stages); got != ".upper.gz" {
+		t.Errorf("Ext() = %q", got)
+	}
+
+	out := &bufferCloser{}
+	w, err := Chain(out, stages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "orders 2024"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !out.closed {
+		t.Error("Close цепочки не закрыл writer назначения")
+	}
+	gz, err := gzip.NewReader(&out.Buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "ORDERS 2024" {
+		t.Errorf("после этапов получено %q", data)
+	}
+}
@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// chainWriter writer этапа, при закрытии которого закрываются writer этапа и следующий
+type chainWriter struct {
+	io.Writer
+	closers []io.Closer
+}
+
+// Close закрывает writer этапа и следующий, возвращая первую ошибку
+func (c *chainWriter) Close() error {
+	var first error
+	for _, cl := range c.closers {
+		if err := cl.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// gzipStage сжатие gzip
+type gzipStage struct {
+	level int
+	ext   string
+}
+
+// newGzipStage проверяет уровень сжатия
+func newGzipStage(cfg *StageConfig) (Stage, error) {
+	level := cfg.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return nil, fmt.Errorf("некорректный уровень сжатия: %d", cfg.Level)
+	}
+	ext := cfg.Ext
+	if ext == "" {
+		ext = ".gz"
+	}
+	return &gzipStage{level: level, ext: ext}, nil
+}
+
+func (s *gzipStage) Ext() string { return s.ext }
+
+// Wrap сжимает поток
+func (s *gzipStage) Wrap(next io.WriteCloser) (io.WriteCloser, error) {
+	gz, err := gzip.NewWriterLevel(next, s.level)
+	if err != nil {
+		return nil, err
+	}
+	return &chainWriter{Writer: gz, closers: []io.Closer{gz, next}}, nil
+}
+
+// execStage обработка потока внешней программой (age, gpg, zstd, клиент KMS...): поток подается на stdin,
+// stdout передается следующему этапу
+type execStage struct {
+	command []string
+	ext     string
+}
+
+// newExecStage проверяет, что команда задана
+func newExecStage(cfg *StageConfig) (Stage, error) {
+	if len(cfg.Command) == 0 {
+		return nil, fmt.Errorf("не задана command")
+	}
+	return &execStage{command: cfg.Command, ext: cfg.Ext}, nil
+}
+
+func (s *execStage) Ext() string { return s.ext }
+
+// Wrap запускает программу этапа
+func (s *execStage) Wrap(next io.WriteCloser) (io.WriteCloser, error) {
+	cmd := exec.Command(s.command[0], s.command[1:]...)
+	cmd.Stdout = next
+	w := &execWriter{cmd: cmd, next: next}
+	cmd.Stderr = &w.stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	w.stdin = stdin
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// execWriter поток на stdin программы этапа
+type execWriter struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	next   io.WriteCloser
+	stderr bytes.Buffer
+}
+
+func (w *execWriter) Write(p []byte) (int, error) {
+	return w.stdin.Write(p)
+}
+
+// Close закрывает stdin, дожидается завершения программы и закрывает следующий этап
+func (w *execWriter) Close() error {
+	w.stdin.Close()
+	err := w.cmd.Wait()
+	if err != nil {
+		err = fmt.Errorf("%s: %v: %s", filepath.Base(w.cmd.Path), err, strings.TrimSpace(w.stderr.String()))
+	}
+	if closeErr := w.next.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
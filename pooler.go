@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+)
+
+// poolerTransaction режим пулера соединений в режиме транзакций (pgBouncer pool_mode = transaction):
+// соседние транзакции одного соединения dbacker могут выполняться в разных сессиях сервера
+const poolerTransaction = "transaction"
+
+// validatePooler проверяет режим пулера и адрес прямого подключения
+func validatePooler(cfg *PostgresConfig, section string) error {
+	switch cfg.Pooler {
+	case "":
+	case poolerTransaction:
+		if cfg.DirectHost != "" && cfg.DirectPort == 0 {
+			cfg.DirectPort = cfg.Port
+		}
+		// Параметры сессии передаются при подключении, а пулер их не принимает и не сохраняет между транзакциями
+		if len(cfg.Settings) > 0 && cfg.DirectHost == "" {
+			return fmt.Errorf("%s.settings в режиме pooler = \"transaction\" требуют прямого подключения %s.direct_host", section, section)
+		}
+	default:
+		return fmt.Errorf("неизвестное значение %s.pooler: %s", section, cfg.Pooler)
+	}
+	return nil
+}
+
+// sessionPostgres возвращает параметры подключения для операции, которой нужна отдельная сессия сервера:
+// параметры сессии и search_path, вход новой роли, утилиты pg_dump, pg_restore и pg_basebackup.
+// Без пулера это обычное подключение, с пулером в режиме транзакций - прямое подключение direct_host.
+func sessionPostgres(cfg *PostgresConfig, operation string) (*PostgresConfig, error) {
+	if cfg.Pooler != poolerTransaction {
+		return cfg, nil
+	}
+	if cfg.DirectHost == "" {
+		return nil, fmt.Errorf("%s не работает через пулер в режиме транзакций: задайте direct_host для прямого подключения", operation)
+	}
+	direct := *cfg
+	direct.Host, direct.Port = cfg.DirectHost, cfg.DirectPort
+	direct.Pooler = ""
+	return &direct, nil
+}
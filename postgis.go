@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// geometryEncoding представление колонок PostGIS в файлах выгрузки
+type geometryEncoding struct {
+	Output   string // функция PostGIS, которой колонка читается при выгрузке
+	Input    string // функция PostGIS, которой значение разбирается при восстановлении
+	Binary   bool   // значение двоичное (bytea), иначе текст
+	WithSRID bool   // SRID записан в каждом значении (EWKB/EWKT), Input его не принимает
+}
+
+// geometryEncodings поддерживаемые значения export.geometry
+var geometryEncodings = map[string]geometryEncoding{
+	"wkb":  {Output: "ST_AsBinary", Input: "ST_GeomFromWKB", Binary: true},
+	"wkt":  {Output: "ST_AsText", Input: "ST_GeomFromText"},
+	"ewkb": {Output: "ST_AsEWKB", Input: "ST_GeomFromEWKB", Binary: true, WithSRID: true},
+	"ewkt": {Output: "ST_AsEWKT", Input: "ST_GeomFromEWKT", WithSRID: true},
+}
+
+// spatialColumn колонка PostGIS в выгрузке: записывается в манифест артефакта, чтобы восстановить
+// исходный тип колонки из WKB/WKT
+type spatialColumn struct {
+	Table        string `json:"table"`
+	Column       string `json:"column"`
+	Type         string `json:"type"`                    // geometry или geography
+	GeometryType string `json:"geometry_type,omitempty"` // ограничение типа, например Point (пусто - любой)
+	SRID         int    `json:"srid"`                    // SRID колонки (0 - не ограничен)
+	Encoding     string `json:"encoding"`                // значение export.geometry на момент выгрузки
+}
+
+func init() {
+	commands["restore-spatial"] = command{
+		Usage: "Convert WKB/WKT columns of a file export loaded into PostgreSQL back to PostGIS types (test run unless -run)",
+		Run:   cmdRestoreSpatial,
+	}
+}
+
+// postgisTypeRe разбирает тип колонки PostGIS из format_type: "geometry(Point,4326)", "geography(MultiPolygonZ)"
+var postgisTypeRe = regexp.MustCompile(`^(?:[\w.]+\.)?(geometry|geography)(?:\((\w+)(?:,\s*(\d+))?\))?$`)
+
+// spatialType возвращает описание колонки PostGIS или nil для остальных колонок.
+// Колонка geography без явного SRID имеет SRID 4326.
+func spatialType(c *column) *spatialColumn {
+	if c.BaseType != "geometry" && c.BaseType != "geography" {
+		return nil
+	}
+	s := &spatialColumn{Column: c.Name, Type: c.BaseType}
+	if m := postgisTypeRe.FindStringSubmatch(c.Type); m != nil {
+		s.GeometryType = m[2]
+		if strings.EqualFold(s.GeometryType, "geometry") {
+			s.GeometryType = ""
+		}
+		s.SRID, _ = strconv.Atoi(m[3])
+	}
+	if s.Type == "geography" && s.SRID == 0 {
+		s.SRID = 4326
+	}
+	return s
+}
+
+// exportColumns возвращает колонки таблицы для выгрузки в файлы: колонки PostGIS читаются
+// в представлении export.geometry, а не во внутреннем формате, который не понимают другие системы
+func exportColumns(db *sql.DB, cfg *ExportConfig, table string) ([]column, error) {
+	columns, err := tableColumns(db, table)
+	if err != nil {
+		return nil, err
+	}
+	for i := range columns {
+		if spatialType(&columns[i]) != nil {
+			columns[i].Geometry = cfg.Geometry
+		}
+	}
+	return columns, nil
+}
+
+// spatialManifest возвращает описания колонок PostGIS выгруженной таблицы для манифеста
+func spatialManifest(table string, columns []column) []spatialColumn {
+	var spatial []spatialColumn
+	for i := range columns {
+		if columns[i].Geometry == "" {
+			continue
+		}
+		s := spatialType(&columns[i])
+		s.Table, s.Encoding = table, columns[i].Geometry
+		spatial = append(spatial, *s)
+	}
+	return spatial
+}
+
+// withSpatial возвращает манифест с колонками PostGIS или nil, если их нет
+func withSpatial(spatial []spatialColumn) *artifactManifest {
+	if len(spatial) == 0 {
+		return nil
+	}
+	return &artifactManifest{Spatial: spatial}
+}
+
+// spatialRestoreSQL возвращает ALTER TABLE, который переводит колонку, загруженную из файла выгрузки
+// (bytea или text для WKB, text для WKT), обратно в исходный тип PostGIS с SRID из манифеста
+func spatialRestoreSQL(s spatialColumn, loadedType string) string {
+	enc := geometryEncodings[s.Encoding]
+	value := quoteIdent(s.Column)
+	if enc.Binary && loadedType != "bytea" {
+		// CSV и текстовые форматы хранят WKB в шестнадцатеричном виде
+		value = fmt.Sprintf("decode(%s, 'hex')", value)
+	}
+	if loadedType != "text" && !enc.Binary {
+		value += "::text"
+	}
+	expr := fmt.Sprintf("%s(%s)", enc.Input, value)
+	if !enc.WithSRID && s.SRID != 0 {
+		expr = fmt.Sprintf("%s(%s, %d)", enc.Input, value, s.SRID)
+	}
+	target := s.Type
+	if s.GeometryType != "" || s.SRID != 0 {
+		geometryType := s.GeometryType
+		if geometryType == "" {
+			geometryType = "Geometry"
+		}
+		target = fmt.Sprintf("%s(%s,%d)", s.Type, geometryType, s.SRID)
+	}
+	return fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s",
+		quoteIdent(s.Table), quoteIdent(s.Column), target, expr, target)
+}
+
+// cmdRestoreSpatial восстанавливает типы PostGIS в таблицах, загруженных из файла выгрузки dbacker
+// (avro, arrow, sqlite, duckdb, warehouse): колонки берутся из манифеста артефакта в каталоге
+func cmdRestoreSpatial(args []string) error {
+	fs := flag.NewFlagSet("restore-spatial", flag.ExitOnError)
+	run := fs.Bool("run", false, "Normal run instead of test run?")
+	target := fs.String("db", "", "Database the export was loaded into (default postgres.dbname)")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		return fmt.Errorf("использование: dbacker restore-spatial <export file> [-db target] [-run]")
+	}
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	manifest, err := artifactManifestByPath(db, positional[0])
+	if err != nil {
+		return fmt.Errorf("ошибка чтения манифеста: %w", err)
+	}
+	if manifest == nil {
+		return fmt.Errorf("выгрузка %s не найдена в каталоге или записана до появления манифестов", positional[0])
+	}
+	if len(manifest.Spatial) == 0 {
+		log.Printf("В выгрузке %s нет колонок PostGIS", positional[0])
+		return nil
+	}
+
+	loaded := db
+	if *target != "" && *target != config.Postgres.DBName {
+		cfg := config.Postgres
+		cfg.DBName = *target
+		if loaded, err = connectToPostgres(&cfg); err != nil {
+			return err
+		}
+		defer loaded.Close()
+	}
+
+	tx, err := loaded.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, s := range manifest.Spatial {
+		var loadedType string
+		err := tx.QueryRow(`
+			SELECT data_type FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2`,
+			s.Table, s.Column).Scan(&loadedType)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("колонка %s.%s не найдена: загрузите выгрузку в базу до восстановления типов", s.Table, s.Column)
+		}
+		if err != nil {
+			return err
+		}
+		if loadedType == "USER-DEFINED" {
+			log.Printf("Колонка %s.%s уже имеет тип PostGIS", s.Table, s.Column)
+			continue
+		}
+		stmt := spatialRestoreSQL(s, loadedType)
+		if !*run {
+			log.Printf("Тестовый запуск, будет выполнено: %s", stmt)
+			continue
+		}
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("%s.%s: %w", s.Table, s.Column, err)
+		}
+		log.Printf("Колонка %s.%s восстановлена как %s (SRID %d)", s.Table, s.Column, s.Type, s.SRID)
+	}
+	return tx.Commit()
+}
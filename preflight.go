@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"dbacker/dberrors"
+	"github.com/lib/pq"
+)
+
+// probePermissions проверяет права до копирования: CREATE на схему, в которой создаются бэкапы (backupDB),
+// и SELECT на все таблицы запуска (db) - одним запросом. Все таблицы без права SELECT выводятся в лог
+// одним списком и возвращаются с ошибками вида dberrors.ErrPermission; без CREATE на схему запуск невозможен
+// и возвращается ошибка.
+func probePermissions(db, backupDB *sql.DB, tables []string) (map[string]error, error) {
+	var schema string
+	var canCreate bool
+	if err := backupDB.QueryRow(`SELECT current_schema(), has_schema_privilege(current_schema(), 'CREATE')`).Scan(&schema, &canCreate); err != nil {
+		return nil, fmt.Errorf("ошибка проверки прав на схему: %w", err)
+	}
+	if !canCreate {
+		return nil, fmt.Errorf("%w: нет права CREATE на схему %s, в которой создаются бэкапы", dberrors.ErrPermission, schema)
+	}
+
+	// Таблицы, удаленные после составления списка, пропускаются: их обработает копирование
+	rows, err := db.Query(`
+		SELECT t FROM unnest($1::text[]) t
+		WHERE to_regclass(quote_ident(current_schema()) || '.' || quote_ident(t)) IS NOT NULL
+		AND NOT has_table_privilege(to_regclass(quote_ident(current_schema()) || '.' || quote_ident(t)), 'SELECT')`,
+		pq.Array(tables))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка проверки прав на таблицы: %w", err)
+	}
+	defer rows.Close()
+
+	denied := make(map[string]error)
+	var names []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		denied[table] = &dberrors.TableError{Op: dberrors.OpCopy, Table: table, Kind: dberrors.ErrPermission, Err: fmt.Errorf("нет права SELECT на таблицу %s", table)}
+		names = append(names, table)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		log.Printf("Проверка прав перед копированием: нет права SELECT на %d из %d таблиц, их бэкап не будет создан: %s",
+			len(names), len(tables), strings.Join(names, ", "))
+	}
+	return denied, nil
+}
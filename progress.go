@@ -0,0 +1,46 @@
+package main
+
+import "time"
+
+// Типы событий о ходе бэкапа
+const (
+	eventRunStarted    = "run_started"    // начато копирование таблиц
+	eventTableStarted  = "table_started"  // начато копирование таблицы
+	eventTableFinished = "table_finished" // таблица скопирована
+	eventTableFailed   = "table_failed"   // копирование таблицы не удалось
+	eventTableDeferred = "table_deferred" // таблица заблокирована и отложена до конца запуска
+	eventRunFinished   = "run_finished"   // запуск завершен (вместе с выгрузками и загрузкой в хранилища)
+
+	eventSchemaStarted  = "schema_started"  // начат бэкап схемы арендатора (backup.schemas)
+	eventSchemaFinished = "schema_finished" // бэкап схемы арендатора завершен
+)
+
+// progressEvent событие о ходе бэкапа
+type progressEvent struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Table  string    `json:"table,omitempty"`
+	Backup string    `json:"backup,omitempty"`
+	Rows   int64     `json:"rows,omitempty"`   // скопировано строк (table_finished)
+	Tables int       `json:"tables,omitempty"` // всего таблиц (run_started, run_finished)
+	Failed int       `json:"failed,omitempty"` // таблиц с ошибкой (run_finished)
+	Error  string    `json:"error,omitempty"`
+	Kind   string    `json:"kind,omitempty"`   // вид ошибки (table_failed): permission, lock_timeout, disk_full, name_too_long, backup_exists
+	Schema string    `json:"schema,omitempty"` // схема арендатора (backup.schemas)
+}
+
+// progressFunc получатель событий о ходе бэкапа
+type progressFunc func(e progressEvent)
+
+// orNop возвращает получатель, проставляющий время события; для nil - получатель, который ничего не делает
+func (f progressFunc) orNop() progressFunc {
+	if f == nil {
+		return func(progressEvent) {}
+	}
+	return func(e progressEvent) {
+		if e.Time.IsZero() {
+			e.Time = clock.Now()
+		}
+		f(e)
+	}
+}
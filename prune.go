@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	commands["prune"] = command{
+		Usage: "Apply retention now, or preview what expires in the next N days",
+		Run:   cmdPrune,
+	}
+}
+
+// expiringBackup бэкап, который будет удален политикой хранения
+type expiringBackup struct {
+	Name     string
+	Kind     string // таблица или файл
+	Size     int64
+	DeleteOn time.Time // дата первого запуска, который удалит бэкап
+}
+
+// cmdPrune удаляет устаревшие бэкапы (таблицы и файлы) или, с флагом -preview, показывает,
+// какие бэкапы будут удалены в ближайшие дни при текущей политике хранения
+func cmdPrune(args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	run := fs.Bool("run", false, "Normal run instead of test run?")
+	preview := fs.String("preview", "", "List backups deleted over the next period (e.g. 7d) instead of pruning")
+	fs.Parse(args)
+
+	var days int
+	if *preview != "" {
+		var err error
+		if days, err = parseDays(*preview); err != nil {
+			return err
+		}
+	}
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	prefix := config.Backup.Prefix
+
+	backupDB, err := openBackupDatabase(config, db)
+	if err != nil {
+		return err
+	}
+	if backupDB != db {
+		defer backupDB.Close()
+	}
+
+	retained, err := retentionOverrides(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения продлений хранения: %w", err)
+	}
+
+	if *preview == "" {
+		deleted, err := deleteOldBackups(backupDB, &config.Backup, retained, *run)
+		if err != nil {
+			return fmt.Errorf("ошибка удаления старых бэкапов: %w", err)
+		}
+		if *run {
+			names := make([]string, len(deleted))
+			for i, t := range deleted {
+				names[i] = t.Name
+			}
+			if err := markTablesDeleted(db, names); err != nil {
+				return fmt.Errorf("ошибка отметки удаленных бэкапов в каталоге: %w", err)
+			}
+		}
+		for _, dir := range retentionDirs(config) {
+			if err := deleteOldFiles(db, dir, prefix, config.Backup.Retention, *run); err != nil {
+				return fmt.Errorf("ошибка удаления старых файлов в %s: %w", dir, err)
+			}
+		}
+		if err := cleanupLocalFiles(db, config, clock.Now().Format("20060102"), *run); err != nil {
+			return fmt.Errorf("ошибка очистки локальных файлов: %w", err)
+		}
+		if config.Backup.DaySchemas {
+			return syncDaySchemas(backupDB, &config.Backup, *run)
+		}
+		return nil
+	}
+
+	now := clock.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	until := today.AddDate(0, 0, days)
+	var list []expiringBackup
+	add := func(name, kind, date string, size int64) {
+		d, err := time.ParseInLocation("20060102", date, time.Local)
+		if err != nil {
+			return
+		}
+		deleteOn := d.AddDate(0, 0, config.Backup.Retention+1)
+		if until, ok := retained[name]; ok {
+			// продленный бэкап удаляется на следующий день после окончания продления
+			until = time.Date(until.Year(), until.Month(), until.Day()+1, 0, 0, 0, 0, time.Local)
+			if until.After(deleteOn) {
+				deleteOn = until
+			}
+		}
+		if deleteOn.After(until) {
+			return
+		}
+		list = append(list, expiringBackup{Name: name, Kind: kind, Size: size, DeleteOn: deleteOn})
+	}
+
+	tables, err := getBackupTables(backupDB, prefix)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка бэкапов: %w", err)
+	}
+	for _, t := range tables {
+		add(t.Name, "таблица", t.Date, t.Size)
+	}
+	for _, dir := range retentionDirs(config) {
+		files, err := listBackupFiles(dir, prefix)
+		if err != nil {
+			return fmt.Errorf("ошибка чтения каталога %s: %w", dir, err)
+		}
+		for _, f := range files {
+			size, _ := pathSize(f.Path)
+			add(f.Path, "файл", f.Date, size)
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].DeleteOn.Equal(list[j].DeleteOn) {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].DeleteOn.Before(list[j].DeleteOn)
+	})
+
+	if len(list) == 0 {
+		fmt.Printf("В ближайшие %d дн. бэкапы удаляться не будут (хранение %d дн.)\n", days, config.Backup.Retention)
+		return nil
+	}
+
+	var total int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Удаление\tБэкап\tТип\tРазмер")
+	for _, b := range list {
+		when := b.DeleteOn.Format("2006-01-02")
+		if !b.DeleteOn.After(today) {
+			when = "следующий запуск"
+		}
+		total += b.Size
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", when, b.Name, b.Kind, ByteSize(b.Size))
+	}
+	fmt.Fprintf(w, "Итого\t%d\t\t%s\n", len(list), ByteSize(total))
+	return w.Flush()
+}
+
+// retentionDirs возвращает каталоги файловых бэкапов, к которым применяется политика хранения
+func retentionDirs(config *Config) []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, dir := range []string{config.Dump.Dir, config.Cluster.Dir, config.CDC.Dir, config.Report.Dir} {
+		if dir != "" && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// parseDays разбирает количество дней из строки вида "7d", "2w" или "7"
+func parseDays(str string) (int, error) {
+	num := strings.ToLower(strings.TrimSpace(str))
+	mult := 1
+	switch {
+	case strings.HasSuffix(num, "d"):
+		num = strings.TrimSuffix(num, "d")
+	case strings.HasSuffix(num, "w"):
+		num, mult = strings.TrimSuffix(num, "w"), 7
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("некорректный период %q, ожидается число дней вида \"7d\"", str)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// RemoteConfig сервер для бэкапов: снимки таблиц создаются на нем через postgres_fdw, не занимая диск основной базы
+type RemoteConfig struct {
+	PostgresConfig        // Подключение dbacker к серверу бэкапов (пустой host отключает режим)
+	ServerName     string `json:"server_name"` // Имя foreign server на основной базе (по умолчанию "dbacker_backup")
+	FDWHost        string `json:"fdw_host"`    // Адрес сервера бэкапов, как его видит основной сервер (по умолчанию host)
+	FDWPort        int    `json:"fdw_port"`    // Порт сервера бэкапов для основного сервера (по умолчанию port)
+	BatchSize      int    `json:"batch_size"`  // Опция batch_size postgres_fdw для вставки (PostgreSQL 14+)
+}
+
+// Схема на основной базе для временных foreign tables
+const fdwStagingSchema = "dbacker_fdw"
+
+// remoteEnabled возвращает true, если снимки создаются на отдельном сервере
+func (r *RemoteConfig) remoteEnabled() bool {
+	return r.Host != ""
+}
+
+// openBackupDatabase возвращает соединение с базой, где хранятся таблицы бэкапа:
+// сервер бэкапов в режиме postgres_fdw или основную базу
+func openBackupDatabase(config *Config, db *sql.DB) (*sql.DB, error) {
+	if !config.Remote.remoteEnabled() {
+		return db, nil
+	}
+	remote, err := connectToPostgres(&config.Remote.PostgresConfig)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подключения к серверу бэкапов: %w", err)
+	}
+	return remote, nil
+}
+
+// backupPostgres возвращает параметры подключения к базе, где хранятся таблицы бэкапа
+func backupPostgres(config *Config) *PostgresConfig {
+	if config.Remote.remoteEnabled() {
+		return &config.Remote.PostgresConfig
+	}
+	return &config.Postgres
+}
+
+// provisionFDW создает на основной базе расширение postgres_fdw, foreign server и user mapping к серверу бэкапов
+func provisionFDW(db *sql.DB, cfg *RemoteConfig) error {
+	options := []string{
+		"host " + pq.QuoteLiteral(cfg.FDWHost),
+		fmt.Sprintf("port '%d'", cfg.FDWPort),
+		"dbname " + pq.QuoteLiteral(cfg.DBName),
+	}
+	if cfg.BatchSize > 0 {
+		options = append(options, fmt.Sprintf("batch_size '%d'", cfg.BatchSize))
+	}
+	server := quoteIdent(cfg.ServerName)
+
+	statements := []string{
+		"CREATE EXTENSION IF NOT EXISTS postgres_fdw",
+		fmt.Sprintf("CREATE SERVER IF NOT EXISTS %s FOREIGN DATA WRAPPER postgres_fdw OPTIONS (%s)",
+			server, strings.Join(options, ", ")),
+		fmt.Sprintf("CREATE USER MAPPING IF NOT EXISTS FOR CURRENT_USER SERVER %s OPTIONS (user %s, password %s)",
+			server, pq.QuoteLiteral(cfg.User), pq.QuoteLiteral(cfg.Password)),
+		"CREATE SCHEMA IF NOT EXISTS " + fdwStagingSchema,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("%s: %w", strings.SplitN(stmt, " (", 2)[0], err)
+		}
+	}
+	return nil
+}
+
+// createRemoteBackup создает снимок таблицы на сервере бэкапов: пустая таблица с теми же колонками создается там напрямую
+// (типы, которых нет на сервере бэкапов, заменяются на text), а данные переносит основной сервер через временную foreign table
+func createRemoteBackup(db, remote *sql.DB, cfg *RemoteConfig, originalTable, backupTable string, opts copyOptions) (copyResult, error) {
+	var result copyResult
+	columns, err := opts.columns(db, originalTable)
+	if err != nil {
+		return result, err
+	}
+	defs := make([]string, len(columns))
+	for i, c := range columns {
+		typ, _, err := remoteColumnType(remote, c)
+		if err != nil {
+			return result, fmt.Errorf("ошибка проверки типа %s на сервере бэкапов: %w", c.Type, err)
+		}
+		defs[i] = quoteIdent(c.Name) + " " + typ
+	}
+
+	_, err = remote.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", quoteIdent(backupTable), strings.Join(defs, ", ")))
+	if err != nil {
+		return result, fmt.Errorf("ошибка создания таблицы на сервере бэкапов: %w", err)
+	}
+
+	foreign := fdwStagingSchema + "." + quoteIdent(backupTable)
+	_, err = db.Exec(fmt.Sprintf("IMPORT FOREIGN SCHEMA public LIMIT TO (%s) FROM SERVER %s INTO %s",
+		quoteIdent(backupTable), quoteIdent(cfg.ServerName), fdwStagingSchema))
+	if err == nil {
+		insert := fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", foreign, quoteIdent(originalTable))
+		if opts.Nowait || opts.snapshot() {
+			result, err = execCopy(db, quoteIdent(originalTable), insert, opts)
+		} else {
+			var res sql.Result
+			if res, err = db.Exec(insert); err == nil {
+				result.Rows, _ = res.RowsAffected()
+			}
+		}
+	}
+
+	// Временная foreign table не нужна в любом случае, а недописанный снимок удаляется
+	if _, dropErr := db.Exec("DROP FOREIGN TABLE IF EXISTS " + foreign); dropErr != nil {
+		log.Printf("Ошибка удаления foreign table %s: %v", foreign, dropErr)
+	}
+	if err != nil {
+		remote.Exec("DROP TABLE IF EXISTS " + quoteIdent(backupTable))
+		return result, err
+	}
+	return result, nil
+}
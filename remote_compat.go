@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// remoteTypes кеш типов, проверенных на сервере бэкапов: есть ли тип с таким именем
+var (
+	remoteTypesMu sync.Mutex
+	remoteTypes   = map[*sql.DB]map[string]bool{}
+)
+
+// serverVersion возвращает версию сервера в формате server_version_num (например 160002)
+func serverVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow(`SELECT current_setting('server_version_num')::int`).Scan(&version)
+	return version, err
+}
+
+// majorVersion возвращает основную версию PostgreSQL по server_version_num: "16" или "9.6"
+func majorVersion(version int) string {
+	if version >= 100000 {
+		return fmt.Sprint(version / 10000)
+	}
+	return fmt.Sprintf("%d.%d", version/10000, version/100%100)
+}
+
+// remoteColumnType возвращает тип колонки для таблицы бэкапа на сервере бэкапов. Типа может не быть
+// на сервере бэкапов (пользовательские enum и домены, типы расширений, типы новых версий PostgreSQL),
+// тогда колонка создается как text: postgres_fdw передает значения в текстовом виде, поэтому они
+// сохраняются без потерь. Второе значение - true, если тип заменен.
+func remoteColumnType(remote *sql.DB, c column) (string, bool, error) {
+	remoteTypesMu.Lock()
+	defer remoteTypesMu.Unlock()
+
+	known := remoteTypes[remote]
+	if known == nil {
+		known = make(map[string]bool)
+		remoteTypes[remote] = known
+	}
+	exists, ok := known[c.Type]
+	if !ok {
+		if err := remote.QueryRow(`SELECT to_regtype($1) IS NOT NULL`, c.Type).Scan(&exists); err != nil {
+			return "", false, err
+		}
+		known[c.Type] = exists
+	}
+	if !exists {
+		return "text", true, nil
+	}
+	return c.Type, false, nil
+}
+
+// checkRemoteCompatibility сравнивает версии основного сервера и сервера бэкапов и выводит в план
+// запуска несовместимости: типы колонок, которые будут сохранены как text, и настройки, которые
+// не поддерживаются версией сервера. Ошибка возвращается до копирования, а не посреди запуска.
+func checkRemoteCompatibility(db, remote *sql.DB, cfg *RemoteConfig, model *tableModel, tables []string) error {
+	mainVersion, err := serverVersion(db)
+	if err != nil {
+		return fmt.Errorf("ошибка получения версии основного сервера: %w", err)
+	}
+	remoteVersion, err := serverVersion(remote)
+	if err != nil {
+		return fmt.Errorf("ошибка получения версии сервера бэкапов: %w", err)
+	}
+	if majorVersion(mainVersion) != majorVersion(remoteVersion) {
+		log.Printf("Версии отличаются: основной сервер PostgreSQL %s, сервер бэкапов %s",
+			majorVersion(mainVersion), majorVersion(remoteVersion))
+	}
+	// batch_size - опция postgres_fdw на основном сервере
+	if cfg.BatchSize > 0 && mainVersion < 140000 {
+		return fmt.Errorf("remote.batch_size требует PostgreSQL 14+ на основном сервере, версия %s", majorVersion(mainVersion))
+	}
+
+	for _, table := range tables {
+		for _, c := range model.columns(table) {
+			_, replaced, err := remoteColumnType(remote, c)
+			if err != nil {
+				return fmt.Errorf("ошибка проверки типа %s на сервере бэкапов: %w", c.Type, err)
+			}
+			if replaced {
+				log.Printf("Тип %s колонки %s.%s отсутствует на сервере бэкапов PostgreSQL %s, колонка будет сохранена как text",
+					c.Type, table, c.Name, majorVersion(remoteVersion))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// getTableOIDs возвращает oid таблиц текущей схемы
+func getTableOIDs(db *sql.DB, tables []string) (map[string]int64, error) {
+	rows, err := db.Query(`
+		SELECT c.relname, c.oid::bigint
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = current_schema() AND c.relname = ANY($1)`, pq.Array(tables))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	oids := make(map[string]int64, len(tables))
+	for rows.Next() {
+		var name string
+		var oid int64
+		if err := rows.Scan(&name, &oid); err != nil {
+			return nil, err
+		}
+		oids[name] = oid
+	}
+	return oids, rows.Err()
+}
+
+// followRenames находит таблицы, которые с прошлых запусков были переименованы (тот же oid в каталоге
+// под именем, которого больше нет), и переименовывает их бэкапы {prefix}_{старое}_{дата} в {prefix}_{новое}_{дата},
+// чтобы история, хранение и восстановление продолжали работать для таблицы под новым именем
+func followRenames(db, backupDB *sql.DB, prefix string, tables []string, oids map[string]int64, realRun bool) error {
+	known, err := lastTableNames(db)
+	if err != nil || len(known) == 0 {
+		return err
+	}
+	present := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		present[t] = true
+	}
+
+	var backups []backupTable
+	for _, table := range tables {
+		oldName, ok := known[oids[table]]
+		if !ok || oldName == table || present[oldName] {
+			continue
+		}
+		if backups == nil {
+			if backups, err = getBackupTables(backupDB, prefix); err != nil {
+				return err
+			}
+		}
+
+		oldPrefix := prefix + "_" + oldName + "_"
+		renamed := make(map[string]string)
+		for _, b := range backups {
+			if !strings.HasPrefix(b.Name, oldPrefix) || len(b.Name) != len(oldPrefix)+8 {
+				continue
+			}
+			newName := fmt.Sprintf("%s_%s_%s", prefix, table, b.Date)
+			if realRun {
+				_, err := backupDB.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quoteIdent(b.Name), quoteIdent(newName)))
+				if err != nil {
+					log.Printf("Ошибка переименования бэкапа %s: %v", b.Name, err)
+					continue
+				}
+			}
+			renamed[b.Name] = newName
+		}
+		log.Printf("Таблица %s переименована в %s, бэкапов перенесено: %d", oldName, table, len(renamed))
+
+		if realRun && len(renamed) > 0 {
+			if err := renameTableArtifacts(db, renamed, oldName, table); err != nil {
+				return fmt.Errorf("ошибка обновления каталога: %w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func init() {
+	commands["replicate"] = command{
+		Usage: "Copy uploaded artifacts to replica destinations (replica_of)",
+		Run:   cmdReplicate,
+	}
+}
+
+// cmdReplicate в фоне копирует файлы, загруженные в хранилища, в их реплики (например, бакет в другом регионе)
+// и отмечает статус репликации в каталоге
+func cmdReplicate(args []string) error {
+	fs := flag.NewFlagSet("replicate", flag.ExitOnError)
+	run := fs.Bool("run", false, "Normal run instead of test run?")
+	once := fs.Bool("once", false, "Replicate pending artifacts once and exit instead of running as a daemon")
+	interval := fs.Duration("interval", 5*time.Minute, "Pause between replication passes")
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var replicas []*DestinationConfig
+	for i := range config.Destinations {
+		if config.Destinations[i].ReplicaOf != "" {
+			replicas = append(replicas, &config.Destinations[i])
+		}
+	}
+	if len(replicas) == 0 {
+		return fmt.Errorf("нет хранилищ с replica_of")
+	}
+	exists, err := catalogExists(db)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		log.Println("Каталог пуст, реплицировать нечего")
+		return nil
+	}
+	if err := ensureCatalog(db); err != nil {
+		return err
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	for {
+		for _, d := range replicas {
+			if err := replicateDestination(db, config, d, *run); err != nil {
+				log.Printf("Ошибка репликации в хранилище %s: %v", d.Name, err)
+			}
+		}
+		if *once || !*run {
+			return nil
+		}
+
+		select {
+		case <-stop:
+			log.Println("replicate stopped")
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// replicateDestination копирует в хранилище target все еще не реплицированные копии из его исходного хранилища
+func replicateDestination(db *sql.DB, config *Config, target *DestinationConfig, realRun bool) error {
+	pending, err := pendingReplications(db, target.ReplicaOf, target.Name)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if !realRun {
+		log.Printf("Тестовый запуск, будет реплицировано из %s в %s: %d", target.ReplicaOf, target.Name, len(pending))
+		return nil
+	}
+
+	src, err := openStorage(findDestination(config.Destinations, target.ReplicaOf))
+	if err != nil {
+		return err
+	}
+	dst, err := openStorage(target)
+	if err != nil {
+		return err
+	}
+	rep, ok := src.(replicator)
+	if !ok {
+		return fmt.Errorf("хранилище %s не поддерживает репликацию", target.ReplicaOf)
+	}
+
+	var done, failed int
+	for _, a := range pending {
+		replErr := rep.Replicate(a.Path, dst)
+		if replErr != nil {
+			log.Printf("Ошибка репликации %s из %s в %s: %v", a.Path, target.ReplicaOf, target.Name, replErr)
+			failed++
+		} else {
+			done++
+		}
+		if err := recordReplication(db, a, target.Name, replErr); err != nil {
+			log.Printf("Ошибка обновления каталога для %s: %v", a.Path, err)
+		}
+	}
+	log.Printf("Репликация %s -> %s: скопировано %d, ошибок %d", target.ReplicaOf, target.Name, done, failed)
+	return nil
+}
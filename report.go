@@ -0,0 +1,263 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReportConfig настройки HTML-отчета о запусках
+type ReportConfig struct {
+	Dir  string `json:"dir"`  // Каталог, куда после каждого запуска сохраняется отчет {prefix}_report_{YYYYMMDD}.html (пусто - не сохранять)
+	Days int    `json:"days"` // За сколько дней показывать динамику размеров (по умолчанию 30)
+}
+
+func init() {
+	commands["report"] = command{
+		Usage: "Write an HTML summary of the last backup run with size trends",
+		Run:   cmdReport,
+	}
+}
+
+// Символы спарклайна от меньшего значения к большему; в отличие от SVG они отображаются любым почтовым клиентом
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// reportTable строка отчета по исходной таблице
+type reportTable struct {
+	Name   string
+	Backup string
+	Size   ByteSize
+	Trend  string // спарклайн размеров бэкапов по дням
+}
+
+// reportData данные HTML-отчета
+type reportData struct {
+	DB        string
+	Generated time.Time
+	Days      int
+	Run       *runRecord // последний запуск бэкапа таблиц
+	Failures  []string   // ошибки последнего запуска
+	Tables    []reportTable
+	Total     ByteSize    // размер бэкапов последнего запуска
+	Trend     string      // спарклайн суммарного размера бэкапов по дням
+	History   []runRecord // предыдущие запуски, от новых к старым
+}
+
+// cmdReport выводит HTML-отчет о последнем запуске в файл или stdout
+func cmdReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	output := fs.String("o", "", "Write the report to this file instead of stdout")
+	days := fs.Int("days", 0, "Size trend period in days (default report.days)")
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if *days > 0 {
+		config.Report.Days = *days
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	return writeReport(out, db, config)
+}
+
+// saveRunReport сохраняет в report.dir отчет о завершающемся запуске run и регистрирует его как файл запуска,
+// чтобы он загружался в хранилища и удалялся политикой хранения вместе с остальными файлами.
+// Запуск еще не записан в каталог: в отчет попадает его состояние до загрузки файлов в хранилища.
+func saveRunReport(db *sql.DB, config *Config, result *runResult, run *runRecord) error {
+	if err := os.MkdirAll(config.Report.Dir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(config.Report.Dir, fmt.Sprintf("%s_report_%s.html", config.Backup.Prefix, run.StartedAt.Format("20060102")))
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	var tables []artifact
+	for _, a := range result.Artifacts {
+		if a.Kind == artifactKindTable && a.Destination == "" {
+			tables = append(tables, a)
+		}
+	}
+	history, err := lastRuns(db, runKindTables, config.Postgres.Schema, 10)
+	if err == nil {
+		err = renderReport(f, db, config, run, history, tables)
+	} else {
+		err = fmt.Errorf("ошибка чтения каталога запусков: %w", err)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	size, err := pathSize(path)
+	if err != nil {
+		return err
+	}
+	result.addArtifacts(artifact{
+		Kind:      artifactKindReport,
+		Source:    config.Postgres.DBName,
+		Path:      path,
+		Size:      size,
+		CreatedAt: clock.Now(),
+	})
+	log.Printf("Отчет сохранен: %s", path)
+	return nil
+}
+
+// writeReport собирает данные отчета о последнем запуске из каталога и выводит HTML
+func writeReport(w io.Writer, db *sql.DB, config *Config) error {
+	runs, err := lastRuns(db, runKindTables, config.Postgres.Schema, 11)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения каталога запусков: %w", err)
+	}
+	if len(runs) == 0 {
+		return renderReport(w, db, config, nil, nil, nil)
+	}
+	artifacts, err := runArtifacts(db, runs[0].ID, artifactKindTable)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения артефактов запуска: %w", err)
+	}
+	return renderReport(w, db, config, &runs[0], runs[1:], artifacts)
+}
+
+// renderReport выводит HTML-отчет о запуске run (nil - запусков нет) с его бэкапами таблиц artifacts
+// и предыдущими запусками history
+func renderReport(w io.Writer, db *sql.DB, config *Config, run *runRecord, history []runRecord, artifacts []artifact) error {
+	data := reportData{DB: config.Postgres.DBName, Generated: clock.Now(), Days: config.Report.Days}
+	if config.Postgres.Schema != "" {
+		data.DB += "." + config.Postgres.Schema
+	}
+
+	if run != nil {
+		data.Run, data.History = run, history
+		if data.Run.Error != "" {
+			// ошибки отдельных таблиц перечислены в тексте через "; "
+			data.Failures = strings.Split(data.Run.Error, "; ")
+		}
+
+		today := time.Date(data.Generated.Year(), data.Generated.Month(), data.Generated.Day(), 0, 0, 0, 0, time.Local)
+		since := today.AddDate(0, 0, -data.Days+1)
+		sizes, err := tableSizeHistory(db, since)
+		if err != nil {
+			return fmt.Errorf("ошибка чтения истории размеров: %w", err)
+		}
+		if run.ID == 0 {
+			// бэкапы запуска, еще не записанного в каталог
+			if sizes == nil {
+				sizes = make(map[string][]dailySize)
+			}
+			for _, a := range artifacts {
+				sizes[a.Source] = append(sizes[a.Source], dailySize{Date: a.CreatedAt, Size: a.Size})
+			}
+		}
+		total := make([]int64, data.Days)
+		for _, a := range artifacts {
+			series := dailySeries(sizes[a.Source], since, data.Days)
+			for i, v := range series {
+				total[i] += v
+			}
+			data.Tables = append(data.Tables, reportTable{
+				Name:   a.Source,
+				Backup: a.Path,
+				Size:   ByteSize(a.Size),
+				Trend:  sparkline(series),
+			})
+			data.Total += ByteSize(a.Size)
+		}
+		sort.Slice(data.Tables, func(i, j int) bool { return data.Tables[i].Size > data.Tables[j].Size })
+		data.Trend = sparkline(total)
+	}
+	return reportTemplate.Execute(w, data)
+}
+
+// dailySeries раскладывает размеры по дням периода; дни без бэкапа дают 0
+func dailySeries(sizes []dailySize, since time.Time, days int) []int64 {
+	series := make([]int64, days)
+	for _, s := range sizes {
+		d := time.Date(s.Date.Year(), s.Date.Month(), s.Date.Day(), 0, 0, 0, 0, time.Local)
+		i := int(d.Sub(since).Hours()/24 + 0.5)
+		if i >= 0 && i < days {
+			series[i] += s.Size
+		}
+	}
+	return series
+}
+
+// sparkline рисует ряд значений символами разной высоты; нулевые значения (пропуски) выводятся точкой
+func sparkline(values []int64) string {
+	var max int64
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	var b strings.Builder
+	for _, v := range values {
+		if v == 0 || max == 0 {
+			b.WriteRune('·')
+			continue
+		}
+		b.WriteRune(sparkBars[int(v*int64(len(sparkBars)-1)/max)])
+	}
+	return b.String()
+}
+
+// reportTemplate компактный отчет со встроенными стилями: почтовые клиенты не загружают внешние CSS
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"datetime": func(t time.Time) string { return t.Local().Format("2006-01-02 15:04") },
+	"duration": func(r runRecord) string { return r.Duration().Round(time.Second).String() },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>dbacker: {{.DB}}</title></head>
+<body style="font-family: Arial, sans-serif; font-size: 14px; color: #222;">
+<h2 style="margin-bottom: 4px;">dbacker: {{.DB}}</h2>
+<div style="color: #777;">Отчет от {{datetime .Generated}}</div>
+{{with .Run}}
+<p style="padding: 8px; background: {{if eq .Status "ok"}}#e6f4ea{{else}}#fce8e6{{end}};">
+Последний запуск {{datetime .StartedAt}}: <b>{{.Status}}</b>, {{duration .}}, таблиц {{.TablesTotal}}, ошибок
+{{if .TablesFailed}}<b style="color: #c5221f;">{{.TablesFailed}}</b>{{else}}0{{end}}{{if .DeletedCount}}, удалено старых бэкапов {{.DeletedCount}}{{end}}
+</p>
+{{else}}
+<p>В каталоге нет запусков бэкапа таблиц.</p>
+{{end}}
+{{with .Failures}}
+<h3>Ошибки</h3>
+<ul style="color: #c5221f;">{{range .}}<li>{{.}}</li>{{end}}</ul>
+{{end}}
+{{if .Run}}
+<h3>Бэкапы ({{.Total}}) <span style="font-family: monospace; font-weight: normal;" title="за {{.Days}} дней">{{.Trend}}</span></h3>
+<table cellpadding="4" style="border-collapse: collapse;">
+<tr style="background: #f1f3f4;"><th align="left">Таблица</th><th align="left">Бэкап</th><th align="right">Размер</th><th align="left">За {{.Days}} дней</th></tr>
+{{range .Tables}}<tr style="border-top: 1px solid #eee;"><td>{{.Name}}</td><td>{{.Backup}}</td><td align="right">{{.Size}}</td><td style="font-family: monospace;">{{.Trend}}</td></tr>
+{{end}}</table>
+{{end}}
+{{with .History}}
+<h3>Предыдущие запуски</h3>
+<table cellpadding="4" style="border-collapse: collapse;">
+{{range .}}<tr style="border-top: 1px solid #eee;{{if ne .Status "ok"}} color: #c5221f;{{end}}"><td>{{datetime .StartedAt}}</td><td>{{.Status}}</td><td>{{duration .}}</td><td>таблиц {{.TablesTotal}}, ошибок {{.TablesFailed}}</td></tr>
+{{end}}</table>
+{{end}}
+</body></html>
+`))
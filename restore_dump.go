@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// RestoreConfig настройки восстановления
+type RestoreConfig struct {
+	// Наборы SQL-преобразований по имени, которые restore-dump -transform применяет к восстановленной базе,
+	// например {"staging": ["UPDATE users SET api_token = NULL", "UPDATE sites SET host = replace(host, 'example.com', 'staging.example.com')"]}
+	Transforms map[string][]string `json:"transforms"`
+}
+
+func init() {
+	commands["restore-dump"] = command{
+		Usage: "Restore a pg_dump archive with pg_restore and record it in the catalog",
+		Run:   cmdRestoreDump,
+	}
+}
+
+// cmdRestoreDump восстанавливает архив pg_dump (custom или directory) через pg_restore
+// и при -transform применяет к восстановленной базе набор SQL-преобразований
+func cmdRestoreDump(args []string) error {
+	fs := flag.NewFlagSet("restore-dump", flag.ExitOnError)
+	run := fs.Bool("run", false, "Normal run instead of test run?")
+	target := fs.String("db", "", "Target database (default postgres.dbname)")
+	clean := fs.Bool("clean", false, "Drop database objects before recreating them")
+	create := fs.Bool("create", false, "Create the database before restoring into it")
+	jobs := fs.Int("jobs", 1, "Number of parallel pg_restore jobs")
+	noOwner := fs.Bool("no-owner", false, "Do not restore object ownership")
+	transform := fs.String("transform", "", "Apply this set of restore.transforms to the restored database")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		return fmt.Errorf("использование: dbacker restore-dump <archive> [-db target] [-clean] [-create] [-jobs N] [-no-owner] [-transform name] [-run]")
+	}
+	archive := positional[0]
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *target == "" {
+		*target = config.Postgres.DBName
+	}
+	var transforms []string
+	if *transform != "" {
+		var ok bool
+		if transforms, ok = config.Restore.Transforms[*transform]; !ok {
+			return fmt.Errorf("набор преобразований %s не найден в restore.transforms", *transform)
+		}
+	}
+	if _, err := os.Stat(archive); err != nil {
+		return fmt.Errorf("архив недоступен: %w", err)
+	}
+	if err := checkDumpArchive(db, config, archive); err != nil {
+		return err
+	}
+	timescale, err := archiveHasTimescale(config.Dump.RestoreBinary, archive)
+	if err != nil {
+		return err
+	}
+	if timescale && *create {
+		return fmt.Errorf("архив содержит TimescaleDB: создайте базу %s и восстановите без -create, чтобы dbacker выполнил timescaledb_pre_restore()", *target)
+	}
+
+	// pg_restore настраивает сессию командами SET, а --create подключается к другой базе
+	pg, err := sessionPostgres(&config.Postgres, "pg_restore")
+	if err != nil {
+		return err
+	}
+	pgArgs := []string{
+		"--host", pg.Host,
+		"--port", strconv.Itoa(pg.Port),
+		"--username", pg.User,
+		"--no-password",
+	}
+	if *create {
+		// С --create pg_restore подключается к служебной базе и сам создает целевую
+		pgArgs = append(pgArgs, "--create", "--dbname", "postgres")
+	} else {
+		pgArgs = append(pgArgs, "--dbname", *target)
+	}
+	if *clean {
+		pgArgs = append(pgArgs, "--clean", "--if-exists")
+	}
+	if *jobs > 1 {
+		pgArgs = append(pgArgs, "--jobs", strconv.Itoa(*jobs))
+	}
+	if *noOwner {
+		pgArgs = append(pgArgs, "--no-owner")
+	}
+	pgArgs = append(pgArgs, archive)
+
+	if !*run {
+		if timescale {
+			log.Printf("Тестовый запуск, архив содержит TimescaleDB: восстановление будет выполнено между timescaledb_pre_restore() и timescaledb_post_restore()")
+		}
+		log.Printf("Тестовый запуск, будет выполнено: %s %s", config.Dump.RestoreBinary, strings.Join(pgArgs, " "))
+		for _, stmt := range transforms {
+			log.Printf("Затем в базе %s: %s", *target, stmt)
+		}
+		return nil
+	}
+
+	startedAt := clock.Now()
+	result := &runResult{Tables: 1}
+	if timescale {
+		err = restoreTimescale(pg, *target, func() error {
+			return runPgRestore(pg, config.Dump.RestoreBinary, pgArgs)
+		})
+	} else {
+		err = runPgRestore(pg, config.Dump.RestoreBinary, pgArgs)
+	}
+	if err != nil {
+		result.addFailure(fmt.Sprintf("%s: %v", archive, err))
+		err = fmt.Errorf("ошибка восстановления %s в базу %s: %w", archive, *target, err)
+	} else if len(transforms) > 0 {
+		if err = applyTransforms(pg, *target, transforms); err != nil {
+			result.addFailure(fmt.Sprintf("%s: %v", archive, err))
+			err = fmt.Errorf("ошибка преобразования %s в базе %s: %w", *transform, *target, err)
+		} else {
+			log.Printf("Применен набор преобразований %s (%d запросов)", *transform, len(transforms))
+		}
+	}
+	size, _ := pathSize(archive)
+	result.addArtifacts(artifact{
+		Kind:      runKindRestore,
+		Source:    *target,
+		Path:      archive,
+		Size:      size,
+		CreatedAt: clock.Now(),
+	})
+	finishRun(db, config, runKindRestore, startedAt, result, err)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Архив %s восстановлен в базу %s", archive, *target)
+	return nil
+}
+
+// checkDumpArchive до запуска pg_restore проверяет, что архив можно восстановить: манифест из каталога
+// сверяется с матрицей совместимости и с содержимым файла, а версия pg_dump из манифеста - с версией pg_restore.
+// Архивы без манифеста (созданные прежними версиями dbacker или вручную) проверяются только по содержимому.
+func checkDumpArchive(db *sql.DB, config *Config, archive string) error {
+	manifest, err := artifactManifestByPath(db, archive)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения манифеста архива: %w", err)
+	}
+	if manifest != nil {
+		if err := checkRestorable(manifest, "restore-dump"); err != nil {
+			return fmt.Errorf("архив %s: %w", archive, err)
+		}
+	}
+	layout, err := sniffDumpLayout(archive)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		log.Printf("Манифест архива %s не найден в каталоге, формат определен по содержимому: %s", archive, layout)
+		manifest = &artifactManifest{Format: artifactManifestFormat, Layout: layout}
+		if err := checkRestorable(manifest, "restore-dump"); err != nil {
+			return fmt.Errorf("архив %s: %w", archive, err)
+		}
+	} else if manifest.Layout != layout {
+		return fmt.Errorf("архив %s не соответствует манифесту: в каталоге %s, по содержимому %s", archive, manifest.Layout, layout)
+	}
+
+	dumpMajor := postgresMajor(manifest.Tool)
+	if dumpMajor == 0 {
+		return nil
+	}
+	restoreVersion, err := toolVersion(config.Dump.RestoreBinary)
+	if err != nil {
+		log.Printf("Не удалось определить версию %s: %v", config.Dump.RestoreBinary, err)
+		return nil
+	}
+	if restoreMajor := postgresMajor(restoreVersion); restoreMajor > 0 && restoreMajor < dumpMajor {
+		return fmt.Errorf("архив %s создан программой %s, а %s не читает архивы более новых версий PostgreSQL",
+			archive, manifest.Tool, restoreVersion)
+	}
+	return nil
+}
+
+// runPgRestore запускает pg_restore с указанными аргументами
+func runPgRestore(pg *PostgresConfig, binary string, args []string) error {
+	cmd := exec.Command(binary, args...)
+	cmd.Env = append(os.Environ(), pgEnv(pg)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// applyTransforms выполняет запросы преобразования в базе dbname в одной транзакции:
+// при ошибке любого запроса не применяется ни один
+func applyTransforms(pg *PostgresConfig, dbname string, statements []string) error {
+	cfg := *pg
+	cfg.DBName = dbname
+	db, err := connectToPostgres(&cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range statements {
+		res, err := tx.Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+		rows, _ := res.RowsAffected()
+		log.Printf("Преобразование: %s (строк: %d)", stmt, rows)
+	}
+	return tx.Commit()
+}
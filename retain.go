@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	commands["retain"] = command{
+		Usage: "Keep a specific backup past normal retention (retain <backup> -until 2025-12-31)",
+		Run:   cmdRetain,
+	}
+}
+
+// cmdRetain продлевает хранение одного бэкапа до указанной даты; без аргументов выводит действующие продления
+func cmdRetain(args []string) error {
+	fs := flag.NewFlagSet("retain", flag.ExitOnError)
+	until := fs.String("until", "", "Keep the backup until this date (YYYY-MM-DD)")
+	reason := fs.String("reason", "", "Why the backup is kept (e.g. legal hold ticket)")
+	positional := parseArgs(fs, args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if len(positional) == 0 {
+		return printRetentionOverrides(db)
+	}
+	if len(positional) > 1 {
+		return fmt.Errorf("укажите один бэкап: dbacker retain <backup> -until YYYY-MM-DD")
+	}
+	if *until == "" {
+		return fmt.Errorf("не указана дата -until")
+	}
+	date, err := time.ParseInLocation("2006-01-02", *until, time.Local)
+	if err != nil {
+		return fmt.Errorf("некорректная дата -until %q, ожидается YYYY-MM-DD", *until)
+	}
+
+	backupDB, err := openBackupDatabase(config, db)
+	if err != nil {
+		return err
+	}
+	if backupDB != db {
+		defer backupDB.Close()
+	}
+
+	name, err := resolveBackup(backupDB, config, positional[0])
+	if err != nil {
+		return err
+	}
+	if err := setRetentionOverride(db, retentionOverride{Backup: name, Until: date, Reason: *reason}); err != nil {
+		return fmt.Errorf("ошибка записи продления в каталог: %w", err)
+	}
+	fmt.Printf("Бэкап %s будет храниться до %s\n", name, date.Format("2006-01-02"))
+	return nil
+}
+
+// resolveBackup находит бэкап по имени таблицы бэкапа, пути к файлу или имени файла в каталогах бэкапов
+// и возвращает имя, под которым его видит политика хранения
+func resolveBackup(backupDB *sql.DB, config *Config, name string) (string, error) {
+	tables, err := getBackupTables(backupDB, config.Backup.Prefix)
+	if err != nil {
+		return "", fmt.Errorf("ошибка получения списка бэкапов: %w", err)
+	}
+	for _, t := range tables {
+		if t.Name == name {
+			return name, nil
+		}
+	}
+
+	for _, dir := range retentionDirs(config) {
+		files, err := listBackupFiles(dir, config.Backup.Prefix)
+		if err != nil {
+			return "", fmt.Errorf("ошибка чтения каталога %s: %w", dir, err)
+		}
+		for _, f := range files {
+			if f.Path == filepath.Clean(name) || filepath.Base(f.Path) == name {
+				return f.Path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("бэкап %s не найден", name)
+}
+
+// printRetentionOverrides выводит продления хранения из каталога
+func printRetentionOverrides(db *sql.DB) error {
+	overrides, err := listRetentionOverrides(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения продлений хранения: %w", err)
+	}
+	if len(overrides) == 0 {
+		fmt.Println("Продлений хранения нет")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Бэкап\tДо\tСтатус\tПричина")
+	for _, o := range overrides {
+		status := "действует"
+		if !isRetained(o.Until) {
+			status = "истекло"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", o.Backup, o.Until.Format("2006-01-02"), status, o.Reason)
+	}
+	return w.Flush()
+}
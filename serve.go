@@ -0,0 +1,367 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+func init() {
+	commands["serve"] = command{
+		Usage: "HTTP server: trigger backups with POST /backup, stream progress (SSE), SLO and run metrics, legal holds",
+		Run:   cmdServe,
+	}
+}
+
+// server HTTP-сервер dbacker: запускает бэкапы по запросу и транслирует ход текущего запуска подписчикам
+type server struct {
+	db       *sql.DB
+	backupDB *sql.DB
+	config   *Config
+	token    string
+
+	mu          sync.Mutex
+	running     bool
+	events      []progressEvent // события текущего (или последнего) запуска для подключившихся позже
+	subscribers map[chan progressEvent]bool
+}
+
+// cmdServe запускает HTTP-сервер
+func cmdServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "Listen address")
+	token := fs.String("token", "", "Require this bearer token in the Authorization header")
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	backupDB, err := openBackupDatabase(config, db)
+	if err != nil {
+		return err
+	}
+	if backupDB != db {
+		defer backupDB.Close()
+	}
+
+	s := &server{
+		db:          db,
+		backupDB:    backupDB,
+		config:      config,
+		token:       *token,
+		subscribers: make(map[chan progressEvent]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/backup", s.auth(s.handleBackup))
+	mux.HandleFunc("/events", s.auth(s.handleEvents))
+	mux.HandleFunc("/metrics", s.auth(s.handleMetrics))
+	mux.HandleFunc("/holds", s.auth(s.handleHolds))
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	log.Printf("dbacker слушает %s", *addr)
+	return http.ListenAndServe(*addr, mux)
+}
+
+// auth проверяет токен, если он задан
+func (s *server) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" && r.Header.Get("Authorization") != "Bearer "+s.token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleBackup запускает бэкап (POST /backup?run=true) или снимок одной таблицы (POST /backup?table=orders&label=before_fix)
+// и транслирует его ход в ответ как Server-Sent Events.
+// Отключение клиента не прерывает запуск.
+func (s *server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	realRun := r.URL.Query().Get("run") == "true"
+	table, label := r.URL.Query().Get("table"), r.URL.Query().Get("label")
+
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		http.Error(w, "backup is already running", http.StatusConflict)
+		return
+	}
+	s.running = true
+	s.events = nil
+	s.mu.Unlock()
+
+	ch := s.subscribe()
+	defer s.unsubscribe(ch)
+
+	go func() {
+		var err error
+		switch {
+		case table != "":
+			_, err = runSnapshot(s.db, s.backupDB, s.config, table, label, realRun, s.publish)
+		case s.config.Backup.Schemas != "":
+			err = runTenants(s.db, s.config, realRun, s.publish)
+		default:
+			_, err = runBackup(s.db, s.backupDB, s.config, realRun, s.publish)
+		}
+		if err != nil {
+			log.Printf("Ошибка выполнения бэкапа: %v", err)
+		}
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+	}()
+
+	s.stream(w, r, ch, true)
+}
+
+// handleEvents транслирует события текущего запуска (начиная с уже произошедших) и всех следующих (GET /events)
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	ch := s.subscribe()
+	defer s.unsubscribe(ch)
+	s.stream(w, r, ch, false)
+}
+
+// stream пишет события в ответ в формате text/event-stream; при untilFinished завершается после run_finished
+func (s *server) stream(w http.ResponseWriter, r *http.Request, ch chan progressEvent, untilFinished bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case e := <-ch:
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+			if untilFinished && e.Type == eventRunFinished {
+				return
+			}
+		}
+	}
+}
+
+// subscribe подписывает на события; уже произошедшие события текущего запуска отправляются сразу
+func (s *server) subscribe() chan progressEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan progressEvent, len(s.events)+256)
+	for _, e := range s.events {
+		ch <- e
+	}
+	s.subscribers[ch] = true
+	return ch
+}
+
+// unsubscribe отменяет подписку
+func (s *server) unsubscribe(ch chan progressEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, ch)
+}
+
+// publish рассылает событие подписчикам; медленный подписчик пропускает события о ходе запуска, но не тормозит
+// бэкап. run_finished доставляется всегда: по нему POST /backup завершает ответ.
+func (s *server) publish(e progressEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+	for ch := range s.subscribers {
+		select {
+		case ch <- e:
+			continue
+		default:
+		}
+		if e.Type != eventRunFinished {
+			continue
+		}
+		// Место под run_finished освобождается за счет самого старого события. Отправляют в канал только
+		// publish и subscribe под s.mu, так что после этого отправка не блокируется.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- e
+	}
+}
+
+// handleMetrics отдает состояние целей по свежести бэкапов в текстовом формате Prometheus (GET /metrics)
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	statuses, err := evaluateSLOs(s.db, s.config.SLOs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP dbacker_slo_backup_age_seconds Age of the newest successful backup of the table (+Inf if none).")
+	fmt.Fprintln(w, "# TYPE dbacker_slo_backup_age_seconds gauge")
+	for _, st := range statuses {
+		age := "+Inf"
+		if !st.LastBackup.IsZero() {
+			age = fmt.Sprintf("%g", st.Age.Seconds())
+		}
+		fmt.Fprintf(w, "dbacker_slo_backup_age_seconds%s %s\n", sloLabels(s.config, &st), age)
+	}
+	fmt.Fprintln(w, "# HELP dbacker_slo_max_age_seconds Maximum allowed backup age of the table.")
+	fmt.Fprintln(w, "# TYPE dbacker_slo_max_age_seconds gauge")
+	for _, st := range statuses {
+		fmt.Fprintf(w, "dbacker_slo_max_age_seconds%s %g\n", sloLabels(s.config, &st), time.Duration(st.SLO.MaxAge).Seconds())
+	}
+	fmt.Fprintln(w, "# HELP dbacker_slo_breached 1 if the newest backup is older than max_age or missing.")
+	fmt.Fprintln(w, "# TYPE dbacker_slo_breached gauge")
+	for _, st := range statuses {
+		breached := 0
+		if st.State == sloBreached {
+			breached = 1
+		}
+		fmt.Fprintf(w, "dbacker_slo_breached%s %d\n", sloLabels(s.config, &st), breached)
+	}
+
+	// Метрики запусков по видам: на них построены правила dbacker observability export
+	runs, err := runStats(s.db)
+	if err != nil {
+		log.Printf("Ошибка чтения каталога запусков для метрик: %v", err)
+		return
+	}
+	for _, m := range []struct {
+		name, help string
+		value      func(st *runKindStats) (float64, bool)
+	}{
+		{"dbacker_last_run_timestamp_seconds", "Unix time the last run of this kind finished.",
+			func(st *runKindStats) (float64, bool) { return float64(st.LastRun.Unix()), true }},
+		{"dbacker_last_success_timestamp_seconds", "Unix time the last successful run of this kind finished (absent if none).",
+			func(st *runKindStats) (float64, bool) {
+				return float64(st.LastSuccess.Unix()), !st.LastSuccess.IsZero()
+			}},
+		{"dbacker_run_failure_streak", "Failed runs in a row since the last successful run.",
+			func(st *runKindStats) (float64, bool) { return float64(st.FailureStreak), true }},
+		{"dbacker_last_run_duration_seconds", "Duration of the last run.",
+			func(st *runKindStats) (float64, bool) { return st.LastDuration.Seconds(), true }},
+		{"dbacker_run_duration_average_seconds", "Average duration of the previous successful runs (up to 10, absent if none).",
+			func(st *runKindStats) (float64, bool) { return st.AvgDuration.Seconds(), st.AvgDuration > 0 }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", m.name)
+		for i := range runs {
+			if v, ok := m.value(&runs[i]); ok {
+				fmt.Fprintf(w, "%s%s %g\n", m.name, runLabels(s.config, &runs[i]), v)
+			}
+		}
+	}
+}
+
+// sloLabels возвращает метки метрики цели по свежести
+func sloLabels(config *Config, st *sloStatus) string {
+	return fmt.Sprintf("{db=%q,table=%q,schema=%q}", config.Postgres.DBName, st.SLO.Table, st.SLO.Schema)
+}
+
+// runLabels возвращает метки метрики запусков одного вида
+func runLabels(config *Config, st *runKindStats) string {
+	return fmt.Sprintf("{db=%q,kind=%q,schema=%q}", config.Postgres.DBName, st.Kind, st.Schema)
+}
+
+// holdRequest тело запроса POST /holds
+type holdRequest struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+	Table  string `json:"table"`
+	Label  string `json:"label"`
+	From   string `json:"from"` // YYYY-MM-DD
+	To     string `json:"to"`   // YYYY-MM-DD
+}
+
+// handleHolds выводит удержания (GET /holds), ставит удержание (POST /holds с JSON holdRequest)
+// и снимает его (DELETE /holds?name=case_42). Действия записываются в журнал от имени "api:<адрес клиента>".
+func (s *server) handleHolds(w http.ResponseWriter, r *http.Request) {
+	actor := "api:" + r.RemoteAddr
+	var (
+		result interface{}
+		err    error
+		status = http.StatusOK
+	)
+	switch r.Method {
+	case http.MethodGet:
+		result, err = listHolds(s.db)
+	case http.MethodPost:
+		var req holdRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		criteria := holdCriteria{Table: req.Table, Label: req.Label}
+		for _, d := range []struct {
+			value string
+			dest  *time.Time
+		}{{req.From, &criteria.From}, {req.To, &criteria.To}} {
+			if d.value == "" {
+				continue
+			}
+			if *d.dest, err = time.ParseInLocation("2006-01-02", d.value, time.Local); err != nil {
+				http.Error(w, fmt.Sprintf("invalid date %q, expected YYYY-MM-DD", d.value), http.StatusBadRequest)
+				return
+			}
+		}
+		h := &legalHold{Name: req.Name, Reason: req.Reason, CreatedBy: actor}
+		if _, err = placeHold(s.db, s.config, h, criteria, true); h.ID == 0 && err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, status = h, http.StatusCreated
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		var released []artifact
+		released, err = liftHold(s.db, s.config, name, actor)
+		if released == nil && err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		result = map[string]int{"released": len(released)}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		// удержание в каталоге изменено, но legal hold в хранилищах выставлен не полностью
+		log.Printf("Ошибка обработки удержания: %v", err)
+		if r.Method == http.MethodGet {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Dbacker-Warning", err.Error())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
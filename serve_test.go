@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestPublishDeliversRunFinished(t *testing.T) {
+	s := &server{subscribers: make(map[chan progressEvent]bool)}
+	ch := s.subscribe()
+	// подписчик не читает события, пока буфер не переполнится
+	for i := 0; i < cap(ch)+10; i++ {
+		s.publish(progressEvent{Type: eventTableFinished, Rows: int64(i)})
+	}
+	s.publish(progressEvent{Type: eventRunFinished})
+
+	if len(ch) != cap(ch) {
+		t.Fatalf("в канале %d событий из %d", len(ch), cap(ch))
+	}
+	var last progressEvent
+	for len(ch) > 0 {
+		last = <-ch
+	}
+	if last.Type != eventRunFinished {
+		t.Errorf("последнее событие %s, ожидалось %s", last.Type, eventRunFinished)
+	}
+}
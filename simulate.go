@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	commands["simulate"] = command{
+		Usage: "Estimate WAL, disk growth, cache pressure and duration of a backup run without copying data",
+		Run:   cmdSimulate,
+	}
+}
+
+// serverCapacity настройки сервера, от которых зависит нагрузка запуска
+type serverCapacity struct {
+	WALLevel           string
+	SharedBuffers      int64
+	EffectiveCacheSize int64
+	DatabaseSize       int64
+}
+
+// simulatedTable оценка нагрузки копирования одной таблицы
+type simulatedTable struct {
+	Table    string
+	Size     int64 // размер данных таблицы с TOAST: столько займет бэкап (индексы не копируются)
+	Read     int64 // размер таблицы с индексами, по нему считается скорость из истории
+	Duration time.Duration
+}
+
+// cmdSimulate оценивает нагрузку запуска бэкапа таблиц без копирования: объем WAL, рост диска
+// с учетом срока хранения, вытеснение кэша и длительность по скорости прошлых запусков.
+// С -config оценивается другая база (например, перед добавлением ее в dbacker targets),
+// а скорость берется из каталога текущей конфигурации.
+func cmdSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	path := fs.String("config", configFile, "Config of the database to simulate (e.g. of a new target)")
+	throughputFlag := fs.String("throughput", "", "Copy throughput per second, e.g. 80MB (default from run history)")
+	top := fs.Int("top", 10, "How many longest tables to list")
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Скорость копирования: из флага или из истории запусков
+	var throughput float64
+	var historyRuns int
+	if *throughputFlag != "" {
+		size, err := parseByteSize(*throughputFlag)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("некорректное значение -throughput: %s", *throughputFlag)
+		}
+		throughput = float64(size)
+	} else if throughput, historyRuns, err = backupThroughput(db, durationHistory); err != nil {
+		return fmt.Errorf("ошибка чтения истории запусков: %w", err)
+	}
+
+	target, source := config, db
+	if *path != configFile {
+		if target, err = loadConfig(*path); err != nil {
+			return fmt.Errorf("ошибка загрузки конфигурации %s: %w", *path, err)
+		}
+		// Каталог в оцениваемой базе не создается: она может еще не обслуживаться dbacker
+		if source, err = connectToPostgres(&target.Postgres); err != nil {
+			return fmt.Errorf("ошибка подключения к PostgreSQL: %w", err)
+		}
+		defer source.Close()
+	}
+	cfg := &target.Backup
+
+	model, err := loadTableModel(source, cfg)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка таблиц: %w", err)
+	}
+	tables := model.backupTables(cfg)
+	totals := model.sizes(tables)
+	data, err := getTableDataSizes(source, tables)
+	if err != nil {
+		return fmt.Errorf("ошибка получения размеров таблиц: %w", err)
+	}
+	server, err := getServerCapacity(source)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения настроек сервера: %w", err)
+	}
+
+	// Существующие бэкапы лежат на сервере бэкапов в режиме postgres_fdw
+	backupDB, err := openBackupDatabase(target, source)
+	if err != nil {
+		return err
+	}
+	if backupDB != source {
+		defer backupDB.Close()
+	}
+	backups, err := getBackupTables(backupDB, cfg.Prefix)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка бэкапов: %w", err)
+	}
+	var existing int64
+	for _, b := range backups {
+		existing += b.Size
+	}
+
+	var list []simulatedTable
+	var copySize, cached int64
+	for _, t := range tables {
+		st := simulatedTable{Table: t, Size: data[t], Read: totals[t]}
+		if throughput > 0 {
+			st.Duration = time.Duration(float64(st.Read) / throughput * float64(time.Second))
+		}
+		copySize += st.Size
+		// Последовательное чтение таблиц больше четверти shared_buffers идет через кольцевой буфер
+		// и почти не вытесняет страницы из shared_buffers, меньшие таблицы читаются в общий кэш
+		if st.Size <= server.SharedBuffers/4 {
+			cached += st.Size
+		}
+		list = append(list, st)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Read == list[j].Read {
+			return list[i].Table < list[j].Table
+		}
+		return list[i].Read > list[j].Read
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "База\t%s (%s)\n", target.Postgres.DBName, ByteSize(server.DatabaseSize))
+	fmt.Fprintf(w, "Таблиц\t%d\n", len(tables))
+	fmt.Fprintf(w, "Объем копирования\t%s\n", ByteSize(copySize))
+
+	switch {
+	case target.Remote.remoteEnabled():
+		fmt.Fprintf(w, "WAL\t%s на сервере бэкапов (postgres_fdw), на исходном сервере только чтение\n", ByteSize(copySize))
+	case server.WALLevel == "minimal":
+		fmt.Fprintf(w, "WAL\tпочти не пишется (wal_level = minimal, CREATE TABLE AS без WAL)\n")
+	default:
+		fmt.Fprintf(w, "WAL\t~%s (wal_level = %s: каждая копия целиком проходит через WAL и реплики)\n", ByteSize(copySize), server.WALLevel)
+	}
+
+	keep := int64(cfg.Retention + 1)
+	steady := copySize * keep
+	fmt.Fprintf(w, "Рост диска за запуск\t%s\n", ByteSize(copySize))
+	fmt.Fprintf(w, "Бэкапы при хранении %d дней\t%s (сейчас %s, прирост %s)\n",
+		cfg.Retention, ByteSize(steady), ByteSize(existing), ByteSize(max(steady-existing, 0)))
+	if cfg.MaxTotalBackupSize > 0 && steady > int64(cfg.MaxTotalBackupSize) {
+		fmt.Fprintf(w, "\tпревышает max_total_backup_size %s: запуски будут %s\n", cfg.MaxTotalBackupSize, budgetOutcome(cfg))
+	}
+
+	fmt.Fprintf(w, "Чтение\t%s", ByteSize(copySize))
+	if server.EffectiveCacheSize > 0 {
+		fmt.Fprintf(w, " (%.0f%% effective_cache_size %s)", float64(copySize)*100/float64(server.EffectiveCacheSize), ByteSize(server.EffectiveCacheSize))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Вытеснение shared_buffers\tдо %s из %s (таблицы больше %s читаются через кольцевой буфер)\n",
+		ByteSize(min(cached, server.SharedBuffers)), ByteSize(server.SharedBuffers), ByteSize(server.SharedBuffers/4))
+
+	if throughput > 0 {
+		var total time.Duration
+		for _, st := range list {
+			total += st.Duration
+		}
+		basis := fmt.Sprintf("по %d прошлым запускам", historyRuns)
+		if *throughputFlag != "" {
+			basis = "задана -throughput"
+		}
+		fmt.Fprintf(w, "Длительность\t~%s при %s/с (%s)\n", total.Round(time.Second), ByteSize(int64(throughput)), basis)
+	} else {
+		fmt.Fprintf(w, "Длительность\tнеизвестна: нет истории успешных запусков, укажите -throughput\n")
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if throughput > 0 && len(list) > 0 {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Таблица\tРазмер\tКопия\tДлительность")
+		for i, st := range list {
+			if i == *top {
+				break
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", st.Table, ByteSize(st.Read), ByteSize(st.Size), st.Duration.Round(time.Second))
+		}
+		return w.Flush()
+	}
+	return nil
+}
+
+// budgetOutcome описывает, что произойдет при превышении лимита размера бэкапов
+func budgetOutcome(cfg *BackupConfig) string {
+	if cfg.BudgetPolicy == "prune" {
+		return "удалять самые старые бэкапы раньше срока хранения"
+	}
+	return "отклоняться"
+}
+
+// getTableDataSizes возвращает размеры данных таблиц с TOAST, но без индексов: столько места
+// занимает копия CREATE TABLE AS. Для гипертаблиц TimescaleDB берется размер всех чанков.
+func getTableDataSizes(db *sql.DB, tables []string) (map[string]int64, error) {
+	rows, err := db.Query(`
+		SELECT table_name, pg_table_size(quote_ident(table_schema) || '.' || quote_ident(table_name))
+		FROM information_schema.tables
+		WHERE table_schema = current_schema()`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	all := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var size int64
+		if err := rows.Scan(&name, &size); err != nil {
+			return nil, err
+		}
+		all[name] = size
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	hypertables, err := hypertableSizes(db)
+	if err != nil {
+		return nil, err
+	}
+	for name, size := range hypertables {
+		all[name] = size
+	}
+
+	sizes := make(map[string]int64, len(tables))
+	for _, t := range tables {
+		sizes[t] = all[t]
+	}
+	return sizes, nil
+}
+
+// getServerCapacity читает настройки сервера, влияющие на WAL и кэш
+func getServerCapacity(db *sql.DB) (*serverCapacity, error) {
+	var s serverCapacity
+	err := db.QueryRow(`
+		SELECT current_setting('wal_level'),
+			pg_size_bytes(current_setting('shared_buffers')),
+			pg_size_bytes(current_setting('effective_cache_size')),
+			pg_database_size(current_database())`).Scan(&s.WALLevel, &s.SharedBuffers, &s.EffectiveCacheSize, &s.DatabaseSize)
+	return &s, err
+}
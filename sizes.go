@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+func init() {
+	commands["sizes"] = command{
+		Usage: "Report disk usage of backup tables vs their sources",
+		Run:   cmdSizes,
+	}
+}
+
+// tableUsage место, занимаемое бэкапами одной исходной таблицы
+type tableUsage struct {
+	Table       string
+	SourceSize  int64
+	Backups     int
+	BackupsSize int64
+}
+
+// cmdSizes выводит размеры бэкапов по исходным таблицам, начиная с самых крупных потребителей
+func cmdSizes(args []string) error {
+	fs := flag.NewFlagSet("sizes", flag.ExitOnError)
+	top := fs.Int("top", 5, "How many biggest consumers to highlight")
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	prefix := config.Backup.Prefix
+
+	backupDB, err := openBackupDatabase(config, db)
+	if err != nil {
+		return err
+	}
+	if backupDB != db {
+		defer backupDB.Close()
+	}
+
+	backups, err := getBackupTables(backupDB, prefix)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка бэкапов: %w", err)
+	}
+	model, err := loadTableModel(db, &config.Backup)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка таблиц: %w", err)
+	}
+	sources, err := backupSources(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения каталога: %w", err)
+	}
+	tables := model.backupTables(&config.Backup)
+	sizes := model.sizes(tables)
+
+	usage := make(map[string]*tableUsage)
+	for _, t := range tables {
+		usage[t] = &tableUsage{Table: t, SourceSize: sizes[t]}
+	}
+	for _, b := range backups {
+		source := backupSourceOf(b.Name, prefix, sources)
+		u, ok := usage[source]
+		if !ok {
+			// бэкапы таблицы, которой уже нет в базе
+			u = &tableUsage{Table: source}
+			usage[source] = u
+		}
+		u.Backups++
+		u.BackupsSize += b.Size
+	}
+
+	list := make([]*tableUsage, 0, len(usage))
+	for _, u := range usage {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].BackupsSize == list[j].BackupsSize {
+			return list[i].Table < list[j].Table
+		}
+		return list[i].BackupsSize > list[j].BackupsSize
+	})
+
+	var totalSource, totalBackups int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tТаблица\tРазмер\tБэкапов\tРазмер бэкапов\tДоля")
+	for _, u := range list {
+		totalSource += u.SourceSize
+		totalBackups += u.BackupsSize
+	}
+	for i, u := range list {
+		mark := ""
+		if i < *top && u.BackupsSize > 0 {
+			mark = "*"
+		}
+		share := 0.0
+		if totalBackups > 0 {
+			share = float64(u.BackupsSize) * 100 / float64(totalBackups)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%.1f%%\n",
+			mark, u.Table, ByteSize(u.SourceSize), u.Backups, ByteSize(u.BackupsSize), share)
+	}
+	fmt.Fprintf(w, "\tИтого\t%s\t%d\t%s\t\n", ByteSize(totalSource), len(backups), ByteSize(totalBackups))
+	return w.Flush()
+}
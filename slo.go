@@ -0,0 +1,207 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+// SLOConfig цель по свежести бэкапа таблицы, например "payments должна иметь успешный бэкап не старше 24h"
+type SLOConfig struct {
+	Table  string   `json:"table"`   // Исходная таблица
+	Schema string   `json:"schema"`  // Схема арендатора в режиме backup.schemas (по умолчанию public)
+	MaxAge Duration `json:"max_age"` // Максимальный возраст последнего успешного бэкапа, например "24h"
+	WarnAt float64  `json:"warn_at"` // Доля max_age, после которой цель считается под угрозой (по умолчанию 0.75)
+}
+
+// Состояния цели по свежести
+const (
+	sloOK       = "ok"       // бэкап свежий
+	sloBurning  = "burning"  // прошло больше warn_at от max_age, следующий бэкап нужен скоро
+	sloBreached = "breached" // бэкап старше max_age или его нет
+)
+
+func init() {
+	commands["check"] = command{
+		Usage: "Evaluate backup age SLOs from the catalog (exit code 1 on breach)",
+		Run:   cmdCheck,
+	}
+}
+
+// sloStatus состояние цели по свежести на момент проверки
+type sloStatus struct {
+	SLO        SLOConfig
+	LastBackup time.Time     // время последнего успешного бэкапа (нулевое, если бэкапа нет)
+	Age        time.Duration // возраст последнего бэкапа
+	State      string
+}
+
+// label возвращает имя таблицы цели с учетом схемы арендатора
+func (s *sloStatus) label() string {
+	if s.SLO.Schema != "" {
+		return s.SLO.Schema + "." + s.SLO.Table
+	}
+	return s.SLO.Table
+}
+
+// validateSLOs проверяет цели по свежести и задает значения по умолчанию
+func validateSLOs(slos []SLOConfig) error {
+	for i := range slos {
+		s := &slos[i]
+		if s.Table == "" {
+			return fmt.Errorf("для slos[%d] не указана таблица", i)
+		}
+		if s.MaxAge <= 0 {
+			return fmt.Errorf("для slo таблицы %s не указан max_age", s.Table)
+		}
+		if s.WarnAt == 0 {
+			s.WarnAt = 0.75
+		}
+		if s.WarnAt < 0 || s.WarnAt > 1 {
+			return fmt.Errorf("warn_at для slo таблицы %s должен быть от 0 до 1", s.Table)
+		}
+	}
+	return nil
+}
+
+// evaluateSLOs вычисляет состояние целей по свежести по каталогу
+func evaluateSLOs(db *sql.DB, slos []SLOConfig) ([]sloStatus, error) {
+	lastBySchema := make(map[string]map[string]time.Time)
+	now := clock.Now()
+
+	statuses := make([]sloStatus, 0, len(slos))
+	for _, slo := range slos {
+		last, ok := lastBySchema[slo.Schema]
+		if !ok {
+			var err error
+			if last, err = lastTableBackups(db, slo.Schema); err != nil {
+				return nil, err
+			}
+			lastBySchema[slo.Schema] = last
+		}
+
+		st := sloStatus{SLO: slo, LastBackup: last[slo.Table], State: sloBreached}
+		if !st.LastBackup.IsZero() {
+			st.Age = now.Sub(st.LastBackup)
+			maxAge := time.Duration(slo.MaxAge)
+			switch {
+			case st.Age > maxAge:
+			case float64(st.Age) > float64(maxAge)*slo.WarnAt:
+				st.State = sloBurning
+			default:
+				st.State = sloOK
+			}
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+// cmdCheck проверяет цели по свежести бэкапов и завершается с ошибкой, если хотя бы одна нарушена
+func cmdCheck(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	notify := fs.Bool("notify", false, "Send notifications with policy on_slo for burning and breached SLOs")
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if len(config.SLOs) == 0 {
+		fmt.Println("Цели по свежести бэкапов (slos) не заданы")
+		return nil
+	}
+	statuses, err := evaluateSLOs(db, config.SLOs)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения каталога: %w", err)
+	}
+
+	var breached int
+	for _, st := range statuses {
+		last := "нет бэкапа"
+		if !st.LastBackup.IsZero() {
+			last = fmt.Sprintf("%s (%s назад)", st.LastBackup.Local().Format("2006-01-02 15:04"), st.Age.Round(time.Minute))
+		}
+		fmt.Printf("%-9s %-40s не старше %-8s последний бэкап: %s\n",
+			st.State, st.label(), time.Duration(st.SLO.MaxAge), last)
+		if st.State == sloBreached {
+			breached++
+		}
+	}
+
+	if *notify {
+		notifySLOs(config.Notifications, statuses)
+	}
+	if breached > 0 {
+		return fmt.Errorf("нарушено целей по свежести бэкапов: %d из %d", breached, len(statuses))
+	}
+	return nil
+}
+
+// notifySLOs отправляет получателям с политикой on_slo сведения о целях под угрозой и нарушенных целях
+func notifySLOs(notifiers []NotifierConfig, statuses []sloStatus) {
+	var alerts []sloStatus
+	for _, st := range statuses {
+		if st.State != sloOK {
+			alerts = append(alerts, st)
+		}
+	}
+	if len(alerts) == 0 {
+		return
+	}
+
+	for _, n := range notifiers {
+		if !hasPolicy(&n, "on_slo") {
+			continue
+		}
+		if err := sendSLONotification(&n, alerts); err != nil {
+			log.Printf("Ошибка отправки уведомления (%s): %v", n.Type, err)
+		}
+	}
+}
+
+// hasPolicy проверяет, что у получателя включена политика p
+func hasPolicy(n *NotifierConfig, p string) bool {
+	for _, policy := range n.Policy {
+		if policy == p {
+			return true
+		}
+	}
+	return false
+}
+
+// sendSLONotification отправляет получателю уведомление о целях по свежести
+func sendSLONotification(n *NotifierConfig, alerts []sloStatus) error {
+	var payload interface{}
+	switch n.Type {
+	case "slack":
+		text := "dbacker: цели по свежести бэкапов под угрозой или нарушены"
+		for _, st := range alerts {
+			age := "нет бэкапа"
+			if !st.LastBackup.IsZero() {
+				age = st.Age.Round(time.Minute).String()
+			}
+			text += fmt.Sprintf("\n%s %s: возраст %s, цель %s", st.State, st.label(), age, time.Duration(st.SLO.MaxAge))
+		}
+		payload = map[string]string{"text": text}
+	default:
+		items := make([]map[string]interface{}, len(alerts))
+		for i, st := range alerts {
+			items[i] = map[string]interface{}{
+				"table":       st.SLO.Table,
+				"schema":      st.SLO.Schema,
+				"state":       st.State,
+				"max_age_sec": time.Duration(st.SLO.MaxAge).Seconds(),
+				"age_sec":     st.Age.Seconds(),
+				"last_backup": st.LastBackup,
+			}
+		}
+		payload = map[string]interface{}{"kind": "slo", "slos": items}
+	}
+
+	return postJSON(n.URL, payload)
+}
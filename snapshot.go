@@ -0,0 +1,156 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+
+	"dbacker/dberrors"
+)
+
+func init() {
+	commands["backup"] = command{
+		Usage: "Back up a single table now, as a labeled snapshot (backup -table orders -label before_fix)",
+		Run:   cmdBackup,
+	}
+}
+
+// snapshotLabel допустимая метка снимка: она входит в имя таблицы бэкапа
+var snapshotLabel = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// cmdBackup создает внеплановый бэкап одной таблицы, например перед ручным изменением данных
+func cmdBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	run := fs.Bool("run", false, "Normal run instead of test run?")
+	table := fs.String("table", "", "Table to back up")
+	label := fs.String("label", "", "Snapshot label, part of the backup name (default: current time HHMMSS)")
+	fs.Parse(args)
+	if *table == "" {
+		return fmt.Errorf("использование: dbacker backup -table <table> [-label name] [-run]")
+	}
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	backupDB, err := openBackupDatabase(config, db)
+	if err != nil {
+		return err
+	}
+	if backupDB != db {
+		defer backupDB.Close()
+	}
+
+	_, err = runSnapshot(db, backupDB, config, *table, *label, *run, nil)
+	return err
+}
+
+// runSnapshot создает бэкап одной таблицы {prefix}_{table}_{label}_{YYYYMMDD}. Снимок записывается в каталог
+// как запуск вида "snapshot" с меткой и удаляется политикой хранения вместе с обычными бэкапами.
+func runSnapshot(db, backupDB *sql.DB, config *Config, table, label string, realRun bool, progress progressFunc) (*runResult, error) {
+	progress = progress.orNop()
+	startedAt := clock.Now()
+	if label == "" {
+		label = startedAt.Format("150405")
+	}
+	result := &runResult{Tables: 1, Date: startedAt.Format("20060102"), Label: label}
+
+	err := snapshotTable(db, backupDB, config, table, result, realRun, progress)
+	if err != nil {
+		result.addFailure(fmt.Sprintf("%s: %v", table, err))
+		progress(progressEvent{Type: eventTableFailed, Table: table, Error: err.Error(), Kind: dberrors.Code(err)})
+	}
+	if config.Ledger.Enabled && realRun && err == nil {
+		if ledgerErr := recordLedger(db, backupDB, &config.Ledger, ledgerEventBackup, result.Created); ledgerErr != nil {
+			log.Printf("Ошибка записи в журнал контрольных сумм: %v", ledgerErr)
+		}
+	}
+	if config.Backup.DaySchemas && err == nil {
+		if syncErr := syncDaySchemas(backupDB, &config.Backup, realRun); syncErr != nil {
+			log.Printf("Ошибка обновления схем дней: %v", syncErr)
+		}
+	}
+	if realRun {
+		finishRun(db, config, runKindSnapshot, startedAt, result, err)
+	}
+
+	finished := progressEvent{Type: eventRunFinished, Tables: 1, Failed: len(result.Failed)}
+	if err != nil {
+		finished.Error = err.Error()
+	}
+	progress(finished)
+	return result, err
+}
+
+// snapshotTable проверяет таблицу и метку и копирует таблицу так же, как обычный запуск
+func snapshotTable(db, backupDB *sql.DB, config *Config, table string, result *runResult, realRun bool, progress progressFunc) error {
+	cfg := &config.Backup
+	if !snapshotLabel.MatchString(result.Label) {
+		return fmt.Errorf("метка %q должна состоять из строчных латинских букв, цифр и _ (до 32 символов)", result.Label)
+	}
+	model, err := loadTableModel(db, cfg)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка таблиц: %w", err)
+	}
+	found := false
+	for _, t := range model.backupTables(cfg) {
+		found = found || t == table
+	}
+	if !found {
+		return fmt.Errorf("таблица %s не найдена или исключена из бэкапа", table)
+	}
+	meta := model.meta(table)
+	if config.Remote.remoteEnabled() {
+		if realRun {
+			if err := provisionFDW(db, &config.Remote); err != nil {
+				return fmt.Errorf("ошибка настройки postgres_fdw: %w", err)
+			}
+		}
+		if err := checkRemoteCompatibility(db, backupDB, &config.Remote, model, []string{table}); err != nil {
+			return err
+		}
+	}
+
+	backup := fmt.Sprintf("%s_%s_%s_%s", cfg.Prefix, table, result.Label, result.Date)
+	progress(progressEvent{Type: eventRunStarted, Tables: 1})
+	progress(progressEvent{Type: eventTableStarted, Table: table, Backup: backup})
+	var copied copyResult
+	if realRun {
+		opts := copyOptions{Nowait: cfg.LockStrategy == "nowait", Columns: meta.Columns, Checks: tableValidations(cfg, table)}
+		for _, t := range cfg.VerifyTables {
+			opts.Hash = opts.Hash || t == table
+		}
+		err := createBackupWithRetry(cfg, table, backup, func(table, backup string) error {
+			var err error
+			copied, err = copyToBackup(db, backupDB, config, table, backup, opts)
+			return classifyDDLError(db, table, meta, err)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Создан снимок таблицы %s как %s", table, backup)
+	progress(progressEvent{Type: eventTableFinished, Table: table, Backup: backup, Rows: copied.Rows})
+	a := artifact{
+		Kind:      artifactKindTable,
+		Source:    table,
+		Path:      backup,
+		Size:      meta.Size,
+		CreatedAt: clock.Now(),
+		SourceOID: meta.OID,
+	}
+	var invalid error
+	if realRun {
+		invalid = validateBackup(backupDB, table, backup, tableValidations(cfg, table), copied.Checks)
+	}
+	if invalid != nil {
+		a.Invalid = invalid.Error()
+	}
+	result.addBackup(createdBackup{Source: table, Backup: backup}, a)
+	return invalid
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+)
+
+func init() {
+	commands["status"] = command{
+		Usage: "Show last run, backups count and size, stale tables",
+		Run:   cmdStatus,
+	}
+}
+
+// cmdStatus выводит результат последнего запуска, количество и объем бэкапов и устаревшие таблицы
+func cmdStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	staleDays := fs.Int("stale-days", 1, "Table is stale when its newest backup is older than this many days")
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	prefix := config.Backup.Prefix
+
+	backupDB, err := openBackupDatabase(config, db)
+	if err != nil {
+		return err
+	}
+	if backupDB != db {
+		defer backupDB.Close()
+	}
+
+	// Последний запуск
+	runs, err := lastRuns(db, runKindTables, config.Postgres.Schema, 1)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения каталога запусков: %w", err)
+	}
+	if len(runs) == 0 {
+		fmt.Println("Последний запуск:    нет данных")
+	} else {
+		r := runs[0]
+		fmt.Printf("Последний запуск:    %s, статус %s, длительность %s, таблиц %d, ошибок %d\n",
+			r.StartedAt.Local().Format("2006-01-02 15:04:05"), r.Status, r.Duration().Round(time.Second),
+			r.TablesTotal, r.TablesFailed)
+		if r.Error != "" {
+			fmt.Printf("Ошибка:              %s\n", r.Error)
+		}
+	}
+	// У dbacker нет режима демона с собственным расписанием: запуски планируются снаружи
+	fmt.Println("Следующий запуск:    неизвестен, расписание задается снаружи (cron, systemd timer, POST /backup в dbacker serve)")
+
+	// Существующие бэкапы
+	backups, err := getBackupTables(backupDB, prefix)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка бэкапов: %w", err)
+	}
+	sources, err := backupSources(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения каталога: %w", err)
+	}
+	var total int64
+	latest := make(map[string]string)
+	for _, b := range backups {
+		total += b.Size
+		source := backupSourceOf(b.Name, prefix, sources)
+		if b.Date > latest[source] {
+			latest[source] = b.Date
+		}
+	}
+	fmt.Printf("Бэкапов:             %d, занято %s\n", len(backups), ByteSize(total))
+
+	// Бэкапы, не прошедшие проверки данных
+	invalid, err := invalidBackups(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения каталога: %w", err)
+	}
+	if len(invalid) > 0 {
+		fmt.Printf("Не прошли проверки:  %d\n", len(invalid))
+		for _, a := range invalid {
+			fmt.Printf("  %s: %s\n", a.Path, a.Invalid)
+		}
+	}
+
+	// Таблицы без свежего бэкапа
+	tables, err := getTablesToBackup(db, &config.Backup)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка таблиц: %w", err)
+	}
+	threshold := clock.Now().AddDate(0, 0, -*staleDays).Format("20060102")
+	var stale []string
+	for _, t := range tables {
+		if latest[t] < threshold {
+			stale = append(stale, t)
+		}
+	}
+	sort.Strings(stale)
+	fmt.Printf("Устаревшие таблицы:  %d\n", len(stale))
+	for _, t := range stale {
+		last := latest[t]
+		if last == "" {
+			last = "нет бэкапа"
+		}
+		fmt.Printf("  %s (последний бэкап: %s)\n", t, last)
+	}
+
+	return nil
+}
+
+// backupSourceOf возвращает исходную таблицу бэкапа по каталогу (sources из backupSources), а для бэкапов,
+// которых в каталоге нет, - по имени. Имена снимков и страховочных копий {prefix}_{table}_{label}_{YYYYMMDD}
+// по имени однозначно не разбираются, но они всегда записываются в каталог
+func backupSourceOf(backupName, prefix string, sources map[string]string) string {
+	if source, ok := sources[backupName]; ok {
+		return source
+	}
+	return backupSource(backupName, prefix)
+}
+
+// backupSource возвращает имя исходной таблицы по имени таблицы бэкапа {prefix}_{table}_{YYYYMMDD}
+func backupSource(backupName, prefix string) string {
+	start := len(prefix) + 1
+	end := len(backupName) - 9
+	if end <= start {
+		return ""
+	}
+	return backupName[start:end]
+}
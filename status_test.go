@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestBackupSourceOf(t *testing.T) {
+	sources := map[string]string{
+		"backup_orders_before_fix_20240131":   "orders",
+		"backup_orders_guard_120000_20240131": "orders",
+		"backup_order_items_20240131":         "order_items",
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"backup_orders_before_fix_20240131", "orders"},
+		{"backup_orders_guard_120000_20240131", "orders"},
+		{"backup_order_items_20240131", "order_items"},
+		// бэкапы, созданные до появления каталога, разбираются по имени
+		{"backup_users_20240130", "users"},
+		{"backup_20240130", ""},
+	}
+	for _, tt := range tests {
+		if got := backupSourceOf(tt.name, "backup", sources); got != tt.want {
+			t.Errorf("backupSourceOf(%q) = %q, ожидалось %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBackupSource(t *testing.T) {
+	sources := map[string]string{
+		"autobackup_orders_20240131":        "orders",
+		"autobackup_public.orders_20240131": "public.orders",
+		"autobackup_order_items_20240131":   "order_items",
+		"autobackup_20240131":               "",
+		"autobackup":                        "",
+	}
+	for name, want := range sources {
+		if got := backupSource(name, "autobackup"); got != want {
+			t.Errorf("backupSource(%q) = %q, ожидалось %q", name, got, want)
+		}
+	}
+	if got := backupSource("bk_x_20240131", "bk"); got != "x" {
+		t.Errorf("короткий префикс: %q", got)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DestinationConfig настройки удаленного хранилища, куда копируются файлы бэкапа (дампы и выгрузки)
+type DestinationConfig struct {
+	Name string `json:"name"` // Имя хранилища для каталога и логов
+	Type string `json:"type"` // Тип хранилища: "webdav" или "rclone"
+
+	URL                string `json:"url"`                  // Адрес корневой коллекции WebDAV
+	User               string `json:"user"`                 // Пользователь для basic auth
+	Password           string `json:"password"`             // Пароль для basic auth
+	CACert             string `json:"ca_cert"`              // Файл с сертификатом CA для проверки сервера
+	ClientCert         string `json:"client_cert"`          // Клиентский сертификат
+	ClientKey          string `json:"client_key"`           // Ключ клиентского сертификата
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"` // Не проверять сертификат сервера
+
+	Remote       string `json:"remote"`        // Remote rclone с путем, например "s3:bucket/dbacker"
+	RcloneBinary string `json:"rclone_binary"` // Путь к rclone (по умолчанию "rclone")
+	RcloneConfig string `json:"rclone_config"` // Файл конфигурации rclone (по умолчанию стандартный)
+
+	// S3-бакет с включенным Object Lock, в котором лежат файлы этого remote: dbacker hold ставит на них
+	// legal hold через aws CLI. Ключ объекта - object_lock_prefix + ключ dbacker.
+	ObjectLockBucket string `json:"object_lock_bucket"`
+	ObjectLockPrefix string `json:"object_lock_prefix"` // Префикс ключей в бакете (путь remote внутри бакета)
+	AWSBinary        string `json:"aws_binary"`         // Путь к aws CLI (по умолчанию "aws")
+
+	PricePerGB float64 `json:"price_per_gb"` // Цена хранения гигабайта в месяц с учетом класса хранения, для отчета dbacker cost
+
+	ReplicaOf string `json:"replica_of"` // Имя хранилища, копии из которого реплицируются сюда командой replicate
+
+	// Шаблон ключа (text/template), например "{{.DB}}/{{.Year}}/{{.Month}}/{{.Name}}" (по умолчанию "{{.Name}}")
+	KeyTemplate string `json:"key_template"`
+
+	UploadConcurrency int      `json:"upload_concurrency"` // Сколько файлов загружается одновременно (по умолчанию 1)
+	UploadRetries     int      `json:"upload_retries"`     // Сколько повторов загрузки допускается за запуск на все файлы вместе
+	UploadRetryDelay  Duration `json:"upload_retry_delay"` // Пауза перед первым повтором, удваивается с каждой попыткой (по умолчанию "5s")
+	UploadOrder       string   `json:"upload_order"`       // Порядок загрузки: "created" (по умолчанию) или "smallest_first"
+}
+
+// storage удаленное хранилище файлов; ключ - путь относительно корня хранилища через "/"
+type storage interface {
+	// Put загружает локальный файл под указанным ключом
+	Put(localPath, key string) error
+	// Delete удаляет файл или каталог по ключу
+	Delete(key string) error
+}
+
+// replicator хранилище, которое умеет копировать свои файлы в другое хранилище напрямую, без загрузки через dbacker
+type replicator interface {
+	// Replicate копирует файл или каталог по ключу в хранилище dst под тем же ключом
+	Replicate(key string, dst storage) error
+}
+
+// legalHolder хранилище, которое умеет защищать файлы от удаления на своей стороне (S3 Object Lock)
+type legalHolder interface {
+	// SetLegalHold ставит (on) или снимает legal hold со всех файлов по ключу, включая файлы каталога
+	SetLegalHold(key string, on bool) error
+}
+
+// storageFactories конструкторы хранилищ по типу
+var storageFactories = map[string]func(cfg *DestinationConfig) (storage, error){}
+
+// validateDestinations проверяет настройки удаленных хранилищ
+func validateDestinations(destinations []DestinationConfig) error {
+	names := make(map[string]bool)
+	for i := range destinations {
+		d := &destinations[i]
+		if _, ok := storageFactories[d.Type]; !ok {
+			return fmt.Errorf("неизвестный тип хранилища: %s", d.Type)
+		}
+		if d.Name == "" {
+			d.Name = d.Type
+		}
+		if names[d.Name] {
+			return fmt.Errorf("имя хранилища %s используется несколько раз", d.Name)
+		}
+		if d.UploadConcurrency < 0 || d.UploadRetries < 0 {
+			return fmt.Errorf("хранилище %s: upload_concurrency и upload_retries не могут быть отрицательными", d.Name)
+		}
+		if d.UploadConcurrency == 0 {
+			d.UploadConcurrency = 1
+		}
+		if d.UploadRetryDelay == 0 {
+			d.UploadRetryDelay = Duration(5 * time.Second)
+		}
+		switch d.UploadOrder {
+		case "":
+			d.UploadOrder = uploadOrderCreated
+		case uploadOrderCreated, uploadOrderSmallestFirst:
+		default:
+			return fmt.Errorf("хранилище %s: неизвестный upload_order %q, допустимо created или smallest_first", d.Name, d.UploadOrder)
+		}
+		if d.KeyTemplate != "" {
+			if _, err := template.New(d.Name).Option("missingkey=error").Parse(d.KeyTemplate); err != nil {
+				return fmt.Errorf("хранилище %s: некорректный key_template: %w", d.Name, err)
+			}
+		}
+		names[d.Name] = true
+	}
+
+	for i := range destinations {
+		d := &destinations[i]
+		if d.ReplicaOf == "" {
+			continue
+		}
+		src := findDestination(destinations, d.ReplicaOf)
+		if src == nil || src == d {
+			return fmt.Errorf("хранилище %s: replica_of ссылается на неизвестное хранилище %s", d.Name, d.ReplicaOf)
+		}
+		if src.ReplicaOf != "" {
+			return fmt.Errorf("хранилище %s: репликация из реплики %s не поддерживается", d.Name, src.Name)
+		}
+		if src.Type != "rclone" || d.Type != "rclone" {
+			return fmt.Errorf("хранилище %s: репликация поддерживается только между хранилищами rclone", d.Name)
+		}
+	}
+	return nil
+}
+
+// findDestination возвращает хранилище по имени или nil
+func findDestination(destinations []DestinationConfig, name string) *DestinationConfig {
+	for i := range destinations {
+		if destinations[i].Name == name {
+			return &destinations[i]
+		}
+	}
+	return nil
+}
+
+// openStorage создает клиент хранилища по настройкам
+func openStorage(cfg *DestinationConfig) (storage, error) {
+	return storageFactories[cfg.Type](cfg)
+}
+
+// uploadArtifacts копирует созданные запуском файлы во все удаленные хранилища через очередь загрузки
+// и дожидается ее; если очередь запущена заранее (startUploads), часть файлов уже загружена.
+// Каталоги загружаются пофайлово с сохранением структуры.
+func uploadArtifacts(config *Config, result *runResult, realRun bool) error {
+	if len(config.Destinations) == 0 {
+		return nil
+	}
+
+	q, artifacts := result.takeUploads()
+	var local int
+	for _, a := range artifacts {
+		if a.Destination == "" && a.Kind != artifactKindTable && fileExists(a.Path) {
+			local++
+		}
+	}
+	if q == nil && local == 0 {
+		return nil
+	}
+
+	if !realRun {
+		for _, d := range config.Destinations {
+			if d.ReplicaOf != "" {
+				continue
+			}
+			log.Printf("Тестовый запуск, будет загружено файлов в хранилище %s: %d", d.Name, local)
+		}
+		return nil
+	}
+
+	if q == nil {
+		q = newUploadQueue(config, result)
+	}
+	q.add(artifacts...)
+	return q.wait()
+}
+
+// artifactKeyData поля, доступные в шаблоне ключа key_template
+type artifactKeyData struct {
+	DB     string // база данных
+	Schema string // схема (public или схема арендатора)
+	Table  string // исходная таблица для выгрузок по таблицам (avro, arrow), иначе пусто
+	Source string // источник артефакта: база или таблица
+	Kind   string // вид артефакта: dump, duckdb, avro, cluster...
+	Prefix string // префикс бэкапов
+	Date   string // дата запуска YYYYMMDD
+	Year   string
+	Month  string
+	Day    string
+	Name   string // имя локального файла
+	Ext    string // расширение локального файла с точкой (".dump", ".avro"), пусто для каталогов
+}
+
+// artifactKey возвращает ключ, под которым артефакт загружается в хранилище: имя файла
+// или результат шаблона key_template хранилища
+func artifactKey(d *DestinationConfig, config *Config, result *runResult, a artifact) (string, error) {
+	name := filepath.Base(a.Path)
+	if d.KeyTemplate == "" {
+		return name, nil
+	}
+
+	date := result.Date
+	if len(date) != 8 {
+		date = a.CreatedAt.Format("20060102")
+	}
+	data := artifactKeyData{
+		DB:     config.Postgres.DBName,
+		Schema: config.Postgres.Schema,
+		Source: a.Source,
+		Kind:   a.Kind,
+		Prefix: config.Backup.Prefix,
+		Date:   date,
+		Year:   date[:4],
+		Month:  date[4:6],
+		Day:    date[6:],
+		Name:   name,
+	}
+	if data.Schema == "" {
+		data.Schema = "public"
+	}
+	if a.Source != config.Postgres.DBName {
+		data.Table = a.Source
+	}
+	if i := strings.Index(name, "."); i >= 0 {
+		data.Ext = name[i:]
+	}
+
+	tmpl, err := template.New(d.Name).Option("missingkey=error").Parse(d.KeyTemplate)
+	if err != nil {
+		return "", err
+	}
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("ошибка шаблона key_template: %w", err)
+	}
+	key := strings.Trim(path.Clean("/"+buf.String()), "/")
+	if key == "" {
+		return "", fmt.Errorf("шаблон key_template дал пустой ключ")
+	}
+	return key, nil
+}
+
+// putPath загружает файл или каталог (рекурсивно) под ключом key
+func putPath(st storage, path, key string) error {
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		k := key
+		if rel != "." {
+			k = key + "/" + filepath.ToSlash(rel)
+		}
+		return st.Put(p, k)
+	})
+}
+
+// pruneRemoteArtifacts удаляет из удаленных хранилищ копии старше срока хранения
+func pruneRemoteArtifacts(db *sql.DB, config *Config, realRun bool) error {
+	if len(config.Destinations) == 0 {
+		return nil
+	}
+	if realRun {
+		if err := ensureCatalog(db); err != nil {
+			return err
+		}
+	}
+
+	threshold := clock.Now().AddDate(0, 0, -config.Backup.Retention)
+	expired, err := expiredRemoteArtifacts(db, threshold)
+	if err != nil {
+		return err
+	}
+	retained, err := retentionOverrides(db)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения продлений хранения: %w", err)
+	}
+
+	storages := make(map[string]storage)
+	for i := range config.Destinations {
+		d := &config.Destinations[i]
+		st, err := openStorage(d)
+		if err != nil {
+			return fmt.Errorf("%s: %w", d.Name, err)
+		}
+		storages[d.Name] = st
+	}
+
+	for _, a := range expired {
+		st, ok := storages[a.Destination]
+		if !ok {
+			// хранилище удалено из конфигурации, удалить копию нечем
+			continue
+		}
+		if until, ok := remoteRetention(a.Path, retained); ok {
+			log.Printf("Копия %s в хранилище %s сохранена до %s", a.Path, a.Destination, until.Format("2006-01-02"))
+			continue
+		}
+		if realRun {
+			if err := st.Delete(a.Path); err != nil {
+				log.Printf("Ошибка удаления %s из хранилища %s: %v", a.Path, a.Destination, err)
+				continue
+			}
+			if err := markRemoteArtifactDeleted(db, a.ID); err != nil {
+				log.Printf("Ошибка обновления каталога для %s: %v", a.Path, err)
+			}
+		}
+		log.Printf("Удалена старая копия из хранилища %s: %s", a.Destination, a.Path)
+	}
+	return nil
+}
+
+// remoteRetention находит действующее продление хранения (retain или удержание) для копии в удаленном хранилище.
+// Копия относится к бэкапу по имени файла: это копия продленного файла с тем же именем или выгрузка
+// продленной таблицы бэкапа ({backup}.{ext}), поэтому key_template должен сохранять имя файла {{.Name}}.
+func remoteRetention(key string, retained map[string]time.Time) (time.Time, bool) {
+	name := path.Base(key)
+	backup := name
+	if i := strings.Index(name, "."); i >= 0 {
+		backup = name[:i]
+	}
+	for b, until := range retained {
+		if b := filepath.Base(b); (b == name || b == backup) && isRetained(until) {
+			return until, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// fileExists проверяет, что локальный файл или каталог существует
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// deliverArtifacts загружает файлы запуска в удаленные хранилища и удаляет оттуда устаревшие копии
+func deliverArtifacts(db *sql.DB, config *Config, result *runResult, realRun bool) error {
+	err := uploadArtifacts(config, result, realRun)
+	if pruneErr := pruneRemoteArtifacts(db, config, realRun); pruneErr != nil {
+		log.Printf("Ошибка удаления старых копий из удаленных хранилищ: %v", pruneErr)
+	}
+	return err
+}
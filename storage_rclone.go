@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func init() {
+	storageFactories["rclone"] = newRcloneStorage
+}
+
+// rcloneStorage хранилище через утилиту rclone: подходит любой настроенный в rclone remote (S3, GCS, Azure, SFTP...)
+type rcloneStorage struct {
+	cfg *DestinationConfig
+}
+
+// newRcloneStorage проверяет настройки remote
+func newRcloneStorage(cfg *DestinationConfig) (storage, error) {
+	if !strings.Contains(cfg.Remote, ":") {
+		return nil, fmt.Errorf("remote должен быть в формате rclone \"имя:путь\", получено %q", cfg.Remote)
+	}
+	return &rcloneStorage{cfg: cfg}, nil
+}
+
+// Put копирует файл в remote под указанным ключом
+func (s *rcloneStorage) Put(localPath, key string) error {
+	return runTool(s.binary(), s.args("copyto", localPath, s.path(key))...)
+}
+
+// Delete удаляет файл, а если по ключу лежит каталог - весь каталог
+func (s *rcloneStorage) Delete(key string) error {
+	err := runTool(s.binary(), s.args("deletefile", s.path(key))...)
+	if err == nil {
+		return nil
+	}
+	if purgeErr := runTool(s.binary(), s.args("purge", s.path(key))...); purgeErr != nil {
+		return err
+	}
+	return nil
+}
+
+// Replicate копирует файл или каталог в другой remote средствами rclone (для облачных хранилищ - на стороне сервера).
+// Оба remote должны быть описаны в одном файле конфигурации rclone.
+func (s *rcloneStorage) Replicate(key string, dst storage) error {
+	d, ok := dst.(*rcloneStorage)
+	if !ok {
+		return fmt.Errorf("репликация rclone возможна только в хранилище rclone")
+	}
+	if d.cfg.RcloneConfig != s.cfg.RcloneConfig {
+		return fmt.Errorf("хранилища %s и %s используют разные файлы конфигурации rclone", s.cfg.Name, d.cfg.Name)
+	}
+	return runTool(s.binary(), s.args("copyto", s.path(key), d.path(key))...)
+}
+
+// SetLegalHold ставит или снимает S3 Object Lock legal hold через aws CLI. Без object_lock_bucket
+// хранилище не поддерживает удержание, и вызов возвращает ошибку.
+func (s *rcloneStorage) SetLegalHold(key string, on bool) error {
+	if s.cfg.ObjectLockBucket == "" {
+		return fmt.Errorf("для хранилища %s не задан object_lock_bucket", s.cfg.Name)
+	}
+	aws := s.cfg.AWSBinary
+	if aws == "" {
+		aws = "aws"
+	}
+	objectKey := strings.TrimSuffix(s.cfg.ObjectLockPrefix, "/")
+	if objectKey != "" {
+		objectKey += "/"
+	}
+	objectKey += key
+
+	// Ключ может оказаться каталогом (выгрузка в формате directory), тогда удерживаются все его файлы
+	cmd := exec.Command(aws, "s3api", "list-objects-v2", "--bucket", s.cfg.ObjectLockBucket,
+		"--prefix", objectKey, "--query", "Contents[].Key", "--output", "text")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var keys []string
+	// --output text разделяет ключи табуляцией, а страницы списка - переводом строки
+	fields := strings.FieldsFunc(stdout.String(), func(r rune) bool { return r == '\t' || r == '\n' })
+	for _, k := range fields {
+		if k == objectKey || strings.HasPrefix(k, objectKey+"/") {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("в бакете %s нет объектов по ключу %s", s.cfg.ObjectLockBucket, objectKey)
+	}
+
+	status := "Status=OFF"
+	if on {
+		status = "Status=ON"
+	}
+	for _, k := range keys {
+		if err := runTool(aws, "s3api", "put-object-legal-hold", "--bucket", s.cfg.ObjectLockBucket,
+			"--key", k, "--legal-hold", status); err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return nil
+}
+
+func (s *rcloneStorage) binary() string {
+	if s.cfg.RcloneBinary != "" {
+		return s.cfg.RcloneBinary
+	}
+	return "rclone"
+}
+
+func (s *rcloneStorage) args(args ...string) []string {
+	if s.cfg.RcloneConfig != "" {
+		return append([]string{"--config", s.cfg.RcloneConfig}, args...)
+	}
+	return args
+}
+
+func (s *rcloneStorage) path(key string) string {
+	return strings.TrimSuffix(s.cfg.Remote, "/") + "/" + key
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRemoteRetention(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	setFakeClock(t, now)
+	retained := map[string]time.Time{
+		"backup_orders_20240131":            now.AddDate(0, 1, 0),
+		"dumps/backup_main_20240131.dump":   now,
+		"backup_users_20240131":             now.AddDate(0, 0, -1),
+		"exports/backup_items_20240131.csv": heldUntil,
+	}
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		// выгрузки продленной таблицы бэкапа в любом формате
+		{"backup_orders_20240131.csv.gz", true},
+		{"main/2024/01/backup_orders_20240131.parquet", true},
+		// копия продленного файла, продление действует до конца дня
+		{"backup_main_20240131.dump", true},
+		{"backup_items_20240131.csv", true},
+		{"backup_items_20240131.parquet", false},
+		// продление истекло
+		{"backup_users_20240131.csv", false},
+		{"backup_orders_20240130.csv", false},
+		{"backup_orders_20240131_extra.csv", false},
+	}
+	for _, tt := range tests {
+		if _, got := remoteRetention(tt.key, retained); got != tt.want {
+			t.Errorf("remoteRetention(%q) = %v, ожидалось %v", tt.key, got, tt.want)
+		}
+	}
+}
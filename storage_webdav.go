@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+func init() {
+	storageFactories["webdav"] = newWebDAVStorage
+}
+
+// webdavStorage хранилище на сервере WebDAV (Nextcloud, ownCloud и т.п.)
+type webdavStorage struct {
+	cfg    *DestinationConfig
+	base   *url.URL
+	client *http.Client
+}
+
+// newWebDAVStorage создает клиент WebDAV с basic auth и настройками TLS
+func newWebDAVStorage(cfg *DestinationConfig) (storage, error) {
+	base, err := url.Parse(strings.TrimSuffix(cfg.URL, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("некорректный адрес WebDAV: %q", cfg.URL)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения ca_cert: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("в %s нет сертификатов", cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка загрузки клиентского сертификата: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &webdavStorage{
+		cfg:    cfg,
+		base:   base,
+		client: &http.Client{Transport: transport, Timeout: 6 * time.Hour},
+	}, nil
+}
+
+// Put создает недостающие коллекции и загружает файл методом PUT
+func (s *webdavStorage) Put(localPath, key string) error {
+	parts := strings.Split(key, "/")
+	for i := 1; i < len(parts); i++ {
+		if err := s.mkcol(strings.Join(parts[:i], "/")); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := s.request(http.MethodPut, key, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	return s.do(req, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+}
+
+// Delete удаляет файл или коллекцию
+func (s *webdavStorage) Delete(key string) error {
+	req, err := s.request(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+}
+
+// mkcol создает коллекцию; 405 означает, что она уже существует
+func (s *webdavStorage) mkcol(key string) error {
+	req, err := s.request("MKCOL", key, nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req, http.StatusCreated, http.StatusMethodNotAllowed)
+}
+
+func (s *webdavStorage) request(method, key string, body io.Reader) (*http.Request, error) {
+	u := *s.base
+	u.Path = s.base.Path + "/" + key
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.User != "" {
+		req.SetBasicAuth(s.cfg.User, s.cfg.Password)
+	}
+	return req, nil
+}
+
+// do выполняет запрос и проверяет, что код ответа входит в ожидаемые
+func (s *webdavStorage) do(req *http.Request, expected ...int) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %s: сервер вернул %s", req.Method, req.URL.Path, resp.Status)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"dbacker/pipeline"
+)
+
+// compilePipeline создает этапы конвейера по настройкам export.pipeline (пакет pipeline)
+func compilePipeline(cfg *ExportConfig) error {
+	cfg.Stages = nil
+	for i := range cfg.Pipeline {
+		s := &cfg.Pipeline[i]
+		stage, err := pipeline.New(s)
+		if err != nil {
+			return fmt.Errorf("этап export.pipeline[%d] (%s): %w", i, s.Type, err)
+		}
+		cfg.Stages = append(cfg.Stages, stage)
+	}
+	return nil
+}
+
+// createPipeline открывает поток в файл path с суффиксами этапов: данные проходят этапы конвейера
+// и записываются в файл, а при export.chunk_size - в каталог с частями part-00000, part-00001...
+// Возвращает writer и итоговый путь; Close writer завершает все этапы.
+func createPipeline(cfg *ExportConfig, path string) (io.WriteCloser, string, error) {
+	path += pipeline.Ext(cfg.Stages)
+	// Повторный запуск за тот же день перезаписывает выгрузку
+	os.RemoveAll(path)
+
+	var w io.WriteCloser
+	if cfg.ChunkSize > 0 {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			return nil, "", err
+		}
+		w = &chunkWriter{dir: path, limit: int64(cfg.ChunkSize)}
+	} else {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, "", err
+		}
+		w = f
+	}
+	w, err := pipeline.Chain(w, cfg.Stages)
+	if err != nil {
+		os.RemoveAll(path)
+		return nil, "", err
+	}
+	return w, path, nil
+}
+
+// pipeArtifacts пропускает готовые файлы выгрузки через конвейер, если он настроен: для выгрузок,
+// которые пишет внешняя программа (duckdb, sqlite3). Исходные файлы удаляются.
+func pipeArtifacts(cfg *ExportConfig, artifacts []artifact) ([]artifact, error) {
+	if len(cfg.Stages) == 0 && cfg.ChunkSize <= 0 {
+		return artifacts, nil
+	}
+	for i := range artifacts {
+		a := &artifacts[i]
+		src, err := os.Open(a.Path)
+		if err != nil {
+			return artifacts, err
+		}
+		w, path, err := createPipeline(cfg, a.Path)
+		if err != nil {
+			src.Close()
+			return artifacts, err
+		}
+		_, err = io.Copy(w, src)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		src.Close()
+		if err != nil {
+			os.RemoveAll(path)
+			return artifacts, fmt.Errorf("%s: %w", a.Path, err)
+		}
+		os.Remove(a.Path)
+		if a.Size, err = pathSize(path); err != nil {
+			return artifacts, err
+		}
+		a.Path, a.CreatedAt = path, clock.Now()
+	}
+	return artifacts, nil
+}
+
+// chunkWriter записывает поток частями не больше limit байт в файлы part-NNNNN каталога dir
+type chunkWriter struct {
+	dir     string
+	limit   int64
+	parts   int
+	cur     *os.File
+	written int64 // записано в текущую часть
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if c.cur == nil {
+			if err := c.next(); err != nil {
+				return total, err
+			}
+		}
+		n := int64(len(p))
+		if n > c.limit-c.written {
+			n = c.limit - c.written
+		}
+		m, err := c.cur.Write(p[:n])
+		total += m
+		c.written += int64(m)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+		if c.written == c.limit {
+			if err := c.cur.Close(); err != nil {
+				return total, err
+			}
+			c.cur = nil
+		}
+	}
+	return total, nil
+}
+
+// next открывает следующую часть
+func (c *chunkWriter) next() error {
+	f, err := os.Create(filepath.Join(c.dir, fmt.Sprintf("part-%05d", c.parts)))
+	if err != nil {
+		return err
+	}
+	c.cur, c.written = f, 0
+	c.parts++
+	return nil
+}
+
+// Close закрывает последнюю часть; пустой поток дает одну пустую часть
+func (c *chunkWriter) Close() error {
+	if c.parts == 0 {
+		if err := c.next(); err != nil {
+			return err
+		}
+	}
+	if c.cur == nil {
+		return nil
+	}
+	err := c.cur.Close()
+	c.cur = nil
+	return err
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// Модель исходных таблиц запуска. В начале запуска метаданные всех таблиц схемы читаются несколькими
+// запросами по всей схеме сразу; список, размеры, oid и колонки таблиц подсистемы запуска берут из модели,
+// а не запрашивают каталог по каждой таблице. На больших базах с тысячами таблиц это сокращает
+// сотни запросов к каталогу до нескольких.
+
+// tableMeta метаданные исходной таблицы на момент начала запуска
+type tableMeta struct {
+	OID        int64
+	Kind       string   // relkind: "r" - таблица, "p" - секционированная таблица, "v" - представление и т.д.
+	Parent     string   // родительская таблица, если таблица - секция или наследница (пусто иначе)
+	Comment    string   // комментарий COMMENT ON TABLE
+	Size       int64    // размер с индексами и TOAST; для гипертаблиц - с чанками, для секционированных - с секциями
+	Columns    []column // колонки в порядке следования
+	PrimaryKey []string // колонки первичного ключа в порядке ключа
+}
+
+// tableModel метаданные таблиц текущей схемы, кроме таблиц бэкапа и каталога
+type tableModel struct {
+	Names    []string // таблицы в порядке, в котором их вернул information_schema
+	Tables   map[string]*tableMeta
+	Internal map[string]bool // внутренние таблицы TimescaleDB (чанки и т.п.), бэкапятся в составе гипертаблиц
+}
+
+// loadTableModel читает метаданные таблиц текущей схемы: таблицы с oid, размерами, секциями и комментариями,
+// колонки и первичные ключи - по одному запросу на всю схему, плюс сведения TimescaleDB, если она установлена
+func loadTableModel(db *sql.DB, cfg *BackupConfig) (*tableModel, error) {
+	rows, err := db.Query(`
+		SELECT c.relname, c.oid::bigint, c.relkind::text, pg_total_relation_size(c.oid),
+			coalesce(obj_description(c.oid, 'pg_class'), ''),
+			coalesce((SELECT p.relname FROM pg_inherits i JOIN pg_class p ON p.oid = i.inhparent
+				WHERE i.inhrelid = c.oid ORDER BY i.inhseqno LIMIT 1), '')
+		FROM information_schema.tables t
+		JOIN pg_namespace n ON n.nspname = t.table_schema
+		JOIN pg_class c ON c.relnamespace = n.oid AND c.relname = t.table_name
+		WHERE t.table_schema = current_schema()
+		AND t.table_name NOT LIKE $1 || '%'
+		AND t.table_name NOT LIKE $2 || '%'`, cfg.Prefix, catalogPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	m := &tableModel{Tables: make(map[string]*tableMeta)}
+	for rows.Next() {
+		var name string
+		t := &tableMeta{}
+		if err := rows.Scan(&name, &t.OID, &t.Kind, &t.Size, &t.Comment, &t.Parent); err != nil {
+			return nil, err
+		}
+		m.Names = append(m.Names, name)
+		m.Tables[name] = t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := m.loadColumns(db, m.Names); err != nil {
+		return nil, err
+	}
+	if err := m.loadPrimaryKeys(db, m.Names); err != nil {
+		return nil, err
+	}
+
+	// Данные гипертаблиц TimescaleDB хранятся в чанках, секционированных таблиц - в секциях
+	hypertables, err := hypertableSizes(db)
+	if err != nil {
+		return nil, err
+	}
+	for name, size := range hypertables {
+		if t := m.Tables[name]; t != nil {
+			t.Size = size
+		}
+	}
+	for _, name := range m.Names {
+		t := m.Tables[name]
+		if t.Kind == "p" {
+			continue
+		}
+		for parent := m.Tables[t.Parent]; parent != nil && parent.Kind == "p"; parent = m.Tables[parent.Parent] {
+			parent.Size += t.Size
+		}
+	}
+
+	if m.Internal, err = timescaleInternalTables(db); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// loadColumns читает колонки указанных таблиц одним запросом
+func (m *tableModel) loadColumns(db *sql.DB, tables []string) error {
+	rows, err := db.Query(`
+		SELECT c.relname, a.attname, format_type(a.atttypid, a.atttypmod), t.typname, a.attnotnull
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_type t ON t.oid = a.atttypid
+		WHERE n.nspname = current_schema() AND c.relname = ANY($1)
+		AND a.attnum > 0
+		AND NOT a.attisdropped
+		ORDER BY c.relname, a.attnum`, pq.Array(tables))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for _, name := range tables {
+		if t := m.Tables[name]; t != nil {
+			t.Columns = nil
+		}
+	}
+	for rows.Next() {
+		var table string
+		var c column
+		if err := rows.Scan(&table, &c.Name, &c.Type, &c.BaseType, &c.NotNull); err != nil {
+			return err
+		}
+		if t := m.Tables[table]; t != nil {
+			t.Columns = append(t.Columns, c)
+		}
+	}
+	return rows.Err()
+}
+
+// loadPrimaryKeys читает первичные ключи указанных таблиц одним запросом
+func (m *tableModel) loadPrimaryKeys(db *sql.DB, tables []string) error {
+	rows, err := db.Query(`
+		SELECT c.relname, a.attname
+		FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		WHERE i.indisprimary AND n.nspname = current_schema() AND c.relname = ANY($1)
+		ORDER BY c.relname, array_position(i.indkey::int2[], a.attnum)`, pq.Array(tables))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for _, name := range tables {
+		if t := m.Tables[name]; t != nil {
+			t.PrimaryKey = nil
+		}
+	}
+	for rows.Next() {
+		var table, name string
+		if err := rows.Scan(&table, &name); err != nil {
+			return err
+		}
+		if t := m.Tables[table]; t != nil {
+			t.PrimaryKey = append(t.PrimaryKey, name)
+		}
+	}
+	return rows.Err()
+}
+
+// backupTables возвращает таблицы, которые нужно бэкапировать: без исключенных шаблонами exclude
+// и внутренних таблиц TimescaleDB (как getTablesToBackup)
+func (m *tableModel) backupTables(cfg *BackupConfig) []string {
+	var tables []string
+	for _, name := range m.Names {
+		if isExcluded(name, cfg.Excludes) || m.Internal[name] {
+			continue
+		}
+		tables = append(tables, name)
+	}
+	return tables
+}
+
+// sizes возвращает размеры указанных таблиц (как getTableSizes)
+func (m *tableModel) sizes(tables []string) map[string]int64 {
+	sizes := make(map[string]int64, len(tables))
+	for _, t := range tables {
+		if meta := m.Tables[t]; meta != nil {
+			sizes[t] = meta.Size
+		}
+	}
+	return sizes
+}
+
+// oids возвращает oid всех таблиц модели (как getTableOIDs)
+func (m *tableModel) oids() map[string]int64 {
+	oids := make(map[string]int64, len(m.Tables))
+	for name, t := range m.Tables {
+		oids[name] = t.OID
+	}
+	return oids
+}
+
+// meta возвращает метаданные таблицы или nil, если таблицы нет в модели
+func (m *tableModel) meta(table string) *tableMeta {
+	if m == nil {
+		return nil
+	}
+	return m.Tables[table]
+}
+
+// columns возвращает колонки таблицы из модели или nil, если таблицы нет в модели
+func (m *tableModel) columns(table string) []column {
+	if t := m.meta(table); t != nil {
+		return t.Columns
+	}
+	return nil
+}
+
+// reload перечитывает oid и колонки таблицы после изменения ее структуры во время запуска
+func (m *tableModel) reload(db *sql.DB, table string) error {
+	t := m.meta(table)
+	if t == nil {
+		return nil
+	}
+	oids, err := getTableOIDs(db, []string{table})
+	if err != nil {
+		return err
+	}
+	t.OID = oids[table]
+	if err := m.loadColumns(db, []string{table}); err != nil {
+		return err
+	}
+	return m.loadPrimaryKeys(db, []string{table})
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TargetConfig отдельная база (или задача) для оркестрации командой dbacker targets: свой каталог
+// с config.ini, в котором dbacker запускается отдельным процессом
+type TargetConfig struct {
+	Name      string   `json:"name"`       // Имя цели для логов и зависимостей
+	Dir       string   `json:"dir"`        // Рабочий каталог с config.ini цели
+	Args      []string `json:"args"`       // Команда dbacker с аргументами, например ["dump"] (по умолчанию бэкап таблиц)
+	Group     string   `json:"group"`      // Цели одной группы выполняются по очереди, разные группы - параллельно
+	DependsOn []string `json:"depends_on"` // Цели, которые должны успешно завершиться до запуска этой
+}
+
+// Состояния целей при оркестрации
+const (
+	targetPending = "pending"
+	targetRunning = "running"
+	targetOK      = "ok"
+	targetFailed  = "failed"
+	targetSkipped = "skipped" // не запускалась, потому что не удалась цель, от которой она зависит
+)
+
+func init() {
+	commands["targets"] = command{
+		Usage: "Run all configured targets respecting groups and dependencies",
+		Run:   cmdTargets,
+	}
+}
+
+// validateTargets проверяет имена, каталоги и зависимости целей, включая отсутствие циклов
+func validateTargets(targets []TargetConfig) error {
+	byName := make(map[string]*TargetConfig)
+	for i := range targets {
+		t := &targets[i]
+		if t.Name == "" || t.Dir == "" {
+			return fmt.Errorf("для targets[%d] нужно указать name и dir", i)
+		}
+		if byName[t.Name] != nil {
+			return fmt.Errorf("имя цели %s используется несколько раз", t.Name)
+		}
+		byName[t.Name] = t
+	}
+	for _, t := range targets {
+		for _, dep := range t.DependsOn {
+			if byName[dep] == nil {
+				return fmt.Errorf("цель %s зависит от неизвестной цели %s", t.Name, dep)
+			}
+		}
+	}
+
+	// Поиск циклов обходом в глубину
+	state := make(map[string]int) // 0 - не посещена, 1 - в обходе, 2 - проверена
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("циклическая зависимость целей: %s", strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, dep := range byName[name].DependsOn {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		return nil
+	}
+	for _, t := range targets {
+		if err := visit(t.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// targetResult завершение цели
+type targetResult struct {
+	Name     string
+	Err      error
+	Duration time.Duration
+}
+
+// cmdTargets запускает цели: группы параллельно, внутри группы по очереди в порядке конфигурации,
+// каждую цель - только после успешного завершения ее зависимостей
+func cmdTargets(args []string) error {
+	fs := flag.NewFlagSet("targets", flag.ExitOnError)
+	run := fs.Bool("run", false, "Normal run instead of test run? (passed to every target)")
+	fs.Parse(args)
+
+	config, err := loadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("ошибка загрузки конфигурации: %w", err)
+	}
+	if len(config.Targets) == 0 {
+		return fmt.Errorf("в конфигурации не заданы цели (targets)")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	state := make(map[string]string)
+	for _, t := range config.Targets {
+		state[t.Name] = targetPending
+	}
+	busyGroups := make(map[string]bool)
+	results := make(map[string]targetResult)
+	done := make(chan targetResult)
+	running := 0
+
+	for {
+		// Запуск всех целей, которые готовы и чья группа свободна
+		for progress := true; progress; {
+			progress = false
+			for _, t := range config.Targets {
+				if state[t.Name] != targetPending {
+					continue
+				}
+				ready := true
+				for _, dep := range t.DependsOn {
+					switch state[dep] {
+					case targetFailed, targetSkipped:
+						log.Printf("[%s] пропущена: не выполнена зависимость %s", t.Name, dep)
+						state[t.Name] = targetSkipped
+						progress = true
+					case targetOK:
+						continue
+					}
+					ready = false
+					break
+				}
+				if !ready || (t.Group != "" && busyGroups[t.Group]) {
+					continue
+				}
+
+				state[t.Name] = targetRunning
+				if t.Group != "" {
+					busyGroups[t.Group] = true
+				}
+				running++
+				go func(t TargetConfig) {
+					started := clock.Now()
+					err := runTarget(executable, t, *run)
+					done <- targetResult{Name: t.Name, Err: err, Duration: clock.Now().Sub(started)}
+				}(t)
+			}
+		}
+		if running == 0 {
+			break
+		}
+
+		r := <-done
+		running--
+		results[r.Name] = r
+		state[r.Name] = targetOK
+		if r.Err != nil {
+			state[r.Name] = targetFailed
+			log.Printf("[%s] ошибка: %v", r.Name, r.Err)
+		} else {
+			log.Printf("[%s] завершена за %s", r.Name, r.Duration.Round(time.Second))
+		}
+		for _, t := range config.Targets {
+			if t.Name == r.Name && t.Group != "" {
+				busyGroups[t.Group] = false
+			}
+		}
+	}
+
+	// Итоговый отчет
+	var failed []string
+	fmt.Println("Цели:")
+	for _, t := range config.Targets {
+		line := fmt.Sprintf("  %-20s %-8s", t.Name, state[t.Name])
+		if r, ok := results[t.Name]; ok {
+			line += " " + r.Duration.Round(time.Second).String()
+		}
+		fmt.Println(line)
+		if state[t.Name] != targetOK {
+			failed = append(failed, t.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("не выполнены цели: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// runTarget запускает dbacker в каталоге цели; вывод процесса пишется в лог с именем цели
+func runTarget(executable string, t TargetConfig, realRun bool) error {
+	args := append([]string{}, t.Args...)
+	if realRun {
+		args = append(args, "-run=true")
+	}
+	log.Printf("[%s] запуск dbacker %s в %s", t.Name, strings.Join(args, " "), t.Dir)
+
+	out := &prefixWriter{prefix: "[" + t.Name + "] ", w: os.Stderr}
+	cmd := exec.Command(executable, args...)
+	cmd.Dir = t.Dir
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err := cmd.Run()
+	out.Flush()
+	return err
+}
+
+// outputMu упорядочивает вывод параллельно работающих целей
+var outputMu sync.Mutex
+
+// prefixWriter пишет вывод построчно с префиксом
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+	buf    bytes.Buffer
+}
+
+// Write дописывает данные и выводит все завершенные строки
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.buf.Write(data)
+	for {
+		i := bytes.IndexByte(p.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(data), nil
+		}
+		line := p.buf.Next(i + 1)
+		outputMu.Lock()
+		fmt.Fprintf(p.w, "%s%s", p.prefix, line)
+		outputMu.Unlock()
+	}
+}
+
+// Flush выводит незавершенную последнюю строку
+func (p *prefixWriter) Flush() {
+	if p.buf.Len() > 0 {
+		outputMu.Lock()
+		fmt.Fprintf(p.w, "%s%s\n", p.prefix, p.buf.Bytes())
+		outputMu.Unlock()
+		p.buf.Reset()
+	}
+}
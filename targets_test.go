@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateTargets(t *testing.T) {
+	target := func(name string, deps ...string) TargetConfig {
+		return TargetConfig{Name: name, Dir: "/srv/" + name, DependsOn: deps}
+	}
+	tests := []struct {
+		name    string
+		targets []TargetConfig
+		wantErr string
+	}{
+		{"independent", []TargetConfig{target("billing"), target("crm")}, ""},
+		{"diamond", []TargetConfig{target("d", "b", "c"), target("b", "a"), target("c", "a"), target("a")}, ""},
+		{"no dir", []TargetConfig{{Name: "billing"}}, "targets[0]"},
+		{"duplicate", []TargetConfig{target("billing"), target("billing")}, "несколько раз"},
+		{"unknown dependency", []TargetConfig{target("crm", "billing")}, "неизвестной цели billing"},
+		{"self", []TargetConfig{target("a", "a")}, "a -> a"},
+		{"cycle", []TargetConfig{target("a", "b"), target("b", "c"), target("c", "a")}, "a -> b -> c -> a"},
+		{"cycle behind acyclic part", []TargetConfig{target("x", "a"), target("a", "b"), target("b", "a")}, "x -> a -> b -> a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTargets(tt.targets)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("неожиданная ошибка: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("ошибка %v, ожидалась содержащая %q", err, tt.wantErr)
+			}
+		})
+	}
+}
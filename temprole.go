@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Префикс имен временных ролей запусков
+const tempRolePrefix = "dbacker_run_"
+
+// Срок действия пароля временной роли: если dbacker аварийно завершится, роль не сможет войти позже
+const tempRoleValidity = 24 * time.Hour
+
+// tempRole временная роль запуска с минимальными правами
+type tempRole struct {
+	Name string
+	DB   *sql.DB // соединение под этой ролью
+}
+
+// openTempRole создает через соединение администратора admin временную роль с правами только на то,
+// что нужно запуску: SELECT на исходные таблицы, CREATE в схеме бэкапов и чтение каталога, - и подключается под ней
+func openTempRole(admin *sql.DB, config *Config) (*tempRole, error) {
+	dropStaleTempRoles(admin)
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	password := hex.EncodeToString(secret)
+	name := fmt.Sprintf("%s%d", tempRolePrefix, clock.Now().UnixNano())
+	role := quoteIdent(name)
+
+	var schema string
+	if err := admin.QueryRow(`SELECT current_schema()`).Scan(&schema); err != nil {
+		return nil, err
+	}
+	tables, err := getTablesToBackup(admin, &config.Backup)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка таблиц: %w", err)
+	}
+
+	statements := []string{
+		fmt.Sprintf("CREATE ROLE %s LOGIN PASSWORD %s VALID UNTIL %s", role, pq.QuoteLiteral(password),
+			pq.QuoteLiteral(clock.Now().Add(tempRoleValidity).Format(time.RFC3339))),
+		// Администратор становится членом роли, чтобы работать с созданными ею бэкапами и затем удалить роль
+		fmt.Sprintf("GRANT %s TO CURRENT_USER", role),
+		fmt.Sprintf("GRANT CONNECT ON DATABASE %s TO %s", quoteIdent(config.Postgres.DBName), role),
+		fmt.Sprintf("GRANT USAGE, CREATE ON SCHEMA %s TO %s", quoteIdent(schema), role),
+	}
+	for _, t := range tables {
+		statements = append(statements, fmt.Sprintf("GRANT SELECT ON %s TO %s", quoteIdent(t), role))
+	}
+
+	// Чтение каталога (продления хранения, история переименований), запись в него делает администратор
+	exists, err := catalogExists(admin)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		statements = append(statements, "GRANT USAGE ON SCHEMA public TO "+role)
+		for _, table := range catalogTables {
+			statements = append(statements, fmt.Sprintf("GRANT SELECT ON %s TO %s", table, role))
+		}
+		if config.Backup.TrackRenames {
+			statements = append(statements, fmt.Sprintf("GRANT UPDATE ON %s, %s TO %s", catalogArtifactsTable, catalogRetentionTable, role))
+		}
+	}
+
+	for _, stmt := range statements {
+		if _, err := admin.Exec(stmt); err != nil {
+			dropTempRole(admin, name)
+			if stmt == statements[0] {
+				return nil, fmt.Errorf("ошибка создания временной роли: %w", err)
+			}
+			return nil, fmt.Errorf("ошибка выдачи прав временной роли: %w", err)
+		}
+	}
+
+	// Пулер обычно не знает пароля новой роли, поэтому роль подключается напрямую
+	direct, err := sessionPostgres(&config.Postgres, "Временная роль")
+	if err != nil {
+		dropTempRole(admin, name)
+		return nil, err
+	}
+	cfg := *direct
+	cfg.User, cfg.Password = name, password
+	db, err := connectToPostgres(&cfg)
+	if err != nil {
+		dropTempRole(admin, name)
+		return nil, fmt.Errorf("ошибка подключения под временной ролью %s: %w", name, err)
+	}
+	log.Printf("Запуск выполняется под временной ролью %s", name)
+	return &tempRole{Name: name, DB: db}, nil
+}
+
+// close закрывает соединение временной роли и удаляет ее; созданные ею бэкапы переходят администратору
+func (r *tempRole) close(admin *sql.DB) {
+	r.DB.Close()
+	dropTempRole(admin, r.Name)
+}
+
+// dropTempRole передает объекты роли текущему пользователю, отзывает ее права и удаляет роль
+func dropTempRole(admin *sql.DB, name string) {
+	role := quoteIdent(name)
+	for _, stmt := range []string{
+		fmt.Sprintf("REASSIGN OWNED BY %s TO CURRENT_USER", role),
+		fmt.Sprintf("DROP OWNED BY %s", role),
+		fmt.Sprintf("DROP ROLE %s", role),
+	} {
+		if _, err := admin.Exec(stmt); err != nil {
+			log.Printf("Ошибка удаления временной роли %s: %v", name, err)
+			return
+		}
+	}
+}
+
+// dropStaleTempRoles удаляет временные роли с истекшим сроком, оставшиеся от аварийно завершенных запусков
+func dropStaleTempRoles(admin *sql.DB) {
+	rows, err := admin.Query(`
+		SELECT rolname FROM pg_roles
+		WHERE rolname LIKE $1 || '%' AND rolvaliduntil < now()`, tempRolePrefix)
+	if err != nil {
+		log.Printf("Ошибка поиска старых временных ролей: %v", err)
+		return
+	}
+	var stale []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			stale = append(stale, name)
+		}
+	}
+	rows.Close()
+
+	for _, name := range stale {
+		log.Printf("Удаление оставшейся временной роли %s", name)
+		dropTempRole(admin, name)
+	}
+}
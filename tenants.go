@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// tenantResult итог бэкапа одной схемы арендатора
+type tenantResult struct {
+	Schema string
+	Result *runResult
+	Err    error
+}
+
+// runTenants выполняет бэкап в каждой схеме, подходящей под шаблон backup.schemas: таблицы, фильтры, хранение
+// и каталог работают в каждой схеме отдельно (бэкапы создаются рядом с исходными таблицами), выгрузки пишутся
+// в подкаталоги по имени схемы. В конце выводится отчет по арендаторам.
+//
+// События схем помечаются полем schema, а их run_started и run_finished становятся schema_started и
+// schema_finished: весь запуск открывается одним run_started и закрывается одним run_finished с итогами
+// по всем схемам.
+func runTenants(db *sql.DB, config *Config, realRun bool, progress progressFunc) (err error) {
+	progress = progress.orNop()
+	progress(progressEvent{Type: eventRunStarted})
+	var tables, failedTables int
+	defer func() {
+		e := progressEvent{Type: eventRunFinished, Tables: tables, Failed: failedTables}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		progress(e)
+	}()
+
+	schemas, err := listSchemas(db, config.Backup.Schemas)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка схем: %w", err)
+	}
+	if len(schemas) == 0 {
+		return fmt.Errorf("нет схем, подходящих под шаблон %s", config.Backup.Schemas)
+	}
+
+	var results []tenantResult
+	for _, schema := range schemas {
+		log.Printf("Бэкап схемы %s", schema)
+		tenant := *config
+		tenant.Postgres.Schema = schema
+		tenant.Export.Dir = filepath.Join(config.Export.Dir, schema)
+		if config.Report.Dir != "" {
+			tenant.Report.Dir = filepath.Join(config.Report.Dir, schema)
+		}
+
+		r := tenantResult{Schema: schema, Result: &runResult{}}
+		tenantDB, err := connectToPostgres(&tenant.Postgres)
+		if err != nil {
+			r.Err = fmt.Errorf("ошибка подключения: %w", err)
+			progress(progressEvent{Type: eventSchemaFinished, Schema: schema, Error: r.Err.Error()})
+		} else {
+			r.Result, r.Err = runBackup(tenantDB, tenantDB, &tenant, realRun, tenantProgress(schema, progress))
+			tenantDB.Close()
+		}
+		if r.Err != nil {
+			log.Printf("Ошибка бэкапа схемы %s: %v", schema, r.Err)
+		}
+		tables += r.Result.Tables
+		failedTables += len(r.Result.Failed)
+		results = append(results, r)
+	}
+
+	printTenantReport(results)
+
+	var failed []string
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r.Schema)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("бэкап не удался в %d из %d схем: %s", len(failed), len(results), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// tenantProgress помечает события бэкапа схемы ее именем; начало и конец запуска схемы становятся
+// schema_started и schema_finished
+func tenantProgress(schema string, progress progressFunc) progressFunc {
+	return func(e progressEvent) {
+		e.Schema = schema
+		switch e.Type {
+		case eventRunStarted:
+			e.Type = eventSchemaStarted
+		case eventRunFinished:
+			e.Type = eventSchemaFinished
+		}
+		progress(e)
+	}
+}
+
+// listSchemas возвращает схемы, имена которых подходят под шаблон LIKE
+func listSchemas(db *sql.DB, pattern string) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT nspname
+		FROM pg_namespace
+		WHERE nspname LIKE $1
+		ORDER BY nspname`, pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schemas []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas, rows.Err()
+}
+
+// printTenantReport выводит итоги бэкапа по схемам арендаторов
+func printTenantReport(results []tenantResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Схема\tТаблиц\tСоздано\tОшибок\tУдалено\tОсвобождено\tСтатус")
+	for _, r := range results {
+		status := "ok"
+		if r.Err != nil {
+			status = r.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\n", r.Schema, r.Result.Tables, len(r.Result.Created),
+			len(r.Result.Failed), r.Result.Deleted, ByteSize(r.Result.DeletedSize), status)
+	}
+	w.Flush()
+}
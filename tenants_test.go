@@ -0,0 +1,24 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTenantProgress(t *testing.T) {
+	var got []progressEvent
+	progress := tenantProgress("tenant_a", func(e progressEvent) { got = append(got, e) })
+	progress(progressEvent{Type: eventRunStarted, Tables: 2})
+	progress(progressEvent{Type: eventTableFinished, Table: "orders", Rows: 10})
+	progress(progressEvent{Type: eventRunFinished, Tables: 2, Failed: 1})
+
+	// начало и конец запуска схемы не должны завершать поток POST /backup до последней схемы
+	want := []progressEvent{
+		{Type: eventSchemaStarted, Tables: 2, Schema: "tenant_a"},
+		{Type: eventTableFinished, Table: "orders", Rows: 10, Schema: "tenant_a"},
+		{Type: eventSchemaFinished, Tables: 2, Failed: 1, Schema: "tenant_a"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("события %+v, ожидалось %+v", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// Поддержка TimescaleDB. Гипертаблица хранит данные в чанках - обычных таблицах, которые в information_schema
+// видны наравне с остальными (при associated_schema_name в схеме бэкапа), а сжатые чанки лежат во внутренних
+// таблицах _compressed_hypertable_N. Бэкап гипертаблицы делается через ее корневую таблицу: SELECT по ней
+// читает все чанки, сжатые распаковываются самим TimescaleDB, и бэкап получается обычной таблицей
+// со всеми строками. Чанки и внутренние таблицы сами по себе не бэкапятся.
+
+// timescaleVersion возвращает версию расширения timescaledb или пустую строку, если оно не установлено
+func timescaleVersion(db *sql.DB) (string, error) {
+	var version string
+	err := db.QueryRow(`SELECT extversion FROM pg_extension WHERE extname = 'timescaledb'`).Scan(&version)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return version, err
+}
+
+// timescaleInternalTables возвращает таблицы текущей схемы, которые TimescaleDB создает для хранения данных
+// гипертаблиц: чанки, внутренние гипертаблицы сжатых данных и материализации непрерывных агрегатов
+func timescaleInternalTables(db *sql.DB) (map[string]bool, error) {
+	version, err := timescaleVersion(db)
+	if err != nil || version == "" {
+		return nil, err
+	}
+	rows, err := db.Query(`
+		SELECT table_name FROM _timescaledb_catalog.chunk
+		WHERE schema_name = current_schema()
+		UNION ALL
+		SELECT h.table_name FROM _timescaledb_catalog.hypertable h
+		WHERE h.schema_name = current_schema()
+		AND (EXISTS (SELECT 1 FROM _timescaledb_catalog.hypertable p WHERE p.compressed_hypertable_id = h.id)
+			OR EXISTS (SELECT 1 FROM _timescaledb_catalog.continuous_agg a WHERE a.mat_hypertable_id = h.id))`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения каталога TimescaleDB %s: %w", version, err)
+	}
+	defer rows.Close()
+
+	internal := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		internal[name] = true
+	}
+	return internal, rows.Err()
+}
+
+// hypertableSizes возвращает размеры гипертаблиц текущей схемы вместе со всеми чанками, включая сжатые:
+// pg_total_relation_size корневой таблицы гипертаблицы почти нулевой
+func hypertableSizes(db *sql.DB) (map[string]int64, error) {
+	version, err := timescaleVersion(db)
+	if err != nil || version == "" {
+		return nil, err
+	}
+	rows, err := db.Query(`
+		SELECT table_name, COALESCE(hypertable_size(format('%I.%I', schema_name, table_name)::regclass), 0)
+		FROM _timescaledb_catalog.hypertable
+		WHERE schema_name = current_schema()`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var size int64
+		if err := rows.Scan(&name, &size); err != nil {
+			return nil, err
+		}
+		sizes[name] = size
+	}
+	return sizes, rows.Err()
+}
+
+// archiveHasTimescale проверяет по оглавлению архива pg_dump, что в нем есть расширение timescaledb
+func archiveHasTimescale(binary, archive string) (bool, error) {
+	out, err := exec.Command(binary, "--list", archive).Output()
+	if err != nil {
+		return false, fmt.Errorf("ошибка чтения оглавления архива: %w", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.Contains(line, " EXTENSION ") && strings.HasSuffix(strings.TrimSpace(line), " timescaledb") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// restoreTimescale выполняет восстановление restore в базе dbname в режиме восстановления TimescaleDB:
+// timescaledb_pre_restore() останавливает фоновые задания (сжатие, политики хранения) и позволяет pg_restore
+// загрузить каталог расширения и чанки как есть, включая сжатые; timescaledb_post_restore() выполняется
+// и при ошибке восстановления, чтобы база не осталась в режиме восстановления
+func restoreTimescale(pg *PostgresConfig, dbname string, restore func() error) (err error) {
+	cfg := *pg
+	cfg.DBName = dbname
+	db, err := connectToPostgres(&cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, stmt := range []string{
+		"CREATE EXTENSION IF NOT EXISTS timescaledb",
+		"SELECT timescaledb_pre_restore()",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	log.Printf("База %s переведена в режим восстановления TimescaleDB", dbname)
+	defer func() {
+		if _, postErr := db.Exec("SELECT timescaledb_post_restore()"); postErr != nil {
+			log.Printf("Ошибка выхода из режима восстановления TimescaleDB, выполните SELECT timescaledb_post_restore() в базе %s: %v", dbname, postErr)
+			if err == nil {
+				err = postErr
+			}
+		}
+	}()
+	return restore()
+}
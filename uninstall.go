@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/lib/pq"
+)
+
+func init() {
+	commands["uninstall"] = command{
+		Usage: "Remove everything dbacker created in the database (test run unless -run)",
+		Run:   cmdUninstall,
+	}
+}
+
+// cmdUninstall удаляет все, что создал dbacker: таблицы бэкапа, каталог, объекты postgres_fdw, слот CDC и временные роли.
+// Без -run только выводит, что будет удалено.
+func cmdUninstall(args []string) error {
+	fs := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	run := fs.Bool("run", false, "Actually drop objects instead of listing them")
+	fs.Parse(args)
+
+	config, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	backupDB, err := openBackupDatabase(config, db)
+	if err != nil {
+		return err
+	}
+	if backupDB != db {
+		defer backupDB.Close()
+	}
+
+	var statements []uninstallStep
+
+	// Схемы дней (backup.day_schemas) содержат представления над таблицами бэкапа и удаляются первыми
+	daySchemas, err := daySchemaViews(backupDB, config.Backup.DaySchemaPrefix)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения схем дней: %w", err)
+	}
+	for _, name := range sortedKeys(daySchemas) {
+		statements = append(statements, uninstallStep{backupDB, "DROP SCHEMA IF EXISTS " + quoteIdent(name) + " CASCADE"})
+	}
+
+	// Таблицы бэкапа: только строго по шаблону {prefix}_{table}_{YYYYMMDD}
+	backups, err := getBackupTables(backupDB, config.Backup.Prefix)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка бэкапов: %w", err)
+	}
+	pattern := backupNamePattern(config.Backup.Prefix)
+	for _, b := range backups {
+		if pattern.MatchString(b.Name) {
+			statements = append(statements, uninstallStep{backupDB, "DROP TABLE IF EXISTS " + quoteIdent(b.Name)})
+		}
+	}
+
+	// Функции для запросов к бэкапам на дату (dbacker asof)
+	for _, fn := range []string{asofFunc + "(anyelement, date)", asofTableFunc + "(text, date)"} {
+		var exists bool
+		if err := backupDB.QueryRow(`SELECT to_regprocedure($1) IS NOT NULL`, fn).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			statements = append(statements, uninstallStep{backupDB, "DROP FUNCTION IF EXISTS " + fn})
+		}
+	}
+
+	// Объекты postgres_fdw на основной базе
+	if config.Remote.remoteEnabled() {
+		statements = append(statements,
+			uninstallStep{db, "DROP SCHEMA IF EXISTS " + fdwStagingSchema + " CASCADE"},
+			uninstallStep{db, "DROP SERVER IF EXISTS " + quoteIdent(config.Remote.ServerName) + " CASCADE"})
+	}
+
+	// Слот логической репликации держит WAL на сервере, его нужно удалить обязательно
+	var slotExists bool
+	err = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)`, config.CDC.Slot).Scan(&slotExists)
+	if err != nil {
+		return fmt.Errorf("ошибка проверки слота репликации: %w", err)
+	}
+	if slotExists {
+		statements = append(statements, uninstallStep{db, fmt.Sprintf("SELECT pg_drop_replication_slot(%s)", pq.QuoteLiteral(config.CDC.Slot))})
+	}
+
+	// Каталог
+	for _, table := range catalogTables {
+		var exists bool
+		if err := db.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			statements = append(statements, uninstallStep{db, "DROP TABLE IF EXISTS " + table})
+		}
+	}
+	var guardExists bool
+	if err := db.QueryRow(`SELECT to_regproc($1) IS NOT NULL`, catalogLedgerGuard).Scan(&guardExists); err != nil {
+		return err
+	}
+	if guardExists {
+		statements = append(statements, uninstallStep{db, "DROP FUNCTION IF EXISTS " + catalogLedgerGuard + "()"})
+	}
+
+	// Временные роли запусков (backup.temp_role), оставшиеся после аварийного завершения
+	rows, err := db.Query(`SELECT rolname FROM pg_roles WHERE rolname LIKE $1 || '%'`, tempRolePrefix)
+	if err != nil {
+		return fmt.Errorf("ошибка поиска временных ролей: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		role := quoteIdent(name)
+		statements = append(statements,
+			uninstallStep{db, "REASSIGN OWNED BY " + role + " TO CURRENT_USER"},
+			uninstallStep{db, "DROP OWNED BY " + role},
+			uninstallStep{db, "DROP ROLE " + role})
+	}
+	rows.Close()
+
+	if len(statements) == 0 {
+		log.Println("Объектов dbacker в базе нет")
+		return nil
+	}
+	for _, st := range statements {
+		if !*run {
+			log.Printf("Тестовый запуск, будет выполнено: %s", st.Query)
+			continue
+		}
+		if _, err := st.DB.Exec(st.Query); err != nil {
+			return fmt.Errorf("%s: %w", st.Query, err)
+		}
+		log.Printf("Выполнено: %s", st.Query)
+	}
+	if *run {
+		log.Println("dbacker удален из базы; файлы бэкапов в локальных каталогах и удаленных хранилищах не затрагиваются")
+	}
+	return nil
+}
+
+// uninstallStep запрос удаления и база, в которой он выполняется
+type uninstallStep struct {
+	DB    *sql.DB
+	Query string
+}
+
+// backupNamePattern возвращает строгий шаблон имени таблицы бэкапа {prefix}_{table}_{YYYYMMDD}
+func backupNamePattern(prefix string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(prefix) + `_.+_(19|20)\d\d(0[1-9]|1[0-2])(0[1-9]|[12]\d|3[01])$`)
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestBackupNamePattern(t *testing.T) {
+	pattern := backupNamePattern("autobackup")
+	for _, name := range []string{
+		"autobackup_orders_20240131",
+		"autobackup_orders_before_fix_20240131",
+		"autobackup_orders_guard_120000_19991231",
+		"autobackup_public.orders_20240229",
+	} {
+		if !pattern.MatchString(name) {
+			t.Errorf("таблица бэкапа %s не распознана", name)
+		}
+	}
+	// uninstall удаляет все совпавшие таблицы, поэтому похожие на бэкапы имена не должны совпадать
+	for _, name := range []string{
+		"autobackup_orders_20241301", // месяц 13
+		"autobackup_orders_20240132", // день 32
+		"autobackup_orders_21240131",
+		"autobackup_orders_2024013",
+		"autobackup_orders_20240131_old",
+		"autobackup_20240131", // нет имени таблицы
+		"autobackupx_orders_20240131",
+		"other_autobackup_orders_20240131",
+	} {
+		if pattern.MatchString(name) {
+			t.Errorf("%s принята за таблицу бэкапа", name)
+		}
+	}
+
+	if backupNamePattern("a.b").MatchString("axb_orders_20240131") {
+		t.Error("точка в префиксе совпала с любым символом")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ByteSize размер в байтах; в конфигурации задаётся числом или строкой вида "500MB", "20GB"
+type ByteSize int64
+
+var sizeUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// UnmarshalJSON разбирает размер из числа или строки с единицами измерения
+func (s *ByteSize) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*s = ByteSize(n)
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("размер должен быть числом или строкой: %s", data)
+	}
+	v, err := parseByteSize(str)
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+// parseByteSize разбирает строку вида "20GB" в байты
+func parseByteSize(str string) (ByteSize, error) {
+	str = strings.ToUpper(strings.TrimSpace(str))
+	if str == "" {
+		return 0, nil
+	}
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(str, u.suffix) {
+			num := strings.TrimSpace(strings.TrimSuffix(str, u.suffix))
+			f, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return 0, fmt.Errorf("некорректный размер %q", str)
+			}
+			return ByteSize(f * float64(u.mult)), nil
+		}
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("некорректный размер %q", str)
+	}
+	return ByteSize(n), nil
+}
+
+// String возвращает размер в удобочитаемом виде
+func (s ByteSize) String() string {
+	for _, u := range sizeUnits {
+		if u.mult > 1 && int64(s) >= u.mult {
+			return fmt.Sprintf("%.1f%s", float64(s)/float64(u.mult), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", int64(s))
+}
+
+// Duration интервал времени; в конфигурации задаётся строкой вида "5s", "10m", "1h30m"
+type Duration time.Duration
+
+// UnmarshalJSON разбирает интервал из строки в формате time.ParseDuration
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("интервал должен быть строкой вида \"5s\": %s", data)
+	}
+	if str == "" {
+		*d = 0
+		return nil
+	}
+	v, err := time.ParseDuration(str)
+	if err != nil {
+		return fmt.Errorf("некорректный интервал %q", str)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// String возвращает интервал в формате time.Duration
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	valid := map[string]ByteSize{
+		"":       0,
+		"1024":   1024,
+		"512B":   512,
+		"10KB":   10 << 10,
+		"500MB":  500 << 20,
+		"500 mb": 500 << 20,
+		"1.5GB":  3 << 29,
+		" 2TB ":  2 << 40,
+	}
+	for in, want := range valid {
+		if got, err := parseByteSize(in); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; ожидалось %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"GB", "10XB", "ten"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) принял некорректный размер", in)
+		}
+	}
+}
+
+func TestByteSizeJSON(t *testing.T) {
+	var cfg struct {
+		Budget ByteSize `json:"budget"`
+		Limit  ByteSize `json:"limit"`
+	}
+	if err := json.Unmarshal([]byte(`{"budget": "20GB", "limit": 1048576}`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Budget != 20<<30 || cfg.Limit != 1<<20 {
+		t.Errorf("budget %d, limit %d", cfg.Budget, cfg.Limit)
+	}
+	if err := json.Unmarshal([]byte(`{"budget": "20 parsecs"}`), &cfg); err == nil {
+		t.Error("принят некорректный размер")
+	}
+	if err := json.Unmarshal([]byte(`{"budget": true}`), &cfg); err == nil {
+		t.Error("принят размер true")
+	}
+
+	if got := ByteSize(1536).String(); got != "1.5KB" {
+		t.Errorf("ByteSize(1536) = %s", got)
+	}
+	if got := ByteSize(512).String(); got != "512B" {
+		t.Errorf("ByteSize(512) = %s", got)
+	}
+	if got := ByteSize(500 << 20).String(); got != "500.0MB" {
+		t.Errorf("ByteSize(500MB) = %s", got)
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Duration
+		wantErr bool
+	}{
+		{`"5s"`, Duration(5 * time.Second), false},
+		{`"1h30m"`, Duration(90 * time.Minute), false},
+		{`"0s"`, 0, false},
+		{`""`, 0, false},
+		{`5`, 0, true},
+		{`"5 days"`, 0, true},
+	}
+	for _, tt := range tests {
+		var got Duration
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Duration из %s = %v, %v; ожидалось %v, ошибка %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
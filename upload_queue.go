@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Очередь загрузки файлов в удаленные хранилища. У каждого хранилища свои обработчики
+// (upload_concurrency) и свой запас повторов на запуск (upload_retries). Очередь запускается до выгрузок:
+// файлы каждого формата начинают загружаться, пока выгружаются следующие форматы и загружается BigQuery,
+// так что медленное хранилище не задерживает работу с базой, а база - загрузку.
+
+// Порядок загрузки файлов из очереди хранилища
+const (
+	uploadOrderCreated       = "created"        // в порядке создания
+	uploadOrderSmallestFirst = "smallest_first" // сначала меньшие: больше файлов оказывается в хранилище раньше
+)
+
+// uploadQueue очередь загрузки файлов запуска во все хранилища, кроме реплик
+type uploadQueue struct {
+	config *Config
+	result *runResult
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	dests   []*destinationQueue
+	queued  map[string]bool // пути файлов, уже поставленных в очередь
+	closed  bool            // новых файлов не будет, обработчики завершаются по опустошении очереди
+	failed  []string
+	workers sync.WaitGroup
+}
+
+// destinationQueue файлы, ожидающие загрузки в одно хранилище
+type destinationQueue struct {
+	cfg     *DestinationConfig
+	st      storage
+	pending []artifact
+	retries int // оставшиеся повторы загрузки
+}
+
+// newUploadQueue открывает хранилища и запускает их обработчики; хранилище, которое не открылось,
+// записывается в ошибки очереди
+func newUploadQueue(config *Config, result *runResult) *uploadQueue {
+	q := &uploadQueue{config: config, result: result, queued: make(map[string]bool)}
+	q.cond = sync.NewCond(&q.mu)
+	for i := range config.Destinations {
+		d := &config.Destinations[i]
+		if d.ReplicaOf != "" {
+			// реплики заполняются командой replicate
+			continue
+		}
+		st, err := openStorage(d)
+		if err != nil {
+			q.failed = append(q.failed, fmt.Sprintf("%s: %v", d.Name, err))
+			continue
+		}
+		dq := &destinationQueue{cfg: d, st: st, retries: d.UploadRetries}
+		q.dests = append(q.dests, dq)
+		for n := 0; n < d.UploadConcurrency; n++ {
+			q.workers.Add(1)
+			go q.work(dq)
+		}
+	}
+	return q
+}
+
+// add ставит в очередь всех хранилищ локальные файлы запуска; таблицы бэкапа, уже загруженные копии
+// и файлы, поставленные в очередь раньше, пропускаются
+func (q *uploadQueue) add(artifacts ...artifact) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, a := range artifacts {
+		if a.Destination != "" || a.Kind == artifactKindTable || q.queued[a.Path] || !fileExists(a.Path) {
+			continue
+		}
+		q.queued[a.Path] = true
+		for _, d := range q.dests {
+			d.pending = append(d.pending, a)
+		}
+	}
+	q.cond.Broadcast()
+}
+
+// wait дожидается загрузки всех поставленных в очередь файлов и возвращает ошибки загрузки
+func (q *uploadQueue) wait() error {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	q.workers.Wait()
+
+	if len(q.failed) > 0 {
+		return fmt.Errorf("ошибка загрузки в удаленные хранилища: %s", strings.Join(q.failed, "; "))
+	}
+	return nil
+}
+
+// next возвращает следующий файл хранилища в порядке upload_order; false, когда очередь закрыта и пуста
+func (q *uploadQueue) next(d *destinationQueue) (artifact, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(d.pending) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(d.pending) == 0 {
+		return artifact{}, false
+	}
+	i := 0
+	if d.cfg.UploadOrder == uploadOrderSmallestFirst {
+		for j, a := range d.pending {
+			if a.Size < d.pending[i].Size {
+				i = j
+			}
+		}
+	}
+	a := d.pending[i]
+	d.pending = append(d.pending[:i], d.pending[i+1:]...)
+	return a, true
+}
+
+// takeRetry расходует один повтор из запаса хранилища; false, если запас исчерпан
+func (q *uploadQueue) takeRetry(d *destinationQueue) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if d.retries == 0 {
+		return false
+	}
+	d.retries--
+	return true
+}
+
+// fail записывает ошибку загрузки
+func (q *uploadQueue) fail(msg string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.failed = append(q.failed, msg)
+}
+
+// work обработчик очереди хранилища: загружает файлы, пока очередь не закрыта и не опустела
+func (q *uploadQueue) work(d *destinationQueue) {
+	defer q.workers.Done()
+	for {
+		a, ok := q.next(d)
+		if !ok {
+			return
+		}
+		key, err := artifactKey(d.cfg, q.config, q.result, a)
+		if err != nil {
+			q.fail(fmt.Sprintf("%s -> %s: %v", a.Path, d.cfg.Name, err))
+			continue
+		}
+
+		delay := time.Duration(d.cfg.UploadRetryDelay)
+		err = putPath(d.st, a.Path, key)
+		for err != nil && q.takeRetry(d) {
+			log.Printf("Ошибка загрузки %s в хранилище %s: %v, повтор через %s", a.Path, d.cfg.Name, err, delay)
+			clock.Sleep(delay)
+			delay *= 2
+			err = putPath(d.st, a.Path, key)
+		}
+		if err != nil {
+			log.Printf("Ошибка загрузки %s в хранилище %s: %v", a.Path, d.cfg.Name, err)
+			q.fail(fmt.Sprintf("%s -> %s: %v", a.Path, d.cfg.Name, err))
+			continue
+		}
+		log.Printf("Файл %s загружен в хранилище %s как %s", a.Path, d.cfg.Name, key)
+		q.result.addArtifacts(artifact{
+			Kind:        a.Kind,
+			Source:      a.Source,
+			Path:        key,
+			Size:        a.Size,
+			CreatedAt:   clock.Now(),
+			Destination: d.cfg.Name,
+			Manifest:    a.Manifest,
+		})
+	}
+}
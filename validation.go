@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"dbacker/dberrors"
+)
+
+// ValidationConfig проверка бэкапа таблицы на уровне данных предметной области (backup.validations)
+type ValidationConfig struct {
+	Table string `json:"table"` // Исходная таблица
+	Name  string `json:"name"`  // Название проверки для логов и уведомлений (по умолчанию текст запроса)
+
+	// Запрос, возвращающий одно значение; {table} заменяется на проверяемую таблицу,
+	// например "SELECT count(*) = 0 FROM {table} WHERE order_id IS NULL"
+	Query string `json:"query"`
+
+	// false - запрос выполняется на бэкапе и должен вернуть true; true - запрос выполняется на бэкапе
+	// и на исходной таблице (в снимке, в котором она копировалась), значения должны совпасть,
+	// например "SELECT sum(amount) FROM {table}"
+	Match bool `json:"match"`
+}
+
+// validateValidations проверяет настройки проверок бэкапов и задает названия по умолчанию
+func validateValidations(validations []ValidationConfig) error {
+	names := make(map[string]bool)
+	for i := range validations {
+		v := &validations[i]
+		if v.Table == "" {
+			return fmt.Errorf("backup.validations[%d]: не задана таблица", i)
+		}
+		if !strings.Contains(v.Query, "{table}") {
+			return fmt.Errorf("backup.validations[%d]: запрос должен содержать {table}", i)
+		}
+		if v.Name == "" {
+			v.Name = v.Query
+		}
+		key := v.Table + "\x00" + v.Name
+		if names[key] {
+			return fmt.Errorf("backup.validations[%d]: проверка %q таблицы %s задана дважды", i, v.Name, v.Table)
+		}
+		names[key] = true
+	}
+	return nil
+}
+
+// tableValidations возвращает проверки таблицы
+func tableValidations(cfg *BackupConfig, table string) []ValidationConfig {
+	var checks []ValidationConfig
+	for _, v := range cfg.Validations {
+		if v.Table == table {
+			checks = append(checks, v)
+		}
+	}
+	return checks
+}
+
+// hasMatchChecks проверяет, что среди проверок есть сравнения с исходной таблицей
+func hasMatchChecks(checks []ValidationConfig) bool {
+	for _, v := range checks {
+		if v.Match {
+			return true
+		}
+	}
+	return false
+}
+
+// checkValue выполняет запрос проверки для таблицы relation (уже в виде идентификатора SQL)
+// и возвращает значение в текстовом виде; NULL возвращается как "NULL"
+func checkValue(q queryRower, query, relation string) (string, error) {
+	var value sql.NullString
+	if err := q.QueryRow(strings.ReplaceAll(query, "{table}", relation)).Scan(&value); err != nil {
+		return "", err
+	}
+	if !value.Valid {
+		return "NULL", nil
+	}
+	return value.String, nil
+}
+
+// sourceCheckValues выполняет запросы проверок с match на исходной таблице relation в транзакции копирования,
+// то есть в том же снимке данных, что и копия; значения возвращаются по названиям проверок
+func sourceCheckValues(q queryRower, relation string, checks []ValidationConfig) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, v := range checks {
+		if !v.Match {
+			continue
+		}
+		value, err := checkValue(q, v.Query, relation)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка проверки %q на таблице %s: %w", v.Name, relation, err)
+		}
+		values[v.Name] = value
+	}
+	return values, nil
+}
+
+// validateBackup выполняет проверки на созданном бэкапе. Бэкап, не прошедший проверку, не удаляется:
+// возвращается ошибка вида dberrors.ErrValidationFailed со всеми непройденными проверками, бэкап отмечается
+// в каталоге как непрошедший проверку, а таблица попадает в ошибки запуска
+func validateBackup(backupDB *sql.DB, table, backup string, checks []ValidationConfig, source map[string]string) error {
+	var problems []string
+	for _, v := range checks {
+		value, err := checkValue(backupDB, v.Query, quoteIdent(backup))
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: ошибка запроса: %v", v.Name, err))
+		case v.Match && value != source[v.Name]:
+			problems = append(problems, fmt.Sprintf("%s: в бэкапе %s, в исходной таблице %s", v.Name, value, source[v.Name]))
+		case !v.Match && value != "true":
+			problems = append(problems, fmt.Sprintf("%s: запрос вернул %s вместо true", v.Name, value))
+		}
+	}
+	if len(problems) == 0 {
+		if len(checks) > 0 {
+			log.Printf("Бэкап %s прошел проверки таблицы %s (%d)", backup, table, len(checks))
+		}
+		return nil
+	}
+	return &dberrors.TableError{Op: dberrors.OpValidate, Table: table, Kind: dberrors.ErrValidationFailed,
+		Err: fmt.Errorf("бэкап %s не прошел проверки: %s", backup, strings.Join(problems, "; "))}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateValidations(t *testing.T) {
+	validations := []ValidationConfig{
+		{Table: "orders", Query: "SELECT count(*) = 0 FROM {table} WHERE customer_id IS NULL"},
+		{Table: "orders", Name: "amount", Query: "SELECT sum(amount) FROM {table}", Match: true},
+		{Table: "items", Name: "amount", Query: "SELECT sum(amount) FROM {table}", Match: true},
+	}
+	if err := validateValidations(validations); err != nil {
+		t.Fatal(err)
+	}
+	if validations[0].Name != validations[0].Query {
+		t.Errorf("название по умолчанию %q, ожидался текст запроса", validations[0].Name)
+	}
+
+	cfg := &BackupConfig{Validations: validations}
+	if checks := tableValidations(cfg, "orders"); len(checks) != 2 || !hasMatchChecks(checks) {
+		t.Errorf("проверки orders: %+v", checks)
+	}
+	if checks := tableValidations(cfg, "users"); len(checks) != 0 || hasMatchChecks(checks) {
+		t.Errorf("проверки users: %+v", checks)
+	}
+
+	invalid := map[string][]ValidationConfig{
+		"не задана таблица":        {{Query: "SELECT true FROM {table}"}},
+		"должен содержать {table}": {{Table: "orders", Query: "SELECT true FROM orders"}},
+		"задана дважды": {
+			{Table: "orders", Name: "amount", Query: "SELECT sum(amount) FROM {table}"},
+			{Table: "orders", Name: "amount", Query: "SELECT sum(amount) FROM {table} WHERE paid"},
+		},
+	}
+	for want, v := range invalid {
+		if err := validateValidations(v); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ошибка %v, ожидалась содержащая %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/rand"
+)
+
+// copyOptions как выполняется копирование таблицы
+type copyOptions struct {
+	Nowait bool // брать блокировку исходной таблицы без ожидания (NOWAIT)
+	Hash   bool // посчитать хеш исходной таблицы в том же снимке, в котором она копировалась
+
+	Columns []column // колонки исходной таблицы из модели запуска; nil - читаются из каталога при копировании
+
+	Checks []ValidationConfig // проверки backup.validations: запросы с match выполняются на исходной таблице в снимке копирования
+}
+
+// snapshot проверяет, что после копирования нужно читать исходную таблицу в том же снимке
+func (o copyOptions) snapshot() bool {
+	return o.Hash || hasMatchChecks(o.Checks)
+}
+
+// columns возвращает колонки исходной таблицы: из модели запуска или, если их нет, из каталога
+func (o copyOptions) columns(db *sql.DB, table string) ([]column, error) {
+	if o.Columns != nil {
+		return o.Columns, nil
+	}
+	return tableColumns(db, table)
+}
+
+// copyResult результат копирования таблицы
+type copyResult struct {
+	Rows int64  // количество скопированных строк
+	Hash string // хеш исходной таблицы, если он запрошен (copyOptions.Hash)
+
+	Checks map[string]string // значения запросов проверок с match на исходной таблице по названиям проверок
+}
+
+// queryRower *sql.DB или *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// execCopy выполняет запрос копирования таблицы table в транзакции: при Nowait сначала берет блокировку
+// без ожидания, при Hash и проверках с match работает в REPEATABLE READ и после копирования считает хеш
+// и значения проверок исходной таблицы в том же снимке, так что изменения, сделанные после копирования,
+// на сверку не влияют
+func execCopy(db *sql.DB, table, query string, opts copyOptions) (copyResult, error) {
+	var result copyResult
+	txOpts := &sql.TxOptions{}
+	if opts.snapshot() {
+		txOpts.Isolation = sql.LevelRepeatableRead
+	}
+	tx, err := db.BeginTx(context.Background(), txOpts)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	if opts.Nowait {
+		if _, err := tx.Exec(fmt.Sprintf("LOCK TABLE %s IN ACCESS SHARE MODE NOWAIT", table)); err != nil {
+			return result, err
+		}
+	}
+	res, err := tx.Exec(query)
+	if err != nil {
+		return result, err
+	}
+	result.Rows, _ = res.RowsAffected()
+
+	if opts.Hash {
+		if result.Hash, err = tableHash(tx, table); err != nil {
+			return result, fmt.Errorf("ошибка подсчета md5 таблицы %s: %w", table, err)
+		}
+	}
+	if result.Checks, err = sourceCheckValues(tx, table, opts.Checks); err != nil {
+		return result, err
+	}
+	return result, tx.Commit()
+}
+
+// tableHash считает на сервере md5 содержимого таблицы: md5 от упорядоченных md5 строк,
+// поэтому результат не зависит от физического порядка строк и не требует передачи данных клиенту
+func tableHash(q queryRower, table string) (string, error) {
+	var hash string
+	var rows int64
+	err := q.QueryRow(fmt.Sprintf(`
+		SELECT md5(coalesce(string_agg(h, '' ORDER BY h), '')), count(*)
+		FROM (SELECT md5(t::text) AS h FROM %s t) s`, table)).Scan(&hash, &rows)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%d", hash, rows), nil
+}
+
+// verifyBackup сверяет md5 созданного бэкапа с хешем исходной таблицы; при расхождении бэкап удаляется
+func verifyBackup(backupDB *sql.DB, table, backup, sourceHash string) error {
+	backupHash, err := tableHash(backupDB, quoteIdent(backup))
+	if err != nil {
+		return fmt.Errorf("ошибка подсчета md5 бэкапа %s: %w", backup, err)
+	}
+	if backupHash != sourceHash {
+		backupDB.Exec("DROP TABLE IF EXISTS " + quoteIdent(backup))
+		return fmt.Errorf("md5 бэкапа %s (%s) не совпадает с исходной таблицей (%s)", backup, backupHash, sourceHash)
+	}
+	log.Printf("Бэкап %s совпадает с таблицей %s (md5/строк %s)", backup, table, backupHash)
+	return nil
+}
+
+// verifySample выбирает таблицы для сверки: все из verify_tables и verify_sample случайных из остальных
+func verifySample(cfg *BackupConfig, tables []string) map[string]bool {
+	verify := make(map[string]bool)
+	present := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		present[t] = true
+	}
+	for _, t := range cfg.VerifyTables {
+		if present[t] {
+			verify[t] = true
+		}
+	}
+
+	var rest []string
+	for _, t := range tables {
+		if !verify[t] {
+			rest = append(rest, t)
+		}
+	}
+	rand.Shuffle(len(rest), func(i, j int) { rest[i], rest[j] = rest[j], rest[i] })
+	for i := 0; i < cfg.VerifySample && i < len(rest); i++ {
+		verify[rest[i]] = true
+	}
+	return verify
+}